@@ -0,0 +1,410 @@
+// Package addrs parses and formats resource addresses.
+//
+// The grammar is:
+//
+//	address  = { "module." name [ key ] "." } [ provider ":" ] type "." name [ key ]
+//	provider = name [ "." alias ]                   e.g. aws, aws.west
+//	type     = Service "." Kind | name              e.g. S3.Bucket, null_resource
+//	key      = "[" int "]" | "[" quoted-string "]"  e.g. [0], ["prod"]
+//
+// A type segment starting with an upper-case letter names a provider service and
+// is followed by the resource kind, so aws:S3.Bucket.logs is the resource logs of
+// type aws:S3.Bucket. Resource names may contain dots, e.g. example.com.
+package addrs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// InstanceKey identifies one instance of a resource or module expanded with count
+// (IntKey) or for_each (StringKey).
+type InstanceKey interface {
+	instanceKey()
+	String() string
+}
+
+// IntKey is the key of a count instance.
+type IntKey int
+
+func (IntKey) instanceKey()     {}
+func (k IntKey) String() string { return fmt.Sprintf("[%d]", int(k)) }
+
+// StringKey is the key of a for_each instance.
+type StringKey string
+
+func (StringKey) instanceKey()     {}
+func (k StringKey) String() string { return fmt.Sprintf("[%q]", string(k)) }
+
+// ModuleInstance is one step of a module path.
+type ModuleInstance struct {
+	Name string
+	Key  InstanceKey // nil unless the module is expanded
+}
+
+func (m ModuleInstance) String() string {
+	s := "module." + m.Name
+	if m.Key != nil {
+		s += m.Key.String()
+	}
+	return s
+}
+
+// Resource is the address of a resource instance.
+type Resource struct {
+	Module   []ModuleInstance // Empty for the root module
+	Provider string           // Provider prefix of the type, e.g. "aws" or "aws.west"; may be empty
+	Type     string           // e.g. "S3.Bucket" or "null_resource"
+	Name     string           // Without instance key
+	Key      InstanceKey      // nil unless the resource is expanded
+}
+
+var (
+	identRe    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+	providerRe = regexp.MustCompile(`^[a-z][a-z0-9_-]*(\.[A-Za-z0-9_-]+)?$`)
+	indexKeyRe = regexp.MustCompile(`\[([0-9]+)\]$`)
+)
+
+// Parse parses a resource address.
+func Parse(s string) (Resource, error) {
+	var r Resource
+	rest := s
+	for strings.HasPrefix(rest, "module.") {
+		m, tail, err := parseModuleInstance(rest[len("module."):])
+		if err != nil {
+			return Resource{}, fmt.Errorf("invalid address %q: %w", s, err)
+		}
+		r.Module = append(r.Module, m)
+		rest = tail
+	}
+
+	typ, name, ok := cutType(rest)
+	if !ok {
+		return Resource{}, fmt.Errorf("invalid address %q, expected [provider:]type.name", s)
+	}
+	r.Provider, r.Type = splitProvider(typ)
+	if !validType(r.Type) {
+		return Resource{}, fmt.Errorf("invalid address %q: invalid resource type %q", s, r.Type)
+	}
+	r.Name, r.Key = splitKey(name)
+	if r.Name == "" {
+		return Resource{}, fmt.Errorf("invalid address %q: missing resource name", s)
+	}
+	return r, nil
+}
+
+// MustParse is like Parse but panics on error. It is meant for tests and constants.
+func MustParse(s string) Resource {
+	r, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Of returns the address of a root module resource with the given type, e.g.
+// "aws:S3.Bucket", and name, which carries the instance key of an expanded
+// resource, e.g. `web["prod"]`. Of(t, n).String() is t + "." + n.
+func Of(typ, name string) Resource {
+	var r Resource
+	r.Provider, r.Type = splitProvider(typ)
+	r.Name, r.Key = splitKey(name)
+	if r.Name == "" {
+		r.Name, r.Key = name, nil
+	}
+	return r
+}
+
+// OfModule is like Of for a resource of the module instance at path, e.g.
+// module.net["prod"], as formatted by ModulePath. An empty path is the root module.
+// It panics if path is invalid, as paths come from ModulePath.
+func OfModule(path, typ, name string) Resource {
+	r := Of(typ, name)
+	if path != "" {
+		m, err := ParseModule(path)
+		if err != nil {
+			panic(err)
+		}
+		r.Module = m
+	}
+	return r
+}
+
+// ParseModule parses a module path such as module.net["prod"].module.subnets.
+func ParseModule(path string) ([]ModuleInstance, error) {
+	var module []ModuleInstance
+	rest := path
+	for {
+		tail, ok := strings.CutPrefix(rest, "module.")
+		if !ok {
+			return nil, fmt.Errorf("invalid module path %q", path)
+		}
+		m, tail, err := parseModuleStep(tail)
+		if err != nil {
+			return nil, fmt.Errorf("invalid module path %q: %w", path, err)
+		}
+		module = append(module, m)
+		if tail == "" {
+			return module, nil
+		}
+		if rest, ok = strings.CutPrefix(tail, "."); !ok {
+			return nil, fmt.Errorf("invalid module path %q", path)
+		}
+	}
+}
+
+// String formats the address. Parse(r.String()) returns r.
+func (r Resource) String() string {
+	var b strings.Builder
+	for _, m := range r.Module {
+		b.WriteString(m.String())
+		b.WriteByte('.')
+	}
+	b.WriteString(r.FullType())
+	b.WriteByte('.')
+	b.WriteString(r.InstanceName())
+	return b.String()
+}
+
+// FullType returns the type with its provider prefix, as stored in state.
+func (r Resource) FullType() string {
+	if r.Provider == "" {
+		return r.Type
+	}
+	return r.Provider + ":" + r.Type
+}
+
+// InstanceName returns the name with its instance key, as stored in state.
+func (r Resource) InstanceName() string {
+	if r.Key == nil {
+		return r.Name
+	}
+	return r.Name + r.Key.String()
+}
+
+// ProviderName returns the provider without its alias, e.g. "aws" for "aws.west".
+func (r Resource) ProviderName() string {
+	name, _, _ := strings.Cut(r.Provider, ".")
+	return name
+}
+
+// Alias returns the provider alias, e.g. "west" for "aws.west", or "".
+func (r Resource) Alias() string {
+	_, alias, _ := strings.Cut(r.Provider, ".")
+	return alias
+}
+
+// NoKey returns the address of the resource as a whole, without instance key.
+func (r Resource) NoKey() Resource {
+	r.Key = nil
+	return r
+}
+
+// Unqualified returns the address without provider prefix.
+func (r Resource) Unqualified() Resource {
+	r.Provider = ""
+	return r
+}
+
+// ModulePath formats the module path, e.g. module.network.module.subnets[0], or
+// returns "" for the root module.
+func (r Resource) ModulePath() string {
+	return FormatModule(r.Module)
+}
+
+// FormatModule formats a module path, e.g. module.network.module.subnets[0], or
+// returns "" for the root module. ParseModule(FormatModule(m)) returns m.
+func FormatModule(module []ModuleInstance) string {
+	parts := make([]string, len(module))
+	for i, m := range module {
+		parts[i] = m.String()
+	}
+	return strings.Join(parts, ".")
+}
+
+// Equal reports whether r and o are the same address.
+func (r Resource) Equal(o Resource) bool {
+	return r.String() == o.String()
+}
+
+// ParseRef parses a ptr://<provider>:<type>/<name>/<attribute> reference into
+// the address it points at and the attribute. The attribute may be empty. A
+// reference to a resource of a module instance starts with the module path, e.g.
+// ptr://module.net["prod"].aws:EC2.Vpc/main/id.
+func ParseRef(ref string) (Resource, string, error) {
+	body, ok := strings.CutPrefix(ref, "ptr://")
+	if !ok {
+		return Resource{}, "", fmt.Errorf("invalid reference %q, expected ptr://<provider>:<type>/<name>/<attribute>", ref)
+	}
+	var module []ModuleInstance
+	for strings.HasPrefix(body, "module.") {
+		m, tail, err := parseModuleInstance(body[len("module."):])
+		if err != nil {
+			return Resource{}, "", fmt.Errorf("invalid reference %q: %w", ref, err)
+		}
+		module = append(module, m)
+		body = tail
+	}
+	parts := strings.SplitN(body, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Resource{}, "", fmt.Errorf("invalid reference %q, expected ptr://<provider>:<type>/<name>/<attribute>", ref)
+	}
+	r := Of(parts[0], parts[1])
+	r.Module = module
+	if !validType(r.Type) {
+		return Resource{}, "", fmt.Errorf("invalid reference %q: invalid resource type %q", ref, r.Type)
+	}
+	attr := ""
+	if len(parts) == 3 {
+		attr = parts[2]
+	}
+	return r, attr, nil
+}
+
+// Ref returns the ptr:// reference to an attribute of the resource.
+func (r Resource) Ref(attr string) string {
+	prefix := ""
+	if len(r.Module) > 0 {
+		prefix = r.ModulePath() + "."
+	}
+	return "ptr://" + prefix + r.FullType() + "/" + r.InstanceName() + "/" + attr
+}
+
+// InModule returns r as a resource of the module instance at path, which may
+// be nested, e.g. module.net["prod"]. An empty path leaves r in its module.
+func (r Resource) InModule(path []ModuleInstance) Resource {
+	r.Module = append(append([]ModuleInstance{}, path...), r.Module...)
+	return r
+}
+
+// parseModuleInstance parses `name[key].` at the start of s and returns the rest.
+func parseModuleInstance(s string) (ModuleInstance, string, error) {
+	m, rest, err := parseModuleStep(s)
+	if err != nil {
+		return ModuleInstance{}, "", err
+	}
+	rest, ok := strings.CutPrefix(rest, ".")
+	if !ok || rest == "" {
+		return ModuleInstance{}, "", fmt.Errorf("module %s must be followed by a resource", m.Name)
+	}
+	return m, rest, nil
+}
+
+// parseModuleStep parses `name[key]` at the start of s and returns the rest.
+func parseModuleStep(s string) (ModuleInstance, string, error) {
+	end := strings.IndexAny(s, ".[")
+	if end < 0 {
+		end = len(s)
+	}
+	m := ModuleInstance{Name: s[:end]}
+	if !identRe.MatchString(m.Name) {
+		return ModuleInstance{}, "", fmt.Errorf("invalid module name %q", m.Name)
+	}
+	rest := s[end:]
+	if strings.HasPrefix(rest, "[") {
+		key, tail, err := parseKeyPrefix(rest)
+		if err != nil {
+			return ModuleInstance{}, "", fmt.Errorf("module %s: %w", m.Name, err)
+		}
+		m.Key, rest = key, tail
+	}
+	return m, rest, nil
+}
+
+// parseKeyPrefix parses an instance key at the start of s and returns the rest.
+func parseKeyPrefix(s string) (InstanceKey, string, error) {
+	inner := s[1:]
+	if strings.HasPrefix(inner, `"`) {
+		quoted, err := strconv.QuotedPrefix(inner)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid instance key in %q", s)
+		}
+		rest, ok := strings.CutPrefix(inner[len(quoted):], "]")
+		if !ok {
+			return nil, "", fmt.Errorf("unterminated instance key in %q", s)
+		}
+		key, _ := strconv.Unquote(quoted)
+		return StringKey(key), rest, nil
+	}
+	digits, rest, ok := strings.Cut(inner, "]")
+	if !ok {
+		return nil, "", fmt.Errorf("unterminated instance key in %q", s)
+	}
+	i, err := strconv.Atoi(digits)
+	if err != nil || i < 0 {
+		return nil, "", fmt.Errorf("invalid instance key [%s]", digits)
+	}
+	return IntKey(i), rest, nil
+}
+
+// cutType splits s into the type, which includes any provider prefix, and the
+// name that follows it.
+func cutType(s string) (typ, name string, ok bool) {
+	prefix := ""
+	if i := strings.IndexByte(s, ':'); i > 0 && providerRe.MatchString(s[:i]) {
+		prefix, s = s[:i+1], s[i+1:]
+	}
+	first, rest, ok := strings.Cut(s, ".")
+	if !ok {
+		return "", "", false
+	}
+	if isService(first) {
+		kind, tail, ok := strings.Cut(rest, ".")
+		if !ok {
+			return "", "", false
+		}
+		first, rest = first+"."+kind, tail
+	}
+	return prefix + first, rest, rest != ""
+}
+
+// splitProvider splits a type into its provider prefix and the type proper.
+func splitProvider(typ string) (provider, rest string) {
+	if i := strings.IndexByte(typ, ':'); i > 0 && providerRe.MatchString(typ[:i]) {
+		return typ[:i], typ[i+1:]
+	}
+	return "", typ
+}
+
+// splitKey splits a trailing instance key off name. The name is returned unchanged
+// if it has none.
+func splitKey(name string) (string, InstanceKey) {
+	if m := indexKeyRe.FindStringSubmatchIndex(name); m != nil {
+		if i, err := strconv.Atoi(name[m[2]:m[3]]); err == nil {
+			return name[:m[0]], IntKey(i)
+		}
+	}
+	if strings.HasSuffix(name, `"]`) {
+		// The key itself may contain `["`, so take the first opening that quotes
+		// the rest of the name exactly
+		for i := strings.Index(name, `["`); i >= 0; {
+			quoted := name[i+1 : len(name)-1]
+			if q, err := strconv.QuotedPrefix(quoted); err == nil && q == quoted {
+				key, _ := strconv.Unquote(quoted)
+				return name[:i], StringKey(key)
+			}
+			next := strings.Index(name[i+1:], `["`)
+			if next < 0 {
+				break
+			}
+			i += next + 1
+		}
+	}
+	return name, nil
+}
+
+// validType reports whether typ is a Service.Kind or a single-segment type.
+func validType(typ string) bool {
+	service, kind, ok := strings.Cut(typ, ".")
+	if ok {
+		return isService(service) && identRe.MatchString(kind)
+	}
+	return identRe.MatchString(typ)
+}
+
+func isService(segment string) bool {
+	return segment != "" && unicode.IsUpper(rune(segment[0])) && identRe.MatchString(segment)
+}
@@ -0,0 +1,137 @@
+package addrs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		addr string
+		want Resource
+	}{
+		{"aws:S3.Bucket.logs", Resource{Provider: "aws", Type: "S3.Bucket", Name: "logs"}},
+		{"null_resource.web", Resource{Type: "null_resource", Name: "web"}},
+		{"null:null_resource.web", Resource{Provider: "null", Type: "null_resource", Name: "web"}},
+		{"aws.west:EC2.Vpc.main", Resource{Provider: "aws.west", Type: "EC2.Vpc", Name: "main"}},
+		{"aws:Route53.HostedZone.example.com.", Resource{Provider: "aws", Type: "Route53.HostedZone", Name: "example.com."}},
+		{"null_resource.web[0]", Resource{Type: "null_resource", Name: "web", Key: IntKey(0)}},
+		{`aws:ECS.Service.svc["prod"]`, Resource{Provider: "aws", Type: "ECS.Service", Name: "svc", Key: StringKey("prod")}},
+		{`aws:ECS.Service.svc["a[\"b\"]"]`, Resource{Provider: "aws", Type: "ECS.Service", Name: "svc", Key: StringKey(`a["b"]`)}},
+		{
+			`module.network.module.subnets["a"].aws:EC2.Subnet.private[1]`,
+			Resource{
+				Module:   []ModuleInstance{{Name: "network"}, {Name: "subnets", Key: StringKey("a")}},
+				Provider: "aws", Type: "EC2.Subnet", Name: "private", Key: IntKey(1),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, err := Parse(tt.addr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.addr, got.String())
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, addr := range []string{
+		"",
+		"null_resource",
+		"aws:S3.Bucket",
+		"aws:S3",
+		"null_resource.",
+		"null_resource.[0]",
+		"module.net",
+		"module.net[x].null_resource.a",
+		"1type.name",
+	} {
+		_, err := Parse(addr)
+		assert.Error(t, err, addr)
+	}
+}
+
+func TestOf(t *testing.T) {
+	r := Of("aws:S3.Bucket", `logs["eu"]`)
+	assert.Equal(t, Resource{Provider: "aws", Type: "S3.Bucket", Name: "logs", Key: StringKey("eu")}, r)
+	assert.Equal(t, "aws:S3.Bucket", r.FullType())
+	assert.Equal(t, `logs["eu"]`, r.InstanceName())
+	assert.Equal(t, `aws:S3.Bucket.logs["eu"]`, r.String())
+
+	assert.Equal(t, "null_resource.a.b", Of("null_resource", "a.b").String())
+}
+
+func TestProviderAlias(t *testing.T) {
+	r := MustParse("aws.west:S3.Bucket.logs")
+	assert.Equal(t, "aws", r.ProviderName())
+	assert.Equal(t, "west", r.Alias())
+	assert.Equal(t, "S3.Bucket.logs", r.Unqualified().String())
+}
+
+func TestParseRef(t *testing.T) {
+	r, attr, err := ParseRef("ptr://aws:EC2.Vpc/my-vpc/id")
+	require.NoError(t, err)
+	assert.Equal(t, "aws:EC2.Vpc.my-vpc", r.String())
+	assert.Equal(t, "id", attr)
+	assert.Equal(t, "ptr://aws:EC2.Vpc/my-vpc/id", r.Ref(attr))
+
+	r, attr, err = ParseRef(`ptr://null:null_resource/web[0]`)
+	require.NoError(t, err)
+	assert.Equal(t, IntKey(0), r.Key)
+	assert.Equal(t, "", attr)
+
+	for _, ref := range []string{"ptr://short", "aws:EC2.Vpc/x/id", "ptr:///x/id"} {
+		_, _, err := ParseRef(ref)
+		assert.Error(t, err, ref)
+	}
+}
+
+func TestParseRef_Module(t *testing.T) {
+	r, attr, err := ParseRef(`ptr://module.net["prod"].module.subnets[0].aws:EC2.Subnet/a.b/id`)
+	require.NoError(t, err)
+	assert.Equal(t, `module.net["prod"].module.subnets[0]`, r.ModulePath())
+	assert.Equal(t, "aws:EC2.Subnet", r.FullType())
+	assert.Equal(t, "a.b", r.Name)
+	assert.Equal(t, `ptr://module.net["prod"].module.subnets[0].aws:EC2.Subnet/a.b/id`, r.Ref(attr))
+}
+
+func TestParseModule(t *testing.T) {
+	m, err := ParseModule(`module.net["a.b"].module.subnets[2]`)
+	require.NoError(t, err)
+	assert.Equal(t, []ModuleInstance{{Name: "net", Key: StringKey("a.b")}, {Name: "subnets", Key: IntKey(2)}}, m)
+	assert.Equal(t, `module.net["a.b"].module.subnets[2]`, FormatModule(m))
+
+	r := OfModule(`module.net["a.b"]`, "null_resource", "x").InModule([]ModuleInstance{{Name: "outer"}})
+	assert.Equal(t, `module.outer.module.net["a.b"].null_resource.x`, r.String())
+
+	for _, path := range []string{"", "net", "module.", "module.net.", "module.net[x]", "module.net.null_resource.x"} {
+		_, err := ParseModule(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestMatch_Module(t *testing.T) {
+	addr := `module.net["prod"].module.subnets[0].aws:EC2.Subnet.a`
+	assert.True(t, Match("module.net", addr))
+	assert.True(t, Match(`module.net["prod"]`, addr))
+	assert.True(t, Match(`module.net["prod"].module.subnets`, addr))
+	assert.False(t, Match(`module.net["dev"]`, addr))
+	assert.False(t, Match("module.subnets", addr))
+	assert.False(t, Match("module.net", "aws:EC2.Subnet.a"))
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match("aws:S3.Bucket.logs", "aws:S3.Bucket.logs"))
+	assert.True(t, Match("aws:Lambda.Function.*", "aws:Lambda.Function.api"))
+	assert.False(t, Match("aws:Lambda.Function.*", "aws:S3.Bucket.api"))
+	assert.True(t, Match(`aws:ECS.Service.svc["*"]`, `aws:ECS.Service.svc["web"]`))
+	assert.False(t, Match(`aws:ECS.Service.svc["*"]`, `aws:ECS.Service.svc[0]`))
+	assert.True(t, Match("null_resource.web", "null_resource.web[2]"))
+	assert.False(t, Match("null_resource.web", "null_resource.webapp"))
+	assert.True(t, Match("null_resource.web?", "null_resource.web1"))
+	assert.False(t, Match("null_resource.web[0]", "null_resource.web[1]"))
+}
@@ -0,0 +1,67 @@
+package addrs
+
+import "strings"
+
+// Match reports whether address matches a target pattern. `*` matches any
+// sequence of characters and `?` a single character; everything else, including
+// brackets and quotes, is literal. A pattern without instance key also matches
+// every for_each/count instance of that resource, e.g. `svc` matches `svc["a"]`.
+// A module path matches every resource of the module, e.g. `module.net` matches
+// `module.net["prod"].aws:EC2.Vpc.main`.
+func Match(pattern, address string) bool {
+	if pattern == address {
+		return true
+	}
+	if m, err := ParseModule(pattern); err == nil {
+		a, err := Parse(address)
+		return err == nil && WithinModule(a.Module, m)
+	}
+	if !strings.ContainsAny(pattern, "*?") {
+		p, err := Parse(pattern)
+		if err != nil || p.Key != nil {
+			return false
+		}
+		a, err := Parse(address)
+		return err == nil && a.Key != nil && p.Equal(a.NoKey())
+	}
+	return globMatch(pattern, address)
+}
+
+// WithinModule reports whether the module instance at path is, or is nested in,
+// the module at prefix. A module in prefix without key matches all its instances.
+func WithinModule(path, prefix []ModuleInstance) bool {
+	if len(path) < len(prefix) {
+		return false
+	}
+	for i, m := range prefix {
+		if path[i].Name != m.Name || (m.Key != nil && path[i].Key != m.Key) {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch matches s against a pattern containing `*` and `?` wildcards.
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	starP, starI := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starI = p, i
+			p++
+		case starP >= 0:
+			starI++
+			p, i = starP+1, starI
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
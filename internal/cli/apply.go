@@ -11,7 +11,6 @@ import (
 	"github.com/picklr-io/picklr/internal/eval"
 	"github.com/picklr-io/picklr/internal/ir"
 	"github.com/picklr-io/picklr/internal/provider"
-	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
@@ -29,7 +28,7 @@ var applyCmd = &cobra.Command{
 	Long: `Build or change infrastructure according to Picklr configuration files.
 
 If a saved plan file (JSON) is provided, it will be applied directly
-without recalculating the plan.`,
+without recalculating the plan, with the input variables it was created with.`,
 	RunE: runApply,
 }
 
@@ -39,6 +38,7 @@ func init() {
 	applyCmd.Flags().BoolVar(&applyJSON, "json", false, "Output in JSON format")
 	applyCmd.Flags().BoolVar(&applyRefresh, "refresh", false, "Refresh state before applying")
 	applyCmd.Flags().StringVar(&applyOnError, "on-error", "fail", "Error handling mode: 'fail' (stop on first error) or 'continue' (apply remaining resources)")
+	addVariableFlags(applyCmd)
 }
 
 func runApply(cmd *cobra.Command, args []string) error {
@@ -59,6 +59,10 @@ func runApply(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if savedPlan != nil && (len(varValues) > 0 || len(varFiles) > 0) {
+		return fmt.Errorf("input variables cannot be set when applying a saved plan; they were fixed when the plan was created")
+	}
+
 	if savedPlan == nil && len(args) > 0 {
 		absPath, err := filepath.Abs(args[0])
 		if err != nil {
@@ -81,13 +85,17 @@ func runApply(cmd *cobra.Command, args []string) error {
 
 	// 1. Initialize Components
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+	stateMgr, err := openStateBackend(ctx, wd, entryPoint, evaluator)
+	if err != nil {
+		return err
+	}
 	registry := provider.NewRegistry()
 	eng := engine.NewEngine(registry)
 	eng.ContinueOnError = applyOnError == "continue"
 
 	// 2. Lock state
-	if err := stateMgr.Lock(); err != nil {
+	if err := lockState(ctx, stateMgr, "apply"); err != nil {
 		return err
 	}
 	defer stateMgr.Unlock()
@@ -124,10 +132,21 @@ func runApply(cmd *cobra.Command, args []string) error {
 		}
 	} else {
 		// 3. Load Config & generate plan
+		if err := verifyModules(ctx, wd, evaluator); err != nil {
+			return err
+		}
 		if !applyJSON {
 			fmt.Print("Loading configuration... ")
 		}
-		cfg, err := evaluator.LoadConfig(ctx, entryPoint, applyProperties)
+		props := configProperties(applyProperties)
+		vars, err := loadVariables(ctx, wd, entryPoint, evaluator, props)
+		if err != nil {
+			if !applyJSON {
+				fmt.Println("FAILED")
+			}
+			return err
+		}
+		cfg, err := evaluator.LoadConfig(ctx, entryPoint, props)
 		if err != nil {
 			if !applyJSON {
 				fmt.Println("FAILED")
@@ -142,6 +161,9 @@ func runApply(cmd *cobra.Command, args []string) error {
 		if err := loadRequiredProviders(registry, cfg); err != nil {
 			return err
 		}
+		if eng.RemoteStates, err = loadRemoteStates(ctx, wd, cfg, evaluator); err != nil {
+			return err
+		}
 		if err := loadStateProviders(registry, currentState); err != nil {
 			return err
 		}
@@ -161,13 +183,14 @@ func runApply(cmd *cobra.Command, args []string) error {
 		if !applyJSON {
 			fmt.Print("Calculating plan... ")
 		}
-		plan, err = eng.CreatePlanWithTargets(ctx, cfg, currentState, targets)
+		plan, err = eng.CreatePlanWithOptions(ctx, cfg, currentState, planOptions())
 		if err != nil {
 			if !applyJSON {
 				fmt.Println("FAILED")
 			}
-			return fmt.Errorf("plan generation failed: %w", err)
+			return redactError(fmt.Errorf("plan generation failed: %w", err), configSecrets(cfg, vars, currentState))
 		}
+		plan.Variables, plan.SensitiveVariables = vars.Values, vars.Sensitive
 		if !applyJSON {
 			fmt.Println("OK")
 		}
@@ -202,6 +225,9 @@ func runApply(cmd *cobra.Command, args []string) error {
 		fmt.Printf("\nApplying %d changes...\n", len(plan.Changes))
 	}
 
+	// Sensitive values must not leak through provider error messages
+	secrets := append(planSecrets(plan), stateSecrets(currentState)...)
+
 	callback := func(event engine.ApplyEvent) {
 		if applyJSON {
 			return // Suppress progress in JSON mode
@@ -238,14 +264,20 @@ func runApply(cmd *cobra.Command, args []string) error {
 			}
 			fmt.Printf("%s%s: %s after %s%s\n", color, event.Address, actionVerb, event.Duration.Round(time.Millisecond), colorize("\033[0m"))
 		case "failed":
-			fmt.Printf("%s%s: FAILED (%v)%s\n", colorize("\033[31m"), event.Address, event.Error, colorize("\033[0m"))
+			fmt.Printf("%s%s: FAILED (%v)%s\n", colorize("\033[31m"), event.Address, redactError(event.Error, secrets), colorize("\033[0m"))
 		}
 	}
 
 	newState, err := eng.ApplyPlanWithCallback(ctx, plan, currentState, callback)
 	if err != nil {
+		err = redactError(err, secrets)
 		// Write partial state on failure so successful changes aren't lost
 		_ = stateMgr.Write(ctx, currentState)
+		_ = writeAuditLog(AuditEntry{
+			Operation: "apply",
+			Changes:   auditChanges(plan),
+			Error:     err.Error(),
+		})
 		return fmt.Errorf("apply failed: %w", err)
 	}
 
@@ -255,13 +287,9 @@ func runApply(cmd *cobra.Command, args []string) error {
 	}
 
 	// 7. Audit log
-	auditChanges := make([]AuditChange, 0, len(plan.Changes))
-	for _, c := range plan.Changes {
-		auditChanges = append(auditChanges, AuditChange{Address: c.Address, Action: c.Action})
-	}
 	_ = writeAuditLog(AuditEntry{
 		Operation: "apply",
-		Changes:   auditChanges,
+		Changes:   auditChanges(plan),
 		Summary: map[string]int{
 			"create":  plan.Summary.Create,
 			"update":  plan.Summary.Update,
@@ -280,7 +308,7 @@ func runApply(cmd *cobra.Command, args []string) error {
 	if len(newState.Outputs) > 0 {
 		fmt.Println("\nOutputs:")
 		for k, v := range newState.Outputs {
-			fmt.Printf("  %s = %v\n", k, v)
+			fmt.Printf("  %s = %v\n", k, formatAttribute(k, v, newState.SensitiveOutputs))
 		}
 	}
 
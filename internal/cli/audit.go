@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/picklr-io/picklr/internal/ir"
 )
 
 // AuditEntry represents a single audit log entry.
@@ -21,8 +23,33 @@ type AuditEntry struct {
 
 // AuditChange records a single resource change.
 type AuditChange struct {
-	Address string `json:"address"`
-	Action  string `json:"action"`
+	Address    string         `json:"address"`
+	Action     string         `json:"action"`
+	Attributes map[string]any `json:"attributes,omitempty"` // New values of changed attributes, sensitive ones masked
+}
+
+// auditChanges records the changes of a plan. Attribute values are taken from the
+// diff, with sensitive values masked.
+func auditChanges(plan *ir.Plan) []AuditChange {
+	changes := make([]AuditChange, 0, len(plan.Changes))
+	for _, c := range plan.Changes {
+		change := AuditChange{Address: c.Address, Action: c.Action}
+		for k, d := range c.Diff {
+			if d.Action == "noop" || d.Action == "delete" {
+				continue
+			}
+			if change.Attributes == nil {
+				change.Attributes = make(map[string]any)
+			}
+			if d.Sensitive {
+				change.Attributes[k] = maskValue(d.After)
+			} else {
+				change.Attributes[k] = d.After
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
 }
 
 // auditLogPath returns the path to the audit log file.
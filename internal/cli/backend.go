@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/state"
+)
+
+var (
+	// backendConfigs holds the -backend-config overrides (key=value pairs or files).
+	backendConfigs []string
+	// lockTimeout is how long to wait for a state lock held by another process.
+	lockTimeout time.Duration
+)
+
+// backendFilePath returns the standalone backend configuration file for a project.
+func backendFilePath(wd string) string {
+	return filepath.Join(wd, picklrDir(), "backend.pkl")
+}
+
+// openStateBackend returns the state backend for the project in wd.
+// The backend is taken from .picklr/backend.pkl if it exists, otherwise from the
+// backend block of the entry point. Without either, state is stored locally in the
+// current workspace's state file. -backend-config overrides are applied last.
+func openStateBackend(ctx context.Context, wd, entryPoint string, evaluator *eval.Evaluator) (state.Backend, error) {
+	cfg, err := loadBackendConfig(ctx, wd, entryPoint, evaluator)
+	if err != nil {
+		return nil, err
+	}
+	return state.NewBackend(cfg, evaluator)
+}
+
+// lockState locks the backend for operation, waiting up to -lock-timeout if
+// another process holds the lock.
+func lockState(ctx context.Context, b state.Backend, operation string) error {
+	return state.AcquireLock(ctx, b, state.NewLockInfo(operation), lockTimeout)
+}
+
+// loadBackendConfig resolves the backend configuration without connecting to it.
+func loadBackendConfig(ctx context.Context, wd, entryPoint string, evaluator *eval.Evaluator) (*state.BackendConfig, error) {
+	var backend *ir.Backend
+	if path := backendFilePath(wd); fileExists(path) {
+		b, err := evaluator.LoadBackend(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load backend from %s: %w", path, err)
+		}
+		backend = b
+	} else if path := filepath.Join(wd, entryPoint); entryPoint != "" && fileExists(path) {
+		// Input variables may be used in the backend block
+		values, _, err := collectVariables(ctx, wd, evaluator)
+		if err != nil {
+			return nil, err
+		}
+		props := variableProperties(values)
+		props[eval.WorkspaceProperty] = currentWorkspace()
+		b, err := evaluator.LoadConfigBackend(ctx, entryPoint, props)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load backend from %s: %w", entryPoint, err)
+		}
+		backend = b
+	}
+
+	cfg := &state.BackendConfig{Type: "local", Config: map[string]string{}}
+	if backend != nil {
+		if backend.Type != "" {
+			cfg.Type = backend.Type
+		}
+		for k, v := range backend.Config {
+			cfg.Config[k] = v
+		}
+	}
+
+	overrides, err := parseBackendConfigs(backendConfigs)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range overrides {
+		cfg.Config[k] = v
+	}
+	for k, v := range cfg.Config {
+		cfg.Config[k] = expandWorkspace(v)
+	}
+
+	if cfg.Type == "local" {
+		path := cfg.Config["path"]
+		if path == "" {
+			path = WorkspaceStatePath()
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(wd, path)
+		}
+		cfg.Config["path"] = path
+	}
+
+	return cfg, nil
+}
+
+// loadRemoteStates reads the states named by cfg's remoteStates block. They are
+// read without locking and never written.
+func loadRemoteStates(ctx context.Context, wd string, cfg *ir.Config, evaluator *eval.Evaluator) (map[string]*ir.State, error) {
+	if len(cfg.RemoteStates) == 0 {
+		return nil, nil
+	}
+
+	states := make(map[string]*ir.State, len(cfg.RemoteStates))
+	for name, remote := range cfg.RemoteStates {
+		bc, err := remoteBackendConfig(wd, name, remote)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := state.NewBackend(bc, evaluator)
+		if err != nil {
+			return nil, fmt.Errorf("remote state %q: %w", name, err)
+		}
+		s, err := b.Read(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read remote state %q: %w", name, err)
+		}
+		states[name] = s
+	}
+	return states, nil
+}
+
+// remoteBackendConfig resolves the backend configuration of a remote state. A
+// relative local path is taken from the project directory wd.
+func remoteBackendConfig(wd, name string, remote *ir.Backend) (*state.BackendConfig, error) {
+	bc := &state.BackendConfig{Type: "local", Config: map[string]string{}}
+	if remote != nil {
+		if remote.Type != "" {
+			bc.Type = remote.Type
+		}
+		for k, v := range remote.Config {
+			bc.Config[k] = expandWorkspace(v)
+		}
+	}
+	if bc.Type == "local" {
+		path := bc.Config["path"]
+		if path == "" {
+			return nil, fmt.Errorf("remote state %q: local backend requires 'path' configuration", name)
+		}
+		if !filepath.IsAbs(path) {
+			bc.Config["path"] = filepath.Join(wd, path)
+		}
+	}
+	return bc, nil
+}
+
+// parseBackendConfigs turns -backend-config values into settings. Each value is
+// either key=value or the path of a file with one key = value setting per line.
+func parseBackendConfigs(values []string) (map[string]string, error) {
+	settings := make(map[string]string)
+	for _, v := range values {
+		if key, val, ok := strings.Cut(v, "="); ok && !fileExists(v) {
+			settings[strings.TrimSpace(key)] = strings.TrimSpace(val)
+			continue
+		}
+
+		f, err := os.Open(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -backend-config %q: expected key=value or a file: %w", v, err)
+		}
+		scanner := bufio.NewScanner(f)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+				continue
+			}
+			key, val, ok := strings.Cut(line, "=")
+			if !ok {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: expected key = value", v, lineNo)
+			}
+			settings[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(val), `"`)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backend config %s: %w", v, err)
+		}
+	}
+	return settings, nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
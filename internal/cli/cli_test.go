@@ -1,10 +1,24 @@
 package cli
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/picklr-io/picklr/internal/eval"
 	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/pklgen"
+	"github.com/picklr-io/picklr/internal/provider"
+	"github.com/picklr-io/picklr/internal/state"
+	pb "github.com/picklr-io/picklr/pkg/proto/provider"
+	"github.com/picklr-io/picklr/pkg/schemas"
+	"github.com/picklr-io/picklr/providers/mock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFormatPkl(t *testing.T) {
@@ -206,3 +220,497 @@ func testPlanWithProps(action, resourceType, name string, props map[string]any)
 		Summary: &ir.PlanSummary{},
 	}
 }
+
+func TestParseBackendConfigs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "backend.conf")
+	require.NoError(t, os.WriteFile(file, []byte("# staging\nbucket = \"state-bucket\"\nregion = eu-west-1\n"), 0644))
+
+	settings, err := parseBackendConfigs([]string{file, "key=staging/state.pkl", "region=us-east-2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"bucket": "state-bucket",
+		"key":    "staging/state.pkl",
+		"region": "us-east-2",
+	}, settings)
+
+	_, err = parseBackendConfigs([]string{"not-a-setting"})
+	assert.Error(t, err)
+}
+
+func TestLoadBackendConfig_DefaultsToLocal(t *testing.T) {
+	wd := t.TempDir()
+
+	cfg, err := loadBackendConfig(context.Background(), wd, "main.pkl", eval.NewEvaluator(wd))
+	require.NoError(t, err)
+	assert.Equal(t, "local", cfg.Type)
+	assert.Equal(t, filepath.Join(wd, WorkspaceStatePath()), cfg.Config["path"])
+}
+
+func TestLoadBackendConfig_Overrides(t *testing.T) {
+	wd := t.TempDir()
+	backendConfigs = []string{"path=custom/state.pkl"}
+	defer func() { backendConfigs = nil }()
+
+	cfg, err := loadBackendConfig(context.Background(), wd, "main.pkl", eval.NewEvaluator(wd))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(wd, "custom/state.pkl"), cfg.Config["path"])
+}
+
+func TestReplaceProvider(t *testing.T) {
+	s := &ir.State{Resources: []*ir.ResourceState{
+		{Type: "aws:S3.Bucket", Name: "logs", Provider: "aws"},
+		{Type: "null_resource", Name: "a", Provider: "null"},
+		{Type: "aws:EC2.Vpc", Name: "main", Provider: "aws"},
+	}}
+
+	matched := replaceProvider(s, "aws", "")
+	assert.Equal(t, []string{"aws:S3.Bucket.logs", "aws:EC2.Vpc.main"}, matched)
+	assert.Equal(t, "aws", s.Resources[0].Provider, "an empty target only reports matches")
+
+	replaceProvider(s, "aws", "aws.west")
+	assert.Equal(t, "aws.west", s.Resources[0].Provider)
+	assert.Equal(t, "null", s.Resources[1].Provider)
+	assert.Equal(t, "aws.west", s.Resources[2].Provider)
+}
+
+func TestMaskedPlan(t *testing.T) {
+	plan := &ir.Plan{
+		Changes: []*ir.ResourceChange{{
+			Address: "aws:RDS.Instance.db",
+			Action:  "UPDATE",
+			Desired: &ir.Resource{
+				Properties: map[string]any{"master_user_password": "new-secret", "port": 5432},
+				Sensitive:  []string{"master_user_password"},
+			},
+			Diff: map[string]*ir.PropertyDiff{
+				"master_user_password": {Before: "old-secret", After: "new-secret", Action: "update", Sensitive: true},
+				"port":                 {Before: 3306, After: 5432, Action: "update"},
+			},
+		}},
+		Outputs:          map[string]any{"password": "new-secret", "port": 5432},
+		SensitiveOutputs: []string{"password"},
+	}
+
+	masked := maskedPlan(plan)
+	assert.Equal(t, sensitiveMask, masked.Changes[0].Desired.Properties["master_user_password"])
+	assert.Equal(t, sensitiveMask, masked.Changes[0].Diff["master_user_password"].After)
+	assert.Equal(t, 5432, masked.Changes[0].Diff["port"].After)
+	assert.Equal(t, sensitiveMask, masked.Outputs["password"])
+	// The plan itself keeps the real values
+	assert.Equal(t, "new-secret", plan.Changes[0].Diff["master_user_password"].After)
+	assert.Equal(t, "new-secret", plan.Outputs["password"])
+
+	changes := auditChanges(plan)
+	require.Len(t, changes, 1)
+	assert.Equal(t, sensitiveMask, changes[0].Attributes["master_user_password"])
+	assert.Equal(t, 5432, changes[0].Attributes["port"])
+
+	err := redactError(assert.AnError, planSecrets(plan))
+	assert.Equal(t, assert.AnError, err)
+	err = redactError(fmt.Errorf("invalid password new-secret"), planSecrets(plan))
+	assert.EqualError(t, err, "invalid password (sensitive)")
+
+	// Before a plan exists, secrets come from the config, variables and state
+	cfg := &ir.Config{Resources: []*ir.Resource{{
+		Type: "aws:RDS.DBInstance", Name: "db",
+		Properties: map[string]any{"master_user_password": "cfg-secret", "port": 5432},
+		Sensitive:  []string{"master_user_password"},
+	}}}
+	vars := &inputVariables{Values: map[string]any{"token": "var-secret", "pin": "123"}, Sensitive: []string{"pin", "token"}}
+	st := &ir.State{Resources: []*ir.ResourceState{{Inputs: map[string]any{"key": "state-secret"}, Sensitive: []string{"key"}}}}
+	err = redactError(fmt.Errorf("cfg-secret var-secret state-secret 5432 123"), configSecrets(cfg, vars, st))
+	// Values shorter than minSecretLength are not redacted
+	assert.EqualError(t, err, "(sensitive) (sensitive) (sensitive) 5432 123")
+}
+
+func TestMoveStateResource(t *testing.T) {
+	s := &ir.State{Resources: []*ir.ResourceState{
+		{Type: "aws:S3.Bucket", Name: "logs", Provider: "aws"},
+		{Type: "aws:S3.Bucket", Name: "assets", Provider: "aws"},
+	}}
+
+	require.NoError(t, moveStateResource(s, "aws:S3.Bucket.logs", `aws:S3.Bucket.logs["eu"]`))
+	assert.Equal(t, "aws:S3.Bucket", s.Resources[0].Type)
+	assert.Equal(t, `logs["eu"]`, s.Resources[0].Name)
+
+	err := moveStateResource(s, "aws:S3.Bucket.assets", `aws:S3.Bucket.logs["eu"]`)
+	assert.EqualError(t, err, `resource aws:S3.Bucket.logs["eu"] already exists in state`)
+	err = moveStateResource(s, "aws:S3.Bucket.missing", "aws:S3.Bucket.other")
+	assert.EqualError(t, err, "resource aws:S3.Bucket.missing not found in state")
+	err = moveStateResource(s, "aws:S3.Bucket.assets", "aws:S3")
+	assert.Error(t, err)
+
+	// Moving a resource into a module keeps its type and name
+	require.NoError(t, moveStateResource(s, "aws:S3.Bucket.assets", `module.web["prod"].aws:S3.Bucket.assets`))
+	assert.Equal(t, `module.web["prod"]`, s.Resources[1].Module)
+	_, err = findStateResource(s, "aws:S3.Bucket.assets")
+	assert.Error(t, err)
+	require.NoError(t, moveStateResource(s, `module.web["prod"].aws:S3.Bucket.assets`, "aws:S3.Bucket.assets"))
+	assert.Equal(t, "", s.Resources[1].Module)
+}
+
+func TestPolicyApplies(t *testing.T) {
+	change := &ir.ResourceChange{Address: "aws:S3.Bucket.state-prod", Action: "DELETE"}
+
+	assert.True(t, policyApplies(PolicyRule{}, change))
+	assert.True(t, policyApplies(PolicyRule{ResourceType: "aws:S3.Bucket"}, change))
+	assert.False(t, policyApplies(PolicyRule{ResourceType: "aws:EC2.Vpc"}, change))
+	assert.True(t, policyApplies(PolicyRule{Address: "aws:S3.Bucket.state-*"}, change))
+	assert.False(t, policyApplies(PolicyRule{Address: "aws:S3.Bucket.logs"}, change))
+}
+
+func TestLoadRemoteStates(t *testing.T) {
+	wd := t.TempDir()
+	data, err := state.SerializeStateJSON(&ir.State{Version: 1, Serial: 4, Lineage: "net", Outputs: map[string]any{"vpc_id": "vpc-123"}})
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(wd, "network"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(wd, "network", "state.json"), data, 0644))
+
+	cfg := &ir.Config{RemoteStates: map[string]*ir.Backend{
+		"network": {Type: "local", Config: map[string]string{"path": "network/state.json", "encoding": "json"}},
+	}}
+	states, err := loadRemoteStates(context.Background(), wd, cfg, eval.NewEvaluator(wd))
+	require.NoError(t, err)
+	require.Contains(t, states, "network")
+	assert.Equal(t, 4, states["network"].Serial)
+	assert.Equal(t, "vpc-123", states["network"].Outputs["vpc_id"])
+
+	cfg.RemoteStates["network"].Config = map[string]string{}
+	_, err = loadRemoteStates(context.Background(), wd, cfg, eval.NewEvaluator(wd))
+	assert.EqualError(t, err, `remote state "network": local backend requires 'path' configuration`)
+}
+
+func TestConfigProperties(t *testing.T) {
+	props := configProperties(map[string]string{"region": "eu-west-1"})
+	assert.Equal(t, map[string]string{"picklr.workspace": "default", "region": "eu-west-1"}, props)
+}
+
+func TestLoadBackendConfig_WorkspaceKey(t *testing.T) {
+	wd := t.TempDir()
+	backendConfigs = []string{"key=env/${workspace}/state.pkl", "path=state/${workspace}.pkl"}
+	defer func() { backendConfigs = nil }()
+
+	cfg, err := loadBackendConfig(context.Background(), wd, "main.pkl", eval.NewEvaluator(wd))
+	require.NoError(t, err)
+	assert.Equal(t, "env/default/state.pkl", cfg.Config["key"])
+	assert.Equal(t, filepath.Join(wd, "state/default.pkl"), cfg.Config["path"])
+}
+
+func TestCollectVariables(t *testing.T) {
+	t.Setenv("PICKLR_VAR_region", "us-west-2")
+	t.Setenv("PICKLR_VAR_replicas", "2")
+	varValues = []string{"replicas=3", "tags={\"a\":\"b\"}"}
+	defer func() { varValues = nil }()
+
+	values, explicit, err := collectVariables(context.Background(), t.TempDir(), eval.NewEvaluator(t.TempDir()))
+	require.NoError(t, err)
+	assert.Equal(t, "us-west-2", values["region"])
+	assert.Equal(t, "3", values["replicas"])
+	assert.Equal(t, `{"a":"b"}`, values["tags"])
+	assert.False(t, explicit["region"])
+	assert.True(t, explicit["replicas"])
+
+	assert.Equal(t, "3", variableProperties(values)["picklr.var.replicas"])
+
+	varValues = []string{"novalue"}
+	_, _, err = collectVariables(context.Background(), t.TempDir(), eval.NewEvaluator(t.TempDir()))
+	assert.ErrorContains(t, err, "expected name=value")
+}
+
+func TestResolveVariables(t *testing.T) {
+	decls := map[string]*ir.Variable{
+		"region":   {Type: "string", Default: "us-east-1"},
+		"replicas": {Type: "number"},
+		"ha":       {Type: "bool", Default: false},
+		"zones":    {Type: "list", Default: []any{}},
+		"password": {Type: "string", Sensitive: true},
+	}
+
+	vars, err := resolveVariables(decls, map[string]string{
+		"replicas": "3",
+		"ha":       "TRUE",
+		"zones":    `["a","b"]`,
+		"password": "hunter2!",
+		"stray":    "x",
+	}, map[string]bool{"replicas": true})
+	require.NoError(t, err)
+	assert.Equal(t, "us-east-1", vars.Values["region"])
+	assert.Equal(t, 3, vars.Values["replicas"])
+	assert.Equal(t, true, vars.Values["ha"])
+	assert.Equal(t, []any{"a", "b"}, vars.Values["zones"])
+	assert.NotContains(t, vars.Values, "stray")
+	assert.Equal(t, []string{"password"}, vars.Sensitive)
+
+	_, err = resolveVariables(decls, map[string]string{"ha": "yes", "stray": "x"}, map[string]bool{"stray": true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `variable "ha": "yes" is not a bool`)
+	assert.Contains(t, err.Error(), `variable "stray" is not declared`)
+	assert.Contains(t, err.Error(), "no value for required variables: password, replicas")
+}
+
+func TestStackProjectOf(t *testing.T) {
+	projects := map[string]*stackProject{
+		"root":    {Name: "root", Dir: "/stack"},
+		"network": {Name: "network", Dir: "/stack/network", Backend: &state.BackendConfig{Type: "s3", Config: map[string]string{"bucket": "b", "key": "network/state.pkl", "region": "eu-west-1"}}},
+		"data":    {Name: "data", Dir: "/stack/data"},
+	}
+
+	local := &state.BackendConfig{Type: "local", Config: map[string]string{"path": "/stack/data/.picklr/state.pkl"}}
+	assert.Equal(t, "data", stackProjectOf(local, projects))
+	local.Config["path"] = "/elsewhere/state.pkl"
+	assert.Equal(t, "", stackProjectOf(local, projects))
+
+	remote := &state.BackendConfig{Type: "s3", Config: map[string]string{"bucket": "b", "key": "network/state.pkl"}}
+	assert.Equal(t, "network", stackProjectOf(remote, projects))
+	remote.Config["key"] = "other/state.pkl"
+	assert.Equal(t, "", stackProjectOf(remote, projects))
+}
+
+func TestRunStackProjects(t *testing.T) {
+	projects := map[string]*stackProject{
+		"network":  {Name: "network"},
+		"data":     {Name: "data", DependsOn: []string{"network"}},
+		"services": {Name: "services", DependsOn: []string{"data", "network", "data"}},
+		"dns":      {Name: "dns"},
+	}
+	dag, err := stackDAG(projects)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var started []string
+	run := func(fail string) func(context.Context, string) *stackResult {
+		return func(_ context.Context, name string) *stackResult {
+			mu.Lock()
+			started = append(started, name)
+			mu.Unlock()
+			if name == fail {
+				return &stackResult{Status: "failed", Err: fmt.Errorf("boom")}
+			}
+			return &stackResult{Status: "succeeded", Summary: ir.PlanSummary{Create: 1}}
+		}
+	}
+	index := func(name string) int {
+		for i, s := range started {
+			if s == name {
+				return i
+			}
+		}
+		return -1
+	}
+
+	results := runStackProjects(context.Background(), dag, false, 2, run(""))
+	require.Len(t, results, 4)
+	for _, r := range results {
+		assert.Equal(t, "succeeded", r.Status)
+	}
+	assert.Less(t, index("network"), index("data"))
+	assert.Less(t, index("data"), index("services"))
+
+	// Destroy runs dependents first
+	started = nil
+	runStackProjects(context.Background(), dag, true, 1, run(""))
+	assert.Less(t, index("services"), index("data"))
+	assert.Less(t, index("data"), index("network"))
+
+	// A failure stops projects that have not started
+	started = nil
+	results = runStackProjects(context.Background(), dag, false, 1, run("data"))
+	assert.Equal(t, "failed", results["data"].Status)
+	assert.Equal(t, "skipped", results["services"].Status)
+	assert.NotContains(t, started, "services")
+	assert.Error(t, renderStackSummary("plan", dag.CreationOrder(), results))
+
+	_, err = stackDAG(map[string]*stackProject{"a": {Name: "a", DependsOn: []string{"b"}}, "b": {Name: "b", DependsOn: []string{"a"}}})
+	assert.Error(t, err)
+}
+
+func TestCheckAssertions(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(n int) *int { return &n }
+
+	plan := &ir.Plan{
+		Changes: []*ir.ResourceChange{
+			{Address: "aws:S3.Bucket.logs", Action: "CREATE", Desired: &ir.Resource{Type: "aws:S3.Bucket", Name: "logs",
+				Properties: map[string]any{"bucket": "acme-logs", "tags": map[string]any{"env": "test"}, "ports": []any{80, 443}}}},
+			{Address: "aws:S3.Bucket.data", Action: "NOOP", Desired: &ir.Resource{Type: "aws:S3.Bucket", Name: "data"}},
+			{Address: "module.net.aws:EC2.Vpc.main", Action: "CREATE", Desired: &ir.Resource{Type: "aws:EC2.Vpc", Name: "main"}},
+		},
+		Outputs: map[string]any{"bucket": "acme-logs"},
+	}
+
+	passing := []*ir.TestAssertion{
+		{Resource: str("aws:S3.Bucket.logs"), Action: str("create")},
+		{Resource: str("aws:S3.Bucket.logs"), Property: str("tags.env"), Equals: "test"},
+		{Resource: str("aws:S3.Bucket.logs"), Property: str("ports.1"), Equals: int64(443)},
+		{Resource: str("aws:S3.Bucket.other"), Action: str("noop")},
+		{Type: str("aws:S3.Bucket"), Count: num(2)},
+		{Action: str("CREATE"), Count: num(2)},
+		{Resource: str("module.net.*"), Count: num(1)},
+		{Output: str("bucket"), Equals: "acme-logs"},
+	}
+	assert.Empty(t, checkAssertions(passing, plan, nil))
+
+	failing := []*ir.TestAssertion{
+		{Resource: str("aws:S3.Bucket.logs"), Action: str("update")},
+		{Resource: str("aws:S3.Bucket.logs"), Property: str("bucket"), Equals: "other", Message: str("bucket name")},
+		{Resource: str("aws:S3.Bucket.logs"), Property: str("missing")},
+		{Resource: str("aws:S3.Bucket.*")},
+		{Type: str("aws:S3.Bucket"), Count: num(3)},
+		{Output: str("missing")},
+		{},
+	}
+	failures := checkAssertions(failing, plan, nil)
+	require.Len(t, failures, len(failing))
+	assert.Equal(t, "assertion 1: aws:S3.Bucket.logs is planned to create, expected update", failures[0])
+	assert.Equal(t, `assertion 2: bucket name: aws:S3.Bucket.logs bucket is "acme-logs", expected "other"`, failures[1])
+	assert.Contains(t, failures[3], "matches several resources")
+
+	// After an apply, properties come from state and counts from its resources
+	st := &ir.State{
+		Resources: []*ir.ResourceState{
+			{Type: "aws:S3.Bucket", Name: "logs", Inputs: map[string]any{"bucket": "acme-logs"}, Outputs: map[string]any{"arn": "arn:aws:s3:::acme-logs"}},
+		},
+		Outputs: map[string]any{"arn": "arn:aws:s3:::acme-logs"},
+	}
+	applied := []*ir.TestAssertion{
+		{Resource: str("aws:S3.Bucket.logs"), Property: str("arn"), Equals: "arn:aws:s3:::acme-logs"},
+		{Resource: str("aws:S3.Bucket.logs"), Property: str("bucket"), Equals: "acme-logs"},
+		{Type: str("aws:S3.Bucket"), Count: num(1)},
+		{Output: str("arn"), Equals: "arn:aws:s3:::acme-logs"},
+	}
+	assert.Empty(t, checkAssertions(applied, plan, st))
+}
+
+func TestWriteTestResults(t *testing.T) {
+	results := []*testResult{
+		{File: "buckets.test.pkl", Name: "creates the bucket"},
+		{File: "buckets.test.pkl", Name: "tags the bucket", Failures: []string{`assertion 1: tag is "a"`}},
+		{File: "vpc.test.pkl", Name: "load", Err: fmt.Errorf("failed to load config")},
+	}
+
+	var tap strings.Builder
+	require.NoError(t, writeTAP(&tap, results))
+	assert.Equal(t, `TAP version 13
+1..3
+ok 1 - buckets.test.pkl: creates the bucket
+not ok 2 - buckets.test.pkl: tags the bucket
+  ---
+  failures:
+    - "assertion 1: tag is \"a\""
+  duration_ms: 0
+  ...
+not ok 3 - vpc.test.pkl: load
+  ---
+  error: "failed to load config"
+  duration_ms: 0
+  ...
+`, tap.String())
+
+	var junit strings.Builder
+	require.NoError(t, writeJUnit(&junit, results))
+	out := junit.String()
+	assert.Contains(t, out, `<testsuites tests="3" failures="1" errors="1">`)
+	assert.Contains(t, out, `<testsuite name="buckets.test.pkl" tests="2" failures="1" errors="0" time="0.000">`)
+	assert.Contains(t, out, `<failure message="assertion 1: tag is &#34;a&#34;">`)
+	assert.Contains(t, out, `<error message="failed to load config">failed to load config</error>`)
+}
+
+func TestFindTestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.test.pkl", "main.pkl", "sub/b.test.pkl", ".picklr/c.test.pkl"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+	}
+
+	files, err := findTestFiles([]string{dir})
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "a.test.pkl"), filepath.Join(dir, "sub/b.test.pkl")}, files)
+}
+
+func TestUnmockedProviders(t *testing.T) {
+	cfg := &ir.Config{
+		Resources: []*ir.Resource{
+			{Type: "aws:S3.Bucket", Name: "logs", Provider: "aws"},
+			{Type: "null:Resource", Name: "marker", Provider: "null"},
+		},
+	}
+	st := &ir.State{Resources: []*ir.ResourceState{{Type: "docker:Container", Name: "old", Provider: "docker"}}}
+
+	// An apply run against an unmocked aws config fails
+	assert.Equal(t, []string{"aws", "docker"}, unmockedProviders(cfg, st, nil))
+	assert.Equal(t, []string{"docker"}, unmockedProviders(cfg, st, []string{"aws"}))
+
+	registry := provider.NewRegistry()
+	mocked, err := registerMockProviders(registry, []string{allProviders}, nil, cfg, st)
+	require.NoError(t, err)
+	assert.Empty(t, unmockedProviders(cfg, st, mocked))
+}
+
+func TestRegisterMockProviders(t *testing.T) {
+	cfg := &ir.Config{
+		Resources: []*ir.Resource{
+			{Type: "aws:S3.Bucket", Name: "logs", Provider: "aws", Properties: map[string]any{"bucket": "acme-logs"}},
+			{Type: "aws:S3.BucketPolicy", Name: "logs", Provider: "aws", Properties: map[string]any{
+				"bucket": "ptr://aws:S3.Bucket/logs/bucket",
+				"policy": map[string]any{"resource": []any{"ptr://aws:S3.Bucket/logs/arn"}},
+			}},
+			{Type: "null:Resource", Name: "marker", Provider: "null"},
+		},
+	}
+	st := &ir.State{Resources: []*ir.ResourceState{{Type: "docker:Container", Name: "old", Provider: "docker"}}}
+
+	attrs := referencedAttributes(cfg)
+	assert.ElementsMatch(t, []string{"bucket", "arn"}, attrs["aws"]["aws:S3.Bucket"])
+
+	registry := provider.NewRegistry()
+	mocked, err := registerMockProviders(registry, []string{allProviders}, map[string]*ir.MockProvider{
+		"aws": {Outputs: map[string]map[string]any{"aws:S3.Bucket": {"region": "eu-west-1"}}},
+	}, cfg, st)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aws", "docker"}, mocked)
+
+	p, err := registry.Get("aws")
+	require.NoError(t, err)
+	resp, err := p.Apply(context.Background(), &pb.ApplyRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: []byte(`{"bucket":"acme-logs"}`)})
+	require.NoError(t, err)
+	// Schema defaults fill in the properties the request leaves out
+	assert.JSONEq(t, `{"bucket":"acme-logs","acl":"private","force_destroy":false,"arn":"mock-aws-logs-arn","region":"eu-west-1","id":"mock-aws-logs"}`, string(resp.NewStateJson))
+
+	// The null provider is only mocked when named
+	_, err = registry.Get("null")
+	assert.Error(t, err)
+	mocked, err = registerMockProviders(provider.NewRegistry(), []string{"null"}, nil, cfg, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"null"}, mocked)
+}
+
+func TestImportResource(t *testing.T) {
+	providerSchemas, err := pklgen.LoadSchemas(schemas.Providers)
+	require.NoError(t, err)
+	registry := provider.NewRegistry()
+	registry.Register("aws", mock.New("aws", map[string]map[string]any{
+		"aws:S3.Bucket": {"bucket": "${name}", "acl": "private", "arn": "arn:aws:s3:::${name}"},
+	}))
+
+	res, err := importResource(context.Background(), registry, providerSchemas, "aws:S3.Bucket.logs", "acme-logs")
+	require.NoError(t, err)
+	assert.Equal(t, "aws:S3.Bucket", res.Type)
+	assert.Equal(t, "logs", res.Name)
+	assert.Equal(t, "aws", res.Provider)
+	assert.Equal(t, "arn:aws:s3:::acme-logs", res.Outputs["arn"])
+	// Inputs hold the attributes of the class properties, not computed outputs
+	assert.Equal(t, map[string]any{"bucket": "acme-logs", "acl": "private"}, res.Inputs)
+}
+
+func TestSchemaBase(t *testing.T) {
+	wd := t.TempDir()
+	assert.Equal(t, "../../pkg/schemas/", schemaBase(wd, filepath.Join(wd, "imported.pkl")), "default without main.pkl")
+
+	require.NoError(t, os.WriteFile(filepath.Join(wd, "main.pkl"), []byte("amends \"../schemas/Config.pkl\"\n"), 0644))
+	assert.Equal(t, "../schemas/", schemaBase(wd, filepath.Join(wd, "imported.pkl")))
+	assert.Equal(t, "../../schemas/", schemaBase(wd, filepath.Join(wd, "generated", "imported.pkl")))
+
+	require.NoError(t, os.WriteFile(filepath.Join(wd, "main.pkl"), []byte("amends \"@picklr/Config.pkl\"\n"), 0644))
+	assert.Equal(t, "@picklr/", schemaBase(wd, filepath.Join(wd, "generated", "imported.pkl")))
+}
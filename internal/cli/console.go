@@ -5,11 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/picklr-io/picklr/internal/addrs"
 	"github.com/picklr-io/picklr/internal/eval"
-	"github.com/picklr-io/picklr/internal/state"
+	"github.com/picklr-io/picklr/internal/ir"
 	"github.com/spf13/cobra"
 )
 
@@ -26,11 +27,16 @@ Available commands:
   resource <addr>    Show a specific resource
   output <name>      Show a specific output
   config             Show current config summary
+  variables          Show input variable values
   help               Show available commands
   exit / quit        Exit the console`,
 	RunE: runConsole,
 }
 
+func init() {
+	addVariableFlags(consoleCmd)
+}
+
 func runConsole(cmd *cobra.Command, args []string) error {
 	wd, err := os.Getwd()
 	if err != nil {
@@ -39,17 +45,31 @@ func runConsole(cmd *cobra.Command, args []string) error {
 
 	ctx := cmd.Context()
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+	stateMgr, err := openStateBackend(ctx, wd, "main.pkl", evaluator)
+	if err != nil {
+		return err
+	}
 
 	currentState, err := stateMgr.Read(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to read state: %w", err)
 	}
+	// The console only displays state, so sensitive values are masked up front
+	currentState = maskedState(currentState)
 
 	// Try to load config
-	cfg, _ := evaluator.LoadConfig(ctx, "main.pkl", nil)
+	var cfg *ir.Config
+	var vars *inputVariables
+	props := configProperties(nil)
+	if vars, err = loadVariables(ctx, wd, "main.pkl", evaluator, props); err == nil {
+		cfg, _ = evaluator.LoadConfig(ctx, "main.pkl", props)
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: configuration not loaded: %v\n", err)
+	}
 
 	fmt.Println("Picklr Console (type 'help' for commands, 'exit' to quit)")
+	fmt.Printf("Workspace: %s\n", currentWorkspace())
 	fmt.Printf("State: %d resources, serial %d\n", len(currentState.Resources), currentState.Serial)
 	if cfg != nil {
 		fmt.Printf("Config: %d resources defined\n", len(cfg.Resources))
@@ -84,6 +104,7 @@ func runConsole(cmd *cobra.Command, args []string) error {
 			fmt.Println("  output <name>      - Show a specific output")
 			fmt.Println("  config             - Show config summary")
 			fmt.Println("  config.resources   - List all resources in config")
+			fmt.Println("  variables          - Show input variable values")
 			fmt.Println("  json <expression>  - Output as JSON")
 			fmt.Println("  exit / quit        - Exit the console")
 
@@ -120,7 +141,7 @@ func runConsole(cmd *cobra.Command, args []string) error {
 			addr := parts[1]
 			found := false
 			for _, res := range currentState.Resources {
-				resAddr := fmt.Sprintf("%s.%s", res.Type, res.Name)
+				resAddr := addrs.OfModule(res.Module, res.Type, res.Name).String()
 				if resAddr == addr {
 					data, _ := json.MarshalIndent(res, "", "  ")
 					fmt.Println(string(data))
@@ -163,6 +184,20 @@ func runConsole(cmd *cobra.Command, args []string) error {
 				}
 			}
 
+		case "variables":
+			if vars == nil || len(vars.Values) == 0 {
+				fmt.Println("No input variables.")
+			} else {
+				names := make([]string, 0, len(vars.Values))
+				for name := range vars.Values {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					fmt.Printf("  %s = %s\n", name, formatAttribute(name, vars.Values[name], vars.Sensitive))
+				}
+			}
+
 		case "json":
 			if len(parts) < 2 {
 				fmt.Println("Usage: json <expression>")
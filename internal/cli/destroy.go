@@ -10,7 +10,6 @@ import (
 	"github.com/picklr-io/picklr/internal/eval"
 	"github.com/picklr-io/picklr/internal/ir"
 	"github.com/picklr-io/picklr/internal/provider"
-	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
@@ -26,7 +25,8 @@ var destroyCmd = &cobra.Command{
 	Long: `Destroys all resources managed by Picklr.
 
 This command is the inverse of 'picklr apply'. It will delete all resources
-tracked in the state file.`,
+tracked in the state file. Input variables are used when evaluating the
+backend block.`,
 	RunE: runDestroy,
 }
 
@@ -34,6 +34,7 @@ func init() {
 	destroyCmd.Flags().BoolVar(&destroyAutoApprove, "auto-approve", false, "Skip interactive approval before destroying")
 	destroyCmd.Flags().BoolVar(&destroyJSON, "json", false, "Output in JSON format")
 	destroyCmd.Flags().StringVar(&destroyOnError, "on-error", "fail", "Error handling mode: 'fail' (stop on first error) or 'continue' (apply remaining resources)")
+	addVariableFlags(destroyCmd)
 }
 
 func runDestroy(cmd *cobra.Command, args []string) error {
@@ -41,6 +42,7 @@ func runDestroy(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to get working directory: %w", err)
 	}
+	entryPoint := "main.pkl"
 
 	if len(args) > 0 {
 		absPath, err := filepath.Abs(args[0])
@@ -55,6 +57,7 @@ func runDestroy(cmd *cobra.Command, args []string) error {
 			wd = absPath
 		} else {
 			wd = filepath.Dir(absPath)
+			entryPoint = filepath.Base(absPath)
 		}
 	}
 
@@ -62,13 +65,17 @@ func runDestroy(cmd *cobra.Command, args []string) error {
 
 	// 1. Initialize components
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+	stateMgr, err := openStateBackend(ctx, wd, entryPoint, evaluator)
+	if err != nil {
+		return err
+	}
 	registry := provider.NewRegistry()
 	eng := engine.NewEngine(registry)
 	eng.ContinueOnError = destroyOnError == "continue"
 
 	// 2. Lock state
-	if err := stateMgr.Lock(); err != nil {
+	if err := lockState(ctx, stateMgr, "destroy"); err != nil {
 		return err
 	}
 	defer stateMgr.Unlock()
@@ -109,12 +116,12 @@ func runDestroy(cmd *cobra.Command, args []string) error {
 	if !destroyJSON {
 		fmt.Print("Calculating destroy plan... ")
 	}
-	plan, err := eng.CreatePlanWithTargets(ctx, emptyCfg, currentState, targets)
+	plan, err := eng.CreatePlanWithOptions(ctx, emptyCfg, currentState, planOptions())
 	if err != nil {
 		if !destroyJSON {
 			fmt.Println("FAILED")
 		}
-		return fmt.Errorf("destroy plan failed: %w", err)
+		return redactError(fmt.Errorf("destroy plan failed: %w", err), stateSecrets(currentState))
 	}
 	if !destroyJSON {
 		fmt.Println("OK")
@@ -150,6 +157,8 @@ func runDestroy(cmd *cobra.Command, args []string) error {
 		fmt.Printf("\nDestroying %d resources...\n", len(plan.Changes))
 	}
 
+	// Secrets are redacted from errors, which may quote resource attributes
+	secrets := stateSecrets(currentState)
 	callback := func(event engine.ApplyEvent) {
 		if destroyJSON {
 			return
@@ -160,14 +169,14 @@ func runDestroy(cmd *cobra.Command, args []string) error {
 		case "completed":
 			fmt.Printf("%s%s: Destruction complete after %s%s\n", colorize("\033[31m"), event.Address, event.Duration.Round(time.Millisecond), colorize("\033[0m"))
 		case "failed":
-			fmt.Printf("%s%s: FAILED (%v)%s\n", colorize("\033[31m"), event.Address, event.Error, colorize("\033[0m"))
+			fmt.Printf("%s%s: FAILED (%v)%s\n", colorize("\033[31m"), event.Address, redactError(event.Error, secrets), colorize("\033[0m"))
 		}
 	}
 
 	newState, err := eng.ApplyPlanWithCallback(ctx, plan, currentState, callback)
 	if err != nil {
 		_ = stateMgr.Write(ctx, currentState)
-		return fmt.Errorf("destroy failed: %w", err)
+		return redactError(fmt.Errorf("destroy failed: %w", err), secrets)
 	}
 
 	// 8. Write state
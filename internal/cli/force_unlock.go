@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/spf13/cobra"
+)
+
+var forceUnlockForce bool
+
+var forceUnlockCmd = &cobra.Command{
+	Use:   "force-unlock <lock-id>",
+	Short: "Release a stuck state lock",
+	Long: `Releases the state lock with the given ID, regardless of which process holds it.
+
+The lock ID is shown in the error reported when a command cannot acquire the lock.
+Only use this if the process holding the lock is no longer running; releasing a
+lock that is still in use can corrupt state.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runForceUnlock,
+}
+
+func init() {
+	forceUnlockCmd.Flags().BoolVar(&forceUnlockForce, "force", false, "Don't ask for confirmation")
+}
+
+func runForceUnlock(cmd *cobra.Command, args []string) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	ctx := cmd.Context()
+	evaluator := eval.NewEvaluator(wd)
+	defer evaluator.Close()
+	stateMgr, err := openStateBackend(ctx, wd, "main.pkl", evaluator)
+	if err != nil {
+		return err
+	}
+
+	lockID := args[0]
+	if !forceUnlockForce {
+		fmt.Printf("Release state lock %s? Other processes may be using it. (y/n): ", lockID)
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "yes" {
+			fmt.Println("Force-unlock cancelled.")
+			return nil
+		}
+	}
+
+	if err := stateMgr.ForceUnlock(lockID); err != nil {
+		return fmt.Errorf("failed to force-unlock: %w", err)
+	}
+
+	fmt.Printf("State lock %s has been released.\n", lockID)
+	return nil
+}
@@ -20,6 +20,10 @@ in Graphviz DOT format. Pipe the output to 'dot' to generate an image:
 	RunE: runGraph,
 }
 
+func init() {
+	addVariableFlags(graphCmd)
+}
+
 func runGraph(cmd *cobra.Command, args []string) error {
 	wd, err := os.Getwd()
 	if err != nil {
@@ -46,12 +50,21 @@ func runGraph(cmd *cobra.Command, args []string) error {
 
 	ctx := cmd.Context()
 	evaluator := eval.NewEvaluator(wd)
+	defer evaluator.Close()
 
-	cfg, err := evaluator.LoadConfig(ctx, entryPoint, nil)
+	props := configProperties(nil)
+	if _, err := loadVariables(ctx, wd, entryPoint, evaluator, props); err != nil {
+		return err
+	}
+	cfg, err := evaluator.LoadConfig(ctx, entryPoint, props)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	if err := engine.ExpandModules(cfg); err != nil {
+		return err
+	}
+	cfg.Resources = engine.ExpandForEach(cfg.Resources)
 	dag, err := engine.BuildDAG(cfg.Resources)
 	if err != nil {
 		return fmt.Errorf("failed to build graph: %w", err)
@@ -63,9 +76,29 @@ func runGraph(cmd *cobra.Command, args []string) error {
 	fmt.Println("  node [shape = rect];")
 	fmt.Println()
 
+	// Resources of a module instance are grouped in a cluster
+	var modules []string
+	byModule := make(map[string][]string)
 	for _, res := range cfg.Resources {
 		addr := engine.ResourceAddrPublic(res)
-		fmt.Printf("  %q;\n", addr)
+		if _, ok := byModule[res.Module]; !ok {
+			modules = append(modules, res.Module)
+		}
+		byModule[res.Module] = append(byModule[res.Module], addr)
+	}
+	for _, module := range modules {
+		if module == "" {
+			for _, addr := range byModule[module] {
+				fmt.Printf("  %q;\n", addr)
+			}
+			continue
+		}
+		fmt.Printf("  subgraph %q {\n", "cluster_"+module)
+		fmt.Printf("    label = %q;\n", module)
+		for _, addr := range byModule[module] {
+			fmt.Printf("    %q;\n", addr)
+		}
+		fmt.Println("  }")
 	}
 	fmt.Println()
 
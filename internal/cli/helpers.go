@@ -8,6 +8,8 @@ import (
 	"os"
 	"sort"
 
+	"github.com/picklr-io/picklr/internal/addrs"
+	"github.com/picklr-io/picklr/internal/engine"
 	"github.com/picklr-io/picklr/internal/ir"
 	pb "github.com/picklr-io/picklr/pkg/proto/provider"
 	"github.com/picklr-io/picklr/internal/provider"
@@ -76,6 +78,9 @@ func renderPlanChanges(plan *ir.Plan) {
 		}
 
 		fmt.Printf("\n%s  # %s will be %s%s\n", color, change.Address, change.Action, reset)
+		if change.Reason != "" {
+			fmt.Printf("%s  # (%s)%s\n", color, change.Reason, reset)
+		}
 		fmt.Printf("%s  %s resource \"%s\" \"%s\" {\n", color, symbol, resourceType, resourceName)
 
 		// Render property diffs if available
@@ -85,14 +90,15 @@ func renderPlanChanges(plan *ir.Plan) {
 			// Fall back to showing desired properties for CREATE, or prior for DELETE
 			if change.Action == "CREATE" && change.Desired != nil {
 				for k, v := range change.Desired.Properties {
-					fmt.Printf("%s      + %s = %v\n", color, k, formatValue(v))
+					fmt.Printf("%s      + %s = %v\n", color, k, formatAttribute(k, v, change.Desired.Sensitive))
 				}
 			} else if change.Action == "DELETE" && change.Prior != nil {
 				for k, v := range change.Prior.Properties {
-					fmt.Printf("%s      - %s = %v\n", color, k, formatValue(v))
+					fmt.Printf("%s      - %s = %v\n", color, k, formatAttribute(k, v, change.Prior.Sensitive))
 				}
 			} else if change.Desired != nil && change.Prior != nil {
-				renderInlineDiff(change.Prior.Properties, change.Desired.Properties, color)
+				renderInlineDiff(maskAttributes(change.Prior.Properties, change.Prior.Sensitive),
+					maskAttributes(change.Desired.Properties, change.Desired.Sensitive), color)
 			} else {
 				fmt.Printf("%s      ...\n", color)
 			}
@@ -106,7 +112,7 @@ func renderPropertyDiff(change *ir.ResourceChange, color string) {
 	for key, diff := range change.Diff {
 		val := func(v any) string {
 			if diff.Sensitive {
-				return "(sensitive)"
+				return sensitiveMask
 			}
 			return formatValue(v)
 		}
@@ -182,11 +188,24 @@ func renderPlanSummary(plan *ir.Plan) {
 	fmt.Printf("  Delete:  %d\n", plan.Summary.Delete)
 	fmt.Printf("  Replace: %d\n", plan.Summary.Replace)
 	fmt.Printf("  NoOp:    %d\n", plan.Summary.NoOp)
+
+	for _, w := range plan.Warnings {
+		fmt.Printf("\n%sWarning:%s %s\n", colorize("\033[33m"), colorize("\033[0m"), w)
+	}
+}
+
+// planOptions returns the resource targeting options from the global flags.
+func planOptions() engine.PlanOptions {
+	return engine.PlanOptions{
+		Targets:           targets,
+		Excludes:          excludes,
+		IncludeDependents: includeDependents,
+	}
 }
 
-// renderPlanJSON outputs the plan as structured JSON.
+// renderPlanJSON outputs the plan as structured JSON, with sensitive values masked.
 func renderPlanJSON(plan *ir.Plan, w io.Writer) error {
-	data, err := json.MarshalIndent(plan, "", "  ")
+	data, err := json.MarshalIndent(maskedPlan(plan), "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal plan: %w", err)
 	}
@@ -203,7 +222,7 @@ func renderApplyResultJSON(plan *ir.Plan, state *ir.State, w io.Writer) error {
 			"delete":  plan.Summary.Delete,
 			"replace": plan.Summary.Replace,
 		},
-		"outputs":   state.Outputs,
+		"outputs":   maskAttributes(state.Outputs, state.SensitiveOutputs),
 		"resources": len(state.Resources),
 	}
 	data, err := json.MarshalIndent(result, "", "  ")
@@ -262,7 +281,7 @@ func refreshStateInPlace(ctx context.Context, state *ir.State, registry *provide
 	var drifted []DriftChange
 
 	for _, res := range state.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
+		addr := addrs.OfModule(res.Module, res.Type, res.Name).String()
 		prov, err := registry.Get(res.Provider)
 		if err != nil {
 			continue
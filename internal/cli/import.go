@@ -1,132 +1,253 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 
+	"github.com/picklr-io/picklr/internal/addrs"
 	"github.com/picklr-io/picklr/internal/eval"
 	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/pklgen"
 	"github.com/picklr-io/picklr/internal/provider"
-	"github.com/picklr-io/picklr/internal/state"
 	pb "github.com/picklr-io/picklr/pkg/proto/provider"
+	"github.com/picklr-io/picklr/pkg/schemas"
 	"github.com/spf13/cobra"
 )
 
+var importGenerateConfig string
+
 var importCmd = &cobra.Command{
-	Use:   "import <resource-address> <cloud-id>",
+	Use:   "import [<resource-address> <cloud-id>]",
 	Short: "Import existing infrastructure into Picklr state",
-	Long: `Import an existing resource into the Picklr state file.
+	Long: `Import existing resources into the Picklr state file.
+
+Each resource is read from its provider and added to the state so that Picklr
+will manage it going forward. Its inputs are set from the attributes the
+provider schema maps to PKL class properties. Without arguments, the resources
+listed in the imports block of main.pkl are imported, skipping any already in
+state.
 
-This does not generate configuration - you must write the corresponding
-PKL configuration manually. It only adds the resource to the state so
-that Picklr will manage it going forward.
+With --generate-config, the configuration of the imported resources is written
+to a new PKL file, to be imported by main.pkl.
 
 Example:
-  picklr import aws:S3.Bucket.my-bucket my-bucket-name`,
-	Args: cobra.ExactArgs(2),
+  picklr import aws:S3.Bucket.my-bucket my-bucket-name
+  picklr import --generate-config imported.pkl`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 && len(args) != 2 {
+			return fmt.Errorf("accepts a resource address and cloud ID, or no arguments to import the imports block, received %d", len(args))
+		}
+		return nil
+	},
 	RunE: runImport,
 }
 
-func runImport(cmd *cobra.Command, args []string) error {
-	addr := args[0]
-	cloudID := args[1]
-
-	// Parse address: type.name
-	parts := strings.SplitN(addr, ".", 2)
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid resource address %q, expected format type.name", addr)
-	}
-	resourceType := parts[0]
-	resourceName := parts[1]
-
-	// Determine provider from type
-	providerName := "null"
-	if strings.Contains(resourceType, ":") {
-		providerName = strings.SplitN(resourceType, ":", 2)[0]
-	} else if strings.HasPrefix(resourceType, "docker_") {
-		providerName = "docker"
-	}
+func init() {
+	importCmd.Flags().StringVar(&importGenerateConfig, "generate-config", "", "Write the configuration of the imported resources to a new PKL file")
+	addVariableFlags(importCmd)
+}
 
+func runImport(cmd *cobra.Command, args []string) error {
 	wd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get working directory: %w", err)
 	}
+	if importGenerateConfig != "" && fileExists(importGenerateConfig) {
+		return fmt.Errorf("%s already exists; choose a new file for the generated configuration", importGenerateConfig)
+	}
 
 	ctx := cmd.Context()
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+
+	// Resources to import, from the arguments or the imports block
+	bulk := len(args) == 0
+	var requests []*ir.Import
+	if bulk {
+		props := configProperties(nil)
+		if _, err := loadVariables(ctx, wd, "main.pkl", evaluator, props); err != nil {
+			return err
+		}
+		cfg, err := evaluator.LoadConfig(ctx, "main.pkl", props)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		if len(cfg.Imports) == 0 {
+			return fmt.Errorf("no resource address given and main.pkl has no imports")
+		}
+		requests = cfg.Imports
+	} else {
+		requests = []*ir.Import{{To: args[0], ID: args[1]}}
+	}
+
+	stateMgr, err := openStateBackend(ctx, wd, "main.pkl", evaluator)
+	if err != nil {
+		return err
+	}
 	registry := provider.NewRegistry()
+	providerSchemas, err := pklgen.LoadSchemas(schemas.Providers)
+	if err != nil {
+		return fmt.Errorf("failed to load provider schemas: %w", err)
+	}
 
 	// Lock state
-	if err := stateMgr.Lock(); err != nil {
+	if err := lockState(ctx, stateMgr, "import"); err != nil {
 		return err
 	}
 	defer stateMgr.Unlock()
 
+	// Read current state
+	currentState, err := stateMgr.Read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+
+	var generated []*pklgen.Resource
+	var unsupported []string
+	count := 0
+	for _, req := range requests {
+		// Check for duplicate
+		if _, err := findStateResource(currentState, req.To); err == nil {
+			if bulk {
+				fmt.Printf("%s is already in state, skipping\n", req.To)
+				continue
+			}
+			return fmt.Errorf("resource %s already exists in state", req.To)
+		}
+
+		fmt.Printf("Importing %s (id: %s)...\n", req.To, req.ID)
+		res, err := importResource(ctx, registry, providerSchemas, req.To, req.ID)
+		if err != nil {
+			return redactError(err, stateSecrets(currentState))
+		}
+		currentState.Resources = append(currentState.Resources, res)
+		count++
+
+		if class := providerSchemas.Class(res.Type); class != nil && class.Collection != "" {
+			generated = append(generated, &pklgen.Resource{Name: res.Name, Class: class, Outputs: res.Outputs})
+		} else {
+			unsupported = append(unsupported, req.To)
+		}
+	}
+	if count == 0 {
+		fmt.Println("Nothing to import.")
+		return nil
+	}
+
+	// Write state
+	if err := stateMgr.Write(ctx, currentState); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	fmt.Printf("Successfully imported %d resource(s)\n", count)
+
+	if importGenerateConfig == "" {
+		fmt.Println("Note: You must also write the corresponding PKL configuration, or use --generate-config.")
+		return nil
+	}
+	if len(generated) == 0 {
+		fmt.Printf("No PKL class is known for the imported resources; %s was not written.\n", importGenerateConfig)
+		return nil
+	}
+	module := strings.TrimSuffix(filepath.Base(importGenerateConfig), ".pkl")
+	data := pklgen.Generate(generated, schemaBase(wd, importGenerateConfig), module)
+	if err := os.WriteFile(importGenerateConfig, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", importGenerateConfig, err)
+	}
+	fmt.Printf("Configuration written to %s\n", importGenerateConfig)
+	for _, addr := range unsupported {
+		fmt.Printf("Note: no PKL class is known for %s; write its configuration by hand.\n", addr)
+	}
+	return nil
+}
+
+// importResource reads an existing resource from its provider and returns its
+// state. Its inputs are the attributes its PKL class maps to properties, so that
+// configuration matching the resource plans no changes.
+func importResource(ctx context.Context, registry *provider.Registry, providerSchemas *pklgen.Schemas, addr, cloudID string) (*ir.ResourceState, error) {
+	target, err := addrs.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	resourceType := target.FullType()
+
+	// Determine provider from type
+	providerName := "null"
+	if target.Provider != "" {
+		providerName = target.ProviderName()
+	} else if strings.HasPrefix(resourceType, "docker_") {
+		providerName = "docker"
+	}
+
 	// Load provider
 	if err := registry.LoadProvider(providerName); err != nil {
-		return fmt.Errorf("failed to load provider %s: %w", providerName, err)
+		return nil, fmt.Errorf("failed to load provider %s: %w", providerName, err)
 	}
-
 	prov, err := registry.Get(providerName)
 	if err != nil {
-		return fmt.Errorf("provider not available: %w", err)
+		return nil, fmt.Errorf("provider not available: %w", err)
 	}
 
 	// Read state from cloud
-	fmt.Printf("Importing %s (id: %s)...\n", addr, cloudID)
 	resp, err := prov.Read(ctx, &pb.ReadRequest{
 		Type: resourceType,
 		Id:   cloudID,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to read resource from provider: %w", err)
+		return nil, fmt.Errorf("failed to read %s from provider: %w", addr, err)
 	}
-
 	if !resp.Exists {
-		return fmt.Errorf("resource %s with id %s does not exist", resourceType, cloudID)
+		return nil, fmt.Errorf("resource %s with id %s does not exist", resourceType, cloudID)
 	}
 
 	// Parse outputs
 	var outputs map[string]any
 	if len(resp.NewStateJson) > 0 {
 		if err := json.Unmarshal(resp.NewStateJson, &outputs); err != nil {
-			return fmt.Errorf("failed to parse provider response: %w", err)
+			return nil, fmt.Errorf("failed to parse provider response: %w", err)
 		}
 	}
 
-	// Read current state
-	currentState, err := stateMgr.Read(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to read state: %w", err)
-	}
-
-	// Check for duplicate
-	for _, res := range currentState.Resources {
-		if res.Type == resourceType && res.Name == resourceName {
-			return fmt.Errorf("resource %s already exists in state", addr)
-		}
+	inputs := map[string]any{}
+	if class := providerSchemas.Class(resourceType); class != nil {
+		inputs = class.Inputs(outputs)
 	}
-
-	// Add to state
-	currentState.Resources = append(currentState.Resources, &ir.ResourceState{
+	return &ir.ResourceState{
 		Type:     resourceType,
-		Name:     resourceName,
+		Name:     target.InstanceName(),
 		Provider: providerName,
-		Inputs:   map[string]any{},
+		Module:   target.ModulePath(),
+		Inputs:   inputs,
 		Outputs:  outputs,
-	})
-	currentState.Serial++
+	}, nil
+}
 
-	// Write state
-	if err := stateMgr.Write(ctx, currentState); err != nil {
-		return fmt.Errorf("failed to write state: %w", err)
-	}
+var amendsConfigRe = regexp.MustCompile(`(?m)^amends "(.*)Config\.pkl"`)
 
-	fmt.Printf("Successfully imported %s\n", addr)
-	fmt.Println("Note: You must also write the corresponding PKL configuration for this resource.")
-	return nil
+// schemaBase returns the path or URI that the configuration amends Config.pkl
+// from, without the file name, as seen from the generated file at out. A relative
+// path is rebased onto the directory of out.
+func schemaBase(wd, out string) string {
+	base := "../../pkg/schemas/"
+	if data, err := os.ReadFile(filepath.Join(wd, "main.pkl")); err == nil {
+		if m := amendsConfigRe.FindSubmatch(data); m != nil {
+			base = string(m[1])
+		}
+	}
+	if base == "" || strings.Contains(base, ":") || strings.HasPrefix(base, "@") || filepath.IsAbs(base) {
+		return base
+	}
+	absOut, err := filepath.Abs(out)
+	if err != nil {
+		return base
+	}
+	rel, err := filepath.Rel(filepath.Dir(absOut), filepath.Join(wd, base))
+	if err != nil {
+		return base
+	}
+	return filepath.ToSlash(rel) + "/"
 }
@@ -1,19 +1,34 @@
 package cli
 
 import (
-	"crypto/rand"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/registry"
+	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
+var initUpgrade bool
+
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize a new Picklr project",
-	Long:  `Creates a new Picklr project with default configuration files.`,
-	RunE:  runInit,
+	Long: `Creates a new Picklr project with default configuration files.
+
+If the PklProject depends on PKL packages, they are resolved, vendored into
+.picklr/cache and recorded with their checksums in PklProject.deps.json. Later
+runs install the recorded versions and verify them against the recorded
+checksums. plan and apply refuse to run if the vendored packages don't match
+PklProject.deps.json or were edited since they were installed.`,
+	RunE: runInit,
+}
+
+func init() {
+	initCmd.Flags().BoolVar(&initUpgrade, "upgrade", false, "Upgrade package dependencies to the newest versions their declared versions allow")
 }
 
 func runInit(cmd *cobra.Command, args []string) error {
@@ -51,7 +66,7 @@ outputs {
 	// Create empty state file with UUID lineage
 	statePath := filepath.Join(".picklr", "state.pkl")
 	if _, err := os.Stat(statePath); os.IsNotExist(err) {
-		lineage := generateUUID()
+		lineage := state.NewLineage()
 		content := fmt.Sprintf(`// Picklr state file - DO NOT EDIT MANUALLY unless you know what you're doing
 amends "picklr:State"
 
@@ -69,6 +84,10 @@ outputs {}
 		fmt.Printf("Created %s\n", statePath)
 	}
 
+	if err := installModules(cmd.Context(), "."); err != nil {
+		return err
+	}
+
 	fmt.Println("\nPicklr initialized successfully!")
 	fmt.Println("Next steps:")
 	fmt.Println("  1. Edit main.pkl to define your infrastructure")
@@ -78,12 +97,74 @@ outputs {}
 	return nil
 }
 
-// generateUUID generates a v4 UUID without external dependencies.
-func generateUUID() string {
-	b := make([]byte, 16)
-	rand.Read(b)
-	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
-	b[8] = (b[8] & 0x3f) | 0x80 // Variant 10
-	return fmt.Sprintf("%08x-%04x-%04x-%04x-%12x",
-		b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+// installModules resolves the package dependencies declared in dir's PklProject,
+// if any, vendors them into the package cache and records them in
+// PklProject.deps.json.
+func installModules(ctx context.Context, dir string) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	evaluator := eval.NewEvaluator(absDir)
+	defer evaluator.Close()
+	project, err := evaluator.Project(ctx)
+	if err != nil || project == nil {
+		return err
+	}
+	remote, local, err := registry.Declared(absDir, project.Dependencies())
+	if err != nil {
+		return err
+	}
+	depsPath := filepath.Join(absDir, registry.DepsFile)
+	if len(remote) == 0 && len(local) == 0 && !fileExists(depsPath) {
+		return nil
+	}
+
+	deps, err := registry.ReadDeps(depsPath)
+	if err != nil {
+		return err
+	}
+	installer := &registry.Installer{
+		CacheDir: filepath.Join(absDir, eval.PackageCacheDir),
+		Deps:     deps,
+		Upgrade:  initUpgrade,
+		Client:   registry.NewClient(),
+	}
+	installed, err := installer.Install(ctx, remote, local)
+	if err != nil {
+		return fmt.Errorf("failed to install packages: %w", err)
+	}
+	for _, p := range installed {
+		if p.Downloaded {
+			fmt.Printf("Installed package %s from %s\n", p.Name, p.URI)
+		} else {
+			fmt.Printf("Using cached package %s from %s\n", p.Name, p.URI)
+		}
+	}
+	return deps.Write(depsPath)
+}
+
+// verifyModules checks that the package dependencies of the project in dir are
+// resolved in PklProject.deps.json and vendored unchanged in the package cache.
+func verifyModules(ctx context.Context, dir string, evaluator *eval.Evaluator) error {
+	project, err := evaluator.Project(ctx)
+	if err != nil || project == nil {
+		return err
+	}
+	remote, _, err := registry.Declared(dir, project.Dependencies())
+	if err != nil || len(remote) == 0 {
+		return err
+	}
+	depsPath := filepath.Join(dir, registry.DepsFile)
+	if !fileExists(depsPath) {
+		return fmt.Errorf("PklProject declares package dependencies but %s is missing; run picklr init", registry.DepsFile)
+	}
+	deps, err := registry.ReadDeps(depsPath)
+	if err != nil {
+		return err
+	}
+	if err := registry.Verify(filepath.Join(dir, eval.PackageCacheDir), deps, remote); err != nil {
+		return fmt.Errorf("%w\nrun picklr init to reinstall them", err)
+	}
+	return nil
 }
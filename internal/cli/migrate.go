@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
@@ -98,7 +99,7 @@ func runMigrate(cmd *cobra.Command, args []string) error {
 
 	lineage := tfState.Lineage
 	if lineage == "" {
-		lineage = generateUUID()
+		lineage = state.NewLineage()
 	}
 
 	fmt.Fprintf(f, "// Picklr state file - migrated from Terraform\n")
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/addrs"
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/pklgen"
+	"github.com/picklr-io/picklr/internal/provider"
+	"github.com/picklr-io/picklr/pkg/schemas"
+	"github.com/picklr-io/picklr/providers/mock"
+	"github.com/spf13/cobra"
+)
+
+// allProviders is the value of a bare --mock-providers, which mocks every provider
+// but null.
+const allProviders = "*"
+
+var (
+	// mockProviderNames holds the --mock-providers names, or allProviders.
+	mockProviderNames []string
+	// mockFixtures is the --mock-fixtures file.
+	mockFixtures string
+)
+
+// addMockFlags registers --mock-providers and --mock-fixtures on a command.
+func addMockFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&mockProviderNames, "mock-providers", nil, "Replace providers by offline mocks: all but null if given alone, or those listed (e.g. --mock-providers=aws,docker)")
+	cmd.Flags().Lookup("mock-providers").NoOptDefVal = allProviders
+	cmd.Flags().StringVar(&mockFixtures, "mock-fixtures", "", "PKL file amending picklr:Mock with the outputs mock providers return")
+}
+
+// mocking reports whether --mock-providers is set.
+func mocking() bool { return len(mockProviderNames) > 0 }
+
+// loadMockFixtures evaluates the --mock-fixtures file, if any.
+func loadMockFixtures(ctx context.Context, evaluator *eval.Evaluator) (map[string]*ir.MockProvider, error) {
+	if mockFixtures == "" {
+		return nil, nil
+	}
+	path, err := filepath.Abs(mockFixtures)
+	if err != nil {
+		return nil, err
+	}
+	mocks, err := evaluator.LoadMocks(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return mocks.Providers, nil
+}
+
+// registerMockProviders replaces providers used by cfg or st with mocks: every
+// provider but null if names holds allProviders, and otherwise those named.
+// Mocks return the fixture outputs of their provider over the defaults of its
+// schemas, and placeholders for the attributes the configuration references but
+// neither sets. It returns the names of the mocked providers.
+func registerMockProviders(registry *provider.Registry, names []string, fixtures map[string]*ir.MockProvider, cfg *ir.Config, st *ir.State) ([]string, error) {
+	mocked := make(map[string]bool)
+	for _, name := range names {
+		if name != allProviders {
+			mocked[name] = true
+			continue
+		}
+		for _, res := range cfg.Resources {
+			if res.Provider != "" && res.Provider != "null" {
+				mocked[res.Provider] = true
+			}
+		}
+		if st != nil {
+			for _, res := range st.Resources {
+				if res.Provider != "" && res.Provider != "null" {
+					mocked[res.Provider] = true
+				}
+			}
+		}
+	}
+
+	providerSchemas, err := pklgen.LoadSchemas(schemas.Providers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load provider schemas: %w", err)
+	}
+	computed := referencedAttributes(cfg)
+	var registered []string
+	for name := range mocked {
+		var outputs map[string]map[string]any
+		if f := fixtures[name]; f != nil {
+			outputs = f.Outputs
+		}
+		m := mock.New(name, outputs)
+		for _, class := range providerSchemas.Provider(name) {
+			m.Defaults(class.Type, class.Defaults())
+		}
+		for typ, attrs := range computed[name] {
+			m.Computed(typ, attrs...)
+		}
+		registry.Register(name, m)
+		registered = append(registered, name)
+	}
+	sort.Strings(registered)
+	return registered, nil
+}
+
+// referencedAttributes returns the attributes that ptr:// references in cfg read,
+// by provider and resource type.
+func referencedAttributes(cfg *ir.Config) map[string]map[string][]string {
+	attrs := make(map[string]map[string][]string)
+	seen := make(map[string]bool)
+	var walk func(v any)
+	walk = func(v any) {
+		switch val := v.(type) {
+		case string:
+			ref, attr, err := addrs.ParseRef(val)
+			if err != nil || attr == "" || ref.Provider == "" {
+				return
+			}
+			typ := ref.Provider + ":" + ref.Type
+			if key := typ + "/" + attr; !seen[key] {
+				seen[key] = true
+				if attrs[ref.Provider] == nil {
+					attrs[ref.Provider] = make(map[string][]string)
+				}
+				attrs[ref.Provider][typ] = append(attrs[ref.Provider][typ], attr)
+			}
+		case map[string]any:
+			for _, item := range val {
+				walk(item)
+			}
+		case []any:
+			for _, item := range val {
+				walk(item)
+			}
+		}
+	}
+	for _, res := range cfg.Resources {
+		walk(res.Properties)
+	}
+	for _, out := range cfg.Outputs {
+		walk(out)
+	}
+	return attrs
+}
+
+// mockWarning is the plan warning naming the mocked providers.
+func mockWarning(names []string) string {
+	return fmt.Sprintf("Planned with mock providers (%s); the plan was not checked against real infrastructure.", strings.Join(names, ", "))
+}
@@ -4,15 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
+	"slices"
 
 	"github.com/picklr-io/picklr/internal/eval"
-	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
 var (
 	outputJSON bool
+	outputRaw  bool
 )
 
 var outputCmd = &cobra.Command{
@@ -21,12 +21,16 @@ var outputCmd = &cobra.Command{
 	Long: `Reads output values from the state file.
 
 If no name is given, all outputs are displayed. If a name is given,
-only that output's value is printed.`,
+only that output's value is printed.
+
+Sensitive outputs are masked unless --raw is given, which prints a single
+output's value as is, e.g. for use in scripts.`,
 	RunE: runOutput,
 }
 
 func init() {
 	outputCmd.Flags().BoolVar(&outputJSON, "json", false, "Output in JSON format")
+	outputCmd.Flags().BoolVar(&outputRaw, "raw", false, "Print the named output's value without quoting or masking")
 }
 
 func runOutput(cmd *cobra.Command, args []string) error {
@@ -36,13 +40,21 @@ func runOutput(cmd *cobra.Command, args []string) error {
 	}
 
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+	stateMgr, err := openStateBackend(cmd.Context(), wd, "main.pkl", evaluator)
+	if err != nil {
+		return err
+	}
 
 	s, err := stateMgr.Read(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("failed to read state: %w", err)
 	}
 
+	if outputRaw && len(args) == 0 {
+		return fmt.Errorf("--raw requires an output name")
+	}
+
 	if len(args) > 0 {
 		// Show specific output
 		name := args[0]
@@ -50,6 +62,13 @@ func runOutput(cmd *cobra.Command, args []string) error {
 		if !ok {
 			return fmt.Errorf("output %q not found", name)
 		}
+		if outputRaw {
+			fmt.Println(val)
+			return nil
+		}
+		if slices.Contains(s.SensitiveOutputs, name) {
+			val = sensitiveMask
+		}
 		if outputJSON {
 			data, _ := json.Marshal(val)
 			fmt.Println(string(data))
@@ -65,11 +84,12 @@ func runOutput(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	outputs := maskAttributes(s.Outputs, s.SensitiveOutputs)
 	if outputJSON {
-		data, _ := json.MarshalIndent(s.Outputs, "", "  ")
+		data, _ := json.MarshalIndent(outputs, "", "  ")
 		fmt.Println(string(data))
 	} else {
-		for k, v := range s.Outputs {
+		for k, v := range outputs {
 			fmt.Printf("%s = %v\n", k, v)
 		}
 	}
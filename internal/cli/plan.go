@@ -9,7 +9,6 @@ import (
 	"github.com/picklr-io/picklr/internal/engine"
 	"github.com/picklr-io/picklr/internal/eval"
 	"github.com/picklr-io/picklr/internal/provider"
-	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
@@ -36,6 +35,8 @@ func init() {
 	planCmd.Flags().StringVarP(&planOutFile, "out", "o", "", "Write plan to file")
 	planCmd.Flags().BoolVar(&planJSON, "json", false, "Output in JSON format")
 	planCmd.Flags().BoolVar(&planRefresh, "refresh", false, "Refresh state before planning")
+	addVariableFlags(planCmd)
+	addMockFlags(planCmd)
 }
 
 func runPlan(cmd *cobra.Command, args []string) error {
@@ -67,7 +68,14 @@ func runPlan(cmd *cobra.Command, args []string) error {
 
 	// 1. Initialize Components
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+	if err := verifyModules(ctx, wd, evaluator); err != nil {
+		return err
+	}
+	stateMgr, err := openStateBackend(ctx, wd, entryPoint, evaluator)
+	if err != nil {
+		return err
+	}
 	registry := provider.NewRegistry()
 	eng := engine.NewEngine(registry)
 
@@ -75,7 +83,15 @@ func runPlan(cmd *cobra.Command, args []string) error {
 	if !planJSON {
 		fmt.Print("Loading configuration... ")
 	}
-	cfg, err := evaluator.LoadConfig(ctx, entryPoint, nil)
+	props := configProperties(nil)
+	vars, err := loadVariables(ctx, wd, entryPoint, evaluator, props)
+	if err != nil {
+		if !planJSON {
+			fmt.Println("FAILED")
+		}
+		return err
+	}
+	cfg, err := evaluator.LoadConfig(ctx, entryPoint, props)
 	if err != nil {
 		if !planJSON {
 			fmt.Println("FAILED")
@@ -86,10 +102,21 @@ func runPlan(cmd *cobra.Command, args []string) error {
 		fmt.Println("OK")
 	}
 
+	if mocking() && planOutFile != "" {
+		return fmt.Errorf("a plan created with --mock-providers can't be saved, since it isn't meant to be applied")
+	}
+	fixtures, err := loadMockFixtures(ctx, evaluator)
+	if err != nil {
+		return err
+	}
+
 	// Auto-load providers required by the config
 	if err := loadRequiredProviders(registry, cfg); err != nil {
 		return err
 	}
+	if eng.RemoteStates, err = loadRemoteStates(ctx, wd, cfg, evaluator); err != nil {
+		return err
+	}
 
 	// 3. Load State
 	currentState, err := stateMgr.Read(ctx)
@@ -101,6 +128,12 @@ func runPlan(cmd *cobra.Command, args []string) error {
 	if err := loadStateProviders(registry, currentState); err != nil {
 		return err
 	}
+	var mocked []string
+	if mocking() {
+		if mocked, err = registerMockProviders(registry, mockProviderNames, fixtures, cfg, currentState); err != nil {
+			return err
+		}
+	}
 
 	// 3.5 Auto-refresh if requested
 	if planRefresh && len(currentState.Resources) > 0 {
@@ -118,12 +151,16 @@ func runPlan(cmd *cobra.Command, args []string) error {
 	if !planJSON {
 		fmt.Print("Calculating plan... ")
 	}
-	plan, err := eng.CreatePlanWithTargets(ctx, cfg, currentState, targets)
+	plan, err := eng.CreatePlanWithOptions(ctx, cfg, currentState, planOptions())
 	if err != nil {
 		if !planJSON {
 			fmt.Println("FAILED")
 		}
-		return fmt.Errorf("plan generation failed: %w", err)
+		return redactError(fmt.Errorf("plan generation failed: %w", err), configSecrets(cfg, vars, currentState))
+	}
+	plan.Variables, plan.SensitiveVariables = vars.Values, vars.Sensitive
+	if len(mocked) > 0 {
+		plan.Warnings = append(plan.Warnings, mockWarning(mocked))
 	}
 	if !planJSON {
 		fmt.Println("OK")
@@ -149,7 +186,8 @@ func runPlan(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return fmt.Errorf("failed to marshal plan: %w", err)
 		}
-		if err := os.WriteFile(planOutFile, planJSONData, 0644); err != nil {
+		// Saved plans hold the real values of sensitive attributes
+		if err := os.WriteFile(planOutFile, planJSONData, 0600); err != nil {
 			return fmt.Errorf("failed to write plan to %s: %w", planOutFile, err)
 		}
 		fmt.Printf("\nPlan saved to %s\n", planOutFile)
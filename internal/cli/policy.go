@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/picklr-io/picklr/internal/addrs"
 	"github.com/picklr-io/picklr/internal/ir"
 	"github.com/spf13/cobra"
 )
@@ -57,7 +58,8 @@ type PolicyRule struct {
 	Name         string `json:"name"`
 	Description  string `json:"description"`
 	ResourceType string `json:"resource_type"` // empty = all types
-	Condition    string `json:"condition"`      // deny_action, property_equals, property_not_equals, require_property
+	Address      string `json:"address"`       // address pattern, as for --target; empty = all resources
+	Condition    string `json:"condition"`     // deny_action, property_equals, property_not_equals, require_property
 	Property     string `json:"property"`
 	Value        string `json:"value"`
 	Severity     string `json:"severity"` // "error", "warning"
@@ -126,17 +128,8 @@ func evaluatePolicies(plan *ir.Plan, policies *PolicyFile) []PolicyViolation {
 
 	for _, rule := range policies.Rules {
 		for _, change := range plan.Changes {
-			// Check if rule applies to this resource type
-			if rule.ResourceType != "" {
-				resourceType := ""
-				if change.Desired != nil {
-					resourceType = change.Desired.Type
-				} else if change.Prior != nil {
-					resourceType = change.Prior.Type
-				}
-				if resourceType != rule.ResourceType {
-					continue
-				}
+			if !policyApplies(rule, change) {
+				continue
 			}
 
 			switch rule.Condition {
@@ -191,3 +184,22 @@ func evaluatePolicies(plan *ir.Plan, policies *PolicyFile) []PolicyViolation {
 
 	return violations
 }
+
+// policyApplies reports whether rule covers the resource a change is for.
+func policyApplies(rule PolicyRule, change *ir.ResourceChange) bool {
+	if rule.Address != "" && !addrs.Match(rule.Address, change.Address) {
+		return false
+	}
+	if rule.ResourceType == "" {
+		return true
+	}
+	resourceType := ""
+	if change.Desired != nil {
+		resourceType = change.Desired.Type
+	} else if change.Prior != nil {
+		resourceType = change.Prior.Type
+	} else if a, err := addrs.Parse(change.Address); err == nil {
+		resourceType = a.FullType()
+	}
+	return resourceType == rule.ResourceType
+}
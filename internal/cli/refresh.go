@@ -4,11 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 
+	"github.com/picklr-io/picklr/internal/addrs"
 	"github.com/picklr-io/picklr/internal/eval"
 	"github.com/picklr-io/picklr/internal/provider"
-	"github.com/picklr-io/picklr/internal/state"
 	pb "github.com/picklr-io/picklr/pkg/proto/provider"
 	"github.com/spf13/cobra"
 )
@@ -31,11 +30,15 @@ func runRefresh(cmd *cobra.Command, args []string) error {
 
 	ctx := cmd.Context()
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+	stateMgr, err := openStateBackend(ctx, wd, "main.pkl", evaluator)
+	if err != nil {
+		return err
+	}
 	registry := provider.NewRegistry()
 
 	// Lock state
-	if err := stateMgr.Lock(); err != nil {
+	if err := lockState(ctx, stateMgr, "refresh"); err != nil {
 		return err
 	}
 	defer stateMgr.Unlock()
@@ -60,12 +63,13 @@ func runRefresh(cmd *cobra.Command, args []string) error {
 	}
 
 	fmt.Printf("Refreshing %d resource(s)...\n\n", len(currentState.Resources))
+	secrets := stateSecrets(currentState)
 
 	drifted := 0
 	deleted := 0
 
 	for _, res := range currentState.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
+		addr := addrs.OfModule(res.Module, res.Type, res.Name).String()
 		prov, err := registry.Get(res.Provider)
 		if err != nil {
 			fmt.Printf("  %s: SKIP (provider %s not available)\n", addr, res.Provider)
@@ -88,7 +92,7 @@ func runRefresh(cmd *cobra.Command, args []string) error {
 			CurrentStateJson: currentJSON,
 		})
 		if err != nil {
-			fmt.Printf("  %s: ERROR (%v)\n", addr, err)
+			fmt.Printf("  %s: ERROR (%v)\n", addr, redactError(err, secrets))
 			continue
 		}
 
@@ -117,7 +121,6 @@ func runRefresh(cmd *cobra.Command, args []string) error {
 
 	// Write updated state
 	if drifted > 0 || deleted > 0 {
-		currentState.Serial++
 		if err := stateMgr.Write(ctx, currentState); err != nil {
 			return fmt.Errorf("failed to write state: %w", err)
 		}
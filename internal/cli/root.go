@@ -2,13 +2,16 @@ package cli
 
 import (
 	"github.com/picklr-io/picklr/internal/logging"
+	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
 var (
-	noColor  bool
-	targets  []string
-	logLevel string
+	noColor           bool
+	targets           []string
+	excludes          []string
+	includeDependents bool
+	logLevel          string
 )
 
 var rootCmd = &cobra.Command{
@@ -23,6 +26,7 @@ It provides a clean, deterministic way to manage cloud infrastructure with:
   - Unified language for config, plans, and state`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		logging.Init(logLevel)
+		state.ClientVersion = Version
 	},
 }
 
@@ -34,6 +38,10 @@ func Execute() error {
 func init() {
 	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable color output")
 	rootCmd.PersistentFlags().StringSliceVar(&targets, "target", nil, "Restrict operations to specific resources (can be specified multiple times)")
+	rootCmd.PersistentFlags().StringSliceVar(&excludes, "exclude", nil, "Skip matching resources and their dependents (can be specified multiple times)")
+	rootCmd.PersistentFlags().BoolVar(&includeDependents, "include-dependents", false, "Also include resources that depend on the targeted resources")
+	rootCmd.PersistentFlags().StringArrayVar(&backendConfigs, "backend-config", nil, "Override a backend setting (key=value) or load settings from a file (can be specified multiple times)")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", 0, "Wait this long for a state lock held by another process (e.g. 30s)")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Set log level (debug, info, warn, error)")
 
 	rootCmd.AddCommand(initCmd)
@@ -42,6 +50,7 @@ func init() {
 	rootCmd.AddCommand(applyCmd)
 	rootCmd.AddCommand(destroyCmd)
 	rootCmd.AddCommand(stateCmd)
+	rootCmd.AddCommand(forceUnlockCmd)
 	rootCmd.AddCommand(importCmd)
 	rootCmd.AddCommand(outputCmd)
 	rootCmd.AddCommand(showCmd)
@@ -54,5 +63,7 @@ func init() {
 	rootCmd.AddCommand(consoleCmd)
 	rootCmd.AddCommand(policyCmd)
 	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(stackCmd)
+	rootCmd.AddCommand(testCmd)
 	rootCmd.AddCommand(versionCmd)
 }
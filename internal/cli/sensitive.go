@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// sensitiveMask is displayed in place of sensitive values.
+const sensitiveMask = "(sensitive)"
+
+// maskAttributes returns a copy of attrs with the values of sensitive attributes
+// replaced by sensitiveMask.
+func maskAttributes(attrs map[string]any, sensitive []string) map[string]any {
+	if len(attrs) == 0 || len(sensitive) == 0 {
+		return attrs
+	}
+	masked := make(map[string]any, len(attrs))
+	for k, v := range attrs {
+		if slices.Contains(sensitive, k) {
+			v = sensitiveMask
+		}
+		masked[k] = v
+	}
+	return masked
+}
+
+// maskedState returns a copy of s that is safe to display.
+func maskedState(s *ir.State) *ir.State {
+	masked := *s
+	masked.Outputs = maskAttributes(s.Outputs, s.SensitiveOutputs)
+	masked.Resources = make([]*ir.ResourceState, len(s.Resources))
+	for i, res := range s.Resources {
+		masked.Resources[i] = maskedResourceState(res)
+	}
+	return &masked
+}
+
+func maskedResourceState(res *ir.ResourceState) *ir.ResourceState {
+	r := *res
+	r.Inputs = maskAttributes(res.Inputs, res.Sensitive)
+	r.Outputs = maskAttributes(res.Outputs, res.Sensitive)
+	return &r
+}
+
+// maskedPlan returns a copy of plan that is safe to display. Saved plan files
+// keep the real values, since they are applied as they are.
+func maskedPlan(plan *ir.Plan) *ir.Plan {
+	masked := *plan
+	masked.Outputs = maskAttributes(plan.Outputs, plan.SensitiveOutputs)
+	masked.Variables = maskAttributes(plan.Variables, plan.SensitiveVariables)
+	masked.Changes = make([]*ir.ResourceChange, len(plan.Changes))
+	for i, change := range plan.Changes {
+		c := *change
+		if change.Desired != nil {
+			desired := *change.Desired
+			desired.Properties = maskAttributes(change.Desired.Properties, change.Desired.Sensitive)
+			c.Desired = &desired
+		}
+		if change.Prior != nil {
+			prior := *change.Prior
+			prior.Properties = maskAttributes(change.Prior.Properties, change.Prior.Sensitive)
+			c.Prior = &prior
+		}
+		if len(change.Diff) > 0 {
+			c.Diff = make(map[string]*ir.PropertyDiff, len(change.Diff))
+			for k, d := range change.Diff {
+				if d.Sensitive {
+					md := *d
+					md.Before, md.After = maskValue(d.Before), maskValue(d.After)
+					d = &md
+				}
+				c.Diff[k] = d
+			}
+		}
+		masked.Changes[i] = &c
+	}
+	return &masked
+}
+
+// maskValue masks a value, keeping nulls so that additions and removals still show.
+func maskValue(v any) any {
+	if v == nil {
+		return nil
+	}
+	return sensitiveMask
+}
+
+// formatAttribute formats an attribute value for display, masking it if sensitive.
+func formatAttribute(name string, v any, sensitive []string) string {
+	if slices.Contains(sensitive, name) {
+		return sensitiveMask
+	}
+	return formatValue(v)
+}
+
+// minSecretLength is the length below which sensitive values are not redacted
+// from free text: replacing every occurrence of a value such as "1" or "on"
+// would garble messages while hiding little.
+const minSecretLength = 4
+
+// stateSecrets returns the string forms of the sensitive values in s, longest
+// first, for redacting free text such as error messages. Values shorter than
+// minSecretLength are left out.
+func stateSecrets(s *ir.State) []string {
+	var secrets []string
+	add := func(attrs map[string]any, sensitive []string) {
+		for _, k := range sensitive {
+			if v, ok := attrs[k]; ok && v != nil {
+				if str := fmt.Sprintf("%v", v); len(str) >= minSecretLength {
+					secrets = append(secrets, str)
+				}
+			}
+		}
+	}
+	add(s.Outputs, s.SensitiveOutputs)
+	for _, res := range s.Resources {
+		add(res.Inputs, res.Sensitive)
+		add(res.Outputs, res.Sensitive)
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	return secrets
+}
+
+// planSecrets returns the string forms of the sensitive values a plan sets,
+// including sensitive input variables.
+func planSecrets(plan *ir.Plan) []string {
+	s := &ir.State{Outputs: plan.Outputs, SensitiveOutputs: plan.SensitiveOutputs}
+	if len(plan.SensitiveVariables) > 0 {
+		s.Resources = append(s.Resources, &ir.ResourceState{Inputs: plan.Variables, Sensitive: plan.SensitiveVariables})
+	}
+	for _, change := range plan.Changes {
+		if change.Desired != nil {
+			s.Resources = append(s.Resources, &ir.ResourceState{Inputs: change.Desired.Properties, Sensitive: change.Desired.Sensitive})
+		}
+	}
+	return stateSecrets(s)
+}
+
+// configSecrets returns the string forms of the sensitive values that cfg sets,
+// that sensitive input variables hold and that st records, for redacting
+// errors raised before a plan exists.
+func configSecrets(cfg *ir.Config, vars *inputVariables, st *ir.State) []string {
+	s := &ir.State{Outputs: cfg.Outputs, SensitiveOutputs: cfg.SensitiveOutputs, Resources: st.Resources}
+	if vars != nil && len(vars.Sensitive) > 0 {
+		s.Resources = append(s.Resources, &ir.ResourceState{Inputs: vars.Values, Sensitive: vars.Sensitive})
+	}
+	for _, res := range cfg.Resources {
+		s.Resources = append(s.Resources, &ir.ResourceState{Inputs: res.Properties, Sensitive: res.Sensitive})
+	}
+	return stateSecrets(s)
+}
+
+// redactError returns err with the given secrets redacted from its message.
+func redactError(err error, secrets []string) error {
+	if err == nil {
+		return nil
+	}
+	if msg := err.Error(); redactSecrets(msg, secrets) != msg {
+		return errors.New(redactSecrets(msg, secrets))
+	}
+	return err
+}
+
+// redactSecrets replaces every occurrence of the given secrets in text.
+func redactSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, sensitiveMask)
+	}
+	return text
+}
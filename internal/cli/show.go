@@ -4,10 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 
+	"github.com/picklr-io/picklr/internal/addrs"
 	"github.com/picklr-io/picklr/internal/eval"
-	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
@@ -18,8 +17,10 @@ var (
 var showCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show the current state",
-	Long:  `Displays a human-readable view of the current state file.`,
-	RunE:  runShow,
+	Long: `Displays a human-readable view of the current state file.
+
+Sensitive values are masked.`,
+	RunE: runShow,
 }
 
 func init() {
@@ -33,12 +34,17 @@ func runShow(cmd *cobra.Command, args []string) error {
 	}
 
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+	stateMgr, err := openStateBackend(cmd.Context(), wd, "main.pkl", evaluator)
+	if err != nil {
+		return err
+	}
 
 	s, err := stateMgr.Read(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("failed to read state: %w", err)
 	}
+	s = maskedState(s)
 
 	if showJSON {
 		data, err := json.MarshalIndent(s, "", "  ")
@@ -53,7 +59,7 @@ func runShow(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Resources: %d\n\n", len(s.Resources))
 
 	for _, res := range s.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
+		addr := addrs.OfModule(res.Module, res.Type, res.Name).String()
 		fmt.Printf("# %s\n", addr)
 		fmt.Printf("  provider = %s\n", res.Provider)
 
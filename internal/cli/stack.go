@@ -0,0 +1,428 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/picklr-io/picklr/internal/engine"
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	stackFile        string
+	stackParallelism int
+	stackAutoApprove bool
+)
+
+var stackCmd = &cobra.Command{
+	Use:   "stack",
+	Short: "Plan, apply or destroy several projects in dependency order",
+	Long: `Runs plan, apply or destroy across the projects of a stack manifest (stack.pkl).
+
+A project depends on another if it reads that project's state through remoteStates,
+or if the manifest lists it in dependsOn. Projects run in dependency order, and in
+reverse order for destroy, with up to --parallelism projects at a time. When a
+project fails, no further projects are started.`,
+}
+
+var stackPlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Plan every project of the stack",
+	RunE:  func(cmd *cobra.Command, args []string) error { return runStack(cmd, "plan") },
+}
+
+var stackApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply every project of the stack in dependency order",
+	RunE:  func(cmd *cobra.Command, args []string) error { return runStack(cmd, "apply") },
+}
+
+var stackDestroyCmd = &cobra.Command{
+	Use:   "destroy",
+	Short: "Destroy every project of the stack in reverse dependency order",
+	RunE:  func(cmd *cobra.Command, args []string) error { return runStack(cmd, "destroy") },
+}
+
+func init() {
+	stackCmd.PersistentFlags().StringVarP(&stackFile, "file", "f", "stack.pkl", "Stack manifest")
+	stackCmd.PersistentFlags().IntVar(&stackParallelism, "parallelism", 0, "Number of projects to run at the same time (default: the manifest's parallelism)")
+	stackApplyCmd.Flags().BoolVar(&stackAutoApprove, "auto-approve", false, "Skip interactive approval before applying")
+	stackDestroyCmd.Flags().BoolVar(&stackAutoApprove, "auto-approve", false, "Skip interactive approval before destroying")
+
+	stackCmd.AddCommand(stackPlanCmd)
+	stackCmd.AddCommand(stackApplyCmd)
+	stackCmd.AddCommand(stackDestroyCmd)
+}
+
+// stackProject is a project of a stack, with its directory resolved.
+type stackProject struct {
+	Name       string
+	Dir        string
+	EntryPoint string
+	DependsOn  []string
+	Backend    *state.BackendConfig // Where the project's state is stored
+}
+
+// stackResult is the outcome of running a project.
+type stackResult struct {
+	Project  string
+	Status   string // "succeeded", "failed" or "skipped"
+	Summary  ir.PlanSummary
+	Changes  []*ir.ResourceChange
+	Err      error
+	Duration time.Duration
+}
+
+func runStack(cmd *cobra.Command, op string) error {
+	ctx := cmd.Context()
+	manifest, err := filepath.Abs(stackFile)
+	if err != nil {
+		return err
+	}
+	evaluator := eval.NewEvaluator(filepath.Dir(manifest))
+	stack, err := evaluator.LoadStack(ctx, manifest)
+	evaluator.Close()
+	if err != nil {
+		return fmt.Errorf("failed to load stack from %s: %w", stackFile, err)
+	}
+	if len(stack.Projects) == 0 {
+		fmt.Println("No projects in stack.")
+		return nil
+	}
+
+	projects, err := loadStackProjects(ctx, filepath.Dir(manifest), stack)
+	if err != nil {
+		return err
+	}
+	dag, err := stackDAG(projects)
+	if err != nil {
+		return fmt.Errorf("invalid stack: %w", err)
+	}
+
+	parallelism := stack.Parallelism
+	if stackParallelism > 0 {
+		parallelism = stackParallelism
+	}
+	reverse := op == "destroy"
+	order := dag.CreationOrder()
+	if reverse {
+		order = dag.DestructionOrder()
+	}
+
+	fmt.Printf("Stack %s: %d project(s), in order: %s\n", stackFile, len(order), strings.Join(order, ", "))
+	if op != "plan" && !stackAutoApprove {
+		fmt.Printf("\nDo you want to %s every project of the stack? (y/n): ", op)
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "yes" {
+			fmt.Printf("Stack %s cancelled.\n", op)
+			return nil
+		}
+	}
+	fmt.Println()
+
+	results := runStackProjects(ctx, dag, reverse, parallelism, func(ctx context.Context, name string) *stackResult {
+		fmt.Printf("%s: %s started\n", name, op)
+		r := runStackProject(ctx, projects[name], op)
+		printStackResult(name, op, r)
+		return r
+	})
+
+	return renderStackSummary(op, order, results)
+}
+
+// loadStackProjects resolves the projects of a stack and the backends their states
+// are stored in.
+func loadStackProjects(ctx context.Context, root string, stack *ir.Stack) (map[string]*stackProject, error) {
+	projects := make(map[string]*stackProject, len(stack.Projects))
+	evaluators := make(map[string]*eval.Evaluator, len(stack.Projects))
+	defer func() {
+		for _, evaluator := range evaluators {
+			evaluator.Close()
+		}
+	}()
+	for name, p := range stack.Projects {
+		dir := p.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		entryPoint := p.EntryPoint
+		if entryPoint == "" {
+			entryPoint = "main.pkl"
+		}
+		if !fileExists(filepath.Join(dir, entryPoint)) {
+			return nil, fmt.Errorf("project %s: %s not found in %s", name, entryPoint, dir)
+		}
+
+		evaluator := eval.NewEvaluator(dir)
+		evaluators[name] = evaluator
+		backend, err := loadBackendConfig(ctx, dir, entryPoint, evaluator)
+		if err != nil {
+			return nil, fmt.Errorf("project %s: %w", name, err)
+		}
+		projects[name] = &stackProject{Name: name, Dir: dir, EntryPoint: entryPoint, DependsOn: p.DependsOn, Backend: backend}
+	}
+
+	// Projects depend on the projects whose states they read
+	for _, p := range projects {
+		evaluator := evaluators[p.Name]
+		props := configProperties(nil)
+		if _, err := loadVariables(ctx, p.Dir, p.EntryPoint, evaluator, props); err != nil {
+			return nil, fmt.Errorf("project %s: %w", p.Name, err)
+		}
+		remotes, err := evaluator.LoadConfigRemoteStates(ctx, p.EntryPoint, props)
+		if err != nil {
+			return nil, fmt.Errorf("project %s: %w", p.Name, err)
+		}
+		for name, remote := range remotes {
+			bc, err := remoteBackendConfig(p.Dir, name, remote)
+			if err != nil {
+				return nil, fmt.Errorf("project %s: %w", p.Name, err)
+			}
+			if dep := stackProjectOf(bc, projects); dep != "" && dep != p.Name {
+				p.DependsOn = append(p.DependsOn, dep)
+			}
+		}
+	}
+	return projects, nil
+}
+
+// stackProjectOf returns the project whose state a remote state reads, or "" if
+// it belongs to no project of the stack. A local state file belongs to the
+// innermost project directory that contains it.
+func stackProjectOf(remote *state.BackendConfig, projects map[string]*stackProject) string {
+	names := make([]string, 0, len(projects))
+	for name := range projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	match := ""
+	for _, name := range names {
+		p := projects[name]
+		if remote.Type == "local" {
+			rel, err := filepath.Rel(p.Dir, remote.Config["path"])
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				continue
+			}
+			if match == "" || len(p.Dir) > len(projects[match].Dir) {
+				match = name
+			}
+			continue
+		}
+		if p.Backend != nil && p.Backend.Type == remote.Type && sameStateLocation(remote.Type, remote.Config, p.Backend.Config) {
+			return name
+		}
+	}
+	return match
+}
+
+// stateLocationKeys are the backend settings that identify where a state is stored.
+var stateLocationKeys = map[string][]string{
+	"s3":   {"bucket", "key"},
+	"gcs":  {"bucket", "prefix"},
+	"http": {"address"},
+}
+
+// sameStateLocation reports whether two backend configurations of a type point to
+// the same state. Types without known location settings compare all settings.
+func sameStateLocation(typ string, a, b map[string]string) bool {
+	keys, ok := stateLocationKeys[typ]
+	if !ok {
+		for k := range a {
+			keys = append(keys, k)
+		}
+		if len(a) != len(b) {
+			return false
+		}
+	}
+	for _, k := range keys {
+		if a[k] != b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// stackDAG orders the projects of a stack by their dependencies.
+func stackDAG(projects map[string]*stackProject) (*engine.DAG, error) {
+	edges := make(map[string][]string, len(projects))
+	for name, p := range projects {
+		seen := make(map[string]bool)
+		edges[name] = []string{}
+		for _, dep := range p.DependsOn {
+			if !seen[dep] {
+				seen[dep] = true
+				edges[name] = append(edges[name], dep)
+			}
+		}
+		sort.Strings(edges[name])
+	}
+	return engine.BuildDAGFromEdges(edges)
+}
+
+// runStackProjects runs every project of the DAG once the projects it waits for
+// have succeeded, up to parallelism at a time. Projects wait for their dependencies,
+// or for their dependents if reverse is set. After a failure, projects that have
+// not started are skipped.
+func runStackProjects(ctx context.Context, dag *engine.DAG, reverse bool, parallelism int, run func(context.Context, string) *stackResult) map[string]*stackResult {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	pending := dag.CreationOrder()
+	waitsFor := dag.Dependencies
+	if reverse {
+		pending = dag.DestructionOrder()
+		waitsFor = dag.Dependents
+	}
+	pending = append([]string{}, pending...)
+
+	results := make(map[string]*stackResult, len(pending))
+	ready := func(name string) bool {
+		for _, dep := range waitsFor(name) {
+			if r, ok := results[dep]; !ok || r.Status != "succeeded" {
+				return false
+			}
+		}
+		return true
+	}
+
+	done := make(chan *stackResult)
+	running, failed := 0, false
+	for {
+		if !failed {
+			var waiting []string
+			for _, name := range pending {
+				if running < parallelism && ready(name) {
+					running++
+					go func(name string) {
+						start := time.Now()
+						r := run(ctx, name)
+						r.Project, r.Duration = name, time.Since(start)
+						done <- r
+					}(name)
+					continue
+				}
+				waiting = append(waiting, name)
+			}
+			pending = waiting
+		}
+		if running == 0 {
+			break
+		}
+
+		r := <-done
+		running--
+		results[r.Project] = r
+		if r.Status != "succeeded" {
+			failed = true
+		}
+	}
+
+	for _, name := range pending {
+		results[name] = &stackResult{Project: name, Status: "skipped"}
+	}
+	return results
+}
+
+// runStackProject runs plan, apply or destroy in a project's directory with the
+// picklr binary, in JSON mode so that its summary can be collected.
+func runStackProject(ctx context.Context, p *stackProject, op string) *stackResult {
+	exe, err := os.Executable()
+	if err != nil {
+		return &stackResult{Status: "failed", Err: err}
+	}
+	args := []string{op, "--json"}
+	if op != "plan" {
+		args = append(args, "--auto-approve")
+	}
+	args = append(args, p.EntryPoint)
+
+	c := exec.CommandContext(ctx, exe, args...)
+	c.Dir = p.Dir
+	var stdout, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &stdout, &stderr
+	if err := c.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return &stackResult{Status: "failed", Err: fmt.Errorf("%s", strings.TrimPrefix(msg, "Error: "))}
+	}
+
+	r := &stackResult{Status: "succeeded"}
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		var result struct {
+			Summary *ir.PlanSummary
+			Changes []*ir.ResourceChange
+		}
+		if err := json.Unmarshal(out, &result); err != nil {
+			return &stackResult{Status: "failed", Err: fmt.Errorf("failed to decode %s output: %w", op, err)}
+		}
+		if result.Summary != nil {
+			r.Summary = *result.Summary
+		}
+		r.Changes = result.Changes
+	}
+	return r
+}
+
+// printStackResult prints the outcome of a project as it completes.
+func printStackResult(name, op string, r *stackResult) {
+	if r.Status != "succeeded" {
+		fmt.Printf("%s%s: %s failed: %v%s\n", colorize("\033[31m"), name, op, r.Err, colorize("\033[0m"))
+		return
+	}
+	fmt.Printf("%s: %s complete (%s)\n", name, op, formatStackSummary(r.Summary))
+	for _, change := range r.Changes {
+		if change.Action != "NOOP" {
+			fmt.Printf("  %s %s\n", strings.ToLower(change.Action), change.Address)
+		}
+	}
+}
+
+// renderStackSummary prints the outcome of every project in order and returns an
+// error if any project failed or was skipped.
+func renderStackSummary(op string, order []string, results map[string]*stackResult) error {
+	fmt.Printf("\nStack %s summary:\n", op)
+	var total ir.PlanSummary
+	var failed, skipped []string
+	for _, name := range order {
+		r := results[name]
+		switch r.Status {
+		case "succeeded":
+			fmt.Printf("  %s%-20s%s %s\n", colorize("\033[32m"), name, colorize("\033[0m"), formatStackSummary(r.Summary))
+			total.Create += r.Summary.Create
+			total.Update += r.Summary.Update
+			total.Delete += r.Summary.Delete
+			total.Replace += r.Summary.Replace
+		case "failed":
+			fmt.Printf("  %s%-20s%s failed\n", colorize("\033[31m"), name, colorize("\033[0m"))
+			failed = append(failed, name)
+		default:
+			fmt.Printf("  %-20s skipped\n", name)
+			skipped = append(skipped, name)
+		}
+	}
+	fmt.Printf("\nTotal: %s\n", formatStackSummary(total))
+
+	if len(failed) > 0 {
+		return fmt.Errorf("stack %s failed in %s; %d project(s) skipped", op, strings.Join(failed, ", "), len(skipped))
+	}
+	return nil
+}
+
+func formatStackSummary(s ir.PlanSummary) string {
+	return fmt.Sprintf("%d create, %d update, %d replace, %d delete", s.Create, s.Update, s.Replace, s.Delete)
+}
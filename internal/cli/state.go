@@ -1,11 +1,11 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
 
+	"github.com/picklr-io/picklr/internal/addrs"
 	"github.com/picklr-io/picklr/internal/eval"
 	"github.com/picklr-io/picklr/internal/ir"
 	"github.com/picklr-io/picklr/internal/state"
@@ -19,16 +19,24 @@ var stateCmd = &cobra.Command{
 }
 
 var stateListCmd = &cobra.Command{
-	Use:   "list",
+	Use:   "list [address...]",
 	Short: "List resources in state",
-	RunE:  runStateList,
+	Long: `List resources in state, optionally only those matching the given addresses.
+An address may be a module path, e.g. module.net["prod"], to list the resources of a module.`,
+	RunE: runStateList,
 }
 
+var stateShowSerial int
+
 var stateShowCmd = &cobra.Command{
-	Use:   "show <address>",
+	Use:   "show [address]",
 	Short: "Show attributes of a single resource",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runStateShow,
+	Long: `Shows the attributes of a single resource.
+
+With --serial, reads a previous state snapshot instead of the current state. If no
+address is given, the snapshot's resources are listed.`,
+	Args: cobra.RangeArgs(0, 1),
+	RunE: runStateShow,
 }
 
 var stateMvCmd = &cobra.Command{
@@ -45,100 +53,158 @@ var stateRmCmd = &cobra.Command{
 	RunE:  runStateRm,
 }
 
+var stateReplaceProviderAutoApprove bool
+
+var stateReplaceProviderCmd = &cobra.Command{
+	Use:   "replace-provider <from> <to>",
+	Short: "Change the provider of resources in state",
+	Long: `Rewrites the provider of every resource in state that uses <from> to <to>,
+for example when moving resources to an aliased provider instance.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runStateReplaceProvider,
+}
+
 func init() {
 	stateCmd.AddCommand(stateListCmd)
 	stateCmd.AddCommand(stateShowCmd)
 	stateCmd.AddCommand(stateMvCmd)
 	stateCmd.AddCommand(stateRmCmd)
+	stateCmd.AddCommand(stateReplaceProviderCmd)
+
+	stateReplaceProviderCmd.Flags().BoolVar(&stateReplaceProviderAutoApprove, "auto-approve", false, "Skip interactive confirmation")
+	stateShowCmd.Flags().IntVar(&stateShowSerial, "serial", 0, "Read the snapshot with this serial instead of the current state")
 }
 
-func loadStateMgr() (*state.Manager, error) {
+// loadStateMgr opens the state backend of the project in the working directory.
+// The backend reads state with the returned evaluator, which the caller closes
+// once it is done with the backend.
+func loadStateMgr(ctx context.Context) (state.Backend, *eval.Evaluator, error) {
+	mgr, _, evaluator, err := loadStateBackend(ctx)
+	return mgr, evaluator, err
+}
+
+// loadStateBackend is loadStateMgr, also returning the backend configuration the
+// backend was opened with.
+func loadStateBackend(ctx context.Context) (state.Backend, *state.BackendConfig, *eval.Evaluator, error) {
 	wd, err := os.Getwd()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get working directory: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to get working directory: %w", err)
 	}
 	evaluator := eval.NewEvaluator(wd)
-	return state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator), nil
+	cfg, err := loadBackendConfig(ctx, wd, "main.pkl", evaluator)
+	if err != nil {
+		evaluator.Close()
+		return nil, nil, nil, err
+	}
+	mgr, err := state.NewBackend(cfg, evaluator)
+	if err != nil {
+		evaluator.Close()
+		return nil, nil, nil, err
+	}
+	return mgr, cfg, evaluator, nil
 }
 
 func runStateList(cmd *cobra.Command, args []string) error {
-	mgr, err := loadStateMgr()
+	mgr, evaluator, err := loadStateMgr(cmd.Context())
 	if err != nil {
 		return err
 	}
+	defer evaluator.Close()
 
 	s, err := mgr.Read(cmd.Context())
 	if err != nil {
 		return fmt.Errorf("failed to read state: %w", err)
 	}
 
+	if len(args) > 0 {
+		filtered := *s
+		filtered.Resources = nil
+		for _, res := range s.Resources {
+			addr := addrs.OfModule(res.Module, res.Type, res.Name).String()
+			for _, pattern := range args {
+				if addrs.Match(pattern, addr) {
+					filtered.Resources = append(filtered.Resources, res)
+					break
+				}
+			}
+		}
+		s = &filtered
+	}
+
 	if len(s.Resources) == 0 {
 		fmt.Println("No resources in state.")
 		return nil
 	}
 
-	fmt.Printf("State version: %d, serial: %d, lineage: %s\n\n", s.Version, s.Serial, s.Lineage)
-	for _, res := range s.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
-		fmt.Printf("  %s (provider: %s)\n", addr, res.Provider)
-	}
-	fmt.Printf("\nTotal: %d resource(s)\n", len(s.Resources))
-
+	printStateSummary(s)
 	return nil
 }
 
 func runStateShow(cmd *cobra.Command, args []string) error {
-	mgr, err := loadStateMgr()
+	mgr, evaluator, err := loadStateMgr(cmd.Context())
 	if err != nil {
 		return err
 	}
+	defer evaluator.Close()
 
-	s, err := mgr.Read(cmd.Context())
-	if err != nil {
-		return fmt.Errorf("failed to read state: %w", err)
+	var s *ir.State
+	if cmd.Flags().Changed("serial") {
+		s, err = readStateVersion(cmd.Context(), mgr, stateShowSerial)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			printStateSummary(s)
+			return nil
+		}
+	} else {
+		if len(args) == 0 {
+			return fmt.Errorf("an address is required unless --serial is given")
+		}
+		s, err = mgr.Read(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("failed to read state: %w", err)
+		}
 	}
 
-	target := args[0]
-	for _, res := range s.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
-		if addr == target {
-			fmt.Printf("# %s\n", addr)
-			fmt.Printf("  provider = %s\n", res.Provider)
-			fmt.Printf("  type     = %s\n", res.Type)
-			fmt.Printf("  name     = %s\n", res.Name)
-
-			if len(res.Inputs) > 0 {
-				fmt.Println("\n  Inputs:")
-				for k, v := range res.Inputs {
-					fmt.Printf("    %s = %v\n", k, v)
-				}
-			}
-
-			if len(res.Outputs) > 0 {
-				fmt.Println("\n  Outputs:")
-				for k, v := range res.Outputs {
-					fmt.Printf("    %s = %v\n", k, v)
-				}
-			}
-
-			if res.InputsHash != "" {
-				fmt.Printf("\n  inputs_hash = %s\n", res.InputsHash)
-			}
+	res, err := findStateResource(s, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# %s\n", addrs.OfModule(res.Module, res.Type, res.Name))
+	fmt.Printf("  provider = %s\n", res.Provider)
+	fmt.Printf("  type     = %s\n", res.Type)
+	fmt.Printf("  name     = %s\n", res.Name)
+
+	if len(res.Inputs) > 0 {
+		fmt.Println("\n  Inputs:")
+		for k, v := range res.Inputs {
+			fmt.Printf("    %s = %v\n", k, formatAttribute(k, v, res.Sensitive))
+		}
+	}
 
-			return nil
+	if len(res.Outputs) > 0 {
+		fmt.Println("\n  Outputs:")
+		for k, v := range res.Outputs {
+			fmt.Printf("    %s = %v\n", k, formatAttribute(k, v, res.Sensitive))
 		}
 	}
 
-	return fmt.Errorf("resource %s not found in state", target)
+	if res.InputsHash != "" {
+		fmt.Printf("\n  inputs_hash = %s\n", res.InputsHash)
+	}
+
+	return nil
 }
 
 func runStateMv(cmd *cobra.Command, args []string) error {
-	mgr, err := loadStateMgr()
+	mgr, evaluator, err := loadStateMgr(cmd.Context())
 	if err != nil {
 		return err
 	}
+	defer evaluator.Close()
 
-	if err := mgr.Lock(); err != nil {
+	if err := lockState(cmd.Context(), mgr, "state mv"); err != nil {
 		return err
 	}
 	defer mgr.Unlock()
@@ -149,25 +215,8 @@ func runStateMv(cmd *cobra.Command, args []string) error {
 	}
 
 	src, dst := args[0], args[1]
-	found := false
-
-	for _, res := range s.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
-		if addr == src {
-			// Parse new address
-			parts := strings.SplitN(dst, ".", 2)
-			if len(parts) != 2 {
-				return fmt.Errorf("invalid destination address %q, expected format type.name", dst)
-			}
-			res.Type = parts[0]
-			res.Name = parts[1]
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		return fmt.Errorf("resource %s not found in state", src)
+	if err := moveStateResource(s, src, dst); err != nil {
+		return err
 	}
 
 	if err := mgr.Write(cmd.Context(), s); err != nil {
@@ -179,12 +228,13 @@ func runStateMv(cmd *cobra.Command, args []string) error {
 }
 
 func runStateRm(cmd *cobra.Command, args []string) error {
-	mgr, err := loadStateMgr()
+	mgr, evaluator, err := loadStateMgr(cmd.Context())
 	if err != nil {
 		return err
 	}
+	defer evaluator.Close()
 
-	if err := mgr.Lock(); err != nil {
+	if err := lockState(cmd.Context(), mgr, "state rm"); err != nil {
 		return err
 	}
 	defer mgr.Unlock()
@@ -195,27 +245,117 @@ func runStateRm(cmd *cobra.Command, args []string) error {
 	}
 
 	target := args[0]
+	removed, err := findStateResource(s, target)
+	if err != nil {
+		return err
+	}
 	newResources := make([]*ir.ResourceState, 0, len(s.Resources))
-	found := false
-
 	for _, res := range s.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
-		if addr == target {
-			found = true
-			continue
+		if res != removed {
+			newResources = append(newResources, res)
 		}
-		newResources = append(newResources, res)
+	}
+	s.Resources = newResources
+	if err := mgr.Write(cmd.Context(), s); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
 	}
 
-	if !found {
-		return fmt.Errorf("resource %s not found in state", target)
+	fmt.Printf("Removed %s from state (resource was NOT destroyed)\n", target)
+	return nil
+}
+
+func runStateReplaceProvider(cmd *cobra.Command, args []string) error {
+	mgr, evaluator, err := loadStateMgr(cmd.Context())
+	if err != nil {
+		return err
 	}
+	defer evaluator.Close()
 
-	s.Resources = newResources
+	if err := lockState(cmd.Context(), mgr, "state replace-provider"); err != nil {
+		return err
+	}
+	defer mgr.Unlock()
+
+	s, err := mgr.Read(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+
+	from, to := args[0], args[1]
+	matched := replaceProvider(s, from, "")
+	if len(matched) == 0 {
+		fmt.Printf("No resources use provider %s.\n", from)
+		return nil
+	}
+
+	fmt.Printf("Provider %s will be replaced with %s in:\n", from, to)
+	for _, addr := range matched {
+		fmt.Printf("  %s\n", addr)
+	}
+	if !stateReplaceProviderAutoApprove {
+		fmt.Print("\nDo you want to continue? (y/n): ")
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "yes" {
+			fmt.Println("Replace cancelled.")
+			return nil
+		}
+	}
+
+	replaceProvider(s, from, to)
 	if err := mgr.Write(cmd.Context(), s); err != nil {
 		return fmt.Errorf("failed to write state: %w", err)
 	}
 
-	fmt.Printf("Removed %s from state (resource was NOT destroyed)\n", target)
+	fmt.Printf("Replaced provider for %d resource(s).\n", len(matched))
 	return nil
 }
+
+// findStateResource returns the resource at address in s.
+func findStateResource(s *ir.State, address string) (*ir.ResourceState, error) {
+	target, err := addrs.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	for _, res := range s.Resources {
+		if addrs.OfModule(res.Module, res.Type, res.Name).Equal(target) {
+			return res, nil
+		}
+	}
+	return nil, fmt.Errorf("resource %s not found in state", address)
+}
+
+// moveStateResource moves the resource at src in s to dst.
+func moveStateResource(s *ir.State, src, dst string) error {
+	res, err := findStateResource(s, src)
+	if err != nil {
+		return err
+	}
+	to, err := addrs.Parse(dst)
+	if err != nil {
+		return fmt.Errorf("invalid destination: %w", err)
+	}
+	if _, err := findStateResource(s, dst); err == nil {
+		return fmt.Errorf("resource %s already exists in state", dst)
+	}
+	res.Type = to.FullType()
+	res.Name = to.InstanceName()
+	res.Module = to.ModulePath()
+	return nil
+}
+
+// replaceProvider sets the provider of every resource using from to to, and returns
+// the addresses of those resources. An empty to only reports the matches.
+func replaceProvider(s *ir.State, from, to string) []string {
+	var matched []string
+	for _, res := range s.Resources {
+		if res.Provider != from {
+			continue
+		}
+		matched = append(matched, addrs.OfModule(res.Module, res.Type, res.Name).String())
+		if to != "" {
+			res.Provider = to
+		}
+	}
+	return matched
+}
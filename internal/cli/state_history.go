@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/picklr-io/picklr/internal/addrs"
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var stateRollbackSerial int
+
+var stateHistoryCmd = &cobra.Command{
+	Use:     "history",
+	Aliases: []string{"list-versions"},
+	Short:   "List previous versions of the state",
+	Long: `Lists the stored snapshots of the state, newest first.
+
+The local backend keeps a snapshot (state.<serial>.pkl.bak) on every write. The S3
+backend uses the bucket's object versions, so versioning must be enabled.
+Snapshots from a different lineage are not listed.`,
+	Args: cobra.NoArgs,
+	RunE: runStateHistory,
+}
+
+var stateRollbackCmd = &cobra.Command{
+	Use:   "rollback --serial N",
+	Short: "Restore a previous version of the state",
+	Long: `Restores the snapshot with the given serial as the current state.
+
+The restored state is written as a new version, so the rollback itself can be
+undone. Snapshots from a different lineage are rejected.`,
+	Args: cobra.NoArgs,
+	RunE: runStateRollback,
+}
+
+func init() {
+	stateCmd.AddCommand(stateHistoryCmd)
+	stateCmd.AddCommand(stateRollbackCmd)
+
+	stateRollbackCmd.Flags().IntVar(&stateRollbackSerial, "serial", 0, "Serial of the snapshot to restore")
+	_ = stateRollbackCmd.MarkFlagRequired("serial")
+}
+
+// stateHistory returns the backend's history support, or an error if it has none.
+func stateHistory(b state.Backend) (state.History, error) {
+	h, ok := b.(state.History)
+	if !ok {
+		return nil, fmt.Errorf("the configured backend does not keep state history")
+	}
+	return h, nil
+}
+
+// readStateVersion loads the snapshot with the given serial, checking that it
+// belongs to the same lineage as the current state.
+func readStateVersion(ctx context.Context, b state.Backend, serial int) (*ir.State, error) {
+	h, err := stateHistory(b)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := b.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	snapshot, err := h.ReadVersion(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	if !state.SameLineage(current, snapshot) {
+		return nil, fmt.Errorf("snapshot %d has lineage %q, but the current state has lineage %q", serial, snapshot.Lineage, current.Lineage)
+	}
+	return snapshot, nil
+}
+
+func runStateHistory(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	mgr, evaluator, err := loadStateMgr(ctx)
+	if err != nil {
+		return err
+	}
+	defer evaluator.Close()
+
+	h, err := stateHistory(mgr)
+	if err != nil {
+		return err
+	}
+
+	current, err := mgr.Read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+
+	versions, err := h.Versions(ctx)
+	if err != nil {
+		return err
+	}
+
+	shown, skipped := 0, 0
+	for _, v := range versions {
+		if current.Lineage != "" && v.Lineage != "" && v.Lineage != current.Lineage {
+			skipped++
+			continue
+		}
+		if shown == 0 {
+			fmt.Printf("Lineage: %s\n\n", current.Lineage)
+			fmt.Printf("  %-8s %-22s %s\n", "SERIAL", "CREATED", "ID")
+		}
+		marker := ""
+		if v.Serial == current.Serial {
+			marker = " (current)"
+		}
+		fmt.Printf("  %-8d %-22s %s%s\n", v.Serial, v.Created.UTC().Format("2006-01-02 15:04:05Z"), v.ID, marker)
+		shown++
+	}
+
+	if shown == 0 {
+		fmt.Println("No state history.")
+	}
+	if skipped > 0 {
+		fmt.Printf("\n%d snapshot(s) from other lineages were skipped.\n", skipped)
+	}
+	return nil
+}
+
+func runStateRollback(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	mgr, evaluator, err := loadStateMgr(ctx)
+	if err != nil {
+		return err
+	}
+	defer evaluator.Close()
+
+	if err := lockState(ctx, mgr, "state rollback"); err != nil {
+		return err
+	}
+	defer mgr.Unlock()
+
+	current, err := mgr.Read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+
+	snapshot, err := readStateVersion(ctx, mgr, stateRollbackSerial)
+	if err != nil {
+		return err
+	}
+
+	// Written as the next version on top of the current one
+	snapshot.Serial = current.Serial
+	snapshot.Lineage = current.Lineage
+	if err := mgr.Write(ctx, snapshot); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+
+	fmt.Printf("Rolled back to serial %d (%d resource(s)).\n", stateRollbackSerial, len(snapshot.Resources))
+	return nil
+}
+
+// printStateSummary lists the resources in a state.
+func printStateSummary(s *ir.State) {
+	fmt.Printf("State version: %d, serial: %d, lineage: %s\n\n", s.Version, s.Serial, s.Lineage)
+	for _, res := range s.Resources {
+		addr := addrs.OfModule(res.Module, res.Type, res.Name).String()
+		fmt.Printf("  %s (provider: %s)\n", addr, res.Provider)
+	}
+	fmt.Printf("\nTotal: %d resource(s)\n", len(s.Resources))
+}
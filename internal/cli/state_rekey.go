@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/picklr-io/picklr/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var stateRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt the state with the current encryption key",
+	Long: `Reads the state and writes it back encrypted with the currently configured key.
+
+To rotate keys, set the new key in PICKLR_STATE_ENCRYPTION_KEY (or the passphrase,
+key file or KMS settings) and the old one in the matching PICKLR_STATE_PREVIOUS_*
+variable, then run 'picklr state rekey'. Snapshots written before the rotation stay
+encrypted with the old key.`,
+	Args: cobra.NoArgs,
+	RunE: runStateRekey,
+}
+
+func init() {
+	stateCmd.AddCommand(stateRekeyCmd)
+}
+
+func runStateRekey(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	provider, err := state.CurrentKeyProvider()
+	if err != nil {
+		return err
+	}
+	if provider == nil {
+		return fmt.Errorf("no state encryption key is configured; set %s, %s, %s or %s",
+			state.EncryptionKeyEnvVar, state.EncryptionPassphraseEnvVar, state.EncryptionKeyFileEnvVar, state.KMSKeyIDEnvVar)
+	}
+
+	mgr, evaluator, err := loadStateMgr(ctx)
+	if err != nil {
+		return err
+	}
+	defer evaluator.Close()
+
+	if err := lockState(ctx, mgr, "state rekey"); err != nil {
+		return err
+	}
+	defer mgr.Unlock()
+
+	s, err := mgr.Read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	if s.Lineage == "" && len(s.Resources) == 0 {
+		return fmt.Errorf("no state to re-encrypt")
+	}
+
+	if err := mgr.Write(ctx, s); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+
+	fmt.Printf("State re-encrypted with %s key %s (serial %d).\n", provider.Name(), provider.KeyID(), s.Serial)
+	return nil
+}
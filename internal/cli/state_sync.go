@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statePullFormat string
+	statePushForce  bool
+	stateConvertTo  string
+)
+
+var statePullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Print the current state from the configured backend",
+	Long: `Reads the state from the configured backend and prints it to stdout, as PKL
+(the default) or JSON. Redirect the output to a file to copy state between
+workspaces or backends with 'picklr state push'.`,
+	Args: cobra.NoArgs,
+	RunE: runStatePull,
+}
+
+var statePushCmd = &cobra.Command{
+	Use:   "push <file>",
+	Short: "Upload a local state file to the configured backend",
+	Long: `Writes a PKL or JSON state file (by extension) to the configured backend.
+
+The push is rejected if the file's lineage differs from the stored state's, or if
+the stored serial is newer than the file's. Use --force to overwrite anyway.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runStatePush,
+}
+
+var stateConvertCmd = &cobra.Command{
+	Use:   "convert --to <pkl|json>",
+	Short: "Rewrite the stored state in another encoding",
+	Long: `Rewrites the state held by the configured backend as PKL or JSON.
+
+The state is validated against the State.pkl schema before it is written. Later
+writes keep the new encoding unless the backend configuration sets one.`,
+	Args: cobra.NoArgs,
+	RunE: runStateConvert,
+}
+
+func init() {
+	stateCmd.AddCommand(statePullCmd)
+	stateCmd.AddCommand(statePushCmd)
+	stateCmd.AddCommand(stateConvertCmd)
+
+	statePullCmd.Flags().StringVar(&statePullFormat, "format", "pkl", "Output format: pkl or json")
+	statePushCmd.Flags().BoolVar(&statePushForce, "force", false, "Skip the lineage and serial checks")
+	stateConvertCmd.Flags().StringVar(&stateConvertTo, "to", "", "Target encoding: pkl or json")
+	_ = stateConvertCmd.MarkFlagRequired("to")
+}
+
+func runStatePull(cmd *cobra.Command, args []string) error {
+	mgr, evaluator, err := loadStateMgr(cmd.Context())
+	if err != nil {
+		return err
+	}
+	defer evaluator.Close()
+
+	s, err := mgr.Read(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+
+	encoding, err := state.ParseEncoding(statePullFormat)
+	if err != nil || encoding == "" {
+		return fmt.Errorf("unknown format %q, expected pkl or json", statePullFormat)
+	}
+	data, err := state.EncodeState(s, encoding)
+	if err != nil {
+		return err
+	}
+	os.Stdout.Write(data)
+	return nil
+}
+
+func runStatePush(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	mgr, evaluator, err := loadStateMgr(ctx)
+	if err != nil {
+		return err
+	}
+	defer evaluator.Close()
+
+	pushed, err := loadStateFile(cmd, args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := lockState(ctx, mgr, "state push"); err != nil {
+		return err
+	}
+	defer mgr.Unlock()
+
+	if statePushForce {
+		ctx = state.WithForceWrite(ctx)
+	}
+	if err := mgr.Write(ctx, pushed); err != nil {
+		return fmt.Errorf("failed to push state: %w", err)
+	}
+
+	fmt.Printf("Pushed %s (%d resource(s), serial %d, lineage %s).\n", args[0], len(pushed.Resources), pushed.Serial, pushed.Lineage)
+	return nil
+}
+
+// loadStateFile reads a state file in PKL or JSON format, chosen by extension.
+func loadStateFile(cmd *cobra.Command, path string) (*ir.State, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %s: %w", path, err)
+	}
+
+	if strings.EqualFold(filepath.Ext(abs), ".json") {
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		s, err := state.ParseStateJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := state.ValidateState(cmd.Context(), s); err != nil {
+			return nil, fmt.Errorf("invalid state in %s: %w", path, err)
+		}
+		return s, nil
+	}
+
+	if !fileExists(abs) {
+		return nil, fmt.Errorf("state file %s not found", path)
+	}
+	evaluator := eval.NewEvaluator(filepath.Dir(abs))
+	defer evaluator.Close()
+	s, err := state.LoadStateFile(cmd.Context(), evaluator, abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func runStateConvert(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	encoding, err := state.ParseEncoding(stateConvertTo)
+	if err != nil || encoding == "" {
+		return fmt.Errorf("unknown encoding %q, expected pkl or json", stateConvertTo)
+	}
+
+	mgr, cfg, evaluator, err := loadStateBackend(ctx)
+	if err != nil {
+		return err
+	}
+	defer evaluator.Close()
+	// A configured encoding would undo the conversion on the next write
+	if configured := cfg.Config["encoding"]; configured != "" && configured != string(encoding) {
+		return fmt.Errorf("the backend configuration sets encoding = %q; change it to %q to switch encodings", configured, encoding)
+	}
+
+	if err := lockState(ctx, mgr, "state convert"); err != nil {
+		return err
+	}
+	defer mgr.Unlock()
+
+	s, err := mgr.Read(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	if err := state.ValidateState(ctx, s); err != nil {
+		return err
+	}
+
+	if err := mgr.Write(state.WithEncoding(ctx, encoding), s); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+
+	fmt.Printf("State converted to %s (serial %d).\n", encoding, s.Serial)
+	return nil
+}
@@ -3,10 +3,8 @@ package cli
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/picklr-io/picklr/internal/eval"
-	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
@@ -35,9 +33,13 @@ func runTaint(cmd *cobra.Command, args []string) error {
 
 	ctx := cmd.Context()
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+	stateMgr, err := openStateBackend(ctx, wd, "main.pkl", evaluator)
+	if err != nil {
+		return err
+	}
 
-	if err := stateMgr.Lock(); err != nil {
+	if err := lockState(ctx, stateMgr, "taint"); err != nil {
 		return err
 	}
 	defer stateMgr.Unlock()
@@ -47,23 +49,19 @@ func runTaint(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to read state: %w", err)
 	}
 
-	target := args[0]
-	for _, res := range s.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
-		if addr == target {
-			if res.Outputs == nil {
-				res.Outputs = make(map[string]any)
-			}
-			res.Outputs["_tainted"] = true
-			if err := stateMgr.Write(ctx, s); err != nil {
-				return fmt.Errorf("failed to write state: %w", err)
-			}
-			fmt.Printf("Resource %s has been tainted. It will be recreated on next apply.\n", target)
-			return nil
-		}
+	res, err := findStateResource(s, args[0])
+	if err != nil {
+		return err
 	}
-
-	return fmt.Errorf("resource %s not found in state", target)
+	if res.Outputs == nil {
+		res.Outputs = make(map[string]any)
+	}
+	res.Outputs["_tainted"] = true
+	if err := stateMgr.Write(ctx, s); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	fmt.Printf("Resource %s has been tainted. It will be recreated on next apply.\n", args[0])
+	return nil
 }
 
 func runUntaint(cmd *cobra.Command, args []string) error {
@@ -74,9 +72,13 @@ func runUntaint(cmd *cobra.Command, args []string) error {
 
 	ctx := cmd.Context()
 	evaluator := eval.NewEvaluator(wd)
-	stateMgr := state.NewManager(filepath.Join(wd, WorkspaceStatePath()), evaluator)
+	defer evaluator.Close()
+	stateMgr, err := openStateBackend(ctx, wd, "main.pkl", evaluator)
+	if err != nil {
+		return err
+	}
 
-	if err := stateMgr.Lock(); err != nil {
+	if err := lockState(ctx, stateMgr, "untaint"); err != nil {
 		return err
 	}
 	defer stateMgr.Unlock()
@@ -86,20 +88,16 @@ func runUntaint(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to read state: %w", err)
 	}
 
-	target := args[0]
-	for _, res := range s.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
-		if addr == target {
-			if res.Outputs != nil {
-				delete(res.Outputs, "_tainted")
-			}
-			if err := stateMgr.Write(ctx, s); err != nil {
-				return fmt.Errorf("failed to write state: %w", err)
-			}
-			fmt.Printf("Resource %s has been untainted.\n", target)
-			return nil
-		}
+	res, err := findStateResource(s, args[0])
+	if err != nil {
+		return err
 	}
-
-	return fmt.Errorf("resource %s not found in state", target)
+	if res.Outputs != nil {
+		delete(res.Outputs, "_tainted")
+	}
+	if err := stateMgr.Write(ctx, s); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	fmt.Printf("Resource %s has been untainted.\n", args[0])
+	return nil
 }
@@ -0,0 +1,639 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/picklr-io/picklr/internal/addrs"
+	"github.com/picklr-io/picklr/internal/engine"
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/provider"
+	"github.com/spf13/cobra"
+)
+
+// testFileSuffix is the suffix of test files.
+const testFileSuffix = ".test.pkl"
+
+var (
+	testFormat string
+	testOutput string
+)
+
+var testCmd = &cobra.Command{
+	Use:   "test [path...]",
+	Short: "Run configuration tests",
+	Long: `Runs the tests in *.test.pkl files, found in the given files and directories
+or in the current directory.
+
+Each run of a test plans, or plans and applies, its configuration with the run's
+input variables and checks assertions against the plan or the resulting state.
+State starts empty for each file and is never written. Providers listed in
+mockProviders are replaced by mocks that answer offline with canned outputs.
+
+Results are written in TAP or JUnit XML format.`,
+	RunE: runTest,
+}
+
+func init() {
+	testCmd.Flags().StringVar(&testFormat, "format", "tap", "Output format: 'tap' or 'junit'")
+	testCmd.Flags().StringVarP(&testOutput, "output", "o", "", "Write results to a file instead of stdout")
+	testCmd.Flags().StringSliceVar(&mockProviderNames, "mock-providers", nil, "Also mock these providers in every test, or all but null if given alone")
+	testCmd.Flags().Lookup("mock-providers").NoOptDefVal = allProviders
+}
+
+// testResult is the outcome of one run of a test file.
+type testResult struct {
+	File     string
+	Name     string
+	Failures []string // Failed assertions
+	Err      error    // Set if the run could not be completed
+	Duration time.Duration
+}
+
+func (r *testResult) passed() bool { return r.Err == nil && len(r.Failures) == 0 }
+
+func runTest(cmd *cobra.Command, args []string) error {
+	if testFormat != "tap" && testFormat != "junit" {
+		return fmt.Errorf("invalid --format %q: expected 'tap' or 'junit'", testFormat)
+	}
+	files, err := findTestFiles(args)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Println("No test files found.")
+		return nil
+	}
+
+	var results []*testResult
+	for _, file := range files {
+		results = append(results, runTestFile(cmd.Context(), file)...)
+	}
+
+	w := cliOutput()
+	if testOutput != "" {
+		f, err := os.Create(testOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", testOutput, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if testFormat == "junit" {
+		err = writeJUnit(w, results)
+	} else {
+		err = writeTAP(w, results)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		if !r.passed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d test run(s) failed", failed, len(results))
+	}
+	return nil
+}
+
+// findTestFiles returns the test files among paths, searching directories
+// recursively but skipping hidden ones such as .picklr.
+func findTestFiles(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	var files []string
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, root)
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && strings.HasSuffix(path, testFileSuffix) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// runTestFile runs the runs of a test file in order, sharing their state.
+func runTestFile(ctx context.Context, file string) []*testResult {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return []*testResult{{File: file, Name: "load", Err: err}}
+	}
+	testEvaluator := eval.NewEvaluator(filepath.Dir(abs))
+	test, err := testEvaluator.LoadTest(ctx, abs)
+	testEvaluator.Close()
+	if err != nil {
+		return []*testResult{{File: file, Name: "load", Err: err}}
+	}
+
+	configPath := test.Config
+	if !filepath.IsAbs(configPath) {
+		configPath = filepath.Join(filepath.Dir(abs), configPath)
+	}
+	// The runs share one evaluator of the configuration
+	evaluator := eval.NewEvaluator(filepath.Dir(configPath))
+	defer evaluator.Close()
+	// Providers of the file's mockProviders are mocked, as well as those of --mock-providers
+	mockNames := append([]string(nil), mockProviderNames...)
+	for name := range test.MockProviders {
+		mockNames = append(mockNames, name)
+	}
+
+	current := &ir.State{Version: 1}
+	var results []*testResult
+	for _, run := range test.Runs {
+		start := time.Now()
+		result := &testResult{File: file, Name: run.Name}
+
+		values := make(map[string]string)
+		for k, v := range test.Variables {
+			values[k] = variableString(v)
+		}
+		for k, v := range run.Variables {
+			values[k] = variableString(v)
+		}
+
+		plan, newState, err := executeTestRun(ctx, evaluator, configPath, mockNames, test.MockProviders, values, run.Command == "apply", current)
+		switch {
+		case run.ExpectError != nil:
+			if err == nil {
+				result.Failures = append(result.Failures, fmt.Sprintf("expected an error containing %q, but the run succeeded", *run.ExpectError))
+			} else if !strings.Contains(err.Error(), *run.ExpectError) {
+				result.Failures = append(result.Failures, fmt.Sprintf("expected an error containing %q, got: %v", *run.ExpectError, err))
+			}
+		case err != nil:
+			result.Err = err
+		default:
+			if newState != nil {
+				current = newState
+			}
+			result.Failures = checkAssertions(run.Assertions, plan, newState)
+		}
+		result.Duration = time.Since(start)
+		results = append(results, result)
+	}
+	return results
+}
+
+// variableString formats a test variable as a raw variable value, as given to --var.
+func variableString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// executeTestRun plans the configuration at configPath, evaluated by evaluator,
+// against current, and applies the plan if apply is set, returning the new state.
+// current is not modified. The providers in mockNames are replaced by mocks
+// returning the outputs in fixtures.
+func executeTestRun(ctx context.Context, evaluator *eval.Evaluator, configPath string, mockNames []string, fixtures map[string]*ir.MockProvider, values map[string]string, apply bool, current *ir.State) (*ir.Plan, *ir.State, error) {
+	wd, entryPoint := filepath.Dir(configPath), filepath.Base(configPath)
+
+	// Tests don't depend on the workspace selected in the project
+	props := map[string]string{eval.WorkspaceProperty: "default"}
+	decls, err := evaluator.LoadVariables(ctx, entryPoint, props)
+	if err != nil {
+		return nil, nil, err
+	}
+	explicit := make(map[string]bool, len(values))
+	for k := range values {
+		explicit[k] = true
+	}
+	vars, err := resolveVariables(decls, values, explicit)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range variableProperties(values) {
+		props[k] = v
+	}
+
+	cfg, err := evaluator.LoadConfig(ctx, entryPoint, props)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// The engine may update the state it is given
+	prior := deepCopyState(current)
+
+	registry := provider.NewRegistry()
+	mocked, err := registerMockProviders(registry, mockNames, fixtures, cfg, prior)
+	if err != nil {
+		return nil, nil, err
+	}
+	if apply {
+		// Tests never create real infrastructure
+		if real := unmockedProviders(cfg, prior, mocked); len(real) > 0 {
+			return nil, nil, fmt.Errorf("apply runs must mock every provider but null; add %s to mockProviders", strings.Join(real, ", "))
+		}
+	}
+	if err := loadRequiredProviders(registry, cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := loadStateProviders(registry, prior); err != nil {
+		return nil, nil, err
+	}
+	eng := engine.NewEngine(registry)
+	if eng.RemoteStates, err = loadRemoteStates(ctx, wd, cfg, evaluator); err != nil {
+		return nil, nil, err
+	}
+	plan, err := eng.CreatePlan(ctx, cfg, prior)
+	if err != nil {
+		return nil, nil, fmt.Errorf("plan generation failed: %w", err)
+	}
+	plan.Variables, plan.SensitiveVariables = vars.Values, vars.Sensitive
+	if !apply {
+		return plan, nil, nil
+	}
+
+	newState, err := eng.ApplyPlan(ctx, plan, prior)
+	if err != nil {
+		return plan, nil, fmt.Errorf("apply failed: %w", err)
+	}
+	return plan, newState, nil
+}
+
+// unmockedProviders returns the providers of resources in cfg or st that are
+// neither null nor in mocked, in sorted order.
+func unmockedProviders(cfg *ir.Config, st *ir.State, mocked []string) []string {
+	seen := make(map[string]bool)
+	var real []string
+	check := func(name string) {
+		if name == "" || name == "null" || seen[name] || slices.Contains(mocked, name) {
+			return
+		}
+		seen[name] = true
+		real = append(real, name)
+	}
+	for _, res := range cfg.Resources {
+		check(res.Provider)
+	}
+	if st != nil {
+		for _, res := range st.Resources {
+			check(res.Provider)
+		}
+	}
+	sort.Strings(real)
+	return real
+}
+
+// deepCopyState copies a state through JSON.
+func deepCopyState(s *ir.State) *ir.State {
+	data, _ := json.Marshal(s)
+	var c ir.State
+	_ = json.Unmarshal(data, &c)
+	return &c
+}
+
+// checkAssertions checks the assertions of a run against its plan and, for an
+// apply run, the resulting state, and describes those that fail.
+func checkAssertions(assertions []*ir.TestAssertion, plan *ir.Plan, st *ir.State) []string {
+	var failures []string
+	for i, a := range assertions {
+		msg := checkAssertion(a, plan, st)
+		if msg == "" {
+			continue
+		}
+		if a.Message != nil {
+			msg = *a.Message + ": " + msg
+		}
+		failures = append(failures, fmt.Sprintf("assertion %d: %s", i+1, msg))
+	}
+	return failures
+}
+
+func checkAssertion(a *ir.TestAssertion, plan *ir.Plan, st *ir.State) string {
+	switch {
+	case a.Output != nil:
+		outputs := plan.Outputs
+		if st != nil {
+			outputs = st.Outputs
+		}
+		v, ok := outputs[*a.Output]
+		if !ok {
+			return fmt.Sprintf("output %q is not set", *a.Output)
+		}
+		if a.Equals != nil && !equalValues(v, a.Equals) {
+			return fmt.Sprintf("output %q is %s, expected %s", *a.Output, formatValue(v), formatValue(a.Equals))
+		}
+		return ""
+
+	case a.Count != nil:
+		n := 0
+		if st != nil && a.Action == nil {
+			for _, res := range st.Resources {
+				if assertionMatches(a, addrs.OfModule(res.Module, res.Type, res.Name).String(), res.Type) {
+					n++
+				}
+			}
+		} else {
+			for _, change := range plan.Changes {
+				if assertionMatches(a, change.Address, changeType(change)) && actionMatches(a, change.Action) {
+					n++
+				}
+			}
+		}
+		if n != *a.Count {
+			return fmt.Sprintf("%s %d, expected %d", countDescription(a, st), n, *a.Count)
+		}
+		return ""
+
+	case a.Resource == nil:
+		return "an assertion needs a resource, an output or a count"
+	}
+
+	var change *ir.ResourceChange
+	for _, c := range plan.Changes {
+		if assertionMatches(a, c.Address, changeType(c)) {
+			if change != nil {
+				return fmt.Sprintf("%q matches several resources; use count", *a.Resource)
+			}
+			change = c
+		}
+	}
+
+	if a.Action != nil {
+		got := "noop"
+		if change != nil {
+			got = strings.ToLower(change.Action)
+		}
+		if !strings.EqualFold(got, *a.Action) {
+			return fmt.Sprintf("%s is planned to %s, expected %s", *a.Resource, got, strings.ToLower(*a.Action))
+		}
+	}
+
+	if st != nil {
+		var res *ir.ResourceState
+		for _, r := range st.Resources {
+			if assertionMatches(a, addrs.OfModule(r.Module, r.Type, r.Name).String(), r.Type) {
+				if res != nil {
+					return fmt.Sprintf("%q matches several resources; use count", *a.Resource)
+				}
+				res = r
+			}
+		}
+		if res == nil {
+			if a.Action != nil && strings.EqualFold(*a.Action, "delete") {
+				return ""
+			}
+			return fmt.Sprintf("%s is not in state", *a.Resource)
+		}
+		if a.Property == nil {
+			return ""
+		}
+		attrs := make(map[string]any, len(res.Inputs)+len(res.Outputs))
+		for k, v := range res.Inputs {
+			attrs[k] = v
+		}
+		for k, v := range res.Outputs {
+			attrs[k] = v
+		}
+		return checkProperty(a, attrs)
+	}
+
+	if change == nil {
+		if a.Action != nil {
+			return ""
+		}
+		return fmt.Sprintf("%s has no planned change", *a.Resource)
+	}
+	if a.Property == nil {
+		return ""
+	}
+	if change.Desired == nil {
+		return fmt.Sprintf("%s is planned to be deleted", *a.Resource)
+	}
+	return checkProperty(a, change.Desired.Properties)
+}
+
+// checkProperty checks the property of an assertion, a dotted path, in attrs.
+func checkProperty(a *ir.TestAssertion, attrs map[string]any) string {
+	v, ok := lookupPath(attrs, *a.Property)
+	if !ok {
+		return fmt.Sprintf("%s has no %s", *a.Resource, *a.Property)
+	}
+	if a.Equals != nil && !equalValues(v, a.Equals) {
+		return fmt.Sprintf("%s %s is %s, expected %s", *a.Resource, *a.Property, formatValue(v), formatValue(a.Equals))
+	}
+	return ""
+}
+
+// lookupPath returns the value at a dotted path such as tags.env or subnets.0.
+func lookupPath(v any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch val := v.(type) {
+		case map[string]any:
+			item, ok := val[key]
+			if !ok {
+				return nil, false
+			}
+			v = item
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(val) {
+				return nil, false
+			}
+			v = val[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// equalValues compares values in their JSON form, so that numbers of different
+// Go types compare equal.
+func equalValues(a, b any) bool {
+	var na, nb any
+	da, errA := json.Marshal(a)
+	db, errB := json.Marshal(b)
+	if errA != nil || errB != nil || json.Unmarshal(da, &na) != nil || json.Unmarshal(db, &nb) != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return reflect.DeepEqual(na, nb)
+}
+
+// assertionMatches reports whether a resource matches the resource pattern and
+// type of an assertion.
+func assertionMatches(a *ir.TestAssertion, addr, typ string) bool {
+	if a.Resource != nil && !addrs.Match(*a.Resource, addr) {
+		return false
+	}
+	return a.Type == nil || *a.Type == typ
+}
+
+func actionMatches(a *ir.TestAssertion, action string) bool {
+	return a.Action == nil || strings.EqualFold(*a.Action, action)
+}
+
+func changeType(change *ir.ResourceChange) string {
+	if change.Desired != nil {
+		return change.Desired.Type
+	}
+	if change.Prior != nil {
+		return change.Prior.Type
+	}
+	return ""
+}
+
+func countDescription(a *ir.TestAssertion, st *ir.State) string {
+	var filters []string
+	if a.Resource != nil {
+		filters = append(filters, *a.Resource)
+	}
+	if a.Type != nil {
+		filters = append(filters, "type "+*a.Type)
+	}
+	if a.Action != nil {
+		filters = append(filters, "action "+strings.ToLower(*a.Action))
+	}
+	what := "planned changes"
+	if st != nil && a.Action == nil {
+		what = "resources in state"
+	}
+	if len(filters) > 0 {
+		what += " matching " + strings.Join(filters, ", ")
+	}
+	return "found " + what + ":"
+}
+
+// writeTAP writes results in the Test Anything Protocol, version 13.
+func writeTAP(w io.Writer, results []*testResult) error {
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%d\n", len(results))
+	for i, r := range results {
+		status := "ok"
+		if !r.passed() {
+			status = "not ok"
+		}
+		fmt.Fprintf(w, "%s %d - %s: %s\n", status, i+1, r.File, r.Name)
+		if r.passed() {
+			continue
+		}
+		fmt.Fprintln(w, "  ---")
+		if r.Err != nil {
+			fmt.Fprintf(w, "  error: %q\n", r.Err.Error())
+		}
+		if len(r.Failures) > 0 {
+			fmt.Fprintln(w, "  failures:")
+			for _, f := range r.Failures {
+				fmt.Fprintf(w, "    - %q\n", f)
+			}
+		}
+		fmt.Fprintf(w, "  duration_ms: %d\n", r.Duration.Milliseconds())
+		fmt.Fprintln(w, "  ...")
+	}
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes results as JUnit XML, with a test suite per test file.
+func writeJUnit(w io.Writer, results []*testResult) error {
+	var doc junitTestSuites
+	index := make(map[string]int)
+	for _, r := range results {
+		i, ok := index[r.File]
+		if !ok {
+			i = len(doc.Suites)
+			index[r.File] = i
+			doc.Suites = append(doc.Suites, junitTestSuite{Name: r.File})
+		}
+		suite := &doc.Suites[i]
+
+		tc := junitTestCase{Name: r.Name, Classname: r.File, Time: fmt.Sprintf("%.3f", r.Duration.Seconds())}
+		switch {
+		case r.Err != nil:
+			tc.Error = &junitMessage{Message: r.Err.Error(), Text: r.Err.Error()}
+			suite.Errors++
+			doc.Errors++
+		case len(r.Failures) > 0:
+			tc.Failure = &junitMessage{Message: r.Failures[0], Text: strings.Join(r.Failures, "\n")}
+			suite.Failures++
+			doc.Failures++
+		}
+		suite.Tests++
+		doc.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+	for i := range doc.Suites {
+		var total time.Duration
+		for _, r := range results {
+			if r.File == doc.Suites[i].Name {
+				total += r.Duration
+			}
+		}
+		doc.Suites[i].Time = fmt.Sprintf("%.3f", total.Seconds())
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JUnit report: %w", err)
+	}
+	fmt.Fprintln(w, xml.Header+string(data))
+	return nil
+}
@@ -17,6 +17,10 @@ var validateCmd = &cobra.Command{
 	RunE:  runValidate,
 }
 
+func init() {
+	addVariableFlags(validateCmd)
+}
+
 func runValidate(cmd *cobra.Command, args []string) error {
 	fmt.Println("Validating configuration...")
 
@@ -46,10 +50,16 @@ func runValidate(cmd *cobra.Command, args []string) error {
 	}
 
 	evaluator := eval.NewEvaluator(wd)
+	defer evaluator.Close()
 
 	// Validate main.pkl
 	fmt.Printf("Checking %s... ", entryPoint)
-	if _, err := evaluator.LoadConfig(cmd.Context(), entryPoint, nil); err != nil {
+	props := configProperties(nil)
+	if _, err := loadVariables(cmd.Context(), wd, entryPoint, evaluator, props); err != nil {
+		fmt.Println("FAILED")
+		return fmt.Errorf("validation failed: %w", err)
+	}
+	if _, err := evaluator.LoadConfig(cmd.Context(), entryPoint, props); err != nil {
 		fmt.Println("FAILED")
 		return fmt.Errorf("validation failed: %w", err)
 	}
@@ -0,0 +1,203 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/spf13/cobra"
+)
+
+// varEnvPrefix prefixes environment variables that set input variables,
+// e.g. PICKLR_VAR_region=us-east-1.
+const varEnvPrefix = "PICKLR_VAR_"
+
+var (
+	// varValues holds the --var name=value assignments.
+	varValues []string
+	// varFiles holds the --var-file paths, applied in order.
+	varFiles []string
+)
+
+// addVariableFlags registers --var and --var-file on a command that evaluates
+// the configuration.
+func addVariableFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&varValues, "var", nil, "Set an input variable (format: name=value, can be specified multiple times)")
+	cmd.Flags().StringArrayVar(&varFiles, "var-file", nil, "Load input variables from a PKL file (can be specified multiple times)")
+}
+
+// inputVariables are the input variable values a configuration is evaluated with.
+type inputVariables struct {
+	Values    map[string]any // By name, converted to their declared types; includes defaults
+	Sensitive []string       // Names of sensitive variables, sorted
+}
+
+// collectVariables returns the raw input variable values from PICKLR_VAR_*
+// environment variables, then the variables file of the current workspace in
+// the project at wd, then --var-file files, then --var flags, later sources
+// taking precedence. It also returns the names set explicitly by files or flags.
+func collectVariables(ctx context.Context, wd string, evaluator *eval.Evaluator) (values map[string]string, explicit map[string]bool, err error) {
+	values = make(map[string]string)
+	explicit = make(map[string]bool)
+
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if name, ok := strings.CutPrefix(k, varEnvPrefix); ok && name != "" {
+			values[name] = v
+		}
+	}
+
+	files := varFiles
+	if path := filepath.Join(wd, workspaceVarsPath(currentWorkspace())); fileExists(path) {
+		files = append([]string{path}, varFiles...)
+	}
+	for _, path := range files {
+		vars, err := evaluator.LoadVars(ctx, path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load variables from %s: %w", path, err)
+		}
+		for k, v := range vars {
+			values[k] = v
+			explicit[k] = true
+		}
+	}
+
+	for _, kv := range varValues {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, nil, fmt.Errorf("invalid --var %q: expected name=value", kv)
+		}
+		values[k] = v
+		explicit[k] = true
+	}
+
+	return values, explicit, nil
+}
+
+// variableProperties returns the external properties that pass values to PKL.
+func variableProperties(values map[string]string) map[string]string {
+	props := make(map[string]string, len(values))
+	for k, v := range values {
+		props[eval.VariablePropertyPrefix+k] = v
+	}
+	return props
+}
+
+// loadVariables resolves the input variables declared by the configuration of
+// the project at wd and adds their values to props. Required variables without a value, values of the
+// wrong type and values for undeclared variables are reported together;
+// environment variables for undeclared names are ignored.
+func loadVariables(ctx context.Context, wd, entryPoint string, evaluator *eval.Evaluator, props map[string]string) (*inputVariables, error) {
+	values, explicit, err := collectVariables(ctx, wd, evaluator)
+	if err != nil {
+		return nil, err
+	}
+	decls, err := evaluator.LoadVariables(ctx, entryPoint, props)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := resolveVariables(decls, values, explicit)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range variableProperties(values) {
+		props[k] = v
+	}
+	return vars, nil
+}
+
+// resolveVariables checks values against the declarations and converts them to
+// their declared types.
+func resolveVariables(decls map[string]*ir.Variable, values map[string]string, explicit map[string]bool) (*inputVariables, error) {
+	vars := &inputVariables{Values: make(map[string]any, len(decls))}
+	var missing, problems []string
+
+	for name := range values {
+		if _, ok := decls[name]; !ok {
+			if explicit[name] {
+				problems = append(problems, fmt.Sprintf("variable %q is not declared", name))
+			}
+			delete(values, name)
+		}
+	}
+
+	for name, decl := range decls {
+		if decl.Sensitive {
+			vars.Sensitive = append(vars.Sensitive, name)
+		}
+		raw, ok := values[name]
+		if !ok {
+			if decl.Default == nil {
+				missing = append(missing, name)
+				continue
+			}
+			vars.Values[name] = decl.Default
+			continue
+		}
+		v, err := convertVariable(decl.Type, raw)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("variable %q: %v", name, err))
+			continue
+		}
+		vars.Values[name] = v
+	}
+	sort.Strings(vars.Sensitive)
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		problems = append(problems, fmt.Sprintf("no value for required variables: %s (set them with --var, --var-file or %s<name>)",
+			strings.Join(missing, ", "), varEnvPrefix))
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, fmt.Errorf("invalid input variables:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return vars, nil
+}
+
+// convertVariable converts a raw value to a variable type, as Variable.parse does in PKL.
+func convertVariable(typ, raw string) (any, error) {
+	switch typ {
+	case "", "string":
+		return raw, nil
+	case "number":
+		if i, err := strconv.Atoi(raw); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", raw)
+		}
+		return f, nil
+	case "bool":
+		switch strings.ToLower(raw) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%q is not a bool, expected true or false", raw)
+	case "list":
+		var v []any
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			return nil, fmt.Errorf("%q is not a JSON array", raw)
+		}
+		return v, nil
+	case "map":
+		var v map[string]any
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			return nil, fmt.Errorf("%q is not a JSON object", raw)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+}
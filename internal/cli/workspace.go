@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/state"
 	"github.com/spf13/cobra"
 )
 
@@ -79,6 +81,29 @@ func currentWorkspace() string {
 	return ws
 }
 
+// workspaceVarsPath returns the path of the variables file of a workspace,
+// relative to the project directory.
+func workspaceVarsPath(ws string) string {
+	return filepath.Join(picklrDir(), "vars", ws+".pkl")
+}
+
+// expandWorkspace replaces ${workspace} in a backend setting with the current
+// workspace, so that workspaces can share a remote backend, e.g. with the key
+// env/${workspace}/state.pkl.
+func expandWorkspace(setting string) string {
+	return strings.ReplaceAll(setting, "${workspace}", currentWorkspace())
+}
+
+// configProperties returns the external properties a configuration is evaluated
+// with: the current workspace as picklr.workspace, and extra.
+func configProperties(extra map[string]string) map[string]string {
+	props := map[string]string{eval.WorkspaceProperty: currentWorkspace()}
+	for k, v := range extra {
+		props[k] = v
+	}
+	return props
+}
+
 // WorkspaceStatePath returns the state file path for the current workspace.
 func WorkspaceStatePath() string {
 	ws := currentWorkspace()
@@ -142,7 +167,7 @@ func runWorkspaceNew(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create empty state for new workspace
-	lineage := generateUUID()
+	lineage := state.NewLineage()
 	content := fmt.Sprintf(`// Picklr state file - DO NOT EDIT MANUALLY unless you know what you're doing
 amends "picklr:State"
 
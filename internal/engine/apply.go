@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/picklr-io/picklr/internal/addrs"
 	"github.com/picklr-io/picklr/internal/ir"
 	"github.com/picklr-io/picklr/internal/logging"
 	pb "github.com/picklr-io/picklr/pkg/proto/provider"
@@ -49,7 +50,7 @@ func (e *Engine) ApplyPlanWithCallback(ctx context.Context, plan *ir.Plan, state
 	// Build a lookup map for existing resources in state by address
 	stateIndex := make(map[string]int)
 	for i, res := range state.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
+		addr := addrs.OfModule(res.Module, res.Type, res.Name).String()
 		stateIndex[addr] = i
 	}
 
@@ -118,8 +119,9 @@ func (e *Engine) ApplyPlanWithCallback(ctx context.Context, plan *ir.Plan, state
 		}
 	}
 
-	state.Serial++
 	state.Outputs = plan.Outputs
+	state.SensitiveOutputs = plan.SensitiveOutputs
+	state.RemoteStateSerials = plan.RemoteStateSerials
 
 	if len(errs) > 0 {
 		return state, fmt.Errorf("%d resource(s) failed: %w", len(errs), errors.Join(errs...))
@@ -154,10 +156,25 @@ func (e *Engine) applyParallel(ctx context.Context, changes []*ir.ResourceChange
 			refs := extractPtrRefs(c.Desired.Properties)
 			for _, ref := range refs {
 				depAddr := ptrRefToAddr(ref)
+				if _, ok := changeMap[depAddr]; !ok {
+					// References to untyped resources are qualified with the provider
+					if r, err := addrs.Parse(depAddr); err == nil {
+						depAddr = r.Unqualified().String()
+					}
+				}
 				if _, ok := changeMap[depAddr]; ok {
 					deps[c.Address][depAddr] = true
 				}
 			}
+			// Check replaceTriggeredBy targets
+			if c.Desired.Lifecycle != nil {
+				for _, trigger := range c.Desired.Lifecycle.ReplaceTriggeredBy {
+					depAddr := replaceTriggerAddr(trigger)
+					if _, ok := changeMap[depAddr]; ok && depAddr != c.Address {
+						deps[c.Address][depAddr] = true
+					}
+				}
+			}
 		}
 	}
 
@@ -279,6 +296,7 @@ func (e *Engine) applyChange(ctx context.Context, change *ir.ResourceChange, sta
 
 	var desiredJSON []byte
 	var priorJSON []byte
+	var resolvedInputs map[string]any
 	var name, typ string
 
 	if change.Desired != nil {
@@ -287,7 +305,15 @@ func (e *Engine) applyChange(ctx context.Context, change *ir.ResourceChange, sta
 		props := normalizeValue(change.Desired.Properties)
 		mu.Lock()
 		resolvedProps := resolveReferences(props, state)
+		resolvedInputs, _ = resolvedProps.(map[string]any)
+		var preErr error
+		if len(change.Desired.Preconditions) > 0 {
+			preErr = checkConditions("precondition", addr, change.Desired.Preconditions, resolvedInputs, state)
+		}
 		mu.Unlock()
+		if preErr != nil {
+			return preErr
+		}
 		desiredJSON, _ = json.Marshal(resolvedProps)
 	} else if change.Prior != nil {
 		name = change.Prior.Name
@@ -342,11 +368,13 @@ func (e *Engine) applyChange(ctx context.Context, change *ir.ResourceChange, sta
 		}
 
 		newResState := &ir.ResourceState{
-			Type:     typ,
-			Name:     name,
-			Provider: provName,
-			Inputs:   change.Desired.Properties,
-			Outputs:  outputs,
+			Type:      typ,
+			Name:      name,
+			Provider:  provName,
+			Inputs:    change.Desired.Properties,
+			Outputs:   outputs,
+			Sensitive: change.Desired.Sensitive,
+			Module:    change.Desired.Module,
 		}
 
 		mu.Lock()
@@ -356,7 +384,23 @@ func (e *Engine) applyChange(ctx context.Context, change *ir.ResourceChange, sta
 			(*stateIndex)[addr] = len(state.Resources)
 			state.Resources = append(state.Resources, newResState)
 		}
+		var postErr error
+		if len(change.Desired.Postconditions) > 0 {
+			// The resource exists at this point, so it stays in state even if a
+			// postcondition fails; the failure marks it (and its dependents) failed.
+			self := make(map[string]any)
+			for k, v := range resolvedInputs {
+				self[k] = v
+			}
+			for k, v := range outputs {
+				self[k] = v
+			}
+			postErr = checkConditions("postcondition", addr, change.Desired.Postconditions, self, state)
+		}
 		mu.Unlock()
+		if postErr != nil {
+			return postErr
+		}
 
 	case "DELETE":
 		var resourceID string
@@ -386,7 +430,7 @@ func (e *Engine) applyChange(ctx context.Context, change *ir.ResourceChange, sta
 			// Rebuild index after removal
 			*stateIndex = make(map[string]int)
 			for i, res := range state.Resources {
-				a := fmt.Sprintf("%s.%s", res.Type, res.Name)
+				a := addrs.OfModule(res.Module, res.Type, res.Name).String()
 				(*stateIndex)[a] = i
 			}
 		}
@@ -399,19 +443,19 @@ func (e *Engine) applyChange(ctx context.Context, change *ir.ResourceChange, sta
 func resolveReferences(val any, state *ir.State) any {
 	switch v := val.(type) {
 	case string:
-		if len(v) > 6 && v[:6] == "ptr://" {
-			for _, res := range state.Resources {
-				matchPrefix := fmt.Sprintf("ptr://%s:%s/%s/", res.Provider, res.Type, res.Name)
-				if len(v) > len(matchPrefix) && v[:len(matchPrefix)] == matchPrefix {
-					attr := v[len(matchPrefix):]
-					if val, ok := res.Outputs[attr]; ok {
-						return val
-					}
-					if val, ok := res.Inputs[attr]; ok {
-						return val
-					}
-					return v
+		ref, attr, err := addrs.ParseRef(v)
+		if err != nil || attr == "" {
+			return v
+		}
+		for _, res := range state.Resources {
+			if refAddr(res.Module, res.Type, res.Name, res.Provider).Equal(ref) {
+				if val, ok := res.Outputs[attr]; ok {
+					return val
+				}
+				if val, ok := res.Inputs[attr]; ok {
+					return val
 				}
+				return v
 			}
 		}
 		return v
@@ -46,7 +46,7 @@ func TestApplyPlan_Create(t *testing.T) {
 	assert.Equal(t, "null_resource", newState.Resources[0].Type)
 	assert.Equal(t, "test1", newState.Resources[0].Name)
 	assert.Equal(t, "null-test1", newState.Resources[0].Outputs["id"])
-	assert.Equal(t, 1, newState.Serial)
+	assert.Equal(t, 0, newState.Serial) // Bumped when the state is written
 }
 
 func TestApplyPlan_Delete(t *testing.T) {
@@ -301,3 +301,74 @@ func TestApplyPlan_ResolveReferences(t *testing.T) {
 	assert.Equal(t, "null-test", list[0])
 	assert.Equal(t, "literal", list[1])
 }
+
+func TestApplyPlan_PostconditionFails(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	ctx := context.Background()
+
+	plan := &ir.Plan{
+		Changes: []*ir.ResourceChange{
+			{
+				Address: "null_resource.test1",
+				Action:  "CREATE",
+				Desired: &ir.Resource{
+					Type:     "null_resource",
+					Name:     "test1",
+					Provider: "null",
+					Properties: map[string]any{
+						"triggers": map[string]any{"a": "b"},
+					},
+					Postconditions: []*ir.Condition{
+						{Condition: `self.id == "null-other"`, ErrorMessage: "id must be null-other"},
+					},
+				},
+			},
+		},
+		Summary: &ir.PlanSummary{Create: 1},
+		Outputs: map[string]any{},
+	}
+
+	newState, err := eng.ApplyPlan(ctx, plan, &ir.State{Version: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "postcondition failed for null_resource.test1")
+	assert.Contains(t, err.Error(), "id must be null-other")
+	// The resource was created, so it is still recorded in state
+	require.Len(t, newState.Resources, 1)
+	assert.Equal(t, "null-test1", newState.Resources[0].Outputs["id"])
+}
+
+func TestApplyPlan_PostconditionPasses(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	ctx := context.Background()
+
+	plan := &ir.Plan{
+		Changes: []*ir.ResourceChange{
+			{
+				Address: "null_resource.test1",
+				Action:  "CREATE",
+				Desired: &ir.Resource{
+					Type:     "null_resource",
+					Name:     "test1",
+					Provider: "null",
+					Properties: map[string]any{
+						"triggers": map[string]any{"a": "b"},
+					},
+					Postconditions: []*ir.Condition{
+						{Condition: `self.id =~ "^null-"`, ErrorMessage: "unexpected id"},
+					},
+				},
+			},
+		},
+		Summary: &ir.PlanSummary{Create: 1},
+		Outputs: map[string]any{},
+	}
+
+	_, err := eng.ApplyPlan(ctx, plan, &ir.State{Version: 1})
+	require.NoError(t, err)
+}
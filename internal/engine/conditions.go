@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// conditionOperators are the supported binary operators, longest first so
+// that ">=" is matched before ">".
+var conditionOperators = []string{"==", "!=", "=~", ">=", "<=", ">", "<"}
+
+// checkConditions evaluates each condition against the given values and returns
+// an error describing the first one that does not hold. Conditions that depend on
+// values not yet known (unresolved ptr:// references) are skipped.
+func checkConditions(kind, addr string, conds []*ir.Condition, self map[string]any, state *ir.State) error {
+	for _, c := range conds {
+		if c == nil || strings.TrimSpace(c.Condition) == "" {
+			continue
+		}
+		ok, known, err := evalCondition(c.Condition, self, state)
+		if err != nil {
+			return fmt.Errorf("invalid %s for %s: %w", kind, addr, err)
+		}
+		if !known || ok {
+			continue
+		}
+		msg := c.ErrorMessage
+		if msg == "" {
+			msg = "condition not satisfied"
+		}
+		return fmt.Errorf("%s failed for %s: %s (condition: %s)", kind, addr, msg, c.Condition)
+	}
+	return nil
+}
+
+// evalCondition evaluates a condition expression of the form `<operand>` (truthiness)
+// or `<operand> <op> <operand>`. Operands may be `self.<path>`, a `ptr://` reference,
+// a quoted string, a number, true, false or null.
+// The second return value is false when an operand could not be resolved yet.
+func evalCondition(expr string, self map[string]any, state *ir.State) (bool, bool, error) {
+	lhsExpr, op, rhsExpr := splitCondition(expr)
+
+	lhs, known, err := evalOperand(lhsExpr, self, state)
+	if err != nil || !known {
+		return false, known, err
+	}
+	if op == "" {
+		return truthy(lhs), true, nil
+	}
+
+	rhs, known, err := evalOperand(rhsExpr, self, state)
+	if err != nil || !known {
+		return false, known, err
+	}
+
+	switch op {
+	case "==":
+		return fmt.Sprintf("%v", lhs) == fmt.Sprintf("%v", rhs), true, nil
+	case "!=":
+		return fmt.Sprintf("%v", lhs) != fmt.Sprintf("%v", rhs), true, nil
+	case "=~":
+		re, err := regexp.Compile(fmt.Sprintf("%v", rhs))
+		if err != nil {
+			return false, true, fmt.Errorf("invalid pattern %q: %w", rhs, err)
+		}
+		return re.MatchString(fmt.Sprintf("%v", lhs)), true, nil
+	default:
+		l, lok := toFloat(lhs)
+		r, rok := toFloat(rhs)
+		if !lok || !rok {
+			return false, true, fmt.Errorf("operator %s requires numeric operands, got %v and %v", op, lhs, rhs)
+		}
+		switch op {
+		case ">=":
+			return l >= r, true, nil
+		case "<=":
+			return l <= r, true, nil
+		case ">":
+			return l > r, true, nil
+		default:
+			return l < r, true, nil
+		}
+	}
+}
+
+// splitCondition splits an expression on the first operator found outside of quotes.
+func splitCondition(expr string) (string, string, string) {
+	inQuote := false
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '\\':
+			if inQuote {
+				i++
+			}
+			continue
+		case '"':
+			inQuote = !inQuote
+			continue
+		}
+		if inQuote {
+			continue
+		}
+		for _, op := range conditionOperators {
+			if strings.HasPrefix(expr[i:], op) {
+				return strings.TrimSpace(expr[:i]), op, strings.TrimSpace(expr[i+len(op):])
+			}
+		}
+	}
+	return strings.TrimSpace(expr), "", ""
+}
+
+func evalOperand(operand string, self map[string]any, state *ir.State) (any, bool, error) {
+	switch {
+	case operand == "":
+		return nil, true, fmt.Errorf("missing operand")
+	case operand == "null":
+		return nil, true, nil
+	case operand == "true":
+		return true, true, nil
+	case operand == "false":
+		return false, true, nil
+	case strings.HasPrefix(operand, `"`):
+		s, err := strconv.Unquote(operand)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid string literal %s", operand)
+		}
+		return s, true, nil
+	case strings.HasPrefix(operand, "ptr://"):
+		if state == nil {
+			return nil, false, nil
+		}
+		val := resolveReferences(operand, state)
+		if s, ok := val.(string); ok && strings.HasPrefix(s, "ptr://") {
+			return nil, false, nil
+		}
+		return val, true, nil
+	case operand == "self":
+		return self, true, nil
+	case strings.HasPrefix(operand, "self."):
+		val, _ := lookupPath(self, operand[len("self."):])
+		if s, ok := val.(string); ok && strings.HasPrefix(s, "ptr://") {
+			return nil, false, nil
+		}
+		return val, true, nil
+	}
+
+	if f, err := strconv.ParseFloat(operand, 64); err == nil {
+		return f, true, nil
+	}
+	return nil, true, fmt.Errorf("unknown operand %q", operand)
+}
+
+// lookupPath walks an attribute path (see parsePath) through nested maps and lists.
+func lookupPath(v any, path string) (any, bool) {
+	cur := v
+	for _, seg := range parsePath(path) {
+		if seg.Index == "" {
+			m, ok := asMap(cur)
+			if !ok {
+				return nil, false
+			}
+			if cur, ok = m[seg.Key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+		list, ok := cur.([]any)
+		if !ok {
+			return nil, false
+		}
+		n, err := strconv.Atoi(seg.Index)
+		if err != nil || n < 0 || n >= len(list) {
+			return nil, false
+		}
+		cur = list[n]
+	}
+	return cur, true
+}
+
+// asMap converts the map shapes produced by PKL decoding and JSON into map[string]any.
+func asMap(v any) (map[string]any, bool) {
+	switch val := v.(type) {
+	case map[string]any:
+		return val, true
+	case map[any]any:
+		return normalizeValue(val).(map[string]any), true
+	case map[string]string:
+		m := make(map[string]any, len(val))
+		for k, v := range val {
+			m[k] = v
+		}
+		return m, true
+	default:
+		return nil, false
+	}
+}
+
+func truthy(v any) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case string:
+		return val != ""
+	case float64:
+		return val != 0
+	case int:
+		return val != 0
+	case map[string]any:
+		return len(val) > 0
+	case []any:
+		return len(val) > 0
+	default:
+		return true
+	}
+}
+
+func toFloat(v any) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case string:
+		f, err := strconv.ParseFloat(val, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
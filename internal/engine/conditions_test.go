@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvalCondition(t *testing.T) {
+	self := map[string]any{
+		"ami":      "ami-12345",
+		"owner":    "123456789012",
+		"size":     float64(20),
+		"dns_name": "",
+		"tags":     map[string]any{"Name": "web"},
+		"rules":    []any{map[string]any{"port": float64(443)}},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`self.owner == "123456789012"`, true},
+		{`self.owner != "123456789012"`, false},
+		{`self.ami =~ "^ami-"`, true},
+		{`self.size >= 20`, true},
+		{`self.size < 10`, false},
+		{`self.tags.Name == "web"`, true},
+		{`self.rules[0].port == 443`, true},
+		{`self.dns_name`, false},
+		{`self.ami`, true},
+		{`self.missing == null`, true},
+		{`self.owner == "a==b"`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, known, err := evalCondition(tt.expr, self, nil)
+			require.NoError(t, err)
+			assert.True(t, known)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEvalCondition_UnknownReference(t *testing.T) {
+	self := map[string]any{"vpc_id": "ptr://aws:EC2.Vpc/main/id"}
+
+	_, known, err := evalCondition(`self.vpc_id != ""`, self, nil)
+	require.NoError(t, err)
+	assert.False(t, known)
+}
+
+func TestEvalCondition_Invalid(t *testing.T) {
+	_, _, err := evalCondition(`self.size > "abc"`, map[string]any{"size": 1}, nil)
+	assert.Error(t, err)
+
+	_, _, err = evalCondition(`self.size == bogus`, map[string]any{}, nil)
+	assert.Error(t, err)
+}
+
+func TestCheckConditions(t *testing.T) {
+	conds := []*ir.Condition{
+		{Condition: `self.acl == "private"`, ErrorMessage: "bucket must be private"},
+	}
+
+	err := checkConditions("precondition", "aws:S3.Bucket.logs", conds, map[string]any{"acl": "private"}, nil)
+	assert.NoError(t, err)
+
+	err = checkConditions("precondition", "aws:S3.Bucket.logs", conds, map[string]any{"acl": "public-read"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "precondition failed for aws:S3.Bucket.logs")
+	assert.Contains(t, err.Error(), "bucket must be private")
+}
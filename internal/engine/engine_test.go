@@ -184,14 +184,110 @@ func TestEngine_CreatePlan_IgnoreChanges(t *testing.T) {
 		},
 	}
 
-	// The null provider returns REPLACE for trigger changes.
-	// But since ChangedAttributes includes "triggers" and that's in IgnoreChanges,
-	// and the action is REPLACE (not UPDATE), IgnoreChanges only applies to UPDATE.
-	// So this test verifies the behavior correctly.
+	// The null provider returns REPLACE for trigger changes, but "triggers" is
+	// ignored so the desired value is replaced with the prior one before diffing.
 	plan, err := eng.CreatePlan(ctx, cfg, state)
 	require.NoError(t, err)
-	// REPLACE is not filtered by IgnoreChanges (only UPDATE is)
-	assert.Len(t, plan.Changes, 1)
+	assert.Len(t, plan.Changes, 0)
+}
+
+func TestEngine_CreatePlan_IgnoreAllReplaceUsesConfig(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	cfg := &ir.Config{
+		Resources: []*ir.Resource{
+			{Type: "null_resource", Name: "dep", Provider: "null"},
+			{
+				Type:     "null_resource",
+				Name:     "app",
+				Provider: "null",
+				Lifecycle: &ir.Lifecycle{
+					IgnoreChanges:      []string{"all"},
+					ReplaceTriggeredBy: []string{"null_resource.dep"},
+				},
+				Properties: map[string]any{
+					"triggers": map[string]any{"a": "new_value"},
+					"added":    "since the last apply",
+				},
+			},
+		},
+	}
+	state := &ir.State{
+		Resources: []*ir.ResourceState{{
+			Type:     "null_resource",
+			Name:     "app",
+			Provider: "null",
+			Inputs:   map[string]any{"triggers": map[string]any{"a": "old_value"}},
+			Outputs:  map[string]any{"id": "null-app"},
+		}},
+	}
+
+	// The replacement is created from the configuration, not the stale inputs
+	plan, err := eng.CreatePlan(context.Background(), cfg, state)
+	require.NoError(t, err)
+	var change *ir.ResourceChange
+	for _, c := range plan.Changes {
+		if c.Address == "null_resource.app" {
+			change = c
+		}
+	}
+	require.NotNil(t, change)
+	assert.Equal(t, "REPLACE", change.Action)
+	assert.Equal(t, map[string]any{"a": "new_value"}, change.Desired.Properties["triggers"])
+	assert.Equal(t, "since the last apply", change.Desired.Properties["added"])
+}
+
+func TestEngine_CreatePlan_IgnoreChangesNestedPath(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	ctx := context.Background()
+
+	newResource := func(ignore ...string) *ir.Config {
+		return &ir.Config{
+			Resources: []*ir.Resource{
+				{
+					Type:      "null_resource",
+					Name:      "nested",
+					Provider:  "null",
+					Lifecycle: &ir.Lifecycle{IgnoreChanges: ignore},
+					Properties: map[string]any{
+						"triggers": map[string]any{"a": "new_value", "b": "same"},
+					},
+				},
+			},
+		}
+	}
+
+	state := &ir.State{
+		Resources: []*ir.ResourceState{
+			{
+				Type:     "null_resource",
+				Name:     "nested",
+				Provider: "null",
+				Outputs: map[string]any{
+					"id":       "null-nested",
+					"triggers": map[string]any{"a": "old_value", "b": "same"},
+				},
+			},
+		},
+	}
+
+	plan, err := eng.CreatePlan(ctx, newResource("triggers.a"), state)
+	require.NoError(t, err)
+	assert.Len(t, plan.Changes, 0)
+
+	plan, err = eng.CreatePlan(ctx, newResource("all"), state)
+	require.NoError(t, err)
+	assert.Len(t, plan.Changes, 0)
+
+	plan, err = eng.CreatePlan(ctx, newResource("triggers.b"), state)
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 1)
+	assert.Equal(t, "REPLACE", plan.Changes[0].Action)
 }
 
 func TestEngine_CreatePlan_Timestamp(t *testing.T) {
@@ -244,3 +340,223 @@ func TestEngine_CreatePlan_DependencyOrder(t *testing.T) {
 	assert.Equal(t, "null_resource.first", plan.Changes[0].Address)
 	assert.Equal(t, "null_resource.second", plan.Changes[1].Address)
 }
+
+func TestEngine_CreatePlan_PreconditionFails(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	ctx := context.Background()
+
+	cfg := &ir.Config{
+		Resources: []*ir.Resource{
+			{
+				Type:     "null_resource",
+				Name:     "checked",
+				Provider: "null",
+				Properties: map[string]any{
+					"triggers": map[string]any{"env": "dev"},
+				},
+				Preconditions: []*ir.Condition{
+					{Condition: `self.triggers.env == "prod"`, ErrorMessage: "only prod is allowed"},
+				},
+			},
+		},
+	}
+
+	_, err := eng.CreatePlan(ctx, cfg, &ir.State{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "precondition failed for null_resource.checked")
+	assert.Contains(t, err.Error(), "only prod is allowed")
+}
+
+func TestEngine_CreatePlan_PreconditionDeferredForUnknownRef(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	ctx := context.Background()
+
+	cfg := &ir.Config{
+		Resources: []*ir.Resource{
+			{Type: "null_resource", Name: "a", Provider: "null"},
+			{
+				Type:     "null_resource",
+				Name:     "b",
+				Provider: "null",
+				Properties: map[string]any{
+					"triggers": map[string]any{"upstream": "ptr://null:null_resource/a/id"},
+				},
+				Preconditions: []*ir.Condition{
+					{Condition: `self.triggers.upstream == "null-a"`, ErrorMessage: "unexpected upstream"},
+				},
+			},
+		},
+	}
+
+	plan, err := eng.CreatePlan(ctx, cfg, &ir.State{})
+	require.NoError(t, err)
+	assert.Len(t, plan.Changes, 2)
+}
+
+func TestEngine_CreatePlan_ReplaceTriggeredBy(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	ctx := context.Background()
+
+	cfg := &ir.Config{
+		Resources: []*ir.Resource{
+			{
+				Type:     "null_resource",
+				Name:     "service",
+				Provider: "null",
+				Lifecycle: &ir.Lifecycle{
+					ReplaceTriggeredBy: []string{"null_resource.taskdef"},
+				},
+				Properties: map[string]any{"triggers": map[string]string{"a": "b"}},
+			},
+			{
+				Type:       "null_resource",
+				Name:       "taskdef",
+				Provider:   "null",
+				Properties: map[string]any{"triggers": map[string]string{"revision": "2"}},
+			},
+		},
+	}
+
+	state := &ir.State{
+		Resources: []*ir.ResourceState{
+			{
+				Type:     "null_resource",
+				Name:     "service",
+				Provider: "null",
+				Outputs:  map[string]any{"id": "null-service", "triggers": map[string]string{"a": "b"}},
+			},
+			{
+				Type:     "null_resource",
+				Name:     "taskdef",
+				Provider: "null",
+				Outputs:  map[string]any{"id": "null-taskdef", "triggers": map[string]string{"revision": "1"}},
+			},
+		},
+	}
+
+	plan, err := eng.CreatePlan(ctx, cfg, state)
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 2)
+
+	assert.Equal(t, "null_resource.taskdef", plan.Changes[0].Address)
+	assert.Equal(t, "null_resource.service", plan.Changes[1].Address)
+	assert.Equal(t, "REPLACE", plan.Changes[1].Action)
+	assert.Equal(t, "replace triggered by null_resource.taskdef", plan.Changes[1].Reason)
+	assert.Equal(t, 2, plan.Summary.Replace)
+
+	// No change to the trigger target means no replacement
+	cfg.Resources[1].Properties["triggers"] = map[string]string{"revision": "1"}
+	plan, err = eng.CreatePlan(ctx, cfg, state)
+	require.NoError(t, err)
+	assert.Len(t, plan.Changes, 0)
+}
+
+func TestEngine_CreatePlan_ReplaceTriggeredBy_Resolved(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+	eng := NewEngine(reg)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		target  string // Name of the changing resource
+		trigger string
+	}{
+		{"qualified ptr reference", "taskdef", "ptr://null:null_resource/taskdef/id"},
+		{"count instance", "web[0]", "null_resource.web"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &ir.Config{
+				Resources: []*ir.Resource{
+					{
+						Type:       "null_resource",
+						Name:       "service",
+						Provider:   "null",
+						Lifecycle:  &ir.Lifecycle{ReplaceTriggeredBy: []string{tt.trigger}},
+						Properties: map[string]any{"triggers": map[string]string{"a": "b"}},
+					},
+					{
+						Type:       "null_resource",
+						Name:       tt.target,
+						Provider:   "null",
+						Properties: map[string]any{"triggers": map[string]string{"revision": "2"}},
+					},
+				},
+			}
+			state := &ir.State{
+				Resources: []*ir.ResourceState{
+					{
+						Type:     "null_resource",
+						Name:     "service",
+						Provider: "null",
+						Outputs:  map[string]any{"id": "null-service", "triggers": map[string]string{"a": "b"}},
+					},
+					{
+						Type:     "null_resource",
+						Name:     tt.target,
+						Provider: "null",
+						Outputs:  map[string]any{"id": "null-" + tt.target, "triggers": map[string]string{"revision": "1"}},
+					},
+				},
+			}
+
+			plan, err := eng.CreatePlan(ctx, cfg, state)
+			require.NoError(t, err)
+			require.Len(t, plan.Changes, 2)
+			assert.Equal(t, "null_resource."+tt.target, plan.Changes[0].Address)
+			assert.Equal(t, "null_resource.service", plan.Changes[1].Address)
+			assert.Equal(t, "REPLACE", plan.Changes[1].Action)
+			assert.Equal(t, "replace triggered by "+tt.trigger, plan.Changes[1].Reason)
+		})
+	}
+}
+
+func TestReplaceTrigger_Attribute(t *testing.T) {
+	planned := map[string]*ir.ResourceChange{
+		"aws:ECS.TaskDefinition.app": {
+			Address: "aws:ECS.TaskDefinition.app",
+			Action:  "UPDATE",
+			Desired: &ir.Resource{Properties: map[string]any{"family": "app", "cpu": 512}},
+			Prior:   &ir.Resource{Properties: map[string]any{"family": "app", "cpu": 256}},
+			Diff:    map[string]*ir.PropertyDiff{"cpu": {Action: "update"}},
+		},
+	}
+
+	res := &ir.Resource{
+		Type:     "aws:ECS.Service",
+		Name:     "app",
+		Provider: "aws",
+		Lifecycle: &ir.Lifecycle{
+			ReplaceTriggeredBy: []string{"ptr://aws:ECS.TaskDefinition/app/family"},
+		},
+	}
+	dag, err := BuildDAG([]*ir.Resource{
+		{Type: "aws:ECS.TaskDefinition", Name: "app", Provider: "aws"},
+		res,
+	})
+	require.NoError(t, err)
+
+	// An unchanged input doesn't trigger
+	assert.Empty(t, replaceTrigger(res, dag, planned))
+
+	res.Lifecycle.ReplaceTriggeredBy = []string{"ptr://aws:ECS.TaskDefinition/app/cpu"}
+	assert.Equal(t, "ptr://aws:ECS.TaskDefinition/app/cpu", replaceTrigger(res, dag, planned))
+
+	// A computed attribute may change on any update
+	res.Lifecycle.ReplaceTriggeredBy = []string{"ptr://aws:ECS.TaskDefinition/app/revision"}
+	assert.Equal(t, "ptr://aws:ECS.TaskDefinition/app/revision", replaceTrigger(res, dag, planned))
+
+	planned["aws:ECS.TaskDefinition.app"].Action = "REPLACE"
+	res.Lifecycle.ReplaceTriggeredBy = []string{"ptr://aws:ECS.TaskDefinition/app/family"}
+	assert.Equal(t, "ptr://aws:ECS.TaskDefinition/app/family", replaceTrigger(res, dag, planned))
+}
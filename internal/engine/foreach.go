@@ -42,15 +42,20 @@ func cloneResource(res *ir.Resource) *ir.Resource {
 		Type:     res.Type,
 		Name:     res.Name,
 		Provider: res.Provider,
+		Module:   res.Module,
 	}
 	if res.Lifecycle != nil {
 		clone.Lifecycle = &ir.Lifecycle{
 			CreateBeforeDestroy: res.Lifecycle.CreateBeforeDestroy,
 			PreventDestroy:      res.Lifecycle.PreventDestroy,
 			IgnoreChanges:       append([]string{}, res.Lifecycle.IgnoreChanges...),
+			ReplaceTriggeredBy:  append([]string{}, res.Lifecycle.ReplaceTriggeredBy...),
 		}
 	}
 	clone.DependsOn = append([]string{}, res.DependsOn...)
+	clone.Preconditions = append([]*ir.Condition{}, res.Preconditions...)
+	clone.Postconditions = append([]*ir.Condition{}, res.Postconditions...)
+	clone.Sensitive = append([]string{}, res.Sensitive...)
 
 	// Deep copy properties
 	clone.Properties = deepCopyMap(res.Properties)
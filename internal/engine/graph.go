@@ -2,8 +2,10 @@ package engine
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/picklr-io/picklr/internal/addrs"
 	"github.com/picklr-io/picklr/internal/ir"
 )
 
@@ -16,49 +18,90 @@ type DAG struct {
 
 type dagNode struct {
 	addr     string
-	edges    []string // resources this node depends on
-	revEdges []string // resources that depend on this node
+	edges    []string          // resources this node depends on
+	revEdges []string          // resources that depend on this node
+	sources  map[string]string // dependency address -> where the edge comes from
+}
+
+// addEdge records that the node depends on dep, keeping the first source seen.
+func (n *dagNode) addEdge(dep, source string) {
+	if n.sources == nil {
+		n.sources = make(map[string]string)
+	}
+	if _, ok := n.sources[dep]; ok {
+		return
+	}
+	n.sources[dep] = source
+	n.edges = append(n.edges, dep)
 }
 
 // BuildDAG constructs a dependency graph from resources.
-// It resolves both explicit DependsOn and implicit ptr:// references.
+// It resolves both explicit DependsOn and implicit ptr:// references. References
+// that do not match any resource are reported as an error.
 func BuildDAG(resources []*ir.Resource) (*DAG, error) {
 	dag := &DAG{
 		nodes: make(map[string]*dagNode),
 	}
 
-	// Build address map for lookups
-	addrMap := make(map[string]*ir.Resource)
 	for _, res := range resources {
 		addr := resourceAddr(res)
-		addrMap[addr] = res
 		dag.nodes[addr] = &dagNode{addr: addr}
 	}
 
-	// Build edges from DependsOn and ptr:// references
+	// Build edges from DependsOn, ptr:// references and replaceTriggeredBy
+	var unresolved []string
 	for _, res := range resources {
 		addr := resourceAddr(res)
 		node := dag.nodes[addr]
 
 		// Explicit DependsOn
 		for _, dep := range res.DependsOn {
-			if _, ok := dag.nodes[dep]; ok {
-				node.edges = append(node.edges, dep)
+			matches := dag.resolveAddr(dep)
+			if len(matches) == 0 {
+				unresolved = append(unresolved, fmt.Sprintf("%s: dependsOn %q does not match any resource%s", addr, dep, dag.suggest(dep)))
+			}
+			for _, m := range matches {
+				node.addEdge(m, "dependsOn")
 			}
 		}
 
 		// Implicit ptr:// references in properties
-		refs := extractPtrRefs(res.Properties)
-		for _, ref := range refs {
-			depAddr := ptrRefToAddr(ref)
-			if depAddr != "" {
-				if _, ok := dag.nodes[depAddr]; ok {
-					node.edges = append(node.edges, depAddr)
+		for _, ref := range extractPtrRefPaths(res.Properties, "properties") {
+			if isRemoteStateRef(ref.Ref) {
+				// Read from another project's state, not a dependency
+				continue
+			}
+			depAddr := ptrRefToAddr(ref.Ref)
+			matches := dag.resolveAddr(depAddr)
+			if len(matches) == 0 {
+				unresolved = append(unresolved, fmt.Sprintf("%s: %s references %q which does not match any resource%s", addr, ref.Path, ref.Ref, dag.suggest(depAddr)))
+			}
+			for _, m := range matches {
+				node.addEdge(m, fmt.Sprintf("ptr:// reference in %s", ref.Path))
+			}
+		}
+
+		// replaceTriggeredBy targets must be planned before this resource
+		if res.Lifecycle != nil {
+			for _, trigger := range res.Lifecycle.ReplaceTriggeredBy {
+				depAddr := replaceTriggerAddr(trigger)
+				matches := dag.resolveAddr(depAddr)
+				if len(matches) == 0 {
+					unresolved = append(unresolved, fmt.Sprintf("%s: lifecycle.replaceTriggeredBy %q does not match any resource%s", addr, trigger, dag.suggest(depAddr)))
+				}
+				for _, m := range matches {
+					if m != addr {
+						node.addEdge(m, "lifecycle.replaceTriggeredBy")
+					}
 				}
 			}
 		}
 	}
 
+	if len(unresolved) > 0 {
+		return nil, fmt.Errorf("unresolved references in resource graph:\n  %s", strings.Join(unresolved, "\n  "))
+	}
+
 	// Build reverse edges
 	for addr, node := range dag.nodes {
 		for _, dep := range node.edges {
@@ -82,6 +125,85 @@ func BuildDAG(resources []*ir.Resource) (*DAG, error) {
 	return dag, nil
 }
 
+// resolveAddr returns the graph nodes a reference points at. A reference without
+// an instance key matches every for_each/count instance, a provider-qualified
+// reference (null:null_resource.x) also matches the unqualified address, and a
+// module path (module.net) matches every resource of the module's instances.
+func (d *DAG) resolveAddr(addr string) []string {
+	if addr == "" {
+		return nil
+	}
+	if _, ok := d.nodes[addr]; ok {
+		return []string{addr}
+	}
+	var matches []string
+	if m, err := addrs.ParseModule(addr); err == nil {
+		for node := range d.nodes {
+			if n, err := addrs.Parse(node); err == nil && addrs.WithinModule(n.Module, m) {
+				matches = append(matches, node)
+			}
+		}
+		sort.Strings(matches)
+		return matches
+	}
+	if r, err := addrs.Parse(addr); err == nil && r.Key == nil {
+		for node := range d.nodes {
+			if n, err := addrs.Parse(node); err == nil && n.Key != nil && n.NoKey().Equal(r) {
+				matches = append(matches, node)
+			}
+		}
+	}
+	if len(matches) > 0 {
+		sort.Strings(matches)
+		return matches
+	}
+	if r, err := addrs.Parse(addr); err == nil && r.Provider != "" {
+		return d.resolveAddr(r.Unqualified().String())
+	}
+	return nil
+}
+
+// suggest returns a " (did you mean ...?)" hint naming the closest known address,
+// or "" if nothing is close enough.
+func (d *DAG) suggest(addr string) string {
+	best, bestDist := "", -1
+	for node := range d.nodes {
+		dist := levenshtein(addr, node)
+		if bestDist < 0 || dist < bestDist || (dist == bestDist && node < best) {
+			best, bestDist = node, dist
+		}
+	}
+	maxDist := len(addr) / 3
+	if maxDist < 2 {
+		maxDist = 2
+	}
+	if best == "" || bestDist > maxDist {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
 // BuildDAGFromState constructs a dependency graph from state resources (for destroy).
 func BuildDAGFromState(resources []*ir.ResourceState) (*DAG, error) {
 	dag := &DAG{
@@ -89,13 +211,13 @@ func BuildDAGFromState(resources []*ir.ResourceState) (*DAG, error) {
 	}
 
 	for _, res := range resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
-		dag.nodes[addr] = &dagNode{addr: addr}
+		addr := addrs.OfModule(res.Module, res.Type, res.Name).String()
+		node := &dagNode{addr: addr}
+		dag.nodes[addr] = node
 
 		// Build edges from Dependencies field
 		for _, dep := range res.Dependencies {
-			dag.nodes[addr] = &dagNode{addr: addr}
-			dag.nodes[addr].edges = append(dag.nodes[addr].edges, dep)
+			node.addEdge(dep, "state dependency")
 		}
 	}
 
@@ -129,6 +251,52 @@ func BuildDAGFromState(resources []*ir.ResourceState) (*DAG, error) {
 	return dag, nil
 }
 
+// BuildDAGFromEdges constructs a dependency graph of arbitrary nodes, such as the
+// projects of a stack, from the dependencies of each node. Dependencies on nodes
+// that are not in edges are reported as an error.
+func BuildDAGFromEdges(edges map[string][]string) (*DAG, error) {
+	dag := &DAG{
+		nodes: make(map[string]*dagNode),
+	}
+	for addr := range edges {
+		dag.nodes[addr] = &dagNode{addr: addr}
+	}
+
+	var unknown []string
+	for addr, deps := range edges {
+		for _, dep := range deps {
+			if _, ok := dag.nodes[dep]; !ok {
+				unknown = append(unknown, fmt.Sprintf("%s: depends on unknown %q%s", addr, dep, dag.suggest(dep)))
+				continue
+			}
+			dag.nodes[addr].addEdge(dep, "dependsOn")
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("invalid dependencies:\n  %s", strings.Join(unknown, "\n  "))
+	}
+
+	for addr, node := range dag.nodes {
+		for _, dep := range node.edges {
+			dag.nodes[dep].revEdges = append(dag.nodes[dep].revEdges, addr)
+		}
+	}
+
+	order, err := dag.topoSort()
+	if err != nil {
+		return nil, err
+	}
+	dag.order = order
+
+	dag.revOrder = make([]string, len(order))
+	for i, addr := range order {
+		dag.revOrder[len(order)-1-i] = addr
+	}
+
+	return dag, nil
+}
+
 // CreationOrder returns resources in dependency-respecting creation order.
 func (d *DAG) CreationOrder() []string {
 	return d.order
@@ -179,13 +347,88 @@ func (d *DAG) topoSort() ([]string, error) {
 	}
 
 	if len(sorted) != len(d.nodes) {
-		return nil, fmt.Errorf("dependency cycle detected in resource graph")
+		return nil, d.cycleError(inDegree)
 	}
 
 	return sorted, nil
 }
 
-// ResourceAddrPublic returns the address of a resource (type.name). Exported for CLI use.
+// cycleError describes one cycle among the nodes Kahn's algorithm could not sort,
+// e.g. "a -> b -> a", along with where each edge comes from.
+func (d *DAG) cycleError(inDegree map[string]int) error {
+	var remaining []string
+	for addr, deg := range inDegree {
+		if deg > 0 {
+			remaining = append(remaining, addr)
+		}
+	}
+	sort.Strings(remaining)
+
+	cycle := d.findCycle(remaining)
+	if len(cycle) == 0 {
+		return fmt.Errorf("dependency cycle detected in resource graph")
+	}
+
+	var steps []string
+	for i := 0; i < len(cycle)-1; i++ {
+		from, to := cycle[i], cycle[i+1]
+		source := d.nodes[from].sources[to]
+		if source == "" {
+			source = "dependency"
+		}
+		steps = append(steps, fmt.Sprintf("%s depends on %s (%s)", from, to, source))
+	}
+	return fmt.Errorf("dependency cycle detected in resource graph: %s\n  %s",
+		strings.Join(cycle, " -> "), strings.Join(steps, "\n  "))
+}
+
+// findCycle returns a dependency path that starts and ends at the same node, using
+// a depth-first search from each of the given start nodes in order.
+func (d *DAG) findCycle(starts []string) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	color := make(map[string]int)
+	var stack []string
+
+	var visit func(addr string) []string
+	visit = func(addr string) []string {
+		color[addr] = visiting
+		stack = append(stack, addr)
+		deps := append([]string{}, d.nodes[addr].edges...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			switch color[dep] {
+			case visiting:
+				for i, a := range stack {
+					if a == dep {
+						return append(append([]string{}, stack[i:]...), dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		color[addr] = done
+		return nil
+	}
+
+	for _, addr := range starts {
+		if color[addr] == unvisited {
+			if cycle := visit(addr); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// ResourceAddrPublic returns the address of a resource (see addrs.Of). Exported for CLI use.
 func ResourceAddrPublic(res *ir.Resource) string {
 	return resourceAddr(res)
 }
@@ -198,6 +441,14 @@ func (d *DAG) Dependencies(addr string) []string {
 	return nil
 }
 
+// Dependents returns the list of nodes that directly depend on a given address.
+func (d *DAG) Dependents(addr string) []string {
+	if node, ok := d.nodes[addr]; ok {
+		return node.revEdges
+	}
+	return nil
+}
+
 // TransitiveDeps returns all transitive dependencies of a given address.
 func (d *DAG) TransitiveDeps(addr string) []string {
 	visited := make(map[string]bool)
@@ -210,6 +461,31 @@ func (d *DAG) TransitiveDeps(addr string) []string {
 	return result
 }
 
+// TransitiveDependents returns all resources that directly or indirectly depend on
+// the given address.
+func (d *DAG) TransitiveDependents(addr string) []string {
+	visited := make(map[string]bool)
+	d.collectDependents(addr, visited)
+	delete(visited, addr) // Don't include self
+	result := make([]string, 0, len(visited))
+	for dep := range visited {
+		result = append(result, dep)
+	}
+	return result
+}
+
+func (d *DAG) collectDependents(addr string, visited map[string]bool) {
+	if visited[addr] {
+		return
+	}
+	visited[addr] = true
+	if node, ok := d.nodes[addr]; ok {
+		for _, dep := range node.revEdges {
+			d.collectDependents(dep, visited)
+		}
+	}
+}
+
 func (d *DAG) collectDeps(addr string, visited map[string]bool) {
 	if visited[addr] {
 		return
@@ -222,13 +498,13 @@ func (d *DAG) collectDeps(addr string, visited map[string]bool) {
 	}
 }
 
-// resourceAddr returns the address of a resource (type.name).
+// resourceAddr returns the address of a resource (see addrs.Of).
 func resourceAddr(res *ir.Resource) string {
 	t := res.Type
 	if t == "" {
 		t = "null_resource"
 	}
-	return fmt.Sprintf("%s.%s", t, res.Name)
+	return addrs.OfModule(res.Module, t, res.Name).String()
 }
 
 // extractPtrRefs extracts all ptr:// references from a property value.
@@ -255,21 +531,66 @@ func extractPtrRefs(v any) []string {
 	return refs
 }
 
-// ptrRefToAddr converts a ptr:// reference to a resource address.
+// ptrRefAt is a ptr:// reference together with the property path it appears at.
+type ptrRefAt struct {
+	Ref  string
+	Path string
+}
+
+// extractPtrRefPaths extracts all ptr:// references from a property value along with
+// their paths, e.g. properties.vpcId or properties.subnets[0]. Map keys are visited
+// in sorted order so that diagnostics are deterministic.
+func extractPtrRefPaths(v any, path string) []ptrRefAt {
+	var refs []ptrRefAt
+	switch val := v.(type) {
+	case string:
+		if strings.HasPrefix(val, "ptr://") {
+			refs = append(refs, ptrRefAt{Ref: val, Path: path})
+		}
+	case map[string]any, map[any]any:
+		m, _ := asMap(val)
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			refs = append(refs, extractPtrRefPaths(m[k], path+"."+k)...)
+		}
+	case []any:
+		for i, v := range val {
+			refs = append(refs, extractPtrRefPaths(v, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return refs
+}
+
+// ptrRefToAddr converts a ptr:// reference to a resource address, or returns ""
+// if ref is not a valid reference.
 // ptr://aws:EC2.Vpc/my-vpc/id -> aws:EC2.Vpc.my-vpc
 func ptrRefToAddr(ref string) string {
-	if !strings.HasPrefix(ref, "ptr://") {
+	r, _, err := addrs.ParseRef(ref)
+	if err != nil {
 		return ""
 	}
-	path := ref[6:] // Remove ptr://
-	// Format: provider:Type/name/attribute
-	// We need provider:Type.name as the address
+	return r.String()
+}
+
+// refAddr returns the address that ptr:// references to a resource use, whose
+// type is qualified with the provider.
+func refAddr(module, typ, name, provider string) addrs.Resource {
+	r := addrs.OfModule(module, typ, name)
+	if r.Provider == "" {
+		r.Provider = provider
+	}
+	return r
+}
 
-	// Find the last slash (attribute) and second-to-last (name)
-	parts := strings.SplitN(path, "/", 3)
-	if len(parts) < 2 {
-		return ""
+// replaceTriggerAddr returns the resource address referenced by a replaceTriggeredBy
+// entry, which is either a plain address or a ptr:// attribute reference.
+func replaceTriggerAddr(trigger string) string {
+	if strings.HasPrefix(trigger, "ptr://") {
+		return ptrRefToAddr(trigger)
 	}
-	// parts[0] = "aws:EC2.Vpc", parts[1] = "my-vpc", parts[2] = "id" (optional)
-	return fmt.Sprintf("%s.%s", parts[0], parts[1])
+	return trigger
 }
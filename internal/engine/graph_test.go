@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/picklr-io/picklr/internal/ir"
@@ -162,3 +163,86 @@ func indexOf(slice []string, item string) int {
 	}
 	return -1
 }
+
+func TestTransitiveDependents(t *testing.T) {
+	resources := []*ir.Resource{
+		{Type: "null_resource", Name: "a", Provider: "null"},
+		{Type: "null_resource", Name: "b", Provider: "null", DependsOn: []string{"null_resource.a"}},
+		{Type: "null_resource", Name: "c", Provider: "null", DependsOn: []string{"null_resource.b"}},
+		{Type: "null_resource", Name: "d", Provider: "null"},
+	}
+
+	dag, err := BuildDAG(resources)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"null_resource.b", "null_resource.c"}, dag.TransitiveDependents("null_resource.a"))
+	assert.Empty(t, dag.TransitiveDependents("null_resource.c"))
+}
+
+func TestBuildDAG_CyclePath(t *testing.T) {
+	resources := []*ir.Resource{
+		{Type: "null_resource", Name: "a", Provider: "null", DependsOn: []string{"null_resource.b"}},
+		{Type: "null_resource", Name: "b", Provider: "null", Properties: map[string]any{
+			"triggers": map[string]any{"c": "ptr://null:null_resource/c/id"},
+		}},
+		{Type: "null_resource", Name: "c", Provider: "null", DependsOn: []string{"null_resource.a"}},
+		{Type: "null_resource", Name: "d", Provider: "null", DependsOn: []string{"null_resource.a"}},
+	}
+
+	_, err := BuildDAG(resources)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "null_resource.a -> null_resource.b -> null_resource.c -> null_resource.a")
+	assert.Contains(t, err.Error(), "null_resource.a depends on null_resource.b (dependsOn)")
+	assert.Contains(t, err.Error(), "null_resource.b depends on null_resource.c (ptr:// reference in properties.triggers.c)")
+	assert.NotContains(t, err.Error(), "null_resource.d")
+}
+
+func TestBuildDAG_UnresolvedReferences(t *testing.T) {
+	resources := []*ir.Resource{
+		{Type: "aws:EC2.Vpc", Name: "main-vpc", Provider: "aws"},
+		{Type: "aws:EC2.Subnet", Name: "sub", Provider: "aws", DependsOn: []string{"aws:EC2.Vpc.main-vcp"}},
+		{Type: "aws:EC2.Instance", Name: "web", Provider: "aws", Properties: map[string]any{
+			"subnetIds": []any{"ptr://aws:EC2.Subnet/sbu/id"},
+		}},
+		{Type: "null_resource", Name: "x", Provider: "null", DependsOn: []string{"completely.unrelated"}},
+	}
+
+	_, err := BuildDAG(resources)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `aws:EC2.Subnet.sub: dependsOn "aws:EC2.Vpc.main-vcp" does not match any resource (did you mean "aws:EC2.Vpc.main-vpc"?)`)
+	assert.Contains(t, err.Error(), `aws:EC2.Instance.web: properties.subnetIds[0] references "ptr://aws:EC2.Subnet/sbu/id"`)
+	assert.Contains(t, err.Error(), `(did you mean "aws:EC2.Subnet.sub"?)`)
+	// No suggestion when nothing is close
+	assert.True(t, strings.HasSuffix(err.Error(), `dependsOn "completely.unrelated" does not match any resource`))
+}
+
+func TestBuildDAG_DependsOnAllInstances(t *testing.T) {
+	resources := []*ir.Resource{
+		{Type: "null_resource", Name: "web[0]", Provider: "null"},
+		{Type: "null_resource", Name: "web[1]", Provider: "null"},
+		{Type: "null_resource", Name: "lb", Provider: "null", DependsOn: []string{"null_resource.web"}},
+	}
+
+	dag, err := BuildDAG(resources)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"null_resource.web[0]", "null_resource.web[1]"}, dag.Dependencies("null_resource.lb"))
+}
+
+func TestBuildDAGFromEdges(t *testing.T) {
+	dag, err := BuildDAGFromEdges(map[string][]string{
+		"network":  nil,
+		"data":     {"network"},
+		"services": {"network", "data"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"network", "data", "services"}, dag.CreationOrder())
+	assert.Equal(t, []string{"services", "data", "network"}, dag.DestructionOrder())
+	assert.ElementsMatch(t, []string{"data", "services"}, dag.Dependents("network"))
+
+	_, err = BuildDAGFromEdges(map[string][]string{"network": nil, "data": {"netwrok"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `data: depends on unknown "netwrok" (did you mean "network"?)`)
+
+	_, err = BuildDAGFromEdges(map[string][]string{"a": {"b"}, "b": {"a"}})
+	assert.Error(t, err)
+}
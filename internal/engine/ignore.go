@@ -0,0 +1,166 @@
+package engine
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// ignoreAll is the ignoreChanges wildcard that ignores every attribute.
+const ignoreAll = "all"
+
+// pathSegment is one step of an attribute path: either a map key or a list index.
+// Index is "*" for every element, a number for a single element, or "" for a key step.
+type pathSegment struct {
+	Key   string
+	Index string
+}
+
+// parsePath splits an attribute path such as `containerDefinitions[*].image` or
+// `tags.LastModified` into segments.
+func parsePath(path string) []pathSegment {
+	var segs []pathSegment
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		rest := ""
+		if i := strings.Index(part, "["); i >= 0 {
+			key, rest = part[:i], part[i:]
+		}
+		if key != "" {
+			segs = append(segs, pathSegment{Key: key})
+		}
+		for _, idx := range strings.Split(rest, "[") {
+			if idx != "" {
+				segs = append(segs, pathSegment{Index: strings.TrimSuffix(idx, "]")})
+			}
+		}
+	}
+	return segs
+}
+
+// ignoresAll reports whether the lifecycle ignores every attribute.
+func ignoresAll(lc *ir.Lifecycle) bool {
+	if lc == nil {
+		return false
+	}
+	for _, p := range lc.IgnoreChanges {
+		if p == ignoreAll {
+			return true
+		}
+	}
+	return false
+}
+
+// stripIgnoredChanges returns a copy of desired in which every ignoreChanges path
+// takes its prior value, so ignored attributes never produce a diff regardless of
+// whether the provider reports changed attributes. Paths missing from prior are
+// removed from desired. With the "all" wildcard, the prior values are returned.
+// The result is only meant for updates in place: a resource that is replaced is
+// created from desired.
+func stripIgnoredChanges(desired map[string]any, prior *ir.ResourceState, lc *ir.Lifecycle) map[string]any {
+	if prior == nil || lc == nil || len(lc.IgnoreChanges) == 0 {
+		return desired
+	}
+
+	// Inputs hold what was last declared; fall back to provider outputs for
+	// resources that were imported or created before inputs were recorded.
+	priorVals := make(map[string]any)
+	for k, v := range prior.Outputs {
+		priorVals[k] = v
+	}
+	for k, v := range prior.Inputs {
+		priorVals[k] = v
+	}
+
+	if ignoresAll(lc) {
+		result := make(map[string]any, len(desired))
+		for k := range desired {
+			if v, ok := priorVals[k]; ok {
+				result[k] = deepCopyValue(normalizeValue(v))
+			}
+		}
+		return result
+	}
+
+	result := deepCopyMap(normalizeValue(desired).(map[string]any))
+	for _, path := range lc.IgnoreChanges {
+		segs := parsePath(path)
+		if len(segs) == 0 {
+			continue
+		}
+		if v, keep := applyPriorAtPath(result, normalizeValue(priorVals), true, segs); keep {
+			result, _ = v.(map[string]any)
+		}
+	}
+	return result
+}
+
+// applyPriorAtPath replaces the value at segs in desired with the value at the same
+// path in prior. The bool result is false when the value should be removed.
+func applyPriorAtPath(desired, prior any, priorOK bool, segs []pathSegment) (any, bool) {
+	if len(segs) == 0 {
+		if !priorOK {
+			return nil, false
+		}
+		return deepCopyValue(prior), true
+	}
+
+	seg := segs[0]
+	if seg.Index == "" {
+		m, ok := asMap(desired)
+		if !ok {
+			return desired, true
+		}
+		pm, _ := asMap(prior)
+		pv, pok := pm[seg.Key]
+		if _, exists := m[seg.Key]; !exists && !pok {
+			return m, true
+		}
+		if v, keep := applyPriorAtPath(m[seg.Key], pv, pok && priorOK, segs[1:]); keep {
+			m[seg.Key] = v
+		} else {
+			delete(m, seg.Key)
+		}
+		return m, true
+	}
+
+	list, ok := desired.([]any)
+	if !ok {
+		return desired, true
+	}
+	priorList, _ := prior.([]any)
+	for i := range list {
+		if seg.Index != "*" {
+			if n, err := strconv.Atoi(seg.Index); err != nil || n != i {
+				continue
+			}
+		}
+		var pv any
+		pok := i < len(priorList)
+		if pok {
+			pv = priorList[i]
+		}
+		// Elements can't be removed from the middle of a list, so a path with no
+		// prior value leaves the desired element as is.
+		if v, keep := applyPriorAtPath(list[i], pv, pok && priorOK, segs[1:]); keep {
+			list[i] = v
+		}
+	}
+	return list, true
+}
+
+// attrIgnored reports whether a changed attribute reported by a provider is
+// covered by one of the ignoreChanges paths.
+func attrIgnored(attr string, ignore []string) bool {
+	for _, p := range ignore {
+		if p == ignoreAll || p == attr {
+			return true
+		}
+		// An ignored parent covers all nested attributes
+		if strings.HasPrefix(attr, p+".") || strings.HasPrefix(attr, p+"[") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePath(t *testing.T) {
+	assert.Equal(t, []pathSegment{{Key: "tags"}, {Key: "LastModified"}}, parsePath("tags.LastModified"))
+	assert.Equal(t, []pathSegment{{Key: "containerDefinitions"}, {Index: "*"}, {Key: "image"}}, parsePath("containerDefinitions[*].image"))
+	assert.Equal(t, []pathSegment{{Key: "matrix"}, {Index: "0"}, {Index: "1"}}, parsePath("matrix[0][1]"))
+}
+
+func TestStripIgnoredChanges_NestedKey(t *testing.T) {
+	desired := map[string]any{
+		"name": "bucket",
+		"tags": map[string]any{"Env": "prod", "LastModified": "today"},
+	}
+	prior := &ir.ResourceState{
+		Inputs: map[string]any{
+			"name": "bucket",
+			"tags": map[string]any{"Env": "dev", "LastModified": "yesterday"},
+		},
+	}
+
+	got := stripIgnoredChanges(desired, prior, &ir.Lifecycle{IgnoreChanges: []string{"tags.LastModified"}})
+	assert.Equal(t, map[string]any{"Env": "prod", "LastModified": "yesterday"}, got["tags"])
+	// The original desired map is left untouched
+	assert.Equal(t, "today", desired["tags"].(map[string]any)["LastModified"])
+}
+
+func TestStripIgnoredChanges_ListWildcard(t *testing.T) {
+	desired := map[string]any{
+		"containerDefinitions": []any{
+			map[string]any{"name": "app", "image": "app:v2"},
+			map[string]any{"name": "sidecar", "image": "proxy:v2"},
+		},
+	}
+	prior := &ir.ResourceState{
+		Inputs: map[string]any{
+			"containerDefinitions": []any{
+				map[string]any{"name": "app", "image": "app:v1"},
+				map[string]any{"name": "sidecar", "image": "proxy:v1"},
+			},
+		},
+	}
+
+	got := stripIgnoredChanges(desired, prior, &ir.Lifecycle{IgnoreChanges: []string{"containerDefinitions[*].image"}})
+	assert.Equal(t, prior.Inputs["containerDefinitions"], got["containerDefinitions"])
+
+	got = stripIgnoredChanges(desired, prior, &ir.Lifecycle{IgnoreChanges: []string{"containerDefinitions[1].image"}})
+	defs := got["containerDefinitions"].([]any)
+	assert.Equal(t, "app:v2", defs[0].(map[string]any)["image"])
+	assert.Equal(t, "proxy:v1", defs[1].(map[string]any)["image"])
+}
+
+func TestStripIgnoredChanges_MissingPriorRemoves(t *testing.T) {
+	desired := map[string]any{"tags": map[string]any{"Owner": "me"}}
+	prior := &ir.ResourceState{Inputs: map[string]any{"tags": map[string]any{}}}
+
+	got := stripIgnoredChanges(desired, prior, &ir.Lifecycle{IgnoreChanges: []string{"tags.Owner"}})
+	assert.Equal(t, map[string]any{}, got["tags"])
+}
+
+func TestStripIgnoredChanges_All(t *testing.T) {
+	desired := map[string]any{"name": "new", "size": 2.0, "extra": "x"}
+	prior := &ir.ResourceState{
+		Inputs:  map[string]any{"name": "old"},
+		Outputs: map[string]any{"id": "abc", "size": 1.0},
+	}
+
+	got := stripIgnoredChanges(desired, prior, &ir.Lifecycle{IgnoreChanges: []string{"all"}})
+	assert.Equal(t, map[string]any{"name": "old", "size": 1.0}, got)
+}
+
+func TestAttrIgnored(t *testing.T) {
+	ignore := []string{"tags", "containerDefinitions[*].image"}
+	assert.True(t, attrIgnored("tags", ignore))
+	assert.True(t, attrIgnored("tags.LastModified", ignore))
+	assert.True(t, attrIgnored("containerDefinitions[*].image", ignore))
+	assert.False(t, attrIgnored("name", ignore))
+	assert.False(t, attrIgnored("tagsExtra", ignore))
+	assert.True(t, attrIgnored("anything", []string{"all"}))
+}
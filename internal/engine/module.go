@@ -0,0 +1,345 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/addrs"
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// moduleOutputType is the type of references to module outputs,
+// ptr://picklr:module/<name>/<output>. The name carries the instance key of a
+// module created with count or forEach, e.g. net["prod"].
+const moduleOutputType = "picklr:module"
+
+// ptrTokenRe matches ptr:// references inside condition expressions.
+var ptrTokenRe = regexp.MustCompile(`ptr://\S+`)
+
+// ExpandModules flattens the module instances of cfg into its resources, which
+// are addressed under the module path, e.g. module.net["prod"].aws:EC2.Vpc.main.
+// Within a module, references to its own resources are qualified with the path,
+// and references to module outputs in the root resources and outputs are replaced
+// with the output values. It must be called before ExpandForEach.
+func ExpandModules(cfg *ir.Config) error {
+	if len(cfg.Modules) == 0 {
+		return nil
+	}
+	resources, outputs, err := expandModules(cfg.Modules, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	root := &moduleScope{outputs: outputs}
+	for _, res := range cfg.Resources {
+		if err := root.rewriteResource(res); err != nil {
+			return fmt.Errorf("%s: %w", resourceAddr(res), err)
+		}
+	}
+	for k, v := range cfg.Outputs {
+		if cfg.Outputs[k], err = root.rewriteValue(v); err != nil {
+			return fmt.Errorf("output %s: %w", k, err)
+		}
+	}
+
+	cfg.Resources = append(cfg.Resources, resources...)
+	cfg.Modules = nil
+	return nil
+}
+
+// moduleInstance is one instance of a module call along with the placeholder
+// replacements for its resources.
+type moduleInstance struct {
+	addrs.ModuleInstance
+	replacements map[string]string
+}
+
+// expandModules expands the module calls made at parent. Resources without their
+// own count or forEach take the ${count.index}, ${each.key} and ${each.value}
+// placeholders of the innermost module instance created with count or forEach;
+// replacements holds those of parent. It returns the resources and the outputs
+// of each instance, keyed by instance name, e.g. net["prod"].
+func expandModules(calls map[string]*ir.Module, parent []addrs.ModuleInstance, replacements map[string]string) ([]*ir.Resource, map[string]map[string]any, error) {
+	names := make([]string, 0, len(calls))
+	for name := range calls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var resources []*ir.Resource
+	outputs := make(map[string]map[string]any)
+	for _, name := range names {
+		call := calls[name]
+		for _, inst := range moduleInstances(name, call, replacements) {
+			path := append(append([]addrs.ModuleInstance{}, parent...), inst.ModuleInstance)
+			modulePath := addrs.FormatModule(path)
+
+			children, childOutputs, err := expandModules(call.Modules, path, inst.replacements)
+			if err != nil {
+				return nil, nil, err
+			}
+			scope := &moduleScope{path: path, local: make(map[string]bool), outputs: childOutputs}
+			for _, res := range call.Resources {
+				scope.local[localAddr(res)] = true
+			}
+
+			var instResources []*ir.Resource
+			for _, res := range call.Resources {
+				clone := cloneResource(res)
+				clone.Count, clone.ForEach, clone.Timeout = res.Count, deepCopyMap(res.ForEach), res.Timeout
+				clone.Module = modulePath
+				if inst.replacements != nil && res.Count == 0 && len(res.ForEach) == 0 {
+					clone.Properties = substituteAll(clone.Properties, inst.replacements)
+				}
+				if err := scope.rewriteResource(clone); err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", resourceAddr(clone), err)
+				}
+				instResources = append(instResources, clone)
+			}
+			instResources = append(instResources, children...)
+
+			// dependsOn of the call names modules and resources of the caller
+			caller := &moduleScope{path: parent}
+			for _, dep := range call.DependsOn {
+				dep = caller.rewriteAddr(dep)
+				for _, res := range instResources {
+					res.DependsOn = append(res.DependsOn, dep)
+				}
+			}
+			resources = append(resources, instResources...)
+
+			out := make(map[string]any, len(call.Outputs))
+			for k, v := range call.Outputs {
+				if inst.replacements != nil {
+					v = substituteValue(v, inst.replacements)
+				}
+				if out[k], err = scope.rewriteValue(v); err != nil {
+					return nil, nil, fmt.Errorf("%s: output %s: %w", modulePath, k, err)
+				}
+			}
+			outputs[inst.Name+keyString(inst.Key)] = out
+		}
+	}
+	return resources, outputs, nil
+}
+
+// moduleInstances returns the instances of a module call, one per count index or
+// forEach key, or a single unkeyed instance that inherits the replacements of
+// its parent.
+func moduleInstances(name string, call *ir.Module, replacements map[string]string) []moduleInstance {
+	if call.Count > 0 {
+		instances := make([]moduleInstance, call.Count)
+		for i := range instances {
+			instances[i] = moduleInstance{
+				ModuleInstance: addrs.ModuleInstance{Name: name, Key: addrs.IntKey(i)},
+				replacements:   map[string]string{"${count.index}": strconv.Itoa(i)},
+			}
+		}
+		return instances
+	}
+	if len(call.ForEach) > 0 {
+		keys := make([]string, 0, len(call.ForEach))
+		for k := range call.ForEach {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		instances := make([]moduleInstance, len(keys))
+		for i, k := range keys {
+			instances[i] = moduleInstance{
+				ModuleInstance: addrs.ModuleInstance{Name: name, Key: addrs.StringKey(k)},
+				replacements: map[string]string{
+					"${each.key}":   k,
+					"${each.value}": fmt.Sprintf("%v", call.ForEach[k]),
+				},
+			}
+		}
+		return instances
+	}
+	return []moduleInstance{{ModuleInstance: addrs.ModuleInstance{Name: name}, replacements: replacements}}
+}
+
+// moduleScope rewrites the references made by the resources of one module
+// instance, or of the root module if path is empty.
+type moduleScope struct {
+	path    []addrs.ModuleInstance
+	local   map[string]bool           // Addresses of the instance's own resources, see localAddr
+	outputs map[string]map[string]any // Outputs of the modules it calls, by instance name
+}
+
+// localAddr returns the provider-qualified address of a module resource without
+// module path or instance key, which references to it are matched against.
+func localAddr(res *ir.Resource) string {
+	t := res.Type
+	if t == "" {
+		t = "null_resource"
+	}
+	return refAddr("", t, res.Name, res.Provider).NoKey().String()
+}
+
+// rewriteResource rewrites the references in the properties, dependsOn, lifecycle
+// and conditions of res.
+func (s *moduleScope) rewriteResource(res *ir.Resource) error {
+	if res.Properties != nil {
+		props, err := s.rewriteValue(res.Properties)
+		if err != nil {
+			return err
+		}
+		res.Properties = props.(map[string]any)
+	}
+
+	for i, dep := range res.DependsOn {
+		res.DependsOn[i] = s.rewriteAddr(dep)
+	}
+	if res.Lifecycle != nil {
+		for i, trigger := range res.Lifecycle.ReplaceTriggeredBy {
+			res.Lifecycle.ReplaceTriggeredBy[i] = s.rewriteAddr(trigger)
+		}
+	}
+	res.Preconditions = s.rewriteConditions(res.Preconditions)
+	res.Postconditions = s.rewriteConditions(res.Postconditions)
+	return nil
+}
+
+// rewriteValue rewrites the ptr:// references in v. A reference to a module output
+// is replaced by the output's value.
+func (s *moduleScope) rewriteValue(v any) (any, error) {
+	switch val := v.(type) {
+	case string:
+		if !strings.HasPrefix(val, "ptr://") {
+			return v, nil
+		}
+		return s.rewriteRef(val)
+	case map[string]any:
+		result := make(map[string]any, len(val))
+		for k, item := range val {
+			r, err := s.rewriteValue(item)
+			if err != nil {
+				return nil, err
+			}
+			result[k] = r
+		}
+		return result, nil
+	case []any:
+		result := make([]any, len(val))
+		for i, item := range val {
+			r, err := s.rewriteValue(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = r
+		}
+		return result, nil
+	default:
+		return v, nil
+	}
+}
+
+// rewriteRef resolves a reference to a module output, and qualifies a reference
+// to a resource of the scope or of a module it calls with the module path.
+func (s *moduleScope) rewriteRef(ref string) (any, error) {
+	r, attr, err := addrs.ParseRef(ref)
+	if err != nil {
+		return ref, nil
+	}
+	if len(r.Module) > 0 {
+		return r.InModule(s.path).Ref(attr), nil
+	}
+	if r.FullType() == moduleOutputType {
+		outputs, ok := s.outputs[r.InstanceName()]
+		if !ok {
+			return nil, s.unknownModule(r.InstanceName())
+		}
+		v, ok := outputs[attr]
+		if !ok {
+			return nil, fmt.Errorf("module %s has no output %q", r.InstanceName(), attr)
+		}
+		return v, nil
+	}
+	if s.isLocal(r) {
+		return r.InModule(s.path).Ref(attr), nil
+	}
+	return ref, nil
+}
+
+// rewriteAddr rewrites a dependsOn or replaceTriggeredBy entry, which is a ptr://
+// reference, a module path or a resource address.
+func (s *moduleScope) rewriteAddr(addr string) string {
+	if strings.HasPrefix(addr, "ptr://") {
+		if v, err := s.rewriteRef(addr); err == nil {
+			if ref, ok := v.(string); ok {
+				return ref
+			}
+		}
+		return addr
+	}
+	if len(s.path) == 0 {
+		return addr
+	}
+	if strings.HasPrefix(addr, "module.") {
+		if _, err := addrs.ParseModule(addr); err == nil {
+			return addrs.FormatModule(s.path) + "." + addr
+		}
+	}
+	if r, err := addrs.Parse(addr); err == nil && (len(r.Module) > 0 || s.isLocal(r)) {
+		return r.InModule(s.path).String()
+	}
+	return addr
+}
+
+// rewriteConditions rewrites the ptr:// references in condition expressions.
+func (s *moduleScope) rewriteConditions(conds []*ir.Condition) []*ir.Condition {
+	if len(s.path) == 0 || len(conds) == 0 {
+		return conds
+	}
+	result := make([]*ir.Condition, len(conds))
+	for i, c := range conds {
+		rewritten := *c
+		rewritten.Condition = ptrTokenRe.ReplaceAllStringFunc(c.Condition, s.rewriteAddr)
+		result[i] = &rewritten
+	}
+	return result
+}
+
+// isLocal reports whether r, without module path, is a resource of the scope.
+func (s *moduleScope) isLocal(r addrs.Resource) bool {
+	if len(s.path) == 0 {
+		return false
+	}
+	r = r.NoKey()
+	if s.local[r.String()] {
+		return true
+	}
+	// Unqualified addresses such as null_resource.x match the qualified type
+	for local := range s.local {
+		if l, err := addrs.Parse(local); err == nil && r.Provider == "" && l.Unqualified().Equal(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// unknownModule describes a reference to a module instance that does not exist.
+func (s *moduleScope) unknownModule(name string) error {
+	var candidates []string
+	for inst := range s.outputs {
+		if strings.HasPrefix(inst, name+"[") {
+			candidates = append(candidates, inst)
+		}
+	}
+	if len(candidates) > 0 {
+		sort.Strings(candidates)
+		return fmt.Errorf("module %s has several instances; reference one of %s", name, strings.Join(candidates, ", "))
+	}
+	return fmt.Errorf("unknown module %q", name)
+}
+
+// keyString formats an instance key, or returns "" for nil.
+func keyString(k addrs.InstanceKey) string {
+	if k == nil {
+		return ""
+	}
+	return k.String()
+}
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// networkModule is a module with a vpc and a subnet that references it.
+func networkModule() *ir.Module {
+	return &ir.Module{
+		Resources: []*ir.Resource{
+			{
+				Type:       "null_resource",
+				Name:       "vpc",
+				Provider:   "null",
+				Properties: map[string]any{"triggers": map[string]any{"env": "${each.key}", "cidr": "${each.value}"}},
+			},
+			{
+				Type:       "null_resource",
+				Name:       "subnet",
+				Provider:   "null",
+				Properties: map[string]any{"triggers": map[string]any{"vpc": "ptr://null:null_resource/vpc/id"}},
+				DependsOn:  []string{"null_resource.vpc"},
+			},
+		},
+		Outputs: map[string]any{"vpc_id": "ptr://null:null_resource/vpc/id", "env": "${each.key}"},
+		ForEach: map[string]any{"prod": "10.0.0.0/16", "dev": "10.1.0.0/16"},
+	}
+}
+
+func TestExpandModules(t *testing.T) {
+	cfg := &ir.Config{
+		Resources: []*ir.Resource{{
+			Type:       "null_resource",
+			Name:       "app",
+			Provider:   "null",
+			Properties: map[string]any{"triggers": map[string]any{"vpc": `ptr://picklr:module/net["prod"]/vpc_id`}},
+			DependsOn:  []string{"module.net"},
+		}},
+		Outputs: map[string]any{"env": `ptr://picklr:module/net["dev"]/env`},
+		Modules: map[string]*ir.Module{"net": networkModule()},
+	}
+
+	require.NoError(t, ExpandModules(cfg))
+	assert.Nil(t, cfg.Modules)
+
+	var got []string
+	byAddr := make(map[string]*ir.Resource)
+	for _, res := range cfg.Resources {
+		got = append(got, resourceAddr(res))
+		byAddr[resourceAddr(res)] = res
+	}
+	assert.Equal(t, []string{
+		"null_resource.app",
+		`module.net["dev"].null_resource.vpc`,
+		`module.net["dev"].null_resource.subnet`,
+		`module.net["prod"].null_resource.vpc`,
+		`module.net["prod"].null_resource.subnet`,
+	}, got)
+
+	vpc := byAddr[`module.net["prod"].null_resource.vpc`]
+	assert.Equal(t, map[string]any{"env": "prod", "cidr": "10.0.0.0/16"}, vpc.Properties["triggers"])
+	subnet := byAddr[`module.net["prod"].null_resource.subnet`]
+	assert.Equal(t, `ptr://module.net["prod"].null:null_resource/vpc/id`, subnet.Properties["triggers"].(map[string]any)["vpc"])
+	assert.Equal(t, []string{`module.net["prod"].null_resource.vpc`}, subnet.DependsOn)
+
+	app := byAddr["null_resource.app"]
+	assert.Equal(t, `ptr://module.net["prod"].null:null_resource/vpc/id`, app.Properties["triggers"].(map[string]any)["vpc"])
+	assert.Equal(t, "dev", cfg.Outputs["env"])
+
+	dag, err := BuildDAG(cfg.Resources)
+	require.NoError(t, err)
+	assert.Len(t, dag.Dependencies("null_resource.app"), 4)
+}
+
+func TestExpandModules_Nested(t *testing.T) {
+	inner := &ir.Module{
+		Resources: []*ir.Resource{{Type: "null_resource", Name: "x", Provider: "null"}},
+		Outputs:   map[string]any{"id": "ptr://null:null_resource/x/id"},
+		Count:     2,
+	}
+	outer := &ir.Module{
+		Modules:   map[string]*ir.Module{"inner": inner},
+		Outputs:   map[string]any{"first": `ptr://picklr:module/inner[0]/id`},
+		DependsOn: []string{"null_resource.base"},
+	}
+	cfg := &ir.Config{
+		Resources: []*ir.Resource{{Type: "null_resource", Name: "base", Provider: "null"}},
+		Outputs:   map[string]any{"first": "ptr://picklr:module/outer/first"},
+		Modules:   map[string]*ir.Module{"outer": outer},
+	}
+
+	require.NoError(t, ExpandModules(cfg))
+	require.Len(t, cfg.Resources, 3)
+	assert.Equal(t, "module.outer.module.inner[1].null_resource.x", resourceAddr(cfg.Resources[2]))
+	assert.Equal(t, []string{"null_resource.base"}, cfg.Resources[2].DependsOn)
+	assert.Equal(t, "ptr://module.outer.module.inner[0].null:null_resource/x/id", cfg.Outputs["first"])
+}
+
+func TestExpandModules_Errors(t *testing.T) {
+	tests := []struct {
+		ref     string
+		wantErr string
+	}{
+		{"ptr://picklr:module/missing/vpc_id", `unknown module "missing"`},
+		{"ptr://picklr:module/net/vpc_id", `module net has several instances; reference one of net["dev"], net["prod"]`},
+		{`ptr://picklr:module/net["prod"]/nope`, `module net["prod"] has no output "nope"`},
+	}
+	for _, tt := range tests {
+		cfg := &ir.Config{
+			Outputs: map[string]any{"x": tt.ref},
+			Modules: map[string]*ir.Module{"net": networkModule()},
+		}
+		err := ExpandModules(cfg)
+		require.Error(t, err, tt.ref)
+		assert.Contains(t, err.Error(), tt.wantErr)
+	}
+}
+
+func TestEngine_PlanApply_Modules(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+	eng := NewEngine(reg)
+	ctx := context.Background()
+
+	cfg := &ir.Config{
+		Outputs: map[string]any{"prod_vpc": `ptr://picklr:module/net["prod"]/vpc_id`},
+		Modules: map[string]*ir.Module{"net": networkModule()},
+	}
+	plan, err := eng.CreatePlan(ctx, cfg, &ir.State{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, plan.Summary.Create)
+
+	newState, err := eng.ApplyPlan(ctx, plan, &ir.State{Version: 1})
+	require.NoError(t, err)
+	require.Len(t, newState.Resources, 4)
+	for _, res := range newState.Resources {
+		assert.Contains(t, []string{`module.net["dev"]`, `module.net["prod"]`}, res.Module)
+	}
+	assert.Equal(t, `ptr://module.net["prod"].null:null_resource/vpc/id`, newState.Outputs["prod_vpc"])
+
+	// Removing an instance deletes only its resources
+	cfg = &ir.Config{Modules: map[string]*ir.Module{"net": networkModule()}}
+	cfg.Modules["net"].ForEach = map[string]any{"prod": "10.0.0.0/16"}
+	plan, err = eng.CreatePlan(ctx, cfg, newState)
+	require.NoError(t, err)
+	var deleted []string
+	for _, change := range plan.Changes {
+		if change.Action == "DELETE" {
+			deleted = append(deleted, change.Address)
+		}
+	}
+	assert.ElementsMatch(t, []string{`module.net["dev"].null_resource.vpc`, `module.net["dev"].null_resource.subnet`}, deleted)
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/picklr-io/picklr/internal/addrs"
 	"github.com/picklr-io/picklr/internal/ir"
 	"github.com/picklr-io/picklr/internal/logging"
 	"github.com/picklr-io/picklr/internal/provider"
@@ -16,6 +18,10 @@ import (
 type Engine struct {
 	registry        *provider.Registry
 	ContinueOnError bool // If true, apply continues past failures instead of stopping
+
+	// RemoteStates are the read-only states of other projects that the config's
+	// remoteStates block names, keyed by that name.
+	RemoteStates map[string]*ir.State
 }
 
 func NewEngine(registry *provider.Registry) *Engine {
@@ -32,7 +38,12 @@ func (e *Engine) CreatePlan(ctx context.Context, cfg *ir.Config, state *ir.State
 // CreatePlanWithTargets generates a plan filtered to specific resource addresses.
 // If targets is nil or empty, all resources are planned.
 func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, state *ir.State, targets []string) (*ir.Plan, error) {
-	logging.Debug("creating plan", "resources", len(cfg.Resources), "state_resources", len(state.Resources), "targets", len(targets))
+	return e.CreatePlanWithOptions(ctx, cfg, state, PlanOptions{Targets: targets})
+}
+
+// CreatePlanWithOptions generates a plan restricted by target and exclude patterns.
+func (e *Engine) CreatePlanWithOptions(ctx context.Context, cfg *ir.Config, state *ir.State, opts PlanOptions) (*ir.Plan, error) {
+	logging.Debug("creating plan", "resources", len(cfg.Resources), "state_resources", len(state.Resources), "targets", len(opts.Targets), "excludes", len(opts.Excludes))
 	plan := &ir.Plan{
 		Metadata: &ir.PlanMetadata{
 			Timestamp: time.Now().UTC().Format(time.RFC3339),
@@ -42,6 +53,11 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 		Outputs: cfg.Outputs,
 	}
 
+	// 0. Flatten module instances into resources
+	if err := ExpandModules(cfg); err != nil {
+		return nil, err
+	}
+
 	// 1. Load all required providers
 	for _, res := range cfg.Resources {
 		if err := e.registry.LoadProvider(res.Provider); err != nil {
@@ -52,6 +68,15 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 	// 1.5 Expand for_each/count resources
 	cfg.Resources = ExpandForEach(cfg.Resources)
 
+	// 1.6 Substitute the outputs of remote states
+	serials, err := resolveRemoteStates(cfg, e.RemoteStates)
+	if err != nil {
+		return nil, err
+	}
+	plan.RemoteStateSerials = serials
+	plan.Warnings = append(plan.Warnings, remoteStateWarnings(serials, state)...)
+	plan.SensitiveOutputs = sensitiveOutputs(cfg)
+
 	// 2. Build dependency graph for ordering
 	dag, err := BuildDAG(cfg.Resources)
 	if err != nil {
@@ -61,7 +86,7 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 	// 3. Build state map for quick lookup
 	stateMap := make(map[string]*ir.ResourceState)
 	for _, res := range state.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
+		addr := addrs.OfModule(res.Module, res.Type, res.Name).String()
 		stateMap[addr] = res
 	}
 
@@ -72,22 +97,23 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 		configByAddr[addr] = res
 	}
 
-	// 5. Build target set (if targets specified, include their dependencies)
-	var targetSet map[string]bool
-	if len(targets) > 0 {
-		targetSet = make(map[string]bool)
-		for _, t := range targets {
-			targetSet[t] = true
-		}
-		// Add transitive dependencies of targets
-		for _, t := range targets {
-			for _, dep := range dag.TransitiveDeps(t) {
-				targetSet[dep] = true
-			}
+	// 5. Build target set (targets pull in their dependencies, excludes their dependents)
+	candidates := make([]string, 0, len(configByAddr)+len(stateMap))
+	for addr := range configByAddr {
+		candidates = append(candidates, addr)
+	}
+	for addr := range stateMap {
+		if _, ok := configByAddr[addr]; !ok {
+			candidates = append(candidates, addr)
 		}
 	}
+	stateDAG, _ := BuildDAGFromState(state.Resources)
+	targetSet, kept, warnings := selectTargets(opts, candidates, dag, stateDAG)
+	plan.Warnings = append(plan.Warnings, warnings...)
+	var skipped []string
 
 	// 6. Iterate desired resources in dependency order
+	planned := make(map[string]*ir.ResourceChange)
 	for _, addr := range dag.CreationOrder() {
 		res, ok := configByAddr[addr]
 		if !ok {
@@ -97,6 +123,7 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 		// Skip non-targeted resources
 		if targetSet != nil && !targetSet[addr] {
 			plan.Summary.NoOp++
+			skipped = append(skipped, addr)
 			continue
 		}
 
@@ -110,6 +137,16 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 			return nil, err
 		}
 
+		// Ignored attributes keep their prior values so they never produce a diff
+		configured := res
+		if res.Lifecycle != nil && len(res.Lifecycle.IgnoreChanges) > 0 {
+			if prior, ok := stateMap[addr]; ok {
+				effective := *res
+				effective.Properties = stripIgnoredChanges(res.Properties, prior, res.Lifecycle)
+				res = &effective
+			}
+		}
+
 		// Prepare request
 		props := normalizeValue(res.Properties)
 		desiredJSON, err := json.Marshal(props)
@@ -117,6 +154,14 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 			return nil, fmt.Errorf("failed to marshal properties for %s: %w", res.Name, err)
 		}
 
+		// Check preconditions against the inputs as resolved from current state
+		if len(res.Preconditions) > 0 {
+			resolved, _ := resolveReferences(props, state).(map[string]any)
+			if err := checkConditions("precondition", addr, res.Preconditions, resolved, state); err != nil {
+				return nil, err
+			}
+		}
+
 		var priorJSON []byte
 		if prior, ok := stateMap[addr]; ok {
 			priorJSON, _ = json.Marshal(prior.Outputs)
@@ -132,55 +177,75 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 			return nil, fmt.Errorf("plan failed for %s: %w", addr, err)
 		}
 
-		if resp.Action != pb.PlanResponse_NOOP {
-			// Enforce lifecycle rules
-			if err := enforceLifecycle(res, resp.Action, addr); err != nil {
-				return nil, err
-			}
-
-			// Apply IgnoreChanges filtering
-			action := resp.Action
-			if res.Lifecycle != nil && len(res.Lifecycle.IgnoreChanges) > 0 && action == pb.PlanResponse_UPDATE {
+		action := resp.Action
+		if action != pb.PlanResponse_NOOP {
+			// Apply IgnoreChanges filtering to provider-reported attributes
+			if res.Lifecycle != nil && len(res.Lifecycle.IgnoreChanges) > 0 && (action == pb.PlanResponse_UPDATE || action == pb.PlanResponse_REPLACE) {
 				action = filterIgnoredChanges(res, resp, stateMap[addr])
 			}
 
-			if action == pb.PlanResponse_NOOP {
-				plan.Summary.NoOp++
-				continue
-			}
-
-			change := &ir.ResourceChange{
-				Address: addr,
-				Action:  action.String(),
-				Desired: res,
+			// Enforce lifecycle rules
+			if err := enforceLifecycle(res, action, addr); err != nil {
+				return nil, err
 			}
+		}
 
-			if prior, ok := stateMap[addr]; ok {
-				change.Prior = &ir.Resource{
-					Type:       prior.Type,
-					Name:       prior.Name,
-					Provider:   prior.Provider,
-					Properties: prior.Inputs,
+		// Upgrade to REPLACE if a replaceTriggeredBy target is changing
+		var reason string
+		if _, exists := stateMap[addr]; exists && (action == pb.PlanResponse_NOOP || action == pb.PlanResponse_UPDATE) {
+			if trigger := replaceTrigger(res, dag, planned); trigger != "" {
+				action = pb.PlanResponse_REPLACE
+				reason = fmt.Sprintf("replace triggered by %s", trigger)
+				if err := enforceLifecycle(res, action, addr); err != nil {
+					return nil, err
 				}
-				change.Diff = buildPropertyDiff(prior.Inputs, res.Properties)
-			} else {
-				change.Diff = buildCreateDiff(res.Properties)
 			}
+		}
 
-			plan.Changes = append(plan.Changes, change)
+		if action == pb.PlanResponse_NOOP {
+			plan.Summary.NoOp++
+			continue
+		}
+		// A resource that is created anew takes every configured value, as
+		// ignored attributes only keep prior values for updates in place
+		if action == pb.PlanResponse_CREATE || action == pb.PlanResponse_REPLACE {
+			res = configured
+		}
+
+		change := &ir.ResourceChange{
+			Address: addr,
+			Action:  action.String(),
+			Desired: res,
+			Reason:  reason,
+		}
 
-			switch action {
-			case pb.PlanResponse_CREATE:
-				plan.Summary.Create++
-			case pb.PlanResponse_UPDATE:
-				plan.Summary.Update++
-			case pb.PlanResponse_REPLACE:
-				plan.Summary.Replace++
-			case pb.PlanResponse_DELETE:
-				plan.Summary.Delete++
+		if prior, ok := stateMap[addr]; ok {
+			change.Prior = &ir.Resource{
+				Type:       prior.Type,
+				Name:       prior.Name,
+				Provider:   prior.Provider,
+				Properties: prior.Inputs,
+				Sensitive:  prior.Sensitive,
+				Module:     prior.Module,
 			}
+			change.Diff = buildPropertyDiff(prior.Inputs, res.Properties)
 		} else {
-			plan.Summary.NoOp++
+			change.Diff = buildCreateDiff(res.Properties)
+		}
+		markSensitive(change.Diff, res.Sensitive)
+
+		plan.Changes = append(plan.Changes, change)
+		planned[addr] = change
+
+		switch action {
+		case pb.PlanResponse_CREATE:
+			plan.Summary.Create++
+		case pb.PlanResponse_UPDATE:
+			plan.Summary.Update++
+		case pb.PlanResponse_REPLACE:
+			plan.Summary.Replace++
+		case pb.PlanResponse_DELETE:
+			plan.Summary.Delete++
 		}
 	}
 
@@ -192,10 +257,12 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 	}
 
 	for _, res := range state.Resources {
-		addr := fmt.Sprintf("%s.%s", res.Type, res.Name)
+		addr := addrs.OfModule(res.Module, res.Type, res.Name).String()
 		if !configMap[addr] {
-			// Skip non-targeted resources for deletion too
-			if targetSet != nil && !targetSet[addr] {
+			// Skip non-targeted resources for deletion too, and those an
+			// excluded resource depends on
+			if targetSet != nil && (!targetSet[addr] || kept[addr]) {
+				skipped = append(skipped, addr)
 				continue
 			}
 			change := &ir.ResourceChange{
@@ -206,14 +273,21 @@ func (e *Engine) CreatePlanWithTargets(ctx context.Context, cfg *ir.Config, stat
 					Name:       res.Name,
 					Provider:   res.Provider,
 					Properties: res.Inputs,
+					Sensitive:  res.Sensitive,
+					Module:     res.Module,
 				},
 				Diff: buildDeleteDiff(res.Inputs),
 			}
+			markSensitive(change.Diff, res.Sensitive)
 			plan.Changes = append(plan.Changes, change)
 			plan.Summary.Delete++
 		}
 	}
 
+	if len(skipped) > 0 {
+		plan.Warnings = append(plan.Warnings, partialConvergenceWarning(skipped))
+	}
+
 	return plan, nil
 }
 
@@ -230,22 +304,70 @@ func enforceLifecycle(res *ir.Resource, action pb.PlanResponse_Action, addr stri
 	return nil
 }
 
-// filterIgnoredChanges checks if all changed attributes are in IgnoreChanges.
-// If so, downgrades the action to NOOP.
+// replaceTrigger returns the first replaceTriggeredBy entry of res whose target is
+// planned to change, or "" if none is. Entries are resolved like other references
+// in dag, so an entry without an instance key triggers on a change to any
+// instance. A plain address triggers on any change; a ptr:// reference to an
+// input attribute triggers only when that attribute changes or the target is
+// created or replaced. A reference to a computed attribute, which the plan can't
+// see change, triggers on any change.
+func replaceTrigger(res *ir.Resource, dag *DAG, planned map[string]*ir.ResourceChange) string {
+	if res.Lifecycle == nil {
+		return ""
+	}
+	for _, trigger := range res.Lifecycle.ReplaceTriggeredBy {
+		for _, addr := range dag.resolveAddr(replaceTriggerAddr(trigger)) {
+			if change, ok := planned[addr]; ok && triggersReplace(trigger, change) {
+				return trigger
+			}
+		}
+	}
+	return ""
+}
+
+// triggersReplace reports whether a planned change to the target of a
+// replaceTriggeredBy entry triggers the replacement.
+func triggersReplace(trigger string, change *ir.ResourceChange) bool {
+	if !strings.HasPrefix(trigger, "ptr://") {
+		return true
+	}
+	if change.Action == pb.PlanResponse_CREATE.String() || change.Action == pb.PlanResponse_REPLACE.String() {
+		return true
+	}
+	attr := trigger[strings.LastIndex(trigger, "/")+1:]
+	_, changed := change.Diff[attr]
+	return changed || !isInput(change, attr)
+}
+
+// isInput reports whether attr is an input of the resource a change is planned
+// for, set by its configuration or recorded as an input in state.
+func isInput(change *ir.ResourceChange, attr string) bool {
+	for _, res := range []*ir.Resource{change.Desired, change.Prior} {
+		if res == nil {
+			continue
+		}
+		if _, ok := res.Properties[attr]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterIgnoredChanges checks if all changed attributes reported by the provider
+// are covered by IgnoreChanges. If so, downgrades the action to NOOP.
 func filterIgnoredChanges(res *ir.Resource, resp *pb.PlanResponse, prior *ir.ResourceState) pb.PlanResponse_Action {
 	if prior == nil || res.Lifecycle == nil {
 		return resp.Action
 	}
 
-	ignoreSet := make(map[string]bool)
-	for _, attr := range res.Lifecycle.IgnoreChanges {
-		ignoreSet[attr] = true
+	if ignoresAll(res.Lifecycle) {
+		return pb.PlanResponse_NOOP
 	}
 
 	if len(resp.ChangedAttributes) > 0 {
 		allIgnored := true
 		for _, attr := range resp.ChangedAttributes {
-			if !ignoreSet[attr] {
+			if !attrIgnored(attr, res.Lifecycle.IgnoreChanges) {
 				allIgnored = false
 				break
 			}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/picklr-io/picklr/internal/addrs"
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// remoteStateType is the type of remote state references,
+// ptr://picklr:remote_state/<name>/<output>.
+const remoteStateType = "picklr:remote_state"
+
+// isRemoteStateRef reports whether ref is a reference to a remote state output.
+func isRemoteStateRef(ref string) bool {
+	r, _, err := addrs.ParseRef(ref)
+	return err == nil && r.FullType() == remoteStateType
+}
+
+// resolveRemoteStates replaces references to remote state outputs in the resource
+// properties and outputs of cfg with their values. Properties and outputs that take
+// a sensitive remote output become sensitive themselves. It returns the serials of
+// the remote states, to be recorded in state on apply. Keys are visited in sorted
+// order, so that the error reported for a configuration is always the same.
+func resolveRemoteStates(cfg *ir.Config, remotes map[string]*ir.State) (map[string]int, error) {
+	for _, name := range slices.Sorted(maps.Keys(cfg.RemoteStates)) {
+		if _, ok := remotes[name]; !ok {
+			return nil, fmt.Errorf("remote state %q is not loaded", name)
+		}
+	}
+
+	var err error
+	for _, res := range cfg.Resources {
+		for _, k := range slices.Sorted(maps.Keys(res.Properties)) {
+			var sensitive bool
+			if res.Properties[k], sensitive, err = substituteRemoteRefs(res.Properties[k], remotes); err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", resourceAddr(res), k, err)
+			}
+			if sensitive && !slices.Contains(res.Sensitive, k) {
+				res.Sensitive = append(res.Sensitive, k)
+			}
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(cfg.Outputs)) {
+		var sensitive bool
+		if cfg.Outputs[k], sensitive, err = substituteRemoteRefs(cfg.Outputs[k], remotes); err != nil {
+			return nil, fmt.Errorf("output %s: %w", k, err)
+		}
+		if sensitive && !slices.Contains(cfg.SensitiveOutputs, k) {
+			cfg.SensitiveOutputs = append(cfg.SensitiveOutputs, k)
+		}
+	}
+
+	if len(remotes) == 0 {
+		return nil, nil
+	}
+	serials := make(map[string]int, len(remotes))
+	for name, s := range remotes {
+		serials[name] = s.Serial
+	}
+	return serials, nil
+}
+
+// substituteRemoteRefs returns v with remote state references replaced, and
+// whether any of them was to a sensitive output.
+func substituteRemoteRefs(v any, remotes map[string]*ir.State) (any, bool, error) {
+	switch val := v.(type) {
+	case string:
+		if !isRemoteStateRef(val) {
+			return v, false, nil
+		}
+		ref, output, _ := addrs.ParseRef(val)
+		remote, ok := remotes[ref.Name]
+		if !ok {
+			return nil, false, fmt.Errorf("unknown remote state %q", ref.Name)
+		}
+		out, ok := remote.Outputs[output]
+		if !ok {
+			return nil, false, fmt.Errorf("remote state %q has no output %q", ref.Name, output)
+		}
+		return out, slices.Contains(remote.SensitiveOutputs, output), nil
+	case map[string]any:
+		result := make(map[string]any, len(val))
+		sensitive := false
+		for _, k := range slices.Sorted(maps.Keys(val)) {
+			r, s, err := substituteRemoteRefs(val[k], remotes)
+			if err != nil {
+				return nil, false, err
+			}
+			result[k] = r
+			sensitive = sensitive || s
+		}
+		return result, sensitive, nil
+	case []any:
+		result := make([]any, len(val))
+		sensitive := false
+		for i, item := range val {
+			r, s, err := substituteRemoteRefs(item, remotes)
+			if err != nil {
+				return nil, false, err
+			}
+			result[i] = r
+			sensitive = sensitive || s
+		}
+		return result, sensitive, nil
+	default:
+		return v, false, nil
+	}
+}
+
+// remoteStateWarnings reports remote states whose serial changed since the serials
+// recorded in state by the last apply.
+func remoteStateWarnings(serials map[string]int, state *ir.State) []string {
+	names := make([]string, 0, len(serials))
+	for name := range serials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var warnings []string
+	for _, name := range names {
+		last, ok := state.RemoteStateSerials[name]
+		if ok && last != serials[name] {
+			warnings = append(warnings, fmt.Sprintf(
+				"Remote state %q changed since the last apply (serial %d, now %d); outputs read from it may differ.",
+				name, last, serials[name]))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func remoteStateTestConfig() *ir.Config {
+	return &ir.Config{
+		Resources: []*ir.Resource{
+			{
+				Type:     "null_resource",
+				Name:     "app",
+				Provider: "null",
+				Properties: map[string]any{
+					"triggers": map[string]any{
+						"vpc":      "ptr://picklr:remote_state/network/vpc_id",
+						"password": "ptr://picklr:remote_state/network/db_password",
+					},
+				},
+			},
+		},
+		Outputs:      map[string]any{"vpc": "ptr://picklr:remote_state/network/vpc_id"},
+		RemoteStates: map[string]*ir.Backend{"network": {Type: "local", Config: map[string]string{"path": "network.pkl"}}},
+	}
+}
+
+func TestEngine_CreatePlan_RemoteState(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	eng.RemoteStates = map[string]*ir.State{
+		"network": {
+			Serial:           7,
+			Outputs:          map[string]any{"vpc_id": "vpc-123", "db_password": "hunter2!"},
+			SensitiveOutputs: []string{"db_password"},
+		},
+	}
+
+	plan, err := eng.CreatePlan(context.Background(), remoteStateTestConfig(), &ir.State{})
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 1)
+	triggers := plan.Changes[0].Desired.Properties["triggers"].(map[string]any)
+	assert.Equal(t, "vpc-123", triggers["vpc"])
+	assert.Equal(t, "hunter2!", triggers["password"])
+	assert.Contains(t, plan.Changes[0].Desired.Sensitive, "triggers")
+	assert.Equal(t, "vpc-123", plan.Outputs["vpc"])
+	assert.Equal(t, map[string]int{"network": 7}, plan.RemoteStateSerials)
+	assert.Empty(t, plan.Warnings)
+
+	newState, err := eng.ApplyPlan(context.Background(), plan, &ir.State{Version: 1})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"network": 7}, newState.RemoteStateSerials)
+
+	// A later change to the remote state is reported
+	newState.RemoteStateSerials = map[string]int{"network": 5}
+	plan, err = eng.CreatePlan(context.Background(), remoteStateTestConfig(), newState)
+	require.NoError(t, err)
+	require.Len(t, plan.Warnings, 1)
+	assert.Contains(t, plan.Warnings[0], `Remote state "network" changed since the last apply (serial 5, now 7)`)
+}
+
+func TestEngine_CreatePlan_RemoteStateMissingOutput(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	eng.RemoteStates = map[string]*ir.State{"network": {Outputs: map[string]any{"db_password": "hunter2!"}}}
+
+	_, err := eng.CreatePlan(context.Background(), remoteStateTestConfig(), &ir.State{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `remote state "network" has no output "vpc_id"`)
+
+	eng.RemoteStates = nil
+	_, err = eng.CreatePlan(context.Background(), remoteStateTestConfig(), &ir.State{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `remote state "network" is not loaded`)
+}
@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// markSensitive flags the diffs of sensitive attributes so they are masked when
+// the plan is displayed.
+func markSensitive(diff map[string]*ir.PropertyDiff, sensitive []string) {
+	for _, attr := range sensitive {
+		if d, ok := diff[attr]; ok {
+			d.Sensitive = true
+		}
+	}
+}
+
+// sensitiveOutputs returns the outputs declared sensitive, plus those whose value
+// references a sensitive attribute of a resource.
+func sensitiveOutputs(cfg *ir.Config) []string {
+	names := make(map[string]bool)
+	for _, name := range cfg.SensitiveOutputs {
+		names[name] = true
+	}
+
+	refs := make(map[string]bool)
+	for _, res := range cfg.Resources {
+		for _, attr := range res.Sensitive {
+			refs[refAddr(res.Module, res.Type, res.Name, res.Provider).Ref(attr)] = true
+		}
+	}
+	for name, val := range cfg.Outputs {
+		for _, ref := range extractPtrRefs(val) {
+			if refs[ref] {
+				names[name] = true
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine_CreatePlan_Sensitive(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	ctx := context.Background()
+
+	cfg := &ir.Config{
+		Resources: []*ir.Resource{
+			{
+				Type:     "null_resource",
+				Name:     "db",
+				Provider: "null",
+				Properties: map[string]any{
+					"triggers": map[string]any{"a": "b"},
+					"password": "hunter2!",
+				},
+				Sensitive: []string{"password"},
+			},
+		},
+		Outputs: map[string]any{
+			"password": "ptr://null:null_resource/db/password",
+			"name":     "db",
+			"token":    "abc",
+		},
+		SensitiveOutputs: []string{"token"},
+	}
+
+	plan, err := eng.CreatePlan(ctx, cfg, &ir.State{})
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 1)
+	assert.True(t, plan.Changes[0].Diff["password"].Sensitive)
+	assert.False(t, plan.Changes[0].Diff["triggers"].Sensitive)
+	assert.Equal(t, []string{"password", "token"}, plan.SensitiveOutputs)
+
+	newState, err := eng.ApplyPlan(ctx, plan, &ir.State{Version: 1})
+	require.NoError(t, err)
+	require.Len(t, newState.Resources, 1)
+	assert.Equal(t, []string{"password"}, newState.Resources[0].Sensitive)
+	assert.Equal(t, []string{"password", "token"}, newState.SensitiveOutputs)
+}
+
+func TestEngine_CreatePlan_SensitiveDelete(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	state := &ir.State{
+		Resources: []*ir.ResourceState{
+			{
+				Type:      "null_resource",
+				Name:      "db",
+				Provider:  "null",
+				Inputs:    map[string]any{"password": "hunter2!"},
+				Outputs:   map[string]any{"id": "null-db"},
+				Sensitive: []string{"password"},
+			},
+		},
+	}
+
+	plan, err := eng.CreatePlan(context.Background(), &ir.Config{}, state)
+	require.NoError(t, err)
+	require.Len(t, plan.Changes, 1)
+	assert.Equal(t, "DELETE", plan.Changes[0].Action)
+	assert.True(t, plan.Changes[0].Diff["password"].Sensitive)
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/addrs"
+)
+
+// PlanOptions restricts which resources a plan covers.
+type PlanOptions struct {
+	// Targets limits the plan to matching addresses and their dependencies.
+	Targets []string
+	// Excludes removes matching addresses and everything that depends on them,
+	// and keeps what they depend on from being deleted.
+	Excludes []string
+	// IncludeDependents also plans resources that depend on the targets.
+	IncludeDependents bool
+}
+
+// targeted reports whether any targeting option is set.
+func (o PlanOptions) targeted() bool {
+	return len(o.Targets) > 0 || len(o.Excludes) > 0
+}
+
+// selectTargets returns the set of addresses a targeted plan covers, or nil if
+// opts does not restrict the plan, and the set of addresses that must not be
+// deleted because an excluded resource depends on them. Dependencies and
+// dependents are looked up in every given graph so that both config and
+// state-only resources are covered. Patterns that match nothing are reported as
+// warnings.
+func selectTargets(opts PlanOptions, candidates []string, graphs ...*DAG) (selected, kept map[string]bool, warnings []string) {
+	if !opts.targeted() {
+		return nil, nil, nil
+	}
+
+	match := func(patterns []string) map[string]bool {
+		matched := make(map[string]bool)
+		for _, p := range patterns {
+			found := false
+			for _, addr := range candidates {
+				if addrs.Match(p, addr) {
+					matched[addr] = true
+					found = true
+				}
+			}
+			if !found {
+				warnings = append(warnings, fmt.Sprintf("Pattern %q did not match any resource", p))
+			}
+		}
+		return matched
+	}
+	// related returns the transitive dependencies, or dependents, of set.
+	related := func(set map[string]bool, dependents bool) map[string]bool {
+		result := make(map[string]bool)
+		for addr := range set {
+			for _, g := range graphs {
+				if g == nil {
+					continue
+				}
+				found := g.TransitiveDeps(addr)
+				if dependents {
+					found = g.TransitiveDependents(addr)
+				}
+				for _, r := range found {
+					result[r] = true
+				}
+			}
+		}
+		return result
+	}
+	merge := func(dst, src map[string]bool) {
+		for addr := range src {
+			dst[addr] = true
+		}
+	}
+
+	selected = make(map[string]bool)
+	if len(opts.Targets) > 0 {
+		merge(selected, match(opts.Targets))
+		if opts.IncludeDependents {
+			merge(selected, related(selected, true))
+		}
+		merge(selected, related(selected, false))
+	} else {
+		for _, addr := range candidates {
+			selected[addr] = true
+		}
+	}
+
+	kept = make(map[string]bool)
+	if len(opts.Excludes) > 0 {
+		excluded := match(opts.Excludes)
+		// Planning a resource without the one it depends on would leave it
+		// pointing at stale values, so dependents are excluded as well.
+		merge(excluded, related(excluded, true))
+		for addr := range excluded {
+			delete(selected, addr)
+		}
+		// Deleting a resource that an excluded resource depends on would
+		// break the excluded resource, so its dependencies are kept.
+		for addr := range related(excluded, false) {
+			if !excluded[addr] {
+				kept[addr] = true
+			}
+		}
+	}
+
+	return selected, kept, warnings
+}
+
+// partialConvergenceWarning describes the resources a targeted plan skipped.
+func partialConvergenceWarning(skipped []string) string {
+	sort.Strings(skipped)
+	const maxListed = 5
+	list := skipped
+	more := ""
+	if len(list) > maxListed {
+		more = fmt.Sprintf(" and %d more", len(list)-maxListed)
+		list = list[:maxListed]
+	}
+	return fmt.Sprintf("Resource targeting is in effect: %d resource(s) were not planned (%s%s). "+
+		"The configuration and state may be only partially converged; run a plan without -target/-exclude to check for remaining changes.",
+		len(skipped), strings.Join(list, ", "), more)
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/picklr-io/picklr/internal/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func targetTestResources() []*ir.Resource {
+	return []*ir.Resource{
+		{Type: "null_resource", Name: "vpc", Provider: "null"},
+		{Type: "null_resource", Name: "db", Provider: "null", DependsOn: []string{"null_resource.vpc"}},
+		{Type: "null_resource", Name: "app", Provider: "null", DependsOn: []string{"null_resource.db"}},
+		{Type: "null_resource", Name: "cdn", Provider: "null"},
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	dag, err := BuildDAG(targetTestResources())
+	require.NoError(t, err)
+	addrs := dag.CreationOrder()
+
+	set, kept, warnings := selectTargets(PlanOptions{}, addrs, dag)
+	assert.Nil(t, kept)
+	assert.Nil(t, set)
+	assert.Empty(t, warnings)
+
+	set, _, _ = selectTargets(PlanOptions{Targets: []string{"null_resource.db"}}, addrs, dag)
+	assert.Equal(t, map[string]bool{"null_resource.vpc": true, "null_resource.db": true}, set)
+
+	set, _, _ = selectTargets(PlanOptions{Targets: []string{"null_resource.db"}, IncludeDependents: true}, addrs, dag)
+	assert.Equal(t, map[string]bool{"null_resource.vpc": true, "null_resource.db": true, "null_resource.app": true}, set)
+
+	set, kept, _ = selectTargets(PlanOptions{Excludes: []string{"null_resource.db"}}, addrs, dag)
+	assert.Equal(t, map[string]bool{"null_resource.vpc": true, "null_resource.cdn": true}, set)
+	assert.Equal(t, map[string]bool{"null_resource.vpc": true}, kept)
+
+	_, _, warnings = selectTargets(PlanOptions{Targets: []string{"null_resource.missing*"}}, addrs, dag)
+	require.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "null_resource.missing*")
+}
+
+func TestEngine_CreatePlanWithOptions_Exclude(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	cfg := &ir.Config{Resources: targetTestResources()}
+
+	plan, err := eng.CreatePlanWithOptions(context.Background(), cfg, &ir.State{}, PlanOptions{
+		Excludes: []string{"null_resource.d*"},
+	})
+	require.NoError(t, err)
+
+	var addrs []string
+	for _, c := range plan.Changes {
+		addrs = append(addrs, c.Address)
+	}
+	assert.ElementsMatch(t, []string{"null_resource.vpc", "null_resource.cdn"}, addrs)
+	require.Len(t, plan.Warnings, 1)
+	assert.Contains(t, plan.Warnings[0], "partially converged")
+	assert.Contains(t, plan.Warnings[0], "null_resource.app")
+}
+
+func TestEngine_CreatePlanWithOptions_DestroyExclude(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	state := &ir.State{}
+	for _, res := range targetTestResources() {
+		state.Resources = append(state.Resources, &ir.ResourceState{
+			Type: res.Type, Name: res.Name, Provider: res.Provider, Dependencies: res.DependsOn,
+		})
+	}
+
+	// Destroying everything but the db keeps the vpc it depends on
+	plan, err := eng.CreatePlanWithOptions(context.Background(), &ir.Config{}, state, PlanOptions{
+		Excludes: []string{"null_resource.db"},
+	})
+	require.NoError(t, err)
+
+	var addrs []string
+	for _, c := range plan.Changes {
+		assert.Equal(t, "DELETE", c.Action)
+		addrs = append(addrs, c.Address)
+	}
+	assert.ElementsMatch(t, []string{"null_resource.cdn"}, addrs)
+}
+
+func TestEngine_CreatePlanWithOptions_DestroyExcludeKeepsAllDependencies(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	state := &ir.State{Resources: []*ir.ResourceState{
+		{Type: "null_resource", Name: "a", Provider: "null"},
+		{Type: "null_resource", Name: "b", Provider: "null"},
+		{Type: "null_resource", Name: "c", Provider: "null"},
+		{Type: "null_resource", Name: "e", Provider: "null", Dependencies: []string{"null_resource.a", "null_resource.b"}},
+	}}
+
+	plan, err := eng.CreatePlanWithOptions(context.Background(), &ir.Config{}, state, PlanOptions{
+		Excludes: []string{"null_resource.e"},
+	})
+	require.NoError(t, err)
+
+	var addrs []string
+	for _, c := range plan.Changes {
+		addrs = append(addrs, c.Address)
+	}
+	assert.Equal(t, []string{"null_resource.c"}, addrs)
+}
+
+func TestEngine_CreatePlan_NoTargetsNoWarnings(t *testing.T) {
+	reg := provider.NewRegistry()
+	require.NoError(t, reg.LoadProvider("null"))
+
+	eng := NewEngine(reg)
+	plan, err := eng.CreatePlan(context.Background(), &ir.Config{Resources: targetTestResources()}, &ir.State{})
+	require.NoError(t, err)
+	assert.Len(t, plan.Changes, 4)
+	assert.Empty(t, plan.Warnings)
+}
@@ -2,17 +2,38 @@ package eval
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"net/url"
+	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/apple/pkl-go/pkl"
 	"github.com/picklr-io/picklr/internal/ir"
 )
 
-// Evaluator handles PKL evaluation into IR types.
+// WorkspaceProperty is the external property that holds the current workspace,
+// read in PKL with read("prop:picklr.workspace").
+const WorkspaceProperty = "picklr.workspace"
+
+// VariablePropertyPrefix prefixes the external properties that hold input variable
+// values, read in PKL with variable(name).
+const VariablePropertyPrefix = "picklr.var."
+
+// PackageCacheDir is the directory, relative to a project, that picklr init
+// vendors the project's PKL packages into. Project evaluations read packages
+// from it when it exists.
+const PackageCacheDir = ".picklr/cache"
+
+// Evaluator handles PKL evaluation into IR types. Its evaluations share one PKL
+// process, started on first use and stopped by Close.
 type Evaluator struct {
 	projectDir string
+
+	mu      sync.Mutex
+	manager pkl.EvaluatorManager
+	project *pkl.Project // Loaded from the project directory's PklProject, if any
+	loaded  bool
 }
 
 func NewEvaluator(projectDir string) *Evaluator {
@@ -21,56 +42,220 @@ func NewEvaluator(projectDir string) *Evaluator {
 	}
 }
 
-// LoadConfig evaluates the main configuration file and returns the IR.
-func (e *Evaluator) LoadConfig(ctx context.Context, entryPoint string, properties map[string]string) (*ir.Config, error) {
-	u, err := url.Parse("file://" + e.projectDir + "/")
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse project directory URL: %w", err)
+// Close stops the PKL process, if one was started.
+func (e *Evaluator) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.manager == nil {
+		return nil
 	}
+	err := e.manager.Close()
+	e.manager, e.project, e.loaded = nil, nil, false
+	return err
+}
 
-	// Ensure entryPoint is absolute or relative to projectDir
-	if !filepath.IsAbs(entryPoint) {
-		entryPoint = filepath.Join(e.projectDir, entryPoint)
+// newEvaluator returns an evaluator of the shared PKL process. Project evaluators
+// resolve dependencies through the PklProject of the project directory, if it has
+// one, and read modules relative to it.
+func (e *Evaluator) newEvaluator(ctx context.Context, project bool, opts ...func(*pkl.EvaluatorOptions)) (pkl.Evaluator, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.manager == nil {
+		e.manager = pkl.NewEvaluatorManager()
+	}
+	opts = append([]func(*pkl.EvaluatorOptions){pkl.PreconfiguredOptions}, opts...)
+	if !project {
+		return e.manager.NewEvaluator(ctx, opts...)
 	}
 
-	opts := []func(*pkl.EvaluatorOptions){pkl.PreconfiguredOptions}
-	if len(properties) > 0 {
-		opts = append(opts, func(o *pkl.EvaluatorOptions) {
-			if o.Properties == nil {
-				o.Properties = make(map[string]string)
-			}
-			for k, v := range properties {
-				o.Properties[k] = v
-			}
-		})
+	if err := e.loadProject(ctx); err != nil {
+		return nil, err
+	}
+	if e.project != nil {
+		opts = append(opts, pkl.WithProject(e.project))
 	}
+	if cache := filepath.Join(e.projectDir, PackageCacheDir); fileExists(cache) {
+		opts = append(opts, func(o *pkl.EvaluatorOptions) { o.CacheDir = cache })
+	}
+	return e.manager.NewEvaluator(ctx, opts...)
+}
 
-	evaluator, err := pkl.NewProjectEvaluator(ctx, u, opts...)
+// loadProject loads the PklProject of the project directory once, if it has one.
+// The caller holds e.mu.
+func (e *Evaluator) loadProject(ctx context.Context) error {
+	if e.loaded {
+		return nil
+	}
+	if e.manager == nil {
+		e.manager = pkl.NewEvaluatorManager()
+	}
+	if path := filepath.Join(e.projectDir, "PklProject"); fileExists(path) {
+		ev, err := e.manager.NewEvaluator(ctx, pkl.PreconfiguredOptions)
+		if err == nil && ev == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+		e.project, err = pkl.LoadProjectFromEvaluator(ctx, ev, pkl.FileSource(path))
+		ev.Close()
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", path, err)
+		}
+	}
+	e.loaded = true
+	return nil
+}
+
+// Project returns the PklProject of the project directory, or nil if it has none.
+func (e *Evaluator) Project(ctx context.Context) (*pkl.Project, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.loadProject(ctx); err != nil {
+		return nil, err
+	}
+	return e.project, nil
+}
+
+// evaluate evaluates expr in the module at source into a T, or the whole module
+// if expr is "". Project evaluations see properties as external properties;
+// others read the schemas embedded in the binary. what names the result in errors.
+func evaluate[T any](ctx context.Context, e *Evaluator, source *pkl.ModuleSource, expr, what string, project bool, properties map[string]string) (T, error) {
+	var out T
+	opts := []func(*pkl.EvaluatorOptions){withProperties(properties)}
+	if !project {
+		opts = append(opts, pkl.WithModuleReader(schemaReader{}))
+	}
+	evaluator, err := e.newEvaluator(ctx, project, opts...)
+	if err == nil && evaluator == nil {
+		err = ctx.Err() // Canceled while the evaluator was created
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create PKL evaluator: %w", err)
+		return out, fmt.Errorf("failed to create PKL evaluator: %w", err)
 	}
 	defer evaluator.Close()
 
-	var cfg ir.Config
-	if err := evaluator.EvaluateModule(ctx, pkl.FileSource(entryPoint), &cfg); err != nil {
-		return nil, fmt.Errorf("failed to evaluate config: %w", err)
+	if expr == "" {
+		err = evaluator.EvaluateModule(ctx, source, &out)
+	} else {
+		err = evaluator.EvaluateExpression(ctx, source, expr, &out)
+	}
+	if err != nil {
+		return out, fmt.Errorf("failed to evaluate %s: %w", what, err)
+	}
+	return out, nil
+}
+
+// entryPointSource returns the source of a configuration file, relative to the
+// project directory unless absolute.
+func (e *Evaluator) entryPointSource(entryPoint string) *pkl.ModuleSource {
+	if !filepath.IsAbs(entryPoint) {
+		entryPoint = filepath.Join(e.projectDir, entryPoint)
 	}
+	return pkl.FileSource(entryPoint)
+}
+
+// LoadConfig evaluates the main configuration file and returns the IR.
+func (e *Evaluator) LoadConfig(ctx context.Context, entryPoint string, properties map[string]string) (*ir.Config, error) {
+	return evaluate[*ir.Config](ctx, e, e.entryPointSource(entryPoint), "", "config", true, properties)
+}
 
-	return &cfg, nil
+// LoadBackend evaluates a standalone backend module such as .picklr/backend.pkl.
+func (e *Evaluator) LoadBackend(ctx context.Context, backendFile string) (*ir.Backend, error) {
+	return evaluate[*ir.Backend](ctx, e, pkl.FileSource(backendFile), "", "backend", false, nil)
+}
+
+// LoadConfigBackend evaluates only the backend block of a configuration file.
+// PKL is lazy, so resources are not evaluated; it returns nil if no block is set.
+func (e *Evaluator) LoadConfigBackend(ctx context.Context, entryPoint string, properties map[string]string) (*ir.Backend, error) {
+	return evaluate[*ir.Backend](ctx, e, e.entryPointSource(entryPoint), "backend", "backend", true, properties)
+}
+
+// LoadVariables evaluates only the input variable declarations of a configuration
+// file. Like LoadConfigBackend it leaves resources unevaluated, so it succeeds
+// before variable values are known.
+func (e *Evaluator) LoadVariables(ctx context.Context, entryPoint string, properties map[string]string) (map[string]*ir.Variable, error) {
+	return evaluate[map[string]*ir.Variable](ctx, e, e.entryPointSource(entryPoint), "variables", "variables", true, properties)
+}
+
+// LoadConfigRemoteStates evaluates only the remoteStates block of a configuration
+// file, leaving resources unevaluated like LoadConfigBackend.
+func (e *Evaluator) LoadConfigRemoteStates(ctx context.Context, entryPoint string, properties map[string]string) (map[string]*ir.Backend, error) {
+	return evaluate[map[string]*ir.Backend](ctx, e, e.entryPointSource(entryPoint), "remoteStates", "remote states", true, properties)
 }
 
 // LoadState evaluates a state file and returns the IR.
 func (e *Evaluator) LoadState(ctx context.Context, stateFile string) (*ir.State, error) {
-	evaluator, err := pkl.NewEvaluator(ctx, pkl.PreconfiguredOptions)
+	return evaluate[*ir.State](ctx, e, pkl.FileSource(stateFile), "", "state", false, nil)
+}
+
+// LoadStateText evaluates state content held in memory, such as decrypted or
+// remote state, without writing it to disk. Its amends clause is resolved against
+// the State.pkl schema embedded in the binary.
+func (e *Evaluator) LoadStateText(ctx context.Context, content []byte) (*ir.State, error) {
+	text := stateAmendsRe.ReplaceAllString(string(content), `amends "picklr:State"`)
+	return evaluate[*ir.State](ctx, e, pkl.TextSource(text), "", "state", false, nil)
+}
+
+// LoadStack evaluates a stack manifest, which amends the Stack.pkl schema
+// embedded in the binary.
+func (e *Evaluator) LoadStack(ctx context.Context, file string) (*ir.Stack, error) {
+	return evaluate[*ir.Stack](ctx, e, pkl.FileSource(file), "", "stack", false, nil)
+}
+
+// LoadTest evaluates a *.test.pkl file, which amends the Test.pkl schema
+// embedded in the binary.
+func (e *Evaluator) LoadTest(ctx context.Context, file string) (*ir.Test, error) {
+	return evaluate[*ir.Test](ctx, e, pkl.FileSource(file), "", "test", false, nil)
+}
+
+// LoadMocks evaluates a mock fixtures file, a module amending picklr:Mock.
+func (e *Evaluator) LoadMocks(ctx context.Context, file string) (*ir.Mocks, error) {
+	return evaluate[*ir.Mocks](ctx, e, pkl.FileSource(file), "", "mock fixtures", false, nil)
+}
+
+// LoadVars evaluates a variables file, a module of plain properties such as
+// `instance_count = 3`, and returns its values as external properties. Strings are
+// taken as they are and other values in their JSON form.
+func (e *Evaluator) LoadVars(ctx context.Context, varsFile string) (map[string]string, error) {
+	rendered, err := evaluate[string](ctx, e, pkl.FileSource(varsFile), "new JsonRenderer {}.renderDocument(module)", "variables", false, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create PKL evaluator: %w", err)
+		return nil, err
 	}
-	defer evaluator.Close()
 
-	var state ir.State
-	if err := evaluator.EvaluateModule(ctx, pkl.FileSource(stateFile), &state); err != nil {
-		return nil, fmt.Errorf("failed to evaluate state: %w", err)
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(rendered), &values); err != nil {
+		return nil, fmt.Errorf("failed to decode variables: %w", err)
 	}
+	vars := make(map[string]string, len(values))
+	for k, raw := range values {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			vars[k] = s
+		} else {
+			vars[k] = string(raw)
+		}
+	}
+	return vars, nil
+}
 
-	return &state, nil
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// withProperties sets external properties, read in PKL with read("prop:<name>").
+func withProperties(properties map[string]string) func(*pkl.EvaluatorOptions) {
+	return func(o *pkl.EvaluatorOptions) {
+		if len(properties) == 0 {
+			return
+		}
+		if o.Properties == nil {
+			o.Properties = make(map[string]string)
+		}
+		for k, v := range properties {
+			o.Properties[k] = v
+		}
+	}
 }
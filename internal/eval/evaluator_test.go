@@ -1,7 +1,14 @@
 package eval
 
 import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEvaluator_LoadConfig(t *testing.T) {
@@ -32,3 +39,58 @@ func TestEvaluator_LoadConfig(t *testing.T) {
 
 	// Instead, let's just verify the structs compile and imports are correct for now.
 }
+
+func TestEvaluator_LoadVars(t *testing.T) {
+	if _, err := exec.LookPath("pkl"); err != nil && os.Getenv("PKL_EXEC") == "" {
+		t.Skip("pkl is not installed")
+	}
+
+	path := filepath.Join(t.TempDir(), "prod.pkl")
+	require.NoError(t, os.WriteFile(path, []byte("environment = \"prod\"\ninstance_count = 3\nenable_cdn = true\n"), 0644))
+
+	vars, err := NewEvaluator(filepath.Dir(path)).LoadVars(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"environment": "prod", "instance_count": "3", "enable_cdn": "true"}, vars)
+}
+
+func TestEvaluator_LoadVariables(t *testing.T) {
+	if _, err := exec.LookPath("pkl"); err != nil && os.Getenv("PKL_EXEC") == "" {
+		t.Skip("pkl is not installed")
+	}
+
+	dir := t.TempDir()
+	content := "variables {\n  [\"replicas\"] { type = \"number\"; default = 2 }\n  [\"region\"] { type = \"string\"; sensitive = false }\n}\n" +
+		"resources = throw(\"resources must not be evaluated\")\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.pkl"), []byte(content), 0644))
+
+	vars, err := NewEvaluator(dir).LoadVariables(context.Background(), "main.pkl", nil)
+	require.NoError(t, err)
+	require.Len(t, vars, 2)
+	assert.Equal(t, "number", vars["replicas"].Type)
+	assert.EqualValues(t, 2, vars["replicas"].Default)
+	assert.Nil(t, vars["region"].Default)
+}
+
+func TestEvaluator_Project(t *testing.T) {
+	if _, err := exec.LookPath("pkl"); err != nil && os.Getenv("PKL_EXEC") == "" {
+		t.Skip("pkl is not installed")
+	}
+
+	dir := t.TempDir()
+	evaluator := NewEvaluator(dir)
+	defer evaluator.Close()
+	project, err := evaluator.Project(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, project)
+
+	content := "amends \"pkl:Project\"\n\ndependencies {\n  [\"vpc\"] { uri = \"package://pkg.example.com/picklr/vpc@1.2.0\" }\n}\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "PklProject"), []byte(content), 0644))
+	evaluator = NewEvaluator(dir)
+	defer evaluator.Close()
+	project, err = evaluator.Project(context.Background())
+	require.NoError(t, err)
+	require.NotNil(t, project)
+	remote := project.Dependencies().RemoteDependencies
+	require.Contains(t, remote, "vpc")
+	assert.Equal(t, "package://pkg.example.com/picklr/vpc@1.2.0", remote["vpc"].PackageUri)
+}
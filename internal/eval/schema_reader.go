@@ -0,0 +1,44 @@
+package eval
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/apple/pkl-go/pkl"
+	"github.com/picklr-io/picklr/pkg/schemas"
+)
+
+// schemaReader serves the schemas embedded in the binary under the picklr: scheme,
+// e.g. `amends "picklr:State"`.
+type schemaReader struct{}
+
+var _ pkl.ModuleReader = schemaReader{}
+
+func (schemaReader) Scheme() string            { return "picklr" }
+func (schemaReader) IsGlobbable() bool         { return false }
+func (schemaReader) HasHierarchicalUris() bool { return false }
+func (schemaReader) IsLocal() bool             { return true }
+
+func (schemaReader) ListElements(url.URL) ([]pkl.PathElement, error) {
+	return nil, nil
+}
+
+func (schemaReader) Read(u url.URL) (string, error) {
+	switch u.Opaque {
+	case "State", "State.pkl":
+		return string(schemas.State), nil
+	case "Stack", "Stack.pkl":
+		return string(schemas.Stack), nil
+	case "Mock", "Mock.pkl":
+		return string(schemas.Mock), nil
+	case "Test", "Test.pkl":
+		return string(schemas.Test), nil
+	default:
+		return "", fmt.Errorf("unknown schema %s", u.String())
+	}
+}
+
+// stateAmendsRe matches the amends clause of a state file, whatever path it uses
+// to reach State.pkl.
+var stateAmendsRe = regexp.MustCompile(`(?m)^amends "[^"]*State(\.pkl)?"`)
@@ -2,6 +2,63 @@ package ir
 
 // Config represents the top-level configuration.
 type Config struct {
-	Resources []*Resource    `pkl:"resources"`
-	Outputs   map[string]any `pkl:"outputs"`
+	Resources        []*Resource    `pkl:"resources"`
+	Outputs          map[string]any `pkl:"outputs"`
+	SensitiveOutputs []string       `pkl:"sensitiveOutputs"` // Names of outputs masked in output
+	Backend          *Backend       `pkl:"backend"`
+
+	// RemoteStates are other projects' states whose outputs the config reads,
+	// keyed by the name used in ptr://picklr:remote_state/<name>/<output>.
+	RemoteStates map[string]*Backend `pkl:"remoteStates"`
+
+	// Modules are the module instances the config creates, keyed by name.
+	Modules map[string]*Module `pkl:"modules"`
+
+	// Imports are existing resources to bring under management with picklr import.
+	Imports []*Import `pkl:"imports"`
+}
+
+// Import is an existing resource to import into state.
+type Import struct {
+	To string `pkl:"to"` // Resource address
+	ID string `pkl:"id"` // Provider ID
+}
+
+// Module is a reusable set of resources, instantiated by a config or another module.
+// Its resources are addressed under module.<name>, e.g. module.net.aws:EC2.Vpc.main.
+type Module struct {
+	Resources []*Resource        `pkl:"resources"`
+	Outputs   map[string]any     `pkl:"output"`    // Read by the caller with ptr://picklr:module/<name>/<output>
+	DependsOn []string           `pkl:"dependsOn"` // Modules or resources all its resources depend on
+	Count     int                `pkl:"count"`     // Create N instances
+	ForEach   map[string]any     `pkl:"forEach"`   // Create instance per key
+	Modules   map[string]*Module `pkl:"modules"`   // Nested module instances
+}
+
+// Variable declares an input variable, supplied on the command line, in a variables
+// file or in the environment.
+type Variable struct {
+	Type        string  `pkl:"type"`    // "string", "number", "bool", "list" or "map"
+	Default     any     `pkl:"default"` // Used when no value is supplied; required if nil
+	Description *string `pkl:"description"`
+	Sensitive   bool    `pkl:"sensitive"` // Masked when the plan is displayed
+}
+
+// Backend selects where state is stored.
+type Backend struct {
+	Type   string            `pkl:"type"`   // "local", "s3", "gcs", "http"
+	Config map[string]string `pkl:"config"` // Backend-specific settings, e.g. bucket and key
+}
+
+// Stack is a set of projects that are planned, applied and destroyed together.
+type Stack struct {
+	Projects    map[string]*StackProject `pkl:"projects"`
+	Parallelism int                      `pkl:"parallelism"`
+}
+
+// StackProject is a project of a stack.
+type StackProject struct {
+	Path       string   `pkl:"path"`       // Project directory, relative to the manifest
+	EntryPoint string   `pkl:"entryPoint"` // Configuration file, main.pkl by default
+	DependsOn  []string `pkl:"dependsOn"`  // Projects that must run first
 }
@@ -2,10 +2,17 @@ package ir
 
 // Plan represents a calculated execution plan.
 type Plan struct {
-	Metadata *PlanMetadata     `pkl:"metadata"`
-	Changes  []*ResourceChange `pkl:"changes"`
-	Summary  *PlanSummary      `pkl:"summary"`
-	Outputs  map[string]any    `pkl:"outputs"`
+	Metadata         *PlanMetadata     `pkl:"metadata"`
+	Changes          []*ResourceChange `pkl:"changes"`
+	Summary          *PlanSummary      `pkl:"summary"`
+	Outputs          map[string]any    `pkl:"outputs"`
+	SensitiveOutputs []string          `pkl:"sensitiveOutputs"` // Names of outputs masked in output
+	Warnings         []string          `pkl:"warnings"`         // Non-fatal issues to surface alongside the plan
+
+	RemoteStateSerials map[string]int `pkl:"remoteStateSerials"` // Serials of the remote states read, by name
+
+	Variables          map[string]any `pkl:"variables"`          // Input variable values the plan was created with
+	SensitiveVariables []string       `pkl:"sensitiveVariables"` // Names of variables masked in output
 }
 
 type PlanMetadata struct {
@@ -20,6 +27,7 @@ type ResourceChange struct {
 	Desired *Resource                `pkl:"resource"`
 	Prior   *Resource                `pkl:"prior"`
 	Diff    map[string]*PropertyDiff `pkl:"diff"`
+	Reason  string                   `pkl:"reason"` // Why the action was chosen, if not obvious from the diff
 }
 
 type PropertyDiff struct {
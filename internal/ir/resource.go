@@ -2,19 +2,34 @@ package ir
 
 // Resource represents a single managed resource.
 type Resource struct {
-	Type       string            `pkl:"type" json:"type"` // e.g., "aws:S3.Bucket"
-	Name       string            `pkl:"name" json:"name"`
-	Provider   string            `pkl:"provider" json:"provider"`
-	Lifecycle  *Lifecycle        `pkl:"lifecycle" json:"lifecycle,omitempty"`
-	DependsOn  []string          `pkl:"dependsOn" json:"depends_on,omitempty"`
-	Properties map[string]any    `pkl:"properties" json:"properties"` // Dynamic properties
-	Count      int               `pkl:"count" json:"count,omitempty"`        // Create N instances
-	ForEach    map[string]any    `pkl:"forEach" json:"for_each,omitempty"`   // Create instance per key
-	Timeout    string            `pkl:"timeout" json:"timeout,omitempty"`    // Per-resource timeout (e.g. "30m")
+	Type           string         `pkl:"type" json:"type"` // e.g., "aws:S3.Bucket"
+	Name           string         `pkl:"name" json:"name"`
+	Provider       string         `pkl:"provider" json:"provider"`
+	Lifecycle      *Lifecycle     `pkl:"lifecycle" json:"lifecycle,omitempty"`
+	DependsOn      []string       `pkl:"dependsOn" json:"depends_on,omitempty"`
+	Properties     map[string]any `pkl:"properties" json:"properties"`                   // Dynamic properties
+	Count          int            `pkl:"count" json:"count,omitempty"`                   // Create N instances
+	ForEach        map[string]any `pkl:"forEach" json:"for_each,omitempty"`              // Create instance per key
+	Timeout        string         `pkl:"timeout" json:"timeout,omitempty"`               // Per-resource timeout (e.g. "30m")
+	Preconditions  []*Condition   `pkl:"preconditions" json:"preconditions,omitempty"`   // Checked at plan time
+	Postconditions []*Condition   `pkl:"postconditions" json:"postconditions,omitempty"` // Checked after apply
+	Sensitive      []string       `pkl:"sensitive" json:"sensitive,omitempty"`           // Attributes masked in output
+
+	// Module is the path of the module instance the resource belongs to, e.g.
+	// module.net["prod"], or "" for the root module. It is set when modules are expanded.
+	Module string `pkl:"-" json:"module,omitempty"`
 }
 
 type Lifecycle struct {
 	CreateBeforeDestroy bool     `pkl:"createBeforeDestroy"`
 	PreventDestroy      bool     `pkl:"preventDestroy"`
 	IgnoreChanges       []string `pkl:"ignoreChanges"`
+	ReplaceTriggeredBy  []string `pkl:"replaceTriggeredBy"` // Resource addresses or ptr:// attributes
+}
+
+// Condition is an invariant that must hold for a resource.
+// The expression is evaluated by the engine, e.g. `self.image_owner == "123456789012"`.
+type Condition struct {
+	Condition    string `pkl:"condition" json:"condition"`
+	ErrorMessage string `pkl:"errorMessage" json:"error_message"`
 }
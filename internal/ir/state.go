@@ -2,19 +2,27 @@ package ir
 
 // State represents the persistent state.
 type State struct {
-	Version   int              `pkl:"version"`
-	Serial    int              `pkl:"serial"`
-	Lineage   string           `pkl:"lineage"`
-	Resources []*ResourceState `pkl:"resources"`
-	Outputs   map[string]any   `pkl:"outputs"`
+	Version   int              `pkl:"version" json:"version"`
+	Serial    int              `pkl:"serial" json:"serial"`
+	Lineage   string           `pkl:"lineage" json:"lineage"`
+	Resources []*ResourceState `pkl:"resources" json:"resources"`
+	Outputs   map[string]any   `pkl:"outputs" json:"outputs"`
+
+	SensitiveOutputs []string `pkl:"sensitiveOutputs" json:"sensitive_outputs,omitempty"`
+
+	// RemoteStateSerials are the serials of the remote states read by the last
+	// apply, by name. Plans warn when a remote state has changed since.
+	RemoteStateSerials map[string]int `pkl:"remoteStateSerials" json:"remote_state_serials,omitempty"`
 }
 
 type ResourceState struct {
-	Type         string         `pkl:"type"`
-	Name         string         `pkl:"name"`
-	Provider     string         `pkl:"provider"`
-	Inputs       map[string]any `pkl:"inputs"` // User provided
-	InputsHash   string         `pkl:"inputsHash"`
-	Outputs      map[string]any `pkl:"outputs"` // Provider returned
-	Dependencies []string       `pkl:"dependencies"`
+	Type         string         `pkl:"type" json:"type"`
+	Name         string         `pkl:"name" json:"name"`
+	Provider     string         `pkl:"provider" json:"provider"`
+	Inputs       map[string]any `pkl:"inputs" json:"inputs"` // User provided
+	InputsHash   string         `pkl:"inputsHash" json:"inputs_hash"`
+	Outputs      map[string]any `pkl:"outputs" json:"outputs"` // Provider returned
+	Dependencies []string       `pkl:"dependencies" json:"dependencies,omitempty"`
+	Sensitive    []string       `pkl:"sensitive" json:"sensitive,omitempty"` // Attributes masked in output
+	Module       string         `pkl:"module" json:"module,omitempty"`       // Module instance path, "" for the root module
 }
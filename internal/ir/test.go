@@ -0,0 +1,41 @@
+package ir
+
+// Test is a test of a configuration, evaluated from a *.test.pkl file.
+type Test struct {
+	Config        string                   `pkl:"config"`    // Configuration under test, relative to the test file
+	Variables     map[string]any           `pkl:"variables"` // Input variables of every run
+	MockProviders map[string]*MockProvider `pkl:"mockProviders"`
+	Runs          []*TestRun               `pkl:"runs"`
+}
+
+// MockProvider configures a mock standing in for a provider.
+type MockProvider struct {
+	Outputs map[string]map[string]any `pkl:"outputs"` // Canned outputs by resource type
+}
+
+// Mocks holds the fixtures of mock providers, evaluated from a module amending
+// picklr:Mock.
+type Mocks struct {
+	Providers map[string]*MockProvider `pkl:"providers"`
+}
+
+// TestRun is one plan or apply of a test.
+type TestRun struct {
+	Name        string           `pkl:"name"`
+	Command     string           `pkl:"command"` // "plan" or "apply"
+	Variables   map[string]any   `pkl:"variables"`
+	ExpectError *string          `pkl:"expectError"`
+	Assertions  []*TestAssertion `pkl:"assertions"`
+}
+
+// TestAssertion is a check against the plan or state of a run.
+type TestAssertion struct {
+	Resource *string `pkl:"resource"`
+	Type     *string `pkl:"type"`
+	Action   *string `pkl:"action"`
+	Property *string `pkl:"property"`
+	Output   *string `pkl:"output"`
+	Count    *int    `pkl:"count"`
+	Equals   any     `pkl:"equals"`
+	Message  *string `pkl:"message"`
+}
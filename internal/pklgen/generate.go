@@ -0,0 +1,249 @@
+package pklgen
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Resource is an imported resource to render.
+type Resource struct {
+	Name    string         // Resource name
+	Class   *Class         // Must have a Collection
+	Outputs map[string]any // Attributes read from the provider
+}
+
+// Generate renders resources as a PKL module with a property per collection,
+// e.g. buckets: Listing<S3.Bucket>, to be spread into the provider blocks of a
+// configuration. Schema modules are imported from schemaBase, the path or URI
+// Config.pkl is amended from without its file name, e.g. ../../pkg/schemas/.
+// module is the name the configuration imports the generated module as.
+func Generate(resources []*Resource, schemaBase, module string) []byte {
+	type group struct {
+		class     *Class
+		resources []*Resource
+	}
+	groups := make(map[string]*group)
+	imports := make(map[string]bool)
+	for _, r := range resources {
+		key := r.Class.Provider + "/" + r.Class.Collection
+		if groups[key] == nil {
+			groups[key] = &group{class: r.Class}
+		}
+		groups[key].resources = append(groups[key].resources, r)
+		imports[schemaBase+r.Class.Provider+"/"+r.Class.Module+".pkl"] = true
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("// Generated by `picklr import`. Import this module in the configuration and\n")
+	b.WriteString("// spread its properties into the provider blocks:\n")
+	b.WriteString("//\n")
+	for _, k := range keys {
+		c := groups[k].class
+		fmt.Fprintf(&b, "//   %s { %s { ...%s.%s } }\n", c.Provider, c.Collection, module, c.Collection)
+	}
+	b.WriteString("\n")
+	paths := make([]string, 0, len(imports))
+	for p := range imports {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Fprintf(&b, "import %q\n", p)
+	}
+
+	for _, k := range keys {
+		g := groups[k]
+		c := g.class
+		sort.Slice(g.resources, func(i, j int) bool { return g.resources[i].Name < g.resources[j].Name })
+		classRef := c.Module + "." + c.Name
+		b.WriteString("\n")
+		if c.Keyed {
+			fmt.Fprintf(&b, "%s: Mapping<String, %s> = new {\n", c.Collection, classRef)
+		} else {
+			fmt.Fprintf(&b, "%s: Listing<%s> = new {\n", c.Collection, classRef)
+		}
+		for _, r := range g.resources {
+			if c.Keyed {
+				fmt.Fprintf(&b, "  [%s] = new %s {\n", quote(r.Name), classRef)
+			} else {
+				fmt.Fprintf(&b, "  new %s {\n", classRef)
+			}
+			writeProperties(&b, r)
+			b.WriteString("  }\n")
+		}
+		b.WriteString("}\n")
+	}
+	return []byte(b.String())
+}
+
+// writeProperties writes the properties of a resource whose values differ from
+// their defaults. Resources of a Listing are named with the inherited name
+// property unless their class declares its own.
+func writeProperties(b *strings.Builder, r *Resource) {
+	const indent = "    "
+	c := r.Class
+	ownName := false
+	for _, p := range c.Props {
+		if p.Name == "name" {
+			ownName = true
+		}
+	}
+	if !c.Keyed && !ownName {
+		fmt.Fprintf(b, "%sname = %s\n", indent, quote(r.Name))
+	}
+	for _, p := range c.Props {
+		if p.Key == "" || (c.Keyed && p.Name == "name") {
+			continue
+		}
+		v, ok := r.Outputs[p.Key]
+		if !ok || v == nil {
+			continue
+		}
+		if !renderable(p.Type, v) {
+			fmt.Fprintf(b, "%s// %s (%s) is not generated\n", indent, p.Name, p.Type)
+			continue
+		}
+		value := render(p.Type, v, indent)
+		if value == p.Default {
+			continue
+		}
+		fmt.Fprintf(b, "%s%s = %s\n", indent, p.Name, value)
+	}
+}
+
+// elemType splits Listing<E> or Mapping<String, V> into its kind and element type.
+func elemType(typ string) (kind, elem string) {
+	t := strings.TrimSuffix(strings.TrimSpace(typ), "?")
+	for prefix, kind := range map[string]string{"Listing<": "Listing", "List<": "Listing", "Mapping<String,": "Mapping", "Map<String,": "Mapping"} {
+		if strings.HasPrefix(t, prefix) && strings.HasSuffix(t, ">") {
+			return kind, strings.TrimSpace(t[len(prefix) : len(t)-1])
+		}
+	}
+	return "", t
+}
+
+// renderable reports whether v can be rendered as a value of the PKL type typ.
+// Values of class types are not, as their attributes can't be mapped back.
+func renderable(typ string, v any) bool {
+	kind, t := elemType(typ)
+	switch kind {
+	case "Listing":
+		items, ok := v.([]any)
+		if !ok {
+			return false
+		}
+		for _, item := range items {
+			if !renderable(t, item) {
+				return false
+			}
+		}
+		return true
+	case "Mapping":
+		m, ok := v.(map[string]any)
+		if !ok {
+			return false
+		}
+		for _, item := range m {
+			if !renderable(t, item) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case t == "Any" || t == "Dynamic":
+		return true
+	case t == "String" || strings.HasPrefix(t, `"`):
+		_, ok := v.(string)
+		return ok
+	case t == "Boolean":
+		_, ok := v.(bool)
+		return ok
+	case t == "Int" || strings.HasPrefix(t, "Int("):
+		f, ok := number(v)
+		return ok && f == math.Trunc(f)
+	case t == "Float" || t == "Number":
+		_, ok := number(v)
+		return ok
+	}
+	return false
+}
+
+// render renders v as a PKL value of type typ, indenting nested lines after indent.
+func render(typ string, v any, indent string) string {
+	kind, t := elemType(typ)
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return quote(val)
+	case bool:
+		return strconv.FormatBool(val)
+	case []any:
+		if kind == "" {
+			t = "Any"
+		}
+		var b strings.Builder
+		b.WriteString("new Listing {\n")
+		for _, item := range val {
+			fmt.Fprintf(&b, "%s  %s\n", indent, render(t, item, indent+"  "))
+		}
+		b.WriteString(indent + "}")
+		return b.String()
+	case map[string]any:
+		if kind == "" {
+			t = "Any"
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		b.WriteString("new Mapping {\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s  [%s] = %s\n", indent, quote(k), render(t, val[k], indent+"  "))
+		}
+		b.WriteString(indent + "}")
+		return b.String()
+	}
+
+	f, _ := number(v)
+	if t == "Float" {
+		s := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// quote renders s as a PKL string literal.
+func quote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,203 @@
+package pklgen
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/picklr-io/picklr/pkg/schemas"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSchemas = fstest.MapFS{
+	"aws/Provider.pkl": {Data: []byte(`module picklr.aws.Provider
+
+import "S3.pkl"
+
+class Config {
+  region: String
+
+  buckets: Listing<S3.Bucket>?
+}
+`)},
+	"aws/S3.pkl": {Data: []byte(`module picklr.aws.S3
+
+import "../Resource.pkl"
+
+/// An Amazon S3 bucket.
+class Bucket extends Resource.Resource {
+  provider = "aws"
+
+  /// The name of the bucket.
+  bucket: String?
+
+  acl: String = "private"
+
+  forceDestroy: Boolean = false
+
+  tags: Mapping<String, String>?
+
+  lifecycle: Listing<Rule>?
+
+  properties = new {
+    ["bucket"] = bucket
+    ["acl"] = acl
+    ["force_destroy"] = forceDestroy
+    ["tags"] = tags
+    ["lifecycle_rules"] = lifecycle
+  }
+}
+
+class Rule {
+  days: Int
+}
+`)},
+	"docker/Docker.pkl": {Data: []byte(`module picklr.docker.Docker
+
+import "Container.pkl" as ContainerModule
+
+class Config {
+  containers: Mapping<String, ContainerModule.Container> = new {}
+}
+`)},
+	"docker/Container.pkl": {Data: []byte(`module picklr.docker.Container
+
+import "../Resource.pkl" as Core
+
+class Container extends Core.Resource {
+  provider = "docker"
+  type = "docker_container"
+
+  hidden name: String = ""
+
+  hidden image: String
+
+  hidden ports: Mapping<String, Int>?
+
+  properties = new {
+    ["name"] = name
+    ["image"] = image
+    ["ports"] = ports
+    ["command"] = command
+  }
+
+  hidden command: Listing<String>?
+}
+`)},
+}
+
+func TestLoadSchemas(t *testing.T) {
+	s, err := LoadSchemas(testSchemas)
+	require.NoError(t, err)
+
+	bucket := s.Class("aws:S3.Bucket")
+	require.NotNil(t, bucket)
+	assert.Equal(t, "buckets", bucket.Collection)
+	assert.False(t, bucket.Keyed)
+	require.NotNil(t, bucket.Property("force_destroy"))
+	assert.Equal(t, "forceDestroy", bucket.Property("force_destroy").Name)
+	assert.Equal(t, "false", bucket.Property("force_destroy").Default)
+	assert.Equal(t, `"private"`, bucket.Property("acl").Default)
+	assert.Equal(t, map[string]any{"force_destroy": false, "acl": "private"}, bucket.Defaults())
+	assert.Nil(t, s.Class("aws:S3.Rule"), "only resource classes are loaded")
+
+	container := s.Class("docker_container")
+	require.NotNil(t, container)
+	assert.Equal(t, "containers", container.Collection)
+	assert.True(t, container.Keyed)
+	assert.Equal(t, "command", container.Property("command").Name, "declared after the properties block")
+
+	// Every resource class of the built-in schemas is found
+	builtin, err := LoadSchemas(schemas.Providers)
+	require.NoError(t, err)
+	for _, typ := range []string{"aws:S3.Bucket", "aws:EC2.Instance", "aws:EC2.SecurityGroup", "docker_container"} {
+		c := builtin.Class(typ)
+		if assert.NotNil(t, c, typ) {
+			assert.NotEmpty(t, c.Collection, typ)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	s, err := LoadSchemas(testSchemas)
+	require.NoError(t, err)
+	bucket, container := s.Class("aws:S3.Bucket"), s.Class("docker_container")
+
+	logs := map[string]any{
+		"bucket":          "acme-logs",
+		"acl":             "private",
+		"force_destroy":   true,
+		"tags":            map[string]any{"team": "platform", "env": "prod"},
+		"lifecycle_rules": []any{map[string]any{"days": float64(30)}},
+		"arn":             "arn:aws:s3:::acme-logs",
+	}
+	web := map[string]any{
+		"name":    "web",
+		"image":   "nginx:1.27",
+		"ports":   map[string]any{"8080": float64(80)},
+		"command": []any{"nginx", "-g", "daemon off;"},
+		"id":      "3f2a9c1e",
+	}
+
+	out := Generate([]*Resource{
+		{Name: "web", Class: container, Outputs: web},
+		{Name: "logs", Class: bucket, Outputs: logs},
+	}, "../../pkg/schemas/", "imported")
+
+	assert.Equal(t, `// Generated by `+"`picklr import`"+`. Import this module in the configuration and
+// spread its properties into the provider blocks:
+//
+//   aws { buckets { ...imported.buckets } }
+//   docker { containers { ...imported.containers } }
+
+import "../../pkg/schemas/aws/S3.pkl"
+import "../../pkg/schemas/docker/Container.pkl"
+
+buckets: Listing<S3.Bucket> = new {
+  new S3.Bucket {
+    name = "logs"
+    bucket = "acme-logs"
+    forceDestroy = true
+    tags = new Mapping {
+      ["env"] = "prod"
+      ["team"] = "platform"
+    }
+    // lifecycle (Listing<Rule>?) is not generated
+  }
+}
+
+containers: Mapping<String, Container.Container> = new {
+  ["web"] = new Container.Container {
+    image = "nginx:1.27"
+    ports = new Mapping {
+      ["8080"] = 80
+    }
+    command = new Listing {
+      "nginx"
+      "-g"
+      "daemon off;"
+    }
+  }
+}
+`, string(out))
+
+	// Inputs are the attributes the configuration sets, including defaults
+	assert.Equal(t, map[string]any{
+		"bucket":        "acme-logs",
+		"acl":           "private",
+		"force_destroy": true,
+		"tags":          map[string]any{"team": "platform", "env": "prod"},
+	}, bucket.Inputs(logs))
+}
+
+func TestRender(t *testing.T) {
+	assert.Equal(t, `"say \"hi\"\n\\(x)"`, render("String", "say \"hi\"\n\\(x)", ""))
+	assert.Equal(t, "1.0", render("Float", float64(1), ""))
+	assert.Equal(t, "1.5", render("Float", 1.5, ""))
+	assert.Equal(t, "3", render("Int", float64(3), ""))
+	assert.False(t, renderable("Int", 1.5))
+	assert.False(t, renderable("String", float64(1)))
+	assert.True(t, renderable("Listing<String>?", []any{"a"}))
+	assert.False(t, renderable("Listing<String>?", []any{float64(1)}))
+	assert.True(t, renderable(`"gp2" | "gp3"`, "gp3"))
+}
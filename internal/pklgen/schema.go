@@ -0,0 +1,267 @@
+// Package pklgen maps provider outputs back to the PKL classes of resource types
+// and renders them as configuration, for resources brought under management with
+// picklr import.
+//
+// The provider schemas are read as source: a resource class declares typed
+// properties and maps them to provider attributes in its properties block, e.g.
+// ["force_destroy"] = forceDestroy. The Config class of each provider names the
+// collection resources of a class are listed in, e.g. buckets: Listing<S3.Bucket>?.
+package pklgen
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Class is a resource class of a provider schema.
+type Class struct {
+	Provider string      // e.g. aws
+	Module   string      // Schema module, e.g. S3 for aws/S3.pkl
+	Name     string      // e.g. Bucket
+	Type     string      // Resource type, e.g. aws:S3.Bucket
+	Props    []*Property // In declaration order
+
+	// Collection is the property of the provider's Config class that lists
+	// resources of this class, e.g. buckets, or "" if there is none.
+	Collection string
+	// Keyed is set if the collection is a Mapping keyed by resource name rather
+	// than a Listing.
+	Keyed bool
+}
+
+// Property is a property declared by a resource class.
+type Property struct {
+	Name    string // e.g. forceDestroy
+	Type    string // PKL type, e.g. Boolean or Mapping<String, String>?
+	Default string // Source of the default value if it is a literal, e.g. false
+	Key     string // Provider attribute the property is sent as, e.g. force_destroy
+}
+
+// Property returns the property of c sent as the provider attribute key.
+func (c *Class) Property(key string) *Property {
+	for _, p := range c.Props {
+		if p.Key == key {
+			return p
+		}
+	}
+	return nil
+}
+
+// Defaults returns the literal defaults of the properties of c, by the provider
+// attribute they are sent as.
+func (c *Class) Defaults() map[string]any {
+	defaults := make(map[string]any)
+	for _, p := range c.Props {
+		if p.Key == "" || p.Default == "" {
+			continue
+		}
+		if v, ok := literal(p.Default); ok {
+			defaults[p.Key] = v
+		}
+	}
+	return defaults
+}
+
+// literal parses the source of a PKL string, boolean or number literal.
+func literal(src string) (any, bool) {
+	switch {
+	case src == "true" || src == "false":
+		return src == "true", true
+	case strings.HasPrefix(src, `"`):
+		// Unicode escapes are written \u{...} in PKL and aren't converted
+		s, err := strconv.Unquote(src)
+		return s, err == nil
+	}
+	if i, err := strconv.ParseInt(src, 10, 64); err == nil {
+		return i, true
+	}
+	f, err := strconv.ParseFloat(src, 64)
+	return f, err == nil
+}
+
+// Schemas holds the resource classes of provider schemas, by type.
+type Schemas struct {
+	classes map[string]*Class
+}
+
+// Class returns the class of a resource type, or nil if no schema declares it.
+func (s *Schemas) Class(typ string) *Class {
+	return s.classes[typ]
+}
+
+// Provider returns the classes of the provider name.
+func (s *Schemas) Provider(name string) []*Class {
+	var classes []*Class
+	for _, c := range s.classes {
+		if c.Provider == name {
+			classes = append(classes, c)
+		}
+	}
+	return classes
+}
+
+var (
+	classRe    = regexp.MustCompile(`^(?:open |abstract )?class (\w+)(?: extends ([\w.]+))? \{`)
+	propRe     = regexp.MustCompile(`^  (?:hidden )?(\w+): ([^=]+?)(?: = (.*))?$`)
+	typeRe     = regexp.MustCompile(`^  type = "([^"]+)"$`)
+	keyRe      = regexp.MustCompile(`^\s+\["([^"]+)"\] = (\w+)$`)
+	importRe   = regexp.MustCompile(`^import "([^"]+)\.pkl"(?: as (\w+))?$`)
+	literalRe  = regexp.MustCompile(`^(?:"(?:[^"\\]|\\.)*"|true|false|-?\d+(?:\.\d+)?)$`)
+	listTypeRe = regexp.MustCompile(`^(Listing|Mapping)<(?:String, ?)?([\w.]+)>\??$`)
+)
+
+// LoadSchemas parses the provider schemas in fsys, laid out as
+// <provider>/<Module>.pkl.
+func LoadSchemas(fsys fs.FS) (*Schemas, error) {
+	files, err := fs.Glob(fsys, "*/*.pkl")
+	if err != nil {
+		return nil, err
+	}
+	s := &Schemas{classes: make(map[string]*Class)}
+	type collection struct {
+		provider, prop, module, class string
+		keyed                         bool
+	}
+	var collections []collection
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		provider := path.Dir(file)
+		module := strings.TrimSuffix(path.Base(file), ".pkl")
+		classes, configProps, aliases, err := parseModule(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, c := range classes {
+			c.Provider, c.Module = provider, module
+			if c.Type == "" {
+				c.Type = provider + ":" + module + "." + c.Name
+			}
+			s.classes[c.Type] = c
+		}
+		for _, p := range configProps {
+			m := listTypeRe.FindStringSubmatch(p.Type)
+			if m == nil {
+				continue
+			}
+			mod, class, ok := strings.Cut(m[2], ".")
+			if !ok {
+				continue
+			}
+			if target, ok := aliases[mod]; ok {
+				mod = target
+			}
+			collections = append(collections, collection{provider, p.Name, mod, class, m[1] == "Mapping"})
+		}
+	}
+
+	for _, col := range collections {
+		for _, c := range s.classes {
+			if c.Provider == col.provider && c.Module == col.module && c.Name == col.class && c.Collection == "" {
+				c.Collection, c.Keyed = col.prop, col.keyed
+			}
+		}
+	}
+	return s, nil
+}
+
+// parseModule parses the resource classes of a schema module, the properties of
+// its Config class and its import aliases.
+func parseModule(data []byte) (classes []*Class, configProps []*Property, aliases map[string]string, err error) {
+	aliases = make(map[string]string)
+	// Properties may be declared after the properties block that maps them
+	keys := make(map[*Class][][2]string)
+	var current *Class
+	var isResource, inProperties bool
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if m := importRe.FindStringSubmatch(line); m != nil {
+			module := path.Base(m[1])
+			alias := m[2]
+			if alias == "" {
+				alias = module
+			}
+			aliases[alias] = module
+			continue
+		}
+		if m := classRe.FindStringSubmatch(line); m != nil {
+			current = &Class{Name: m[1]}
+			// Resource classes extend the Resource class of the core schema
+			isResource = strings.HasSuffix(m[2], ".Resource")
+			inProperties = false
+			if isResource {
+				classes = append(classes, current)
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch {
+		case line == "}":
+			current = nil
+		case inProperties:
+			if line == "  }" {
+				inProperties = false
+			} else if m := keyRe.FindStringSubmatch(line); m != nil {
+				keys[current] = append(keys[current], [2]string{m[1], m[2]})
+			}
+		case line == "  properties = new {":
+			inProperties = true
+		case typeRe.MatchString(line):
+			current.Type = typeRe.FindStringSubmatch(line)[1]
+		default:
+			m := propRe.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			p := &Property{Name: m[1], Type: strings.TrimSpace(m[2])}
+			if literalRe.MatchString(m[3]) {
+				p.Default = m[3]
+			}
+			if current.Name == "Config" && !isResource {
+				configProps = append(configProps, p)
+			} else {
+				current.Props = append(current.Props, p)
+			}
+		}
+	}
+
+	for c, pairs := range keys {
+		for _, pair := range pairs {
+			for _, p := range c.Props {
+				if p.Name == pair[1] && p.Key == "" {
+					p.Key = pair[0]
+				}
+			}
+		}
+	}
+	return classes, configProps, aliases, scanner.Err()
+}
+
+// Inputs returns the attributes of outputs that class properties are sent as,
+// the inputs the generated configuration evaluates to. Attributes whose
+// values can't be rendered are left out, as they are left out of the
+// configuration.
+func (c *Class) Inputs(outputs map[string]any) map[string]any {
+	inputs := make(map[string]any)
+	for _, p := range c.Props {
+		if p.Key == "" {
+			continue
+		}
+		if v, ok := outputs[p.Key]; ok && v != nil && renderable(p.Type, v) {
+			inputs[p.Key] = v
+		}
+	}
+	return inputs
+}
@@ -49,6 +49,14 @@ func (r *Registry) LoadProvider(name string) error {
 	return nil
 }
 
+// Register registers p under name, replacing any provider loaded under that name.
+// It is used to stand in mock providers for real ones.
+func (r *Registry) Register(name string, p provider.ProviderServer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[name] = p
+}
+
 // Get returns a registered provider.
 func (r *Registry) Get(name string) (provider.ProviderServer, error) {
 	r.mu.RLock()
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DepsFile is the file, next to a PklProject, that records the resolved version
+// and checksum of each dependency. It is meant to be committed so that every run
+// uses the same versions.
+const DepsFile = "PklProject.deps.json"
+
+// ProjectDeps is the content of PklProject.deps.json.
+type ProjectDeps struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// Resolved dependencies, keyed by package URI with only the major version,
+	// e.g. package://host/path@1
+	ResolvedDependencies map[string]*ResolvedDependency `json:"resolvedDependencies"`
+}
+
+// ResolvedDependency is a resolved dependency.
+type ResolvedDependency struct {
+	Type      string     `json:"type"`                // "remote" or "local"
+	URI       string     `json:"uri"`                 // e.g. projectpackage://host/path@1.2.3
+	Checksums *Checksums `json:"checksums,omitempty"` // Of the package metadata, for remote dependencies
+	Path      string     `json:"path,omitempty"`      // Of the project directory, for local dependencies
+}
+
+// Version returns the resolved version of a dependency.
+func (d *ResolvedDependency) Version() (Version, error) {
+	src, err := ParseSource("package://" + trimScheme(d.URI))
+	if err != nil {
+		return Version{}, err
+	}
+	return src.Version, nil
+}
+
+// projectPackageURI returns the URI PklProject.deps.json records for a package
+// URI, e.g. projectpackage://host/path@1.2.3.
+func projectPackageURI(uri string) string {
+	return "projectpackage://" + trimScheme(uri)
+}
+
+func trimScheme(uri string) string {
+	if _, rest, ok := strings.Cut(uri, "://"); ok {
+		return rest
+	}
+	return uri
+}
+
+// ReadDeps reads a PklProject.deps.json file, returning empty dependencies if it
+// doesn't exist.
+func ReadDeps(path string) (*ProjectDeps, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &ProjectDeps{SchemaVersion: 1, ResolvedDependencies: make(map[string]*ResolvedDependency)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", DepsFile, err)
+	}
+	var deps ProjectDeps
+	if err := json.Unmarshal(data, &deps); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	if deps.SchemaVersion != 1 {
+		return nil, fmt.Errorf("%s has unsupported schema version %d", path, deps.SchemaVersion)
+	}
+	if deps.ResolvedDependencies == nil {
+		deps.ResolvedDependencies = make(map[string]*ResolvedDependency)
+	}
+	return &deps, nil
+}
+
+// Write writes the PklProject.deps.json file.
+func (d *ProjectDeps) Write(path string) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", DepsFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/apple/pkl-go/pkl"
+)
+
+// Dependency is a remote dependency declared in a PklProject.
+type Dependency struct {
+	Name   string // As declared, e.g. vpc
+	URI    string // e.g. package://pkg.example.com/picklr/vpc@1.2.0
+	SHA256 string // Declared checksum of the package metadata, if any
+}
+
+// Declared returns the remote dependencies of a project, those of its local
+// dependencies included, and the PklProject.deps.json entries of its local
+// dependencies, whose paths are relative to projectDir.
+func Declared(projectDir string, deps *pkl.ProjectDependencies) ([]Dependency, map[string]*ResolvedDependency, error) {
+	var remote []Dependency
+	local := make(map[string]*ResolvedDependency)
+	var walk func(deps *pkl.ProjectDependencies) error
+	walk = func(deps *pkl.ProjectDependencies) error {
+		if deps == nil {
+			return nil
+		}
+		for name, dep := range deps.RemoteDependencies {
+			d := Dependency{Name: name, URI: dep.PackageUri}
+			if dep.Checksums != nil {
+				d.SHA256 = dep.Checksums.Sha256
+			}
+			remote = append(remote, d)
+		}
+		for name, dep := range deps.LocalDependencies {
+			src, err := ParseSource(dep.PackageUri)
+			if err != nil {
+				return fmt.Errorf("local dependency %s: %w", name, err)
+			}
+			u, err := url.Parse(dep.ProjectFileUri)
+			if err != nil {
+				return fmt.Errorf("local dependency %s: %w", name, err)
+			}
+			rel, err := filepath.Rel(projectDir, filepath.Dir(filepath.FromSlash(u.Path)))
+			if err != nil {
+				return fmt.Errorf("local dependency %s: %w", name, err)
+			}
+			local[src.Key()] = &ResolvedDependency{Type: "local", URI: projectPackageURI(dep.PackageUri), Path: filepath.ToSlash(rel)}
+			if err := walk(dep.Dependencies); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(deps); err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(remote, func(a, b int) bool {
+		if remote[a].Name != remote[b].Name {
+			return remote[a].Name < remote[b].Name
+		}
+		return remote[a].URI < remote[b].URI
+	})
+	return remote, local, nil
+}
+
+// Installer resolves the dependencies of a project and vendors the packages into
+// a PKL package cache, laid out as PKL lays out its own so that evaluations use
+// the vendored packages.
+type Installer struct {
+	CacheDir string
+	Deps     *ProjectDeps
+	Upgrade  bool // Resolve the newest allowed versions rather than the recorded ones
+	Client   *Client
+}
+
+// Installed describes an installed package.
+type Installed struct {
+	Name       string // Of the dependency that selected the package
+	URI        string
+	Downloaded bool // False if the vendored package was used
+}
+
+// requirement is a package required by a project or another package.
+type requirement struct {
+	name string
+	src  Source
+	sum  string // Required checksum of the metadata of src's version, if known
+}
+
+// selection is the version of a package that resolution selected.
+type selection struct {
+	name   string
+	uri    string
+	meta   *Metadata
+	sum    string
+	cached bool // The metadata was read from the cache
+}
+
+// Install resolves the remote dependencies, those of the packages included,
+// vendors the packages and replaces the resolved dependencies with them and the
+// local ones. As in PKL, the highest version required of each major version of a
+// package is used. A recorded version is kept as long as every requirement
+// allows it, unless Upgrade is set. Packages are checked against the recorded
+// checksums, so a package that changed since it was resolved is reported rather
+// than installed.
+func (i *Installer) Install(ctx context.Context, remote []Dependency, local map[string]*ResolvedDependency) ([]*Installed, error) {
+	var queue []requirement
+	for _, d := range remote {
+		src, err := ParseSource(d.URI)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %w", d.Name, err)
+		}
+		queue = append(queue, requirement{name: d.Name, src: src, sum: d.SHA256})
+	}
+
+	selected := make(map[string]*selection)
+	versions := make(map[string]Version)
+	for len(queue) > 0 {
+		req := queue[0]
+		queue = queue[1:]
+
+		v, err := i.version(ctx, req.src)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %w", req.name, err)
+		}
+		key := req.src.Key()
+		if cur, ok := versions[key]; ok && cur.Compare(v) >= 0 {
+			continue
+		}
+		uri := req.src.URI(v)
+		wantSum := i.recordedSum(key, uri)
+		if wantSum == "" && v == req.src.Version {
+			wantSum = req.sum
+		}
+		meta, sum, cached, err := i.metadata(ctx, uri, wantSum)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %w", req.name, err)
+		}
+		versions[key] = v
+		selected[key] = &selection{name: req.name, uri: uri, meta: meta, sum: sum, cached: cached}
+
+		names := make([]string, 0, len(meta.Dependencies))
+		for name := range meta.Dependencies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			dep := meta.Dependencies[name]
+			src, err := ParseSource(dep.URI)
+			if err != nil {
+				return nil, fmt.Errorf("dependency %s of %s: %w", name, uri, err)
+			}
+			r := requirement{name: name, src: src}
+			if dep.Checksums != nil {
+				r.sum = dep.Checksums.SHA256
+			}
+			queue = append(queue, r)
+		}
+	}
+
+	keys := make([]string, 0, len(selected))
+	for key := range selected {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	resolved := make(map[string]*ResolvedDependency, len(selected)+len(local))
+	for key, dep := range local {
+		resolved[key] = dep
+	}
+	var installed []*Installed
+	for _, key := range keys {
+		s := selected[key]
+		downloaded, err := i.vendor(ctx, s.uri, s.meta)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %w", s.name, err)
+		}
+		installed = append(installed, &Installed{Name: s.name, URI: s.uri, Downloaded: downloaded || !s.cached})
+		resolved[key] = &ResolvedDependency{Type: "remote", URI: projectPackageURI(s.uri), Checksums: &Checksums{SHA256: s.sum}}
+	}
+	i.Deps.ResolvedDependencies = resolved
+	return installed, nil
+}
+
+// version returns the version of src to use: the newest allowed one when
+// upgrading, otherwise the recorded one if src allows it, or src's own.
+func (i *Installer) version(ctx context.Context, src Source) (Version, error) {
+	if i.Upgrade {
+		return i.Client.Latest(ctx, src)
+	}
+	if dep := i.Deps.ResolvedDependencies[src.Key()]; dep != nil && dep.Type == "remote" {
+		if v, err := dep.Version(); err == nil && src.Constraint().Check(v) {
+			return v, nil
+		}
+	}
+	return src.Version, nil
+}
+
+// recordedSum returns the recorded metadata checksum of the package uri, or "" if
+// another version or none is recorded under key.
+func (i *Installer) recordedSum(key, uri string) string {
+	dep := i.Deps.ResolvedDependencies[key]
+	if dep == nil || dep.Type != "remote" || dep.URI != projectPackageURI(uri) || dep.Checksums == nil {
+		return ""
+	}
+	return dep.Checksums.SHA256
+}
+
+// metadata returns the metadata of the package uri and its checksum, from the
+// cache if it holds metadata with checksum wantSum, or else fetched and cached.
+func (i *Installer) metadata(ctx context.Context, uri, wantSum string) (*Metadata, string, bool, error) {
+	metaPath, _ := cachePaths(i.CacheDir, uri)
+	if wantSum != "" {
+		if data, err := os.ReadFile(metaPath); err == nil && Checksum(data) == wantSum {
+			if meta, err := decodeMetadata(uri, data); err == nil {
+				return meta, wantSum, true, nil
+			}
+		}
+	}
+
+	meta, data, err := i.Client.Metadata(ctx, uri)
+	if err != nil {
+		return nil, "", false, err
+	}
+	sum := Checksum(data)
+	if wantSum != "" && sum != wantSum {
+		return nil, "", false, fmt.Errorf("checksum of the metadata of %s is %s, but %s was expected; the package changed since it was resolved", uri, sum, wantSum)
+	}
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		return nil, "", false, fmt.Errorf("failed to create package cache: %w", err)
+	}
+	if err := os.WriteFile(metaPath, data, 0644); err != nil {
+		return nil, "", false, fmt.Errorf("failed to cache metadata: %w", err)
+	}
+	return meta, sum, false, nil
+}
+
+// vendor makes sure the cache holds the package zip of meta, downloading it if
+// it doesn't, and reports whether it was downloaded.
+func (i *Installer) vendor(ctx context.Context, uri string, meta *Metadata) (bool, error) {
+	_, zipPath := cachePaths(i.CacheDir, uri)
+	if data, err := os.ReadFile(zipPath); err == nil && Checksum(data) == meta.PackageZipChecksums.SHA256 {
+		return false, nil
+	}
+	data, err := i.Client.Download(ctx, meta)
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(zipPath, data, 0644); err != nil {
+		return false, fmt.Errorf("failed to cache package: %w", err)
+	}
+	return true, nil
+}
+
+// Verify checks that the remote dependencies are resolved in deps to versions
+// they allow, and that every resolved package is vendored in cacheDir unchanged.
+func Verify(cacheDir string, deps *ProjectDeps, remote []Dependency) error {
+	var problems []string
+	for _, d := range remote {
+		src, err := ParseSource(d.URI)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("dependency %s: %v", d.Name, err))
+			continue
+		}
+		dep := deps.ResolvedDependencies[src.Key()]
+		if dep == nil || dep.Type != "remote" {
+			problems = append(problems, fmt.Sprintf("dependency %s: %s is not resolved", d.Name, d.URI))
+			continue
+		}
+		if v, err := dep.Version(); err != nil || !src.Constraint().Check(v) {
+			problems = append(problems, fmt.Sprintf("dependency %s: %s is resolved to %s", d.Name, d.URI, dep.URI))
+		}
+	}
+
+	keys := make([]string, 0, len(deps.ResolvedDependencies))
+	for key, dep := range deps.ResolvedDependencies {
+		if dep.Type == "remote" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := checkVendored(cacheDir, deps.ResolvedDependencies[key]); err != nil {
+			problems = append(problems, fmt.Sprintf("package %s: %v", key, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("vendored packages don't match %s:\n  %s", DepsFile, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// checkVendored reports why the resolved package dep isn't vendored in cacheDir as
+// it was resolved, or nil if it is.
+func checkVendored(cacheDir string, dep *ResolvedDependency) error {
+	if dep.Checksums == nil {
+		return fmt.Errorf("no checksum is recorded")
+	}
+	uri := "package://" + trimScheme(dep.URI)
+	metaPath, zipPath := cachePaths(cacheDir, uri)
+	data, err := os.ReadFile(metaPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("not installed")
+	}
+	if err != nil {
+		return err
+	}
+	if Checksum(data) != dep.Checksums.SHA256 {
+		return fmt.Errorf("the metadata of %s was changed since it was installed", uri)
+	}
+	meta, err := decodeMetadata(uri, data)
+	if err != nil {
+		return err
+	}
+	zip, err := os.ReadFile(zipPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("not installed")
+	}
+	if err != nil {
+		return err
+	}
+	if Checksum(zip) != meta.PackageZipChecksums.SHA256 {
+		return fmt.Errorf("the package zip of %s was changed since it was installed", uri)
+	}
+	return nil
+}
+
+// cachePaths returns where PKL caches the metadata and zip of the package uri:
+// <cacheDir>/package-2/<host>/<path>@<version>/<name>@<version>.json and .zip.
+func cachePaths(cacheDir, uri string) (string, string) {
+	rel := encodePath(trimScheme(uri))
+	dir := filepath.Join(cacheDir, "package-2", filepath.FromSlash(rel))
+	base := path.Base(rel)
+	return filepath.Join(dir, base+".json"), filepath.Join(dir, base+".zip")
+}
+
+// encodePath escapes the characters PKL escapes in cache paths, such as the
+// colon before a port, as (<hex>).
+func encodePath(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if r < 0x20 || strings.ContainsRune(`<>:"\|?*`, r) {
+			fmt.Fprintf(&b, "(%x)", r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
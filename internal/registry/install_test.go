@@ -0,0 +1,320 @@
+package registry
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apple/pkl-go/pkl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry serves package versions whose zips hold a single <name>.pkl.
+type testRegistry struct {
+	server  *httptest.Server
+	meta    map[string][]byte // Metadata, by package@version
+	zips    map[string][]byte // By package@version
+	noIndex bool              // Don't list versions
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{meta: make(map[string][]byte), zips: make(map[string][]byte)}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p := strings.TrimPrefix(req.URL.Path, "/")
+		if zip, ok := strings.CutPrefix(p, "zips/"); ok {
+			w.Write(r.zips[strings.TrimSuffix(zip, ".zip")])
+			return
+		}
+		if meta, ok := r.meta[p]; ok {
+			w.Write(meta)
+			return
+		}
+		var index struct {
+			Versions []string `json:"versions"`
+		}
+		for key := range r.meta {
+			if pkg, v, _ := strings.Cut(key, "@"); pkg == p {
+				index.Versions = append(index.Versions, v)
+			}
+		}
+		if r.noIndex || index.Versions == nil {
+			http.NotFound(w, req)
+			return
+		}
+		json.NewEncoder(w).Encode(index)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// publish publishes version of the package picklr/<name>, depending on deps, which
+// are package@version keys of published packages.
+func (r *testRegistry) publish(t *testing.T, name, version, content string, deps ...string) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create(name + ".pkl")
+	require.NoError(t, err)
+	f.Write([]byte(content))
+	require.NoError(t, zw.Close())
+
+	key := "picklr/" + name + "@" + version
+	var meta Metadata
+	meta.Name = name
+	meta.Version = version
+	meta.PackageZipURL = r.server.URL + "/zips/" + key + ".zip"
+	meta.PackageZipChecksums.SHA256 = Checksum(buf.Bytes())
+	for _, dep := range deps {
+		if meta.Dependencies == nil {
+			meta.Dependencies = make(map[string]*MetadataDependency)
+		}
+		pkg, _, _ := strings.Cut(dep, "@")
+		meta.Dependencies[pkg] = &MetadataDependency{URI: r.uri(dep), Checksums: &Checksums{SHA256: Checksum(r.meta["picklr/"+dep])}}
+	}
+	data, err := json.Marshal(meta)
+	require.NoError(t, err)
+	r.zips[key] = buf.Bytes()
+	r.meta[key] = data
+}
+
+// uri returns the package URI of a package@version key.
+func (r *testRegistry) uri(key string) string {
+	return "package://" + strings.TrimPrefix(r.server.URL, "http://") + "/picklr/" + key
+}
+
+func (r *testRegistry) installer(dir string, deps *ProjectDeps) *Installer {
+	return &Installer{CacheDir: dir, Deps: deps, Client: &Client{http: r.server.Client(), scheme: "http"}}
+}
+
+func TestInstaller_Install(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.publish(t, "vpc", "1.0.0", "// vpc 1.0.0\n")
+	reg.publish(t, "vpc", "1.2.0", "// vpc 1.2.0\n")
+	reg.publish(t, "vpc", "2.0.0", "// vpc 2.0.0\n")
+	dir := t.TempDir()
+	deps, err := ReadDeps(filepath.Join(dir, DepsFile))
+	require.NoError(t, err)
+	ctx := context.Background()
+	local := map[string]*ResolvedDependency{
+		"package://example.com/schemas@0": {Type: "local", URI: "projectpackage://example.com/schemas@0.1.0", Path: "../schemas"},
+	}
+	declared := []Dependency{{Name: "vpc", URI: reg.uri("vpc@1.0.0")}}
+
+	installed, err := reg.installer(dir, deps).Install(ctx, declared, local)
+	require.NoError(t, err)
+	require.Len(t, installed, 1)
+	assert.True(t, installed[0].Downloaded)
+	assert.Equal(t, reg.uri("vpc@1.0.0"), installed[0].URI)
+
+	// The package is vendored where PKL caches it
+	metaPath, zipPath := cachePaths(dir, reg.uri("vpc@1.0.0"))
+	assert.Equal(t, filepath.Join(dir, "package-2", encodePath(strings.TrimPrefix(reg.server.URL, "http://")), "picklr", "vpc@1.0.0", "vpc@1.0.0.zip"), zipPath)
+	assert.Contains(t, filepath.ToSlash(zipPath), "(3a)")
+	meta, err := os.ReadFile(metaPath)
+	require.NoError(t, err)
+	assert.Equal(t, reg.meta["picklr/vpc@1.0.0"], meta)
+
+	key := "package://" + strings.TrimPrefix(reg.server.URL, "http://") + "/picklr/vpc@1"
+	assert.Equal(t, &ResolvedDependency{
+		Type:      "remote",
+		URI:       projectPackageURI(reg.uri("vpc@1.0.0")),
+		Checksums: &Checksums{SHA256: Checksum(reg.meta["picklr/vpc@1.0.0"])},
+	}, deps.ResolvedDependencies[key])
+	assert.Equal(t, local["package://example.com/schemas@0"], deps.ResolvedDependencies["package://example.com/schemas@0"])
+
+	// The file round-trips and the vendored package is used again
+	require.NoError(t, deps.Write(filepath.Join(dir, DepsFile)))
+	deps, err = ReadDeps(filepath.Join(dir, DepsFile))
+	require.NoError(t, err)
+	installed, err = reg.installer(dir, deps).Install(ctx, declared, local)
+	require.NoError(t, err)
+	assert.False(t, installed[0].Downloaded)
+
+	// Upgrade resolves the newest version of the same major version
+	inst := reg.installer(dir, deps)
+	inst.Upgrade = true
+	_, err = inst.Install(ctx, declared, local)
+	require.NoError(t, err)
+	assert.Equal(t, projectPackageURI(reg.uri("vpc@1.2.0")), deps.ResolvedDependencies[key].URI)
+
+	// The resolved version is kept while the declared one allows it
+	_, err = reg.installer(dir, deps).Install(ctx, declared, local)
+	require.NoError(t, err)
+	assert.Equal(t, projectPackageURI(reg.uri("vpc@1.2.0")), deps.ResolvedDependencies[key].URI)
+
+	// Dependencies that are no longer declared are dropped
+	_, err = reg.installer(dir, deps).Install(ctx, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, deps.ResolvedDependencies)
+}
+
+func TestInstaller_Install_NoIndex(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.publish(t, "vpc", "1.0.0", "// vpc 1.0.0\n")
+	reg.publish(t, "vpc", "1.2.0", "// vpc 1.2.0\n")
+	reg.noIndex = true
+	deps, err := ReadDeps(filepath.Join(t.TempDir(), DepsFile))
+	require.NoError(t, err)
+
+	// Without a version list, upgrades keep the declared version
+	inst := reg.installer(t.TempDir(), deps)
+	inst.Upgrade = true
+	installed, err := inst.Install(context.Background(), []Dependency{{Name: "vpc", URI: reg.uri("vpc@1.0.0")}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, reg.uri("vpc@1.0.0"), installed[0].URI)
+}
+
+func TestInstaller_Install_Transitive(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.publish(t, "vpc", "1.0.0", "// vpc 1.0.0\n")
+	reg.publish(t, "vpc", "1.1.0", "// vpc 1.1.0\n")
+	reg.publish(t, "net", "2.0.0", "// net\n", "vpc@1.1.0")
+	dir := t.TempDir()
+	deps, err := ReadDeps(filepath.Join(dir, DepsFile))
+	require.NoError(t, err)
+
+	// The highest version required of vpc 1.x wins
+	installed, err := reg.installer(dir, deps).Install(context.Background(), []Dependency{
+		{Name: "net", URI: reg.uri("net@2.0.0")},
+		{Name: "vpc", URI: reg.uri("vpc@1.0.0")},
+	}, nil)
+	require.NoError(t, err)
+	var uris []string
+	for _, inst := range installed {
+		uris = append(uris, inst.URI)
+	}
+	assert.ElementsMatch(t, []string{reg.uri("net@2.0.0"), reg.uri("vpc@1.1.0")}, uris)
+	assert.Len(t, deps.ResolvedDependencies, 2)
+}
+
+func TestInstaller_Install_ChecksumMismatch(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.publish(t, "vpc", "1.0.0", "// vpc 1.0.0\n")
+	dir := t.TempDir()
+	deps, err := ReadDeps(filepath.Join(dir, DepsFile))
+	require.NoError(t, err)
+	ctx := context.Background()
+	declared := []Dependency{{Name: "vpc", URI: reg.uri("vpc@1.0.0")}}
+
+	_, err = reg.installer(dir, deps).Install(ctx, declared, nil)
+	require.NoError(t, err)
+
+	// The vendored package is tampered with: it is downloaded again
+	_, zipPath := cachePaths(dir, reg.uri("vpc@1.0.0"))
+	require.NoError(t, os.WriteFile(zipPath, []byte("tampered"), 0644))
+	installed, err := reg.installer(dir, deps).Install(ctx, declared, nil)
+	require.NoError(t, err)
+	assert.True(t, installed[0].Downloaded)
+
+	// The published package changes: the recorded checksum no longer matches
+	reg.publish(t, "vpc", "1.0.0", "// something else\n")
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "package-2")))
+	_, err = reg.installer(dir, deps).Install(ctx, declared, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "changed since it was resolved")
+
+	// So does a checksum declared in the PklProject
+	_, err = reg.installer(dir, &ProjectDeps{SchemaVersion: 1}).Install(ctx, []Dependency{{Name: "vpc", URI: reg.uri("vpc@1.0.0"), SHA256: strings.Repeat("0", 64)}}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "changed since it was resolved")
+
+	// The zip doesn't match its metadata
+	reg.zips["picklr/vpc@1.0.0"] = []byte("tampered")
+	_, err = reg.installer(dir, &ProjectDeps{SchemaVersion: 1}).Install(ctx, declared, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+}
+
+func TestVerify(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.publish(t, "vpc", "1.0.0", "// vpc 1.0.0\n")
+	reg.publish(t, "vpc", "1.1.0", "// vpc 1.1.0\n")
+	dir := t.TempDir()
+	deps := &ProjectDeps{SchemaVersion: 1}
+	ctx := context.Background()
+	declared := []Dependency{{Name: "vpc", URI: reg.uri("vpc@1.0.0")}}
+
+	_, err := reg.installer(dir, deps).Install(ctx, declared, nil)
+	require.NoError(t, err)
+	require.NoError(t, Verify(dir, deps, declared))
+
+	// An edited vendored package is detected, and installing restores it
+	_, zipPath := cachePaths(dir, reg.uri("vpc@1.0.0"))
+	require.NoError(t, os.WriteFile(zipPath, []byte("edited"), 0644))
+	err = Verify(dir, deps, declared)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "package zip of "+reg.uri("vpc@1.0.0")+" was changed")
+	_, err = reg.installer(dir, deps).Install(ctx, declared, nil)
+	require.NoError(t, err)
+	require.NoError(t, Verify(dir, deps, declared))
+
+	// So is a declared version the resolved one doesn't satisfy
+	newer := []Dependency{{Name: "vpc", URI: reg.uri("vpc@1.1.0")}}
+	err = Verify(dir, deps, newer)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dependency vpc: "+reg.uri("vpc@1.1.0")+" is resolved to")
+
+	// A dependency that isn't resolved
+	err = Verify(dir, &ProjectDeps{SchemaVersion: 1}, declared)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not resolved")
+
+	// And a resolved package that isn't vendored
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "package-2")))
+	err = Verify(dir, deps, declared)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not installed")
+}
+
+func TestDeclared(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "infra")
+	deps := &pkl.ProjectDependencies{
+		RemoteDependencies: map[string]*pkl.ProjectRemoteDependency{
+			"vpc": {PackageUri: "package://pkg.example.com/picklr/vpc@1.2.0", Checksums: &pkl.Checksums{Sha256: "abc"}},
+		},
+		LocalDependencies: map[string]*pkl.ProjectLocalDependency{
+			"schemas": {
+				PackageUri:     "package://example.com/schemas@0.1.0",
+				ProjectFileUri: "file://" + filepath.ToSlash(filepath.Join(filepath.Dir(dir), "schemas", "PklProject")),
+				Dependencies: &pkl.ProjectDependencies{
+					RemoteDependencies: map[string]*pkl.ProjectRemoteDependency{
+						"dns": {PackageUri: "package://pkg.example.com/picklr/dns@1.0.0"},
+					},
+				},
+			},
+		},
+	}
+
+	remote, local, err := Declared(dir, deps)
+	require.NoError(t, err)
+	assert.Equal(t, []Dependency{
+		{Name: "dns", URI: "package://pkg.example.com/picklr/dns@1.0.0"},
+		{Name: "vpc", URI: "package://pkg.example.com/picklr/vpc@1.2.0", SHA256: "abc"},
+	}, remote)
+	assert.Equal(t, map[string]*ResolvedDependency{
+		"package://example.com/schemas@0": {Type: "local", URI: "projectpackage://example.com/schemas@0.1.0", Path: "../schemas"},
+	}, local)
+}
+
+func TestParseSource(t *testing.T) {
+	src, err := ParseSource("package://pkg.example.com/picklr/vpc@1.2.0")
+	require.NoError(t, err)
+	assert.Equal(t, "package://pkg.example.com/picklr/vpc", src.Package)
+	assert.Equal(t, "package://pkg.example.com/picklr/vpc@1", src.Key())
+	assert.Equal(t, "package://pkg.example.com/picklr/vpc@1.2.5", src.URI(Version{Major: 1, Minor: 2, Patch: 5}))
+	assert.True(t, src.Constraint().Check(Version{Major: 1, Minor: 9}))
+	assert.False(t, src.Constraint().Check(Version{Major: 1, Minor: 1}))
+	assert.False(t, src.Constraint().Check(Version{Major: 2}))
+
+	for _, s := range []string{"https://example.com/vpc@1.0.0", "package://example.com/vpc", "package://@1.0.0", "package://example.com/vpc@latest", "package://example.com/vpc@^1.0.0"} {
+		_, err := ParseSource(s)
+		assert.Error(t, err, s)
+	}
+}
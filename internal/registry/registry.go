@@ -0,0 +1,215 @@
+// Package registry resolves the PKL packages a PklProject depends on.
+//
+// As in PKL, a dependency such as package://pkg.example.com/picklr/vpc@1.2.0
+// allows any 1.x version from 1.2.0, and the metadata of version 1.2.3 is served
+// at https://pkg.example.com/picklr/vpc@1.2.3, naming the package zip and its
+// SHA-256 checksum. Resolved dependencies are recorded in PklProject.deps.json.
+//
+// As an optional extension, a registry may list the published versions at the
+// URI without version, https://pkg.example.com/picklr/vpc, as
+// {"versions": [...]}. Upgrades use the list to find newer versions; without it,
+// the declared versions are used.
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Source is a package dependency: a package and the lowest version it allows.
+type Source struct {
+	Package string // Package URI without version, e.g. package://pkg.example.com/picklr/vpc
+	Version Version
+}
+
+// ParseSource parses a package URI such as package://host/path@1.2.0.
+func ParseSource(s string) (Source, error) {
+	if !strings.HasPrefix(s, "package://") {
+		return Source{}, fmt.Errorf("invalid package URI %q: expected a package:// URI", s)
+	}
+	pkg, version, ok := strings.Cut(s, "@")
+	if !ok || version == "" {
+		return Source{}, fmt.Errorf("invalid package URI %q: expected a version after @", s)
+	}
+	if strings.TrimPrefix(pkg, "package://") == "" {
+		return Source{}, fmt.Errorf("invalid package URI %q: missing host", s)
+	}
+	v, err := ParseVersion(version)
+	if err != nil {
+		return Source{}, fmt.Errorf("invalid package URI %q: %w", s, err)
+	}
+	return Source{Package: pkg, Version: v}, nil
+}
+
+func (s Source) String() string { return s.URI(s.Version) }
+
+// URI returns the package URI of a version, e.g. package://host/path@1.2.3.
+func (s Source) URI(v Version) string { return s.Package + "@" + v.String() }
+
+// Key returns the key of the source in PklProject.deps.json, the package URI with
+// only the major version, e.g. package://host/path@1.
+func (s Source) Key() string { return fmt.Sprintf("%s@%d", s.Package, s.Version.Major) }
+
+// Constraint returns the versions the source allows: those of the same major
+// version, from its version on.
+func (s Source) Constraint() Constraint {
+	c, _ := ParseConstraint(fmt.Sprintf(">=%s, <%d.0.0", s.Version, s.Version.Major+1))
+	return c
+}
+
+// Metadata is the package metadata PKL publishes for a version.
+type Metadata struct {
+	Name                string `json:"name"`
+	PackageURI          string `json:"packageUri"`
+	Version             string `json:"version"`
+	PackageZipURL       string `json:"packageZipUrl"`
+	PackageZipChecksums struct {
+		SHA256 string `json:"sha256"`
+	} `json:"packageZipChecksums"`
+	Dependencies map[string]*MetadataDependency `json:"dependencies"`
+}
+
+// MetadataDependency is a dependency of a package, as listed in its metadata.
+type MetadataDependency struct {
+	URI       string     `json:"uri"`
+	Checksums *Checksums `json:"checksums"`
+}
+
+// Checksums are the checksums of a package's metadata.
+type Checksums struct {
+	SHA256 string `json:"sha256"`
+}
+
+// Client fetches package metadata and zips.
+type Client struct {
+	http   *http.Client
+	scheme string // Scheme package URIs are fetched with, https outside tests
+}
+
+// NewClient returns a client that fetches packages over HTTPS.
+func NewClient() *Client {
+	return &Client{http: &http.Client{Timeout: 60 * time.Second}, scheme: "https"}
+}
+
+// httpURL returns the URL a package URI is served at.
+func (c *Client) httpURL(uri string) string {
+	return c.scheme + "://" + strings.TrimPrefix(uri, "package://")
+}
+
+// Versions returns the published versions of a package, skipping any that
+// aren't valid semantic versions. It returns nil if the registry doesn't list
+// the versions of the package.
+func (c *Client) Versions(ctx context.Context, pkg string) ([]Version, error) {
+	body, err := c.get(ctx, c.httpURL(pkg))
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of %s: %w", pkg, err)
+	}
+	var index struct {
+		Versions []string `json:"versions"`
+	}
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("failed to decode versions of %s: %w", pkg, err)
+	}
+	var versions []Version
+	for _, s := range index.Versions {
+		if v, err := ParseVersion(s); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+// Latest returns the newest published version the source allows, or the
+// source's own version if the registry doesn't list versions.
+func (c *Client) Latest(ctx context.Context, src Source) (Version, error) {
+	versions, err := c.Versions(ctx, src.Package)
+	if err != nil {
+		return Version{}, err
+	}
+	if v, ok := src.Constraint().Latest(versions); ok {
+		return v, nil
+	}
+	return src.Version, nil
+}
+
+// Metadata fetches the metadata of a package version, returning it decoded and
+// as served, which is what PklProject.deps.json checksums.
+func (c *Client) Metadata(ctx context.Context, uri string) (*Metadata, []byte, error) {
+	body, err := c.get(ctx, c.httpURL(uri))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch metadata of %s: %w", uri, err)
+	}
+	meta, err := decodeMetadata(uri, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, body, nil
+}
+
+func decodeMetadata(uri string, data []byte) (*Metadata, error) {
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata of %s: %w", uri, err)
+	}
+	if meta.PackageZipURL == "" || meta.PackageZipChecksums.SHA256 == "" {
+		return nil, fmt.Errorf("metadata of %s has no package zip or checksum", uri)
+	}
+	return &meta, nil
+}
+
+// Download fetches the package zip of meta and checks it against the published
+// checksum.
+func (c *Client) Download(ctx context.Context, meta *Metadata) ([]byte, error) {
+	zip, err := c.get(ctx, meta.PackageZipURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", meta.PackageZipURL, err)
+	}
+	if sum := Checksum(zip); sum != meta.PackageZipChecksums.SHA256 {
+		return nil, fmt.Errorf("checksum mismatch for %s: got sha256 %s, metadata has %s", meta.PackageZipURL, sum, meta.PackageZipChecksums.SHA256)
+	}
+	return zip, nil
+}
+
+func (c *Client) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("GET %s: %w", url, errNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return body, nil
+}
+
+// errNotFound is returned for a URL the registry doesn't serve.
+var errNotFound = errors.New("not found")
+
+// Checksum returns the hex SHA-256 of data, as in PKL package metadata.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,204 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, major.minor.patch with an optional pre-release.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+// ParseVersion parses a version such as 1.2.3 or 2.0.0-rc.1. A leading v is allowed.
+func ParseVersion(s string) (Version, error) {
+	v := strings.TrimPrefix(s, "v")
+	v, _, _ = strings.Cut(v, "+") // build metadata is ignored
+	core, pre, _ := strings.Cut(v, "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid version %q: expected major.minor.patch", s)
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+		nums[i] = n
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2], Pre: pre}, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to or greater than o.
+// A pre-release is lower than its release; pre-releases compare as strings.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	case v.Pre < o.Pre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// Constraint is a set of version ranges that must all hold, e.g. ">=1.2.0, <2.0.0".
+type Constraint struct {
+	raw    string
+	ranges []versionRange
+}
+
+type versionRange struct {
+	op string // One of =, >, >=, <, <=
+	v  Version
+}
+
+// ParseConstraint parses a version constraint. It accepts comma-separated
+// comparisons (=, >, >=, <, <=), caret ranges (^1.2.0 allows 1.x.x from 1.2.0),
+// tilde ranges (~1.2.0 allows 1.2.x from 1.2.0), partial versions (1.2 allows
+// 1.2.x) and exact versions.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{raw: s}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return Constraint{}, fmt.Errorf("invalid constraint %q", s)
+		}
+		ranges, err := parseRange(part)
+		if err != nil {
+			return Constraint{}, fmt.Errorf("invalid constraint %q: %w", s, err)
+		}
+		c.ranges = append(c.ranges, ranges...)
+	}
+	return c, nil
+}
+
+func parseRange(s string) ([]versionRange, error) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if rest, ok := strings.CutPrefix(s, op); ok {
+			v, err := ParseVersion(strings.TrimSpace(rest))
+			if err != nil {
+				return nil, err
+			}
+			return []versionRange{{op, v}}, nil
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(s, "^"); ok {
+		v, err := ParseVersion(rest)
+		if err != nil {
+			return nil, err
+		}
+		upper := Version{Major: v.Major + 1}
+		switch {
+		case v.Major == 0 && v.Minor == 0:
+			upper = Version{Patch: v.Patch + 1}
+		case v.Major == 0:
+			upper = Version{Minor: v.Minor + 1}
+		}
+		return []versionRange{{">=", v}, {"<", upper}}, nil
+	}
+	if rest, ok := strings.CutPrefix(s, "~"); ok {
+		v, err := ParseVersion(rest)
+		if err != nil {
+			return nil, err
+		}
+		return []versionRange{{">=", v}, {"<", Version{Major: v.Major, Minor: v.Minor + 1}}}, nil
+	}
+
+	// Partial versions: 1 allows 1.x.x and 1.2 allows 1.2.x
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) < 3 {
+		var nums []int
+		for _, p := range parts {
+			n, err := strconv.Atoi(p)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid version %q", s)
+			}
+			nums = append(nums, n)
+		}
+		if len(nums) == 1 {
+			return []versionRange{{">=", Version{Major: nums[0]}}, {"<", Version{Major: nums[0] + 1}}}, nil
+		}
+		return []versionRange{{">=", Version{Major: nums[0], Minor: nums[1]}}, {"<", Version{Major: nums[0], Minor: nums[1] + 1}}}, nil
+	}
+
+	v, err := ParseVersion(s)
+	if err != nil {
+		return nil, err
+	}
+	return []versionRange{{"=", v}}, nil
+}
+
+func (c Constraint) String() string { return c.raw }
+
+// Check reports whether v satisfies the constraint. Pre-releases are only
+// allowed if the constraint names one.
+func (c Constraint) Check(v Version) bool {
+	if v.Pre != "" {
+		named := false
+		for _, r := range c.ranges {
+			if r.v.Pre != "" && r.v.Major == v.Major && r.v.Minor == v.Minor && r.v.Patch == v.Patch {
+				named = true
+			}
+		}
+		if !named {
+			return false
+		}
+	}
+	for _, r := range c.ranges {
+		cmp := v.Compare(r.v)
+		ok := false
+		switch r.op {
+		case "=":
+			ok = cmp == 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Latest returns the highest of versions that satisfies the constraint.
+func (c Constraint) Latest(versions []Version) (Version, bool) {
+	var best Version
+	found := false
+	for _, v := range versions {
+		if c.Check(v) && (!found || v.Compare(best) > 0) {
+			best, found = v, true
+		}
+	}
+	return best, found
+}
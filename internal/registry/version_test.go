@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("v1.2.3-rc.1+build")
+	require.NoError(t, err)
+	assert.Equal(t, Version{Major: 1, Minor: 2, Patch: 3, Pre: "rc.1"}, v)
+	assert.Equal(t, "1.2.3-rc.1", v.String())
+
+	for _, s := range []string{"1.2", "1.2.x", "a.b.c", "1.-2.3"} {
+		_, err := ParseVersion(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestConstraint_Check(t *testing.T) {
+	tests := []struct {
+		constraint string
+		allowed    []string
+		rejected   []string
+	}{
+		{"1.2.3", []string{"1.2.3"}, []string{"1.2.4", "1.2.3-rc.1"}},
+		{"^1.2.0", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0", "1.3.0-beta"}},
+		{"^0.2.1", []string{"0.2.1", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.2.0", []string{"1.2.0", "1.2.7"}, []string{"1.3.0"}},
+		{"1.2", []string{"1.2.0", "1.2.5"}, []string{"1.3.0"}},
+		{"1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{">=1.0.0, <2.0.0", []string{"1.0.0", "1.5.0"}, []string{"0.9.0", "2.0.0"}},
+		{"2.0.0-rc.1", []string{"2.0.0-rc.1"}, []string{"2.0.0"}},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		require.NoError(t, err, tt.constraint)
+		for _, s := range tt.allowed {
+			assert.True(t, c.Check(mustVersion(t, s)), "%s should allow %s", tt.constraint, s)
+		}
+		for _, s := range tt.rejected {
+			assert.False(t, c.Check(mustVersion(t, s)), "%s should reject %s", tt.constraint, s)
+		}
+	}
+
+	for _, s := range []string{"", "^x", ">=1.0.0,", "1.2.3.4"} {
+		_, err := ParseConstraint(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestConstraint_Latest(t *testing.T) {
+	c, err := ParseConstraint("^1.2.0")
+	require.NoError(t, err)
+	versions := []Version{mustVersion(t, "1.1.0"), mustVersion(t, "1.4.2"), mustVersion(t, "1.10.0"), mustVersion(t, "2.0.0")}
+
+	v, ok := c.Latest(versions)
+	require.True(t, ok)
+	assert.Equal(t, "1.10.0", v.String())
+
+	_, ok = c.Latest(versions[:1])
+	assert.False(t, ok)
+}
+
+func mustVersion(t *testing.T, s string) Version {
+	t.Helper()
+	v, err := ParseVersion(s)
+	require.NoError(t, err)
+	return v
+}
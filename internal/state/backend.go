@@ -16,13 +16,21 @@ type Backend interface {
 	// Write saves the state to the backend.
 	Write(ctx context.Context, state *ir.State) error
 
-	// Lock acquires an exclusive lock on the state.
-	Lock() error
+	// Lock acquires an exclusive lock on the state, recording info as the holder.
+	// If another process holds the lock, it returns a *LockError.
+	Lock(info *LockInfo) error
 
 	// Unlock releases the lock on the state.
 	Unlock() error
+
+	// ForceUnlock releases the lock with the given ID, regardless of which
+	// process holds it.
+	ForceUnlock(id string) error
 }
 
+// The local file Manager is the default backend.
+var _ Backend = (*Manager)(nil)
+
 // BackendConfig holds configuration for a state backend.
 type BackendConfig struct {
 	Type   string            `json:"type"` // "local", "s3", "gcs", "http"
@@ -48,10 +56,54 @@ type GCSBackendConfig struct {
 // HTTPBackendConfig holds configuration for HTTP state backend.
 type HTTPBackendConfig struct {
 	Address       string `json:"address"`
+	UpdateMethod  string `json:"update_method"` // default POST
 	LockAddress   string `json:"lock_address"`
+	LockMethod    string `json:"lock_method"` // default LOCK
 	UnlockAddress string `json:"unlock_address"`
+	UnlockMethod  string `json:"unlock_method"` // default UNLOCK
 	Username      string `json:"username"`
 	Password      string `json:"password"`
+	Token         string `json:"token"` // bearer token, used instead of basic auth
+	RetryMax      int    `json:"retry_max"`
+	RetryWaitMin  string `json:"retry_wait_min"`
+	RetryWaitMax  string `json:"retry_wait_max"`
+}
+
+// parseStateContent decrypts state content if needed and loads it.
+func parseStateContent(ctx context.Context, evaluator *eval.Evaluator, content []byte) (*ir.State, error) {
+	if IsEncrypted(content) {
+		decrypted, err := DecryptState(ctx, content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt remote state: %w", err)
+		}
+		content = decrypted
+	}
+	state, err := loadStateContent(ctx, evaluator, content)
+	if err != nil {
+		return nil, err
+	}
+	return openSensitive(ctx, state)
+}
+
+// loadStateContent loads decrypted PKL or JSON state content in memory.
+func loadStateContent(ctx context.Context, evaluator *eval.Evaluator, content []byte) (*ir.State, error) {
+	if detectEncoding(content) == EncodingJSON {
+		return ParseStateJSON(content)
+	}
+	state, err := evaluator.LoadStateText(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeState(state), nil
+}
+
+// LoadStateFile evaluates a PKL state file.
+func LoadStateFile(ctx context.Context, evaluator *eval.Evaluator, path string) (*ir.State, error) {
+	state, err := evaluator.LoadState(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeState(state), nil
 }
 
 // NewBackend creates a state backend from configuration.
@@ -61,15 +113,27 @@ func NewBackend(cfg *BackendConfig, evaluator *eval.Evaluator) (Backend, error)
 		return nil, fmt.Errorf("backend configuration is nil")
 	}
 
+	encoding, err := ParseEncoding(cfg.Config["encoding"])
+	if err != nil {
+		return nil, err
+	}
+
 	switch cfg.Type {
 	case "local", "":
-		return nil, fmt.Errorf("use state.Manager for local backend")
+		path := cfg.Config["path"]
+		if path == "" {
+			return nil, fmt.Errorf("local backend requires 'path' configuration")
+		}
+		m := NewManager(path, evaluator)
+		m.encoding = encoding
+		m.encryptSensitive = cfg.Config["encrypt_sensitive"] == "true"
+		return m, nil
 	case "s3":
 		return newS3Backend(cfg.Config, evaluator)
 	case "gcs":
 		return nil, fmt.Errorf("GCS backend not yet implemented")
 	case "http":
-		return nil, fmt.Errorf("HTTP backend not yet implemented")
+		return newHTTPBackend(cfg.Config, evaluator)
 	default:
 		return nil, fmt.Errorf("unknown backend type: %s", cfg.Type)
 	}
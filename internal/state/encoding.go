@@ -0,0 +1,82 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// Encoding is the format state is stored in.
+type Encoding string
+
+const (
+	// EncodingPKL stores state as a PKL module amending State.pkl. Reading it
+	// requires the PKL evaluator.
+	EncodingPKL Encoding = "pkl"
+	// EncodingJSON stores state as JSON, which is parsed natively.
+	EncodingJSON Encoding = "json"
+)
+
+// ParseEncoding validates an encoding name. An empty name means the encoding
+// is not configured.
+func ParseEncoding(name string) (Encoding, error) {
+	switch Encoding(name) {
+	case "", EncodingPKL, EncodingJSON:
+		return Encoding(name), nil
+	default:
+		return "", fmt.Errorf("unknown state encoding %q, expected pkl or json", name)
+	}
+}
+
+// EncodeState serializes state in the given encoding.
+func EncodeState(state *ir.State, encoding Encoding) ([]byte, error) {
+	if encoding == EncodingJSON {
+		return SerializeStateJSON(state)
+	}
+	return []byte(SerializeState(state)), nil
+}
+
+// detectEncoding reports the encoding of decrypted state content.
+func detectEncoding(content []byte) Encoding {
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		return EncodingJSON
+	}
+	return EncodingPKL
+}
+
+// writeEncoding picks the encoding for a write: the one set with WithEncoding,
+// else the configured one, else the encoding of the stored state, else PKL.
+func writeEncoding(ctx context.Context, configured Encoding, stored storedHeader) Encoding {
+	if enc, ok := ctx.Value(encodingKey{}).(Encoding); ok && enc != "" {
+		return enc
+	}
+	if configured != "" {
+		return configured
+	}
+	if stored.Exists && stored.Encoding != "" {
+		return stored.Encoding
+	}
+	return EncodingPKL
+}
+
+type encodingKey struct{}
+
+// WithEncoding returns a context under which Backend.Write stores state in the
+// given encoding, regardless of configuration.
+func WithEncoding(ctx context.Context, encoding Encoding) context.Context {
+	return context.WithValue(ctx, encodingKey{}, encoding)
+}
+
+// ValidateState checks state against the State.pkl schema by evaluating its PKL
+// form. It is how JSON state, which is otherwise never evaluated, is validated.
+func ValidateState(ctx context.Context, state *ir.State) error {
+	evaluator := eval.NewEvaluator("")
+	defer evaluator.Close()
+	if _, err := evaluator.LoadStateText(ctx, []byte(SerializeState(state))); err != nil {
+		return fmt.Errorf("state does not match the State.pkl schema: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package state
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_JSONEncoding(t *testing.T) {
+	t.Setenv(EncryptionKeyEnvVar, "")
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	mgr, err := NewBackend(&BackendConfig{Type: "local", Config: map[string]string{"path": statePath, "encoding": "json"}}, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	s := &ir.State{
+		Version: 1,
+		Lineage: "lin",
+		Outputs: map[string]any{},
+		Resources: []*ir.ResourceState{{
+			Type:       "null_resource",
+			Name:       "a",
+			Provider:   "null",
+			Inputs:     map[string]any{"whole": 2.0, "count": 2, "list": []any{1.5, nil, true}, "nested": map[string]any{"k": "v"}},
+			InputsHash: "h",
+			Outputs:    map[string]any{"id": "null-a"},
+		}},
+	}
+	require.NoError(t, mgr.Write(ctx, s))
+
+	raw, err := os.ReadFile(statePath)
+	require.NoError(t, err)
+	assert.Equal(t, byte('{'), raw[0])
+	assert.Contains(t, string(raw), `"whole": 2.0`)
+
+	// Read without the PKL evaluator
+	got, err := mgr.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, s, got)
+
+	versions, err := mgr.(History).Versions(ctx)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, filepath.Join(filepath.Dir(statePath), "state.1.json.bak"), versions[0].ID)
+	assert.Equal(t, "lin", versions[0].Lineage)
+}
+
+func TestManager_WriteKeepsStoredEncoding(t *testing.T) {
+	t.Setenv(EncryptionKeyEnvVar, "")
+	statePath := filepath.Join(t.TempDir(), "state.pkl")
+	mgr := NewManager(statePath, nil)
+	ctx := context.Background()
+
+	s := &ir.State{Version: 1, Lineage: "lin"}
+	require.NoError(t, mgr.Write(WithEncoding(ctx, EncodingJSON), s))
+	require.NoError(t, mgr.Write(ctx, s))
+
+	stored, err := mgr.storedHeader(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, EncodingJSON, stored.Encoding)
+	assert.Equal(t, 2, stored.Serial)
+
+	require.NoError(t, mgr.Write(WithEncoding(ctx, EncodingPKL), s))
+	stored, err = mgr.storedHeader(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, EncodingPKL, stored.Encoding)
+	assert.Equal(t, 3, stored.Serial)
+}
+
+func TestParseEncoding(t *testing.T) {
+	for _, name := range []string{"", "pkl", "json"} {
+		enc, err := ParseEncoding(name)
+		require.NoError(t, err)
+		assert.Equal(t, Encoding(name), enc)
+	}
+	_, err := ParseEncoding("yaml")
+	assert.Error(t, err)
+
+	_, err = NewBackend(&BackendConfig{Type: "local", Config: map[string]string{"path": "x", "encoding": "yaml"}}, nil)
+	assert.Error(t, err)
+}
+
+func TestValidateState(t *testing.T) {
+	if _, err := exec.LookPath("pkl"); err != nil && os.Getenv("PKL_EXEC") == "" {
+		t.Skip("pkl is not installed")
+	}
+
+	s := &ir.State{Version: 1, Serial: 1, Lineage: "lin", Resources: []*ir.ResourceState{
+		{Type: "null_resource", Name: "a", Provider: "null", Inputs: map[string]any{"k": 1.5}},
+	}}
+	assert.NoError(t, ValidateState(context.Background(), s))
+}
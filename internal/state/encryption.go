@@ -1,113 +1,229 @@
 package state
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
+	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"strings"
 )
 
 const (
-	// EncryptionKeyEnvVar is the environment variable for the state encryption key.
-	EncryptionKeyEnvVar = "PICKLR_STATE_ENCRYPTION_KEY"
-
-	// Encrypted state file header
-	encryptedHeader = "# PICKLR_ENCRYPTED_STATE\n"
+	// Encrypted state header. Version 2 is followed by an envelope line and the
+	// base64 ciphertext; the unversioned legacy header by the ciphertext alone.
+	encryptedHeaderPrefix = "# PICKLR_ENCRYPTED_STATE"
+	encryptedHeader       = encryptedHeaderPrefix + " v2\n"
+	legacyEncryptedHeader = encryptedHeaderPrefix + "\n"
 )
 
-// EncryptState encrypts state content using AES-256-GCM with a key from the environment.
-// Returns the original content if no encryption key is configured.
-func EncryptState(content []byte) ([]byte, error) {
-	key := getEncryptionKey()
-	if key == nil {
+// envelope is the second line of encrypted state. It names the key provider and
+// key the data key was wrapped with, and carries the wrapped data key.
+type envelope struct {
+	Provider string            `json:"provider"`
+	KeyID    string            `json:"key_id"`
+	Params   map[string]string `json:"params,omitempty"`
+	DataKey  string            `json:"data_key"`
+}
+
+// EncryptState encrypts state content with AES-256-GCM under a fresh data key,
+// wrapped by the configured key provider (see CurrentKeyProvider).
+// Returns the original content if no key provider is configured.
+func EncryptState(ctx context.Context, content []byte) ([]byte, error) {
+	provider, err := CurrentKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
 		return content, nil
 	}
+	return encryptWith(ctx, provider, content)
+}
 
-	block, err := aes.NewCipher(key)
+func encryptWith(ctx context.Context, provider KeyProvider, content []byte) ([]byte, error) {
+	key, env, err := newEnvelope(ctx, provider)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	// The header and envelope are authenticated along with the state
+	header := encryptedHeader + string(env) + "\n"
+	ciphertext, err := sealAESGCM(key, content, []byte(header))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		return nil, err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("failed to generate nonce: %w", err)
-	}
+	return []byte(header + base64.StdEncoding.EncodeToString(ciphertext) + "\n"), nil
+}
 
-	ciphertext := gcm.Seal(nonce, nonce, content, nil)
-	encoded := base64.StdEncoding.EncodeToString(ciphertext)
+// newEnvelope generates a data key with provider and returns it together with the
+// encoded envelope that records it.
+func newEnvelope(ctx context.Context, provider KeyProvider) ([]byte, []byte, error) {
+	key, wrapped, params, err := provider.NewDataKey(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return []byte(encryptedHeader + encoded + "\n"), nil
+	env, err := json.Marshal(envelope{
+		Provider: provider.Name(),
+		KeyID:    provider.KeyID(),
+		Params:   params,
+		DataKey:  base64.StdEncoding.EncodeToString(wrapped),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode envelope: %w", err)
+	}
+	return key, env, nil
 }
 
-// DecryptState decrypts state content if it's encrypted.
-// Returns the original content if not encrypted.
-func DecryptState(content []byte) ([]byte, error) {
+// DecryptState decrypts state content if it's encrypted, trying the current and
+// previous key providers. Returns the original content if not encrypted.
+func DecryptState(ctx context.Context, content []byte) ([]byte, error) {
 	if !IsEncrypted(content) {
 		return content, nil
 	}
-
-	key := getEncryptionKey()
-	if key == nil {
-		return nil, fmt.Errorf("state file is encrypted but %s is not set", EncryptionKeyEnvVar)
+	if bytes.HasPrefix(content, []byte(legacyEncryptedHeader)) {
+		return decryptLegacy(content)
+	}
+	if !bytes.HasPrefix(content, []byte(encryptedHeader)) {
+		version, _, _ := strings.Cut(strings.TrimPrefix(string(content), encryptedHeaderPrefix+" "), "\n")
+		return nil, fmt.Errorf("unsupported encrypted state version %q; upgrade picklr", version)
 	}
 
-	// Strip the header and decode
-	encoded := strings.TrimPrefix(string(content), encryptedHeader)
-	encoded = strings.TrimSpace(encoded)
-
-	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	rest := content[len(encryptedHeader):]
+	envLine, body, ok := bytes.Cut(rest, []byte("\n"))
+	if !ok {
+		return nil, fmt.Errorf("encrypted state is truncated")
+	}
+	var env envelope
+	if err := json.Unmarshal(envLine, &env); err != nil {
+		return nil, fmt.Errorf("invalid encrypted state envelope: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(body)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode encrypted state: %w", err)
 	}
+	header := content[:len(encryptedHeader)+len(envLine)+1]
+
+	key, err := unwrapDataKey(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openAESGCM(key, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state encrypted with %s key %q: %w", env.Provider, env.KeyID, err)
+	}
+	return plaintext, nil
+}
 
-	block, err := aes.NewCipher(key)
+// unwrapDataKey recovers the data key of an envelope with the first of the
+// current and previous key providers that can unwrap it.
+func unwrapDataKey(ctx context.Context, env envelope) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(env.DataKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return nil, fmt.Errorf("invalid data key in encrypted state: %w", err)
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	providers, err := decryptionKeyProviders()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		return nil, err
+	}
+
+	var lastErr error
+	for _, p := range orderProviders(providers, env) {
+		key, err := p.UnwrapKey(ctx, wrapped, env.Params)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return key, nil
+	}
+
+	if lastErr == nil {
+		return nil, fmt.Errorf("state is encrypted with %s key %q, but no %s key is configured (%s not set)",
+			env.Provider, env.KeyID, env.Provider, providerEnvVar(env.Provider))
 	}
+	return nil, fmt.Errorf("failed to decrypt state encrypted with %s key %q (wrong key?): %w", env.Provider, env.KeyID, lastErr)
+}
 
-	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return nil, fmt.Errorf("ciphertext too short")
+// orderProviders returns the providers that can unwrap env's data key, those
+// whose key ID matches first.
+func orderProviders(providers []KeyProvider, env envelope) []KeyProvider {
+	var matching, others []KeyProvider
+	for _, p := range providers {
+		switch {
+		case p.Name() != env.Provider:
+		case p.KeyID() == env.KeyID:
+			matching = append(matching, p)
+		default:
+			others = append(others, p)
+		}
+	}
+	if env.Provider == "key" {
+		// Raw keys are identified by their fingerprint alone
+		return matching
 	}
+	return append(matching, others...)
+}
 
-	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+// providerEnvVar names the setting that configures a kind of key provider.
+func providerEnvVar(name string) string {
+	switch name {
+	case "passphrase":
+		return EncryptionPassphraseEnvVar
+	case "kms":
+		return KMSKeyIDEnvVar
+	default:
+		return EncryptionKeyEnvVar + " or " + EncryptionKeyFileEnvVar
+	}
+}
+
+// decryptLegacy decrypts state written before envelope encryption, whose key was
+// PICKLR_STATE_ENCRYPTION_KEY zero-padded or truncated to 32 bytes.
+func decryptLegacy(content []byte) ([]byte, error) {
+	encoded := strings.TrimSpace(strings.TrimPrefix(string(content), legacyEncryptedHeader))
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt state (wrong key?): %w", err)
+		return nil, fmt.Errorf("failed to decode encrypted state: %w", err)
 	}
 
-	return plaintext, nil
+	var keys []string
+	for _, name := range []string{EncryptionKeyEnvVar, previousEnv(EncryptionKeyEnvVar)} {
+		if v := os.Getenv(name); v != "" {
+			keys = append(keys, v)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("state file is encrypted but %s is not set", EncryptionKeyEnvVar)
+	}
+
+	for _, k := range keys {
+		key := make([]byte, 32)
+		copy(key, k)
+		if plaintext, err := openAESGCM(key, ciphertext, nil); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to decrypt state (wrong key?)")
 }
 
 // IsEncrypted checks if state content is encrypted.
 func IsEncrypted(content []byte) bool {
-	return strings.HasPrefix(string(content), encryptedHeader)
+	return bytes.HasPrefix(content, []byte(encryptedHeaderPrefix))
 }
 
-// getEncryptionKey returns the 32-byte AES key from environment, or nil if not set.
-func getEncryptionKey() []byte {
-	keyStr := os.Getenv(EncryptionKeyEnvVar)
-	if keyStr == "" {
-		return nil
+// envelopeKeyID returns the provider and key ID that content is encrypted with,
+// read from its envelope header. ok is false if content is not encrypted with an
+// envelope.
+func envelopeKeyID(content []byte) (provider, keyID string, ok bool) {
+	if !bytes.HasPrefix(content, []byte(encryptedHeader)) {
+		return "", "", false
 	}
-
-	// Key must be exactly 32 bytes for AES-256
-	// If shorter, pad with zeros; if longer, truncate
-	key := make([]byte, 32)
-	copy(key, []byte(keyStr))
-	return key
+	envLine, _, _ := bytes.Cut(content[len(encryptedHeader):], []byte("\n"))
+	var env envelope
+	if json.Unmarshal(envLine, &env) != nil {
+		return "", "", false
+	}
+	return env.Provider, env.KeyID, true
 }
@@ -1,9 +1,22 @@
 package state
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/picklr-io/picklr/internal/ir"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -13,11 +26,11 @@ func TestEncryptDecrypt_NoKey(t *testing.T) {
 	os.Unsetenv(EncryptionKeyEnvVar)
 
 	content := []byte("version = 1\nserial = 0\n")
-	encrypted, err := EncryptState(content)
+	encrypted, err := EncryptState(context.Background(), content)
 	require.NoError(t, err)
 	assert.Equal(t, content, encrypted) // Should be unchanged
 
-	decrypted, err := DecryptState(content)
+	decrypted, err := DecryptState(context.Background(), content)
 	require.NoError(t, err)
 	assert.Equal(t, content, decrypted)
 }
@@ -28,12 +41,12 @@ func TestEncryptDecrypt_WithKey(t *testing.T) {
 
 	content := []byte("version = 1\nserial = 42\nlineage = \"test-uuid\"\n")
 
-	encrypted, err := EncryptState(content)
+	encrypted, err := EncryptState(context.Background(), content)
 	require.NoError(t, err)
 	assert.NotEqual(t, content, encrypted)
 	assert.True(t, IsEncrypted(encrypted))
 
-	decrypted, err := DecryptState(encrypted)
+	decrypted, err := DecryptState(context.Background(), encrypted)
 	require.NoError(t, err)
 	assert.Equal(t, content, decrypted)
 }
@@ -49,12 +62,12 @@ func TestDecryptState_WrongKey(t *testing.T) {
 	defer os.Unsetenv(EncryptionKeyEnvVar)
 
 	content := []byte("test data")
-	encrypted, err := EncryptState(content)
+	encrypted, err := EncryptState(context.Background(), content)
 	require.NoError(t, err)
 
 	// Try decrypting with wrong key
 	os.Setenv(EncryptionKeyEnvVar, "wrong-key-for-decryption!!!!!!!")
-	_, err = DecryptState(encrypted)
+	_, err = DecryptState(context.Background(), encrypted)
 	assert.Error(t, err)
 }
 
@@ -63,12 +76,281 @@ func TestDecryptState_NoKey(t *testing.T) {
 	defer os.Unsetenv(EncryptionKeyEnvVar)
 
 	content := []byte("test data")
-	encrypted, err := EncryptState(content)
+	encrypted, err := EncryptState(context.Background(), content)
 	require.NoError(t, err)
 
 	// Try decrypting without key
 	os.Unsetenv(EncryptionKeyEnvVar)
-	_, err = DecryptState(encrypted)
+	_, err = DecryptState(context.Background(), encrypted)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "not set")
 }
+
+// clearKeyEnv unsets every key provider setting for the duration of the test.
+func clearKeyEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{EncryptionKeyEnvVar, EncryptionPassphraseEnvVar, EncryptionKeyFileEnvVar, KMSKeyIDEnvVar} {
+		t.Setenv(name, "")
+		t.Setenv(previousEnv(name), "")
+	}
+}
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestEncryptState_RawKeyHeader(t *testing.T) {
+	clearKeyEnv(t)
+	t.Setenv(EncryptionKeyEnvVar, testKey(1))
+	ctx := context.Background()
+
+	encrypted, err := EncryptState(ctx, []byte("serial = 1\n"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(encrypted), "# PICKLR_ENCRYPTED_STATE v2\n"))
+
+	provider, keyID, ok := envelopeKeyID(encrypted)
+	require.True(t, ok)
+	assert.Equal(t, "key", provider)
+	assert.Equal(t, newRawKeyProvider(bytes.Repeat([]byte{1}, 32)).KeyID(), keyID)
+	assert.NotContains(t, string(encrypted), testKey(1))
+
+	decrypted, err := DecryptState(ctx, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "serial = 1\n", string(decrypted))
+}
+
+func TestEncryptState_KeyFile(t *testing.T) {
+	clearKeyEnv(t)
+	path := filepath.Join(t.TempDir(), "state.key")
+	require.NoError(t, os.WriteFile(path, []byte(testKey(2)+"\n"), 0600))
+	t.Setenv(EncryptionKeyFileEnvVar, path)
+	ctx := context.Background()
+
+	encrypted, err := EncryptState(ctx, []byte("data"))
+	require.NoError(t, err)
+
+	// The same key given directly decrypts it
+	clearKeyEnv(t)
+	t.Setenv(EncryptionKeyEnvVar, testKey(2))
+	decrypted, err := DecryptState(ctx, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(decrypted))
+}
+
+func TestEncryptState_Passphrase(t *testing.T) {
+	clearKeyEnv(t)
+	t.Setenv(EncryptionPassphraseEnvVar, "correct horse battery staple")
+	ctx := context.Background()
+
+	encrypted, err := EncryptState(ctx, []byte("data"))
+	require.NoError(t, err)
+
+	envLine := strings.Split(string(encrypted), "\n")[1]
+	var env envelope
+	require.NoError(t, json.Unmarshal([]byte(envLine), &env))
+	assert.Equal(t, "passphrase", env.Provider)
+	assert.Equal(t, "pbkdf2-sha256", env.Params["kdf"])
+	assert.NotEmpty(t, env.Params["salt"])
+	assert.Equal(t, strconv.Itoa(pbkdf2Iterations), env.Params["iterations"])
+
+	// Each write uses a fresh data key
+	again, err := EncryptState(ctx, []byte("data"))
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, again)
+
+	decrypted, err := DecryptState(ctx, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(decrypted))
+
+	t.Setenv(EncryptionPassphraseEnvVar, "wrong")
+	_, err = DecryptState(ctx, encrypted)
+	assert.ErrorContains(t, err, "wrong key?")
+}
+
+func TestEncryptState_KeyEnvPassphrase(t *testing.T) {
+	clearKeyEnv(t)
+	// 32 characters, but not a base64 key
+	t.Setenv(EncryptionKeyEnvVar, "my-super-secret-encryption-key!!")
+	ctx := context.Background()
+
+	encrypted, err := EncryptState(ctx, []byte("data"))
+	require.NoError(t, err)
+
+	envLine := strings.Split(string(encrypted), "\n")[1]
+	var env envelope
+	require.NoError(t, json.Unmarshal([]byte(envLine), &env))
+	assert.Equal(t, "passphrase", env.Provider)
+	assert.Equal(t, "pbkdf2-sha256", env.Params["kdf"])
+	assert.NotEmpty(t, env.Params["salt"])
+
+	decrypted, err := DecryptState(ctx, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(decrypted))
+}
+
+func TestDecryptState_Legacy(t *testing.T) {
+	clearKeyEnv(t)
+	legacyKey := "short-legacy-key"
+	key := make([]byte, 32)
+	copy(key, legacyKey)
+	sealed, err := sealAESGCM(key, []byte("version = 1\n"), nil)
+	require.NoError(t, err)
+	legacy := []byte("# PICKLR_ENCRYPTED_STATE\n" + base64.StdEncoding.EncodeToString(sealed) + "\n")
+
+	t.Setenv(EncryptionKeyEnvVar, legacyKey)
+	decrypted, err := DecryptState(context.Background(), legacy)
+	require.NoError(t, err)
+	assert.Equal(t, "version = 1\n", string(decrypted))
+
+	// Still readable as the previous key after switching to a new one
+	t.Setenv(EncryptionKeyEnvVar, testKey(3))
+	t.Setenv(previousEnv(EncryptionKeyEnvVar), legacyKey)
+	_, err = DecryptState(context.Background(), legacy)
+	require.NoError(t, err)
+}
+
+func TestDecryptState_Rotation(t *testing.T) {
+	clearKeyEnv(t)
+	ctx := context.Background()
+	t.Setenv(EncryptionKeyEnvVar, testKey(4))
+	encrypted, err := EncryptState(ctx, []byte("data"))
+	require.NoError(t, err)
+	_, oldID, _ := envelopeKeyID(encrypted)
+
+	t.Setenv(EncryptionKeyEnvVar, testKey(5))
+	_, err = DecryptState(ctx, encrypted)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), oldID)
+
+	t.Setenv(previousEnv(EncryptionKeyEnvVar), testKey(4))
+	decrypted, err := DecryptState(ctx, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(decrypted))
+
+	reencrypted, err := EncryptState(ctx, decrypted)
+	require.NoError(t, err)
+	_, newID, _ := envelopeKeyID(reencrypted)
+	assert.NotEqual(t, oldID, newID)
+}
+
+func TestDecryptState_TamperedHeader(t *testing.T) {
+	clearKeyEnv(t)
+	t.Setenv(EncryptionKeyEnvVar, testKey(6))
+	ctx := context.Background()
+	encrypted, err := EncryptState(ctx, []byte("data"))
+	require.NoError(t, err)
+
+	tampered := bytes.Replace(encrypted, []byte(`"provider":"key"`), []byte(`"provider":"key" `), 1)
+	_, err = DecryptState(ctx, tampered)
+	assert.Error(t, err)
+}
+
+func TestKeyProvider_OnlyOne(t *testing.T) {
+	clearKeyEnv(t)
+	t.Setenv(EncryptionKeyEnvVar, testKey(7))
+	t.Setenv(EncryptionPassphraseEnvVar, "pass")
+	_, err := EncryptState(context.Background(), []byte("data"))
+	assert.ErrorContains(t, err, "only one state encryption key")
+}
+
+// fakeKMS is a local stand-in for the AWS KMS GenerateDataKey and Decrypt APIs.
+func fakeKMS(t *testing.T) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	keys := map[string][]byte{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			KeyId             string
+			CiphertextBlob    []byte
+			EncryptionContext map[string]string
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		mu.Lock()
+		defer mu.Unlock()
+
+		if req.EncryptionContext["picklr"] != "state" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type":"InvalidCiphertextException","message":"encryption context mismatch"}`)
+			return
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GenerateDataKey":
+			key := bytes.Repeat([]byte{byte(len(keys) + 1)}, 32)
+			blob := fmt.Sprintf("%s/%d", req.KeyId, len(keys))
+			keys[blob] = key
+			_ = json.NewEncoder(w).Encode(map[string]any{"KeyId": req.KeyId, "Plaintext": key, "CiphertextBlob": []byte(blob)})
+		case "TrentService.Decrypt":
+			key, ok := keys[string(req.CiphertextBlob)]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type":"InvalidCiphertextException","message":"unknown ciphertext"}`)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"Plaintext": key})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEncryptState_KMS(t *testing.T) {
+	clearKeyEnv(t)
+	srv := fakeKMS(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv(KMSEndpointEnvVar, srv.URL)
+	t.Setenv(KMSKeyIDEnvVar, "alias/picklr-state")
+	ctx := context.Background()
+
+	encrypted, err := EncryptState(ctx, []byte("data"))
+	require.NoError(t, err)
+	provider, keyID, ok := envelopeKeyID(encrypted)
+	require.True(t, ok)
+	assert.Equal(t, "kms", provider)
+	assert.Equal(t, "alias/picklr-state", keyID)
+
+	decrypted, err := DecryptState(ctx, encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(decrypted))
+
+	// Without a KMS key configured the error names the key needed
+	t.Setenv(KMSKeyIDEnvVar, "")
+	_, err = DecryptState(ctx, encrypted)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "alias/picklr-state")
+	assert.Contains(t, err.Error(), KMSKeyIDEnvVar)
+}
+
+func TestManager_EncryptedReadInMemory(t *testing.T) {
+	clearKeyEnv(t)
+	t.Setenv(EncryptionKeyEnvVar, testKey(8))
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	mgr, err := NewBackend(&BackendConfig{Type: "local", Config: map[string]string{"path": statePath, "encoding": "json"}}, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	s := &ir.State{Version: 1, Lineage: "lin", Outputs: map[string]any{}}
+	require.NoError(t, mgr.Write(ctx, s))
+	raw, err := os.ReadFile(statePath)
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(raw))
+
+	got, err := mgr.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "lin", got.Lineage)
+
+	// Nothing decrypted is left on disk
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.NotContains(t, e.Name(), ".dec")
+	}
+}
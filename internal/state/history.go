@@ -0,0 +1,128 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// History is implemented by backends that keep previous versions of the state.
+type History interface {
+	// Versions lists the stored snapshots, newest first.
+	Versions(ctx context.Context) ([]StateVersion, error)
+
+	// ReadVersion loads the snapshot written with the given serial.
+	ReadVersion(ctx context.Context, serial int) (*ir.State, error)
+}
+
+// StateVersion describes one stored snapshot of the state.
+type StateVersion struct {
+	Serial  int
+	Lineage string
+	Created time.Time
+	ID      string // Backend-specific identifier, e.g. a file path or S3 version ID
+}
+
+var (
+	headerSerialRe  = regexp.MustCompile(`(?m)^serial = (\d+)$`)
+	headerLineageRe = regexp.MustCompile(`(?m)^lineage = "([^"]*)"$`)
+)
+
+// parseStateHeader extracts serial and lineage from serialized state without
+// evaluating it, so that listing history does not require the PKL runtime.
+func parseStateHeader(content []byte) (int, string, bool) {
+	if detectEncoding(content) == EncodingJSON {
+		var header struct {
+			Serial  *int   `json:"serial"`
+			Lineage string `json:"lineage"`
+		}
+		if err := json.Unmarshal(content, &header); err != nil || header.Serial == nil {
+			return 0, "", false
+		}
+		return *header.Serial, header.Lineage, true
+	}
+
+	m := headerSerialRe.FindSubmatch(content)
+	if m == nil {
+		return 0, "", false
+	}
+	serial, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return 0, "", false
+	}
+	lineage := ""
+	if l := headerLineageRe.FindSubmatch(content); l != nil {
+		lineage = string(l[1])
+	}
+	return serial, lineage, true
+}
+
+// snapshotPath returns the path of the local snapshot for serial, e.g.
+// .picklr/state.pkl -> .picklr/state.7.pkl.bak.
+func (m *Manager) snapshotPath(serial int) string {
+	ext := filepath.Ext(m.path)
+	return fmt.Sprintf("%s.%d%s.bak", strings.TrimSuffix(m.path, ext), serial, ext)
+}
+
+// Versions lists the local snapshots next to the state file, newest first.
+func (m *Manager) Versions(ctx context.Context) ([]StateVersion, error) {
+	ext := filepath.Ext(m.path)
+	prefix := strings.TrimSuffix(m.path, ext) + "."
+	suffix := ext + ".bak"
+	matches, err := filepath.Glob(prefix + "*" + suffix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list state snapshots: %w", err)
+	}
+
+	var versions []StateVersion
+	for _, path := range matches {
+		// Skip other workspaces' snapshots, e.g. state.staging.3.pkl.bak
+		serial, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, prefix), suffix))
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		lineage := ""
+		if raw, err := os.ReadFile(path); err == nil {
+			if content, err := DecryptState(context.Background(), raw); err == nil {
+				_, lineage, _ = parseStateHeader(content)
+			}
+		}
+		versions = append(versions, StateVersion{Serial: serial, Lineage: lineage, Created: info.ModTime(), ID: path})
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Serial > versions[j].Serial })
+	return versions, nil
+}
+
+// ReadVersion loads the local snapshot with the given serial.
+func (m *Manager) ReadVersion(ctx context.Context, serial int) (*ir.State, error) {
+	path := m.snapshotPath(serial)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("no snapshot with serial %d", serial)
+	}
+	return m.readFile(ctx, path)
+}
+
+// SameLineage reports whether two states belong to the same history. A state
+// without lineage (never written) matches anything.
+func SameLineage(a, b *ir.State) bool {
+	return a.Lineage == "" || b.Lineage == "" || a.Lineage == b.Lineage
+}
+
+var (
+	_ History = (*Manager)(nil)
+	_ History = (*s3Backend)(nil)
+)
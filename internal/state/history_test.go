@@ -0,0 +1,63 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_WriteKeepsSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.pkl")
+	mgr := NewManager(statePath, nil)
+	ctx := context.Background()
+
+	s := &ir.State{Version: 1, Serial: 0, Lineage: "lin-1"}
+	require.NoError(t, mgr.Write(ctx, s))
+	require.NoError(t, mgr.Write(ctx, s))
+	assert.Equal(t, 2, s.Serial)
+
+	for _, name := range []string{"state.1.pkl.bak", "state.2.pkl.bak"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+	}
+
+	// Snapshots of another workspace's state file are not listed
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "state.staging.1.pkl.bak"), []byte("serial = 1\n"), 0644))
+
+	versions, err := mgr.Versions(ctx)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, 2, versions[0].Serial)
+	assert.Equal(t, 1, versions[1].Serial)
+	assert.Equal(t, "lin-1", versions[0].Lineage)
+	assert.Equal(t, filepath.Join(dir, "state.2.pkl.bak"), versions[0].ID)
+}
+
+func TestManager_ReadVersionMissing(t *testing.T) {
+	mgr := NewManager(filepath.Join(t.TempDir(), "state.pkl"), nil)
+	_, err := mgr.ReadVersion(context.Background(), 3)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no snapshot with serial 3")
+}
+
+func TestParseStateHeader(t *testing.T) {
+	serial, lineage, ok := parseStateHeader([]byte(SerializeState(&ir.State{Version: 1, Serial: 4, Lineage: "abc"})))
+	require.True(t, ok)
+	assert.Equal(t, 4, serial)
+	assert.Equal(t, "abc", lineage)
+
+	_, _, ok = parseStateHeader([]byte("resources {}"))
+	assert.False(t, ok)
+}
+
+func TestSameLineage(t *testing.T) {
+	assert.True(t, SameLineage(&ir.State{Lineage: "a"}, &ir.State{Lineage: "a"}))
+	assert.True(t, SameLineage(&ir.State{}, &ir.State{Lineage: "a"}))
+	assert.False(t, SameLineage(&ir.State{Lineage: "a"}, &ir.State{Lineage: "b"}))
+}
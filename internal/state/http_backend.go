@@ -0,0 +1,357 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// httpBackend implements Backend for a REST state service.
+//
+// State is fetched with GET and stored with update_method (POST by default) on
+// address. If lock_address is set, locks are taken with lock_method (LOCK) and
+// released with unlock_method (UNLOCK); both send the lock metadata as JSON. A
+// service reports that the state is already locked with 409 Conflict or 423 Locked,
+// optionally returning the current holder's lock metadata. With a lock endpoint,
+// state is only written under the lock. Without one, concurrent writes are not
+// detected, and the last one wins.
+type httpBackend struct {
+	cfg HTTPBackendConfig
+
+	retryWaitMin time.Duration
+	retryWaitMax time.Duration
+
+	encoding         Encoding
+	encryptSensitive bool // encrypt sensitive values rather than the whole state
+	evaluator        *eval.Evaluator
+	client           *http.Client
+	lock             *LockInfo
+}
+
+func newHTTPBackend(config map[string]string, evaluator *eval.Evaluator) (Backend, error) {
+	cfg := HTTPBackendConfig{
+		Address:       config["address"],
+		UpdateMethod:  config["update_method"],
+		LockAddress:   config["lock_address"],
+		LockMethod:    config["lock_method"],
+		UnlockAddress: config["unlock_address"],
+		UnlockMethod:  config["unlock_method"],
+		Username:      configOrEnv(config, "username", "PICKLR_HTTP_USERNAME"),
+		Password:      configOrEnv(config, "password", "PICKLR_HTTP_PASSWORD"),
+		Token:         configOrEnv(config, "token", "PICKLR_HTTP_TOKEN"),
+		RetryMax:      2,
+		RetryWaitMin:  config["retry_wait_min"],
+		RetryWaitMax:  config["retry_wait_max"],
+	}
+
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("http backend requires 'address' configuration")
+	}
+	for _, addr := range []string{cfg.Address, cfg.LockAddress, cfg.UnlockAddress} {
+		if addr == "" {
+			continue
+		}
+		if u, err := url.Parse(addr); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("http backend address %q must be an http or https URL", addr)
+		}
+	}
+	if cfg.UnlockAddress == "" {
+		cfg.UnlockAddress = cfg.LockAddress
+	}
+	if cfg.UpdateMethod == "" {
+		cfg.UpdateMethod = http.MethodPost
+	}
+	if cfg.LockMethod == "" {
+		cfg.LockMethod = "LOCK"
+	}
+	if cfg.UnlockMethod == "" {
+		cfg.UnlockMethod = "UNLOCK"
+	}
+	if v := config["retry_max"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("http backend retry_max must be a non-negative integer, got %q", v)
+		}
+		cfg.RetryMax = n
+	}
+
+	b := &httpBackend{
+		cfg:              cfg,
+		encoding:         Encoding(config["encoding"]),
+		encryptSensitive: config["encrypt_sensitive"] == "true",
+		evaluator:        evaluator,
+		client:           &http.Client{Timeout: 30 * time.Second},
+	}
+
+	var err error
+	if b.retryWaitMin, err = parseWait(cfg.RetryWaitMin, time.Second); err != nil {
+		return nil, fmt.Errorf("http backend retry_wait_min: %w", err)
+	}
+	if b.retryWaitMax, err = parseWait(cfg.RetryWaitMax, 30*time.Second); err != nil {
+		return nil, fmt.Errorf("http backend retry_wait_max: %w", err)
+	}
+
+	return b, nil
+}
+
+// configOrEnv returns config[key], falling back to an environment variable so that
+// credentials don't have to be written into configuration files.
+func configOrEnv(config map[string]string, key, env string) string {
+	if v := config[key]; v != "" {
+		return v
+	}
+	return os.Getenv(env)
+}
+
+// parseWait parses a duration such as "500ms" or a plain number of seconds.
+func parseWait(v string, def time.Duration) (time.Duration, error) {
+	if v == "" {
+		return def, nil
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
+
+func (b *httpBackend) Read(ctx context.Context) (*ir.State, error) {
+	resp, body, err := b.do(ctx, http.MethodGet, b.cfg.Address, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state from %s: %w", b.cfg.Address, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent:
+		return &ir.State{Version: 1, Serial: 0}, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to read state from %s: %s", b.cfg.Address, describeResponse(resp, body))
+	case len(bytes.TrimSpace(body)) == 0:
+		return &ir.State{Version: 1, Serial: 0}, nil
+	}
+
+	state, err := parseStateContent(ctx, b.evaluator, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote state: %w", err)
+	}
+
+	return state, nil
+}
+
+func (b *httpBackend) Write(ctx context.Context, state *ir.State) error {
+	// The serial check below is only safe from concurrent writes under the lock
+	if b.cfg.LockAddress != "" && b.lock == nil {
+		return fmt.Errorf("refusing to write state to %s without holding its lock", b.cfg.Address)
+	}
+	stored, err := b.storedHeader(ctx)
+	if err != nil {
+		return err
+	}
+	serial, lineage, err := nextVersion(ctx, stored, state)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := encodeForWrite(ctx, versioned(state, serial, lineage), writeEncoding(ctx, b.encoding, stored), b.encryptSensitive)
+	if err != nil {
+		return err
+	}
+
+	// Tell the service which lock the write is made under
+	address := b.cfg.Address
+	if b.lock != nil {
+		u, err := url.Parse(address)
+		if err != nil {
+			return fmt.Errorf("invalid address %s: %w", address, err)
+		}
+		q := u.Query()
+		q.Set("ID", b.lock.ID)
+		u.RawQuery = q.Encode()
+		address = u.String()
+	}
+
+	resp, body, err := b.do(ctx, b.cfg.UpdateMethod, address, encrypted)
+	if err != nil {
+		return fmt.Errorf("failed to write state to %s: %w", b.cfg.Address, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("failed to write state to %s: %s", b.cfg.Address, describeResponse(resp, body))
+	}
+	state.Serial, state.Lineage = serial, lineage
+
+	return nil
+}
+
+// storedHeader fetches the current state and reads its serial and lineage.
+func (b *httpBackend) storedHeader(ctx context.Context) (storedHeader, error) {
+	resp, body, err := b.do(ctx, http.MethodGet, b.cfg.Address, nil)
+	if err != nil {
+		return storedHeader{}, fmt.Errorf("failed to read state from %s: %w", b.cfg.Address, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent:
+		return storedHeader{}, nil
+	case resp.StatusCode != http.StatusOK:
+		return storedHeader{}, fmt.Errorf("failed to read state from %s: %s", b.cfg.Address, describeResponse(resp, body))
+	case len(bytes.TrimSpace(body)) == 0:
+		return storedHeader{}, nil
+	}
+	return headerFromContent(ctx, body)
+}
+
+func (b *httpBackend) Lock(info *LockInfo) error {
+	if b.cfg.LockAddress == "" {
+		return nil // No locking without a lock endpoint
+	}
+
+	if info == nil {
+		info = NewLockInfo("")
+	}
+	info.Path = b.cfg.Address
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode lock info: %w", err)
+	}
+
+	resp, body, err := b.do(context.Background(), b.cfg.LockMethod, b.cfg.LockAddress, payload)
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		b.lock = info
+		return nil
+	case http.StatusConflict, http.StatusLocked:
+		var holder LockInfo
+		if json.Unmarshal(body, &holder) == nil && holder.ID != "" {
+			return &LockError{Info: &holder}
+		}
+		return &LockError{Err: fmt.Errorf("%s", describeResponse(resp, body))}
+	default:
+		return fmt.Errorf("failed to acquire lock: %s", describeResponse(resp, body))
+	}
+}
+
+func (b *httpBackend) Unlock() error {
+	if b.cfg.UnlockAddress == "" || b.lock == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(b.lock)
+	if err != nil {
+		return fmt.Errorf("failed to encode lock info: %w", err)
+	}
+
+	resp, body, err := b.do(context.Background(), b.cfg.UnlockMethod, b.cfg.UnlockAddress, payload)
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to release lock: %s", describeResponse(resp, body))
+	}
+
+	b.lock = nil
+	return nil
+}
+
+func (b *httpBackend) ForceUnlock(id string) error {
+	if b.cfg.UnlockAddress == "" {
+		return fmt.Errorf("http backend has no lock_address configured")
+	}
+
+	payload, err := json.Marshal(&LockInfo{ID: id})
+	if err != nil {
+		return fmt.Errorf("failed to encode lock info: %w", err)
+	}
+
+	resp, body, err := b.do(context.Background(), b.cfg.UnlockMethod, b.cfg.UnlockAddress, payload)
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to release lock %s: %s", id, describeResponse(resp, body))
+	}
+	return nil
+}
+
+// do sends a request, retrying connection errors, 429 and 5xx responses with
+// exponential backoff. The response body is read and returned in full.
+func (b *httpBackend) do(ctx context.Context, method, address string, payload []byte) (*http.Response, []byte, error) {
+	wait := b.retryWaitMin
+	for attempt := 0; ; attempt++ {
+		resp, body, err := b.send(ctx, method, address, payload)
+		retryable := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !retryable || attempt >= b.cfg.RetryMax {
+			return resp, body, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > b.retryWaitMax {
+			wait = b.retryWaitMax
+		}
+	}
+}
+
+func (b *httpBackend) send(ctx context.Context, method, address string, payload []byte) (*http.Response, []byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, address, reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	if payload != nil {
+		if method == b.cfg.LockMethod || method == b.cfg.UnlockMethod {
+			req.Header.Set("Content-Type", "application/json")
+		} else {
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+	}
+
+	if b.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.cfg.Token)
+	} else if b.cfg.Username != "" {
+		req.SetBasicAuth(b.cfg.Username, b.cfg.Password)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return resp, body, nil
+}
+
+// describeResponse formats an unexpected response for error messages.
+func describeResponse(resp *http.Response, body []byte) string {
+	msg := bytes.TrimSpace(body)
+	if len(msg) > 200 {
+		msg = append(msg[:200:200], []byte("...")...)
+	}
+	if len(msg) == 0 {
+		return resp.Status
+	}
+	return fmt.Sprintf("%s: %s", resp.Status, msg)
+}
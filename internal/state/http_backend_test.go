@@ -0,0 +1,246 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStateServer is a minimal stand-in for a REST state service.
+type fakeStateServer struct {
+	mu       sync.Mutex
+	state    []byte
+	lock     *LockInfo
+	requests []*http.Request
+	failNext int // respond 503 to this many requests before serving normally
+}
+
+func (f *fakeStateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r)
+
+	if f.failNext > 0 {
+		f.failNext--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if f.state == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(f.state)
+	case http.MethodPost, http.MethodPut:
+		if f.lock != nil && r.URL.Query().Get("ID") != f.lock.ID {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.state = body
+	case "LOCK":
+		if f.lock != nil {
+			w.WriteHeader(http.StatusLocked)
+			_ = json.NewEncoder(w).Encode(f.lock)
+			return
+		}
+		var info LockInfo
+		_ = json.Unmarshal(body, &info)
+		f.lock = &info
+	case "UNLOCK":
+		f.lock = nil
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newTestHTTPBackend(t *testing.T, srv *httptest.Server, extra map[string]string) *httpBackend {
+	t.Helper()
+	config := map[string]string{
+		"address":        srv.URL + "/state",
+		"lock_address":   srv.URL + "/state/lock",
+		"retry_wait_min": "1ms",
+		"retry_wait_max": "5ms",
+	}
+	for k, v := range extra {
+		config[k] = v
+	}
+	b, err := newHTTPBackend(config, nil)
+	require.NoError(t, err)
+	return b.(*httpBackend)
+}
+
+func TestHTTPBackend_ReadMissingState(t *testing.T) {
+	fake := &fakeStateServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := newTestHTTPBackend(t, srv, nil)
+	s, err := b.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, s.Version)
+	assert.Equal(t, 0, s.Serial)
+}
+
+func TestHTTPBackend_WriteUnderLock(t *testing.T) {
+	t.Setenv(EncryptionKeyEnvVar, "")
+	fake := &fakeStateServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := newTestHTTPBackend(t, srv, nil)
+	require.NoError(t, b.Lock(NewLockInfo("apply")))
+	require.NotNil(t, fake.lock)
+	assert.Equal(t, b.lock.ID, fake.lock.ID)
+	assert.NotEmpty(t, fake.lock.Who)
+	assert.NotEmpty(t, fake.lock.Created)
+
+	require.NoError(t, b.Write(context.Background(), &ir.State{Version: 1, Serial: 1, Lineage: "abc"}))
+	assert.Contains(t, string(fake.state), `lineage = "abc"`)
+
+	require.NoError(t, b.Unlock())
+	assert.Nil(t, fake.lock)
+}
+
+func TestHTTPBackend_WriteRequiresLock(t *testing.T) {
+	fake := &fakeStateServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := newTestHTTPBackend(t, srv, nil)
+	err := b.Write(context.Background(), &ir.State{Version: 1})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "without holding its lock")
+	assert.Empty(t, fake.requests)
+	assert.Nil(t, fake.state)
+}
+
+func TestHTTPBackend_LockConflictReportsHolder(t *testing.T) {
+	fake := &fakeStateServer{lock: &LockInfo{ID: "held-1", Who: "alice@ci", Operation: "apply"}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := newTestHTTPBackend(t, srv, nil)
+	err := b.Lock(NewLockInfo("apply"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "locked by another process")
+	assert.Contains(t, err.Error(), "held-1")
+	assert.Contains(t, err.Error(), "alice@ci")
+
+	// Unlock without holding the lock must not release someone else's lock
+	require.NoError(t, b.Unlock())
+	assert.NotNil(t, fake.lock)
+}
+
+func TestHTTPBackend_NoLockAddress(t *testing.T) {
+	fake := &fakeStateServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b, err := newHTTPBackend(map[string]string{"address": srv.URL}, nil)
+	require.NoError(t, err)
+	require.NoError(t, b.Lock(NewLockInfo("apply")))
+	require.NoError(t, b.Unlock())
+	assert.Empty(t, fake.requests)
+}
+
+func TestHTTPBackend_Retries(t *testing.T) {
+	fake := &fakeStateServer{failNext: 2}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := newTestHTTPBackend(t, srv, nil)
+	_, err := b.Read(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, fake.requests, 3)
+
+	fake.failNext = 5
+	fake.requests = nil
+	b = newTestHTTPBackend(t, srv, map[string]string{"retry_max": "1"})
+	_, err = b.Read(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "503")
+	assert.Len(t, fake.requests, 2)
+}
+
+func TestHTTPBackend_Auth(t *testing.T) {
+	fake := &fakeStateServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := newTestHTTPBackend(t, srv, map[string]string{"username": "picklr", "password": "s3cret"})
+	_, err := b.Read(context.Background())
+	require.NoError(t, err)
+	user, pass, ok := fake.requests[0].BasicAuth()
+	require.True(t, ok)
+	assert.Equal(t, "picklr", user)
+	assert.Equal(t, "s3cret", pass)
+
+	fake.requests = nil
+	b = newTestHTTPBackend(t, srv, map[string]string{"token": "tok-123", "username": "ignored"})
+	_, err = b.Read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer tok-123", fake.requests[0].Header.Get("Authorization"))
+}
+
+func TestHTTPBackend_CustomMethods(t *testing.T) {
+	t.Setenv(EncryptionKeyEnvVar, "")
+	fake := &fakeStateServer{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := newTestHTTPBackend(t, srv, map[string]string{
+		"update_method": "PUT",
+		"lock_address":  "",
+	})
+	require.NoError(t, b.Write(context.Background(), &ir.State{Version: 1}))
+	// The stored serial is fetched before writing
+	assert.Equal(t, http.MethodGet, fake.requests[0].Method)
+	assert.Equal(t, http.MethodPut, fake.requests[1].Method)
+
+	b = newTestHTTPBackend(t, srv, map[string]string{"lock_method": "PATCH"})
+	err := b.Lock(NewLockInfo("apply"))
+	require.Error(t, err)
+	assert.Equal(t, "PATCH", fake.requests[2].Method)
+	assert.Contains(t, err.Error(), "405")
+}
+
+func TestNewHTTPBackendValidation(t *testing.T) {
+	_, err := newHTTPBackend(map[string]string{"address": "ftp://example.com/state"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "http or https")
+
+	_, err = newHTTPBackend(map[string]string{"address": "https://example.com/state", "retry_max": "-1"}, nil)
+	require.Error(t, err)
+
+	b, err := newHTTPBackend(map[string]string{"address": "https://example.com/state", "lock_address": "https://example.com/lock"}, nil)
+	require.NoError(t, err)
+	hb := b.(*httpBackend)
+	assert.Equal(t, "POST", hb.cfg.UpdateMethod)
+	assert.Equal(t, "LOCK", hb.cfg.LockMethod)
+	assert.Equal(t, "UNLOCK", hb.cfg.UnlockMethod)
+	assert.Equal(t, "https://example.com/lock", hb.cfg.UnlockAddress)
+	assert.Equal(t, 2, hb.cfg.RetryMax)
+}
+
+func TestHTTPBackend_ForceUnlock(t *testing.T) {
+	fake := &fakeStateServer{lock: &LockInfo{ID: "stuck-1"}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := newTestHTTPBackend(t, srv, nil)
+	require.NoError(t, b.ForceUnlock("stuck-1"))
+	assert.Nil(t, fake.lock)
+	assert.Equal(t, "UNLOCK", fake.requests[0].Method)
+}
@@ -0,0 +1,135 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// SerializeStateJSON converts a State to indented JSON. Like SerializeState, the
+// output is deterministic, and floats are always written with a fraction or
+// exponent so that they read back as floats.
+func SerializeStateJSON(state *ir.State) ([]byte, error) {
+	out := *state
+	out.Outputs = jsonMap(state.Outputs)
+	out.Resources = make([]*ir.ResourceState, 0, len(state.Resources))
+	for _, res := range sortedResources(state.Resources) {
+		r := *res
+		r.Inputs = jsonMap(res.Inputs)
+		r.Outputs = jsonMap(res.Outputs)
+		out.Resources = append(out.Resources, &r)
+	}
+
+	data, err := json.MarshalIndent(&out, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode state as JSON: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// ParseStateJSON decodes JSON state. Numbers without a fraction or exponent decode
+// as int and all others as float64, so that values keep their types when the state
+// is written back as PKL.
+func ParseStateJSON(data []byte) (*ir.State, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var state ir.State
+	if err := dec.Decode(&state); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON state: %w", err)
+	}
+
+	state.Outputs = normalizeJSONMap(state.Outputs)
+	for _, res := range state.Resources {
+		res.Inputs = normalizeJSONMap(res.Inputs)
+		res.Outputs = normalizeJSONMap(res.Outputs)
+	}
+	return &state, nil
+}
+
+func normalizeJSONMap(m map[string]any) map[string]any {
+	for k, v := range m {
+		m[k] = normalizeJSONValue(v)
+	}
+	return m
+}
+
+// normalizeJSONValue replaces json.Number with int or float64.
+func normalizeJSONValue(v any) any {
+	switch val := v.(type) {
+	case json.Number:
+		if !strings.ContainsAny(string(val), ".eE") {
+			if n, err := val.Int64(); err == nil {
+				return int(n)
+			}
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]any:
+		return normalizeJSONMap(val)
+	case []any:
+		for i := range val {
+			val[i] = normalizeJSONValue(val[i])
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// jsonFloat marshals a float so that it is distinguishable from an int.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("%v cannot be represented in JSON state", v)
+	}
+	s := strconv.FormatFloat(v, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return []byte(s), nil
+}
+
+func jsonMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = jsonValue(v)
+	}
+	return out
+}
+
+// jsonValue copies v, marking floats and turning map[any]any into map[string]any.
+func jsonValue(v any) any {
+	switch val := v.(type) {
+	case float64:
+		return jsonFloat(val)
+	case float32:
+		return jsonFloat(val)
+	case map[string]any:
+		return jsonMap(val)
+	case map[any]any:
+		out := make(map[string]any, len(val))
+		for k, v := range val {
+			out[fmt.Sprintf("%v", k)] = jsonValue(v)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, v := range val {
+			out[i] = jsonValue(v)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,34 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateJSONRoundTrip(t *testing.T) {
+	s := &ir.State{
+		Version: 1,
+		Serial:  3,
+		Lineage: "abc",
+		Outputs: map[string]any{"count": 2},
+		Resources: []*ir.ResourceState{{
+			Type:       "null_resource",
+			Name:       "a",
+			Provider:   "null",
+			Inputs:     map[string]any{"port": 8080, "ratio": 0.5, "tags": []any{"x", 1}, "nested": map[string]any{"on": true}},
+			InputsHash: "h",
+			Outputs:    map[string]any{"id": "null-a"},
+		}},
+	}
+
+	data, err := SerializeStateJSON(s)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"inputs_hash": "h"`)
+
+	decoded, err := ParseStateJSON(data)
+	require.NoError(t, err)
+	assert.Equal(t, s, decoded)
+}
@@ -0,0 +1,379 @@
+package state
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// KeyProvider supplies the data keys that state is encrypted with. Each write
+// encrypts the state with a fresh data key, which the provider wraps (encrypts)
+// with its own key; the wrapped data key is stored in the envelope header.
+type KeyProvider interface {
+	// Name identifies the kind of provider: passphrase, key or kms.
+	Name() string
+	// KeyID identifies the provider's key. It is recorded in the envelope header so
+	// that decryption errors can name the key that is needed.
+	KeyID() string
+	// NewDataKey returns a new data key, its wrapped form and any parameters
+	// needed to unwrap it.
+	NewDataKey(ctx context.Context) (key, wrapped []byte, params map[string]string, err error)
+	// UnwrapKey recovers a data key from its wrapped form.
+	UnwrapKey(ctx context.Context, wrapped []byte, params map[string]string) ([]byte, error)
+}
+
+// Key provider settings. Each is read with the PICKLR_STATE_ prefix for the
+// current key and with the PICKLR_STATE_PREVIOUS_ prefix for a key that is only
+// used to decrypt, while rotating keys with 'picklr state rekey'.
+const (
+	// EncryptionKeyEnvVar is a base64-encoded 32-byte key. For compatibility, a
+	// value that is not one is used as a passphrase.
+	EncryptionKeyEnvVar = "PICKLR_STATE_ENCRYPTION_KEY"
+	// EncryptionPassphraseEnvVar is a passphrase the key is derived from with PBKDF2.
+	EncryptionPassphraseEnvVar = "PICKLR_STATE_ENCRYPTION_PASSPHRASE"
+	// EncryptionKeyFileEnvVar is the path of a file holding a 32-byte key, raw or base64.
+	EncryptionKeyFileEnvVar = "PICKLR_STATE_ENCRYPTION_KEY_FILE"
+	// KMSKeyIDEnvVar is the ID, ARN or alias of an AWS KMS key that generates data keys.
+	KMSKeyIDEnvVar = "PICKLR_STATE_KMS_KEY_ID"
+	// KMSEndpointEnvVar overrides the AWS KMS endpoint, e.g. for a local stand-in.
+	KMSEndpointEnvVar = "PICKLR_STATE_KMS_ENDPOINT"
+
+	previousKeyPrefix = "PICKLR_STATE_PREVIOUS_"
+)
+
+// previousEnv returns the PICKLR_STATE_PREVIOUS_ form of a key provider setting.
+func previousEnv(name string) string {
+	return previousKeyPrefix + strings.TrimPrefix(name, "PICKLR_STATE_")
+}
+
+// CurrentKeyProvider returns the key provider that new state is encrypted with,
+// or nil if encryption is not configured.
+func CurrentKeyProvider() (KeyProvider, error) {
+	return keyProviderFromEnv(os.Getenv)
+}
+
+// decryptionKeyProviders returns the current and previous key providers.
+func decryptionKeyProviders() ([]KeyProvider, error) {
+	var providers []KeyProvider
+	current, err := CurrentKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		providers = append(providers, current)
+	}
+	previous, err := keyProviderFromEnv(func(name string) string { return os.Getenv(previousEnv(name)) })
+	if err != nil {
+		return nil, fmt.Errorf("previous key: %w", err)
+	}
+	if previous != nil {
+		providers = append(providers, previous)
+	}
+	return providers, nil
+}
+
+// keyProviderFromEnv builds the key provider configured by getenv. At most one
+// provider may be configured.
+func keyProviderFromEnv(getenv func(string) string) (KeyProvider, error) {
+	var configured []string
+	var provider KeyProvider
+
+	if v := getenv(EncryptionKeyEnvVar); v != "" {
+		configured = append(configured, EncryptionKeyEnvVar)
+		// Only a base64 key is taken as is; anything else, even 32 characters
+		// long, is a passphrase
+		if key, err := decodeBase64Key(v); err == nil {
+			provider = newRawKeyProvider(key)
+		} else {
+			provider = &passphraseProvider{passphrase: v}
+		}
+	}
+	if v := getenv(EncryptionPassphraseEnvVar); v != "" {
+		configured = append(configured, EncryptionPassphraseEnvVar)
+		provider = &passphraseProvider{passphrase: v}
+	}
+	if v := getenv(EncryptionKeyFileEnvVar); v != "" {
+		configured = append(configured, EncryptionKeyFileEnvVar)
+		data, err := os.ReadFile(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		key, err := decodeKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s: %w", v, err)
+		}
+		provider = newRawKeyProvider(key)
+	}
+	if v := getenv(KMSKeyIDEnvVar); v != "" {
+		configured = append(configured, KMSKeyIDEnvVar)
+		provider = &kmsProvider{keyID: v, endpoint: getenv(KMSEndpointEnvVar)}
+	}
+
+	if len(configured) > 1 {
+		return nil, fmt.Errorf("only one state encryption key may be configured, found %s", strings.Join(configured, ", "))
+	}
+	return provider, nil
+}
+
+// decodeKey reads a 32-byte key file, given raw or base64-encoded.
+func decodeKey(data []byte) ([]byte, error) {
+	if len(data) == 32 {
+		return data, nil
+	}
+	if key, err := decodeBase64Key(string(data)); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("expected a 32-byte key, raw or base64-encoded")
+}
+
+// decodeBase64Key reads a base64-encoded 32-byte key.
+func decodeBase64Key(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if key, err := enc.DecodeString(text); err == nil && len(key) == 32 {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("expected a base64-encoded 32-byte key")
+}
+
+// rawKeyProvider wraps data keys with a fixed 32-byte key. Its key ID is a
+// fingerprint of the key.
+type rawKeyProvider struct {
+	key []byte
+	id  string
+}
+
+func newRawKeyProvider(key []byte) *rawKeyProvider {
+	sum := sha256.Sum256(key)
+	return &rawKeyProvider{key: key, id: "sha256:" + hex.EncodeToString(sum[:8])}
+}
+
+func (p *rawKeyProvider) Name() string  { return "key" }
+func (p *rawKeyProvider) KeyID() string { return p.id }
+
+func (p *rawKeyProvider) NewDataKey(ctx context.Context) ([]byte, []byte, map[string]string, error) {
+	key, wrapped, err := newWrappedKey(p.key)
+	return key, wrapped, nil, err
+}
+
+func (p *rawKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte, params map[string]string) ([]byte, error) {
+	return openAESGCM(p.key, wrapped, nil)
+}
+
+// pbkdf2Iterations is the PBKDF2-HMAC-SHA256 work factor for new passphrase
+// envelopes. The count is stored in the envelope, so it can be raised later.
+var pbkdf2Iterations = 600000
+
+// passphraseProvider wraps data keys with a key derived from a passphrase. The
+// salt and iteration count are stored in the envelope.
+type passphraseProvider struct {
+	passphrase string
+}
+
+// derivedKeys caches keys derived in this process, keyed by passphrase, salt and
+// iteration count, so that PBKDF2 runs once per key rather than once per read.
+var derivedKeys sync.Map
+
+// passphraseSalts holds the salt each passphrase was first used with in this
+// process, so that repeated writes reuse the derived key.
+var passphraseSalts sync.Map
+
+func (p *passphraseProvider) Name() string  { return "passphrase" }
+func (p *passphraseProvider) KeyID() string { return "passphrase" }
+
+func (p *passphraseProvider) NewDataKey(ctx context.Context) ([]byte, []byte, map[string]string, error) {
+	fresh := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, fresh); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	saltValue, _ := passphraseSalts.LoadOrStore(cacheKey(p.passphrase, strconv.Itoa(pbkdf2Iterations)), fresh)
+	salt := saltValue.([]byte)
+
+	kek, err := p.derive(salt, pbkdf2Iterations)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, wrapped, err := newWrappedKey(kek)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	params := map[string]string{
+		"kdf":        "pbkdf2-sha256",
+		"salt":       base64.StdEncoding.EncodeToString(salt),
+		"iterations": strconv.Itoa(pbkdf2Iterations),
+	}
+	return key, wrapped, params, nil
+}
+
+func (p *passphraseProvider) UnwrapKey(ctx context.Context, wrapped []byte, params map[string]string) ([]byte, error) {
+	if kdf := params["kdf"]; kdf != "pbkdf2-sha256" {
+		return nil, fmt.Errorf("unsupported key derivation %q", kdf)
+	}
+	salt, err := base64.StdEncoding.DecodeString(params["salt"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %w", err)
+	}
+	iterations, err := strconv.Atoi(params["iterations"])
+	if err != nil || iterations <= 0 {
+		return nil, fmt.Errorf("invalid iteration count %q", params["iterations"])
+	}
+	kek, err := p.derive(salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	return openAESGCM(kek, wrapped, nil)
+}
+
+func (p *passphraseProvider) derive(salt []byte, iterations int) ([]byte, error) {
+	id := cacheKey(p.passphrase, string(salt), strconv.Itoa(iterations))
+	if key, ok := derivedKeys.Load(id); ok {
+		return key.([]byte), nil
+	}
+	key, err := pbkdf2.Key(sha256.New, p.passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	derivedKeys.Store(id, key)
+	return key, nil
+}
+
+// cacheKey hashes its parts, so that caches don't hold passphrases in the clear.
+func cacheKey(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// kmsProvider generates data keys with AWS KMS. Credentials and region come from
+// the standard AWS environment.
+type kmsProvider struct {
+	keyID    string
+	endpoint string
+
+	once   sync.Once
+	client *kms.Client
+	err    error
+}
+
+// kmsEncryptionContext binds data keys to their use; KMS refuses to decrypt them
+// without it.
+var kmsEncryptionContext = map[string]string{"picklr": "state"}
+
+func (p *kmsProvider) Name() string  { return "kms" }
+func (p *kmsProvider) KeyID() string { return p.keyID }
+
+func (p *kmsProvider) kmsClient(ctx context.Context) (*kms.Client, error) {
+	p.once.Do(func() {
+		cfg, err := awsconfig.LoadDefaultConfig(ctx)
+		if err != nil {
+			p.err = fmt.Errorf("unable to load AWS config: %w", err)
+			return
+		}
+		p.client = kms.NewFromConfig(cfg, func(o *kms.Options) {
+			if p.endpoint != "" {
+				o.BaseEndpoint = aws.String(p.endpoint)
+			}
+		})
+	})
+	return p.client, p.err
+}
+
+func (p *kmsProvider) NewDataKey(ctx context.Context) ([]byte, []byte, map[string]string, error) {
+	client, err := p.kmsClient(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	out, err := client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(p.keyID),
+		NumberOfBytes:     aws.Int32(32),
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate data key with KMS key %s: %w", p.keyID, err)
+	}
+	return out.Plaintext, out.CiphertextBlob, nil, nil
+}
+
+func (p *kmsProvider) UnwrapKey(ctx context.Context, wrapped []byte, params map[string]string) ([]byte, error) {
+	client, err := p.kmsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob:    wrapped,
+		EncryptionContext: kmsEncryptionContext,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key with KMS: %w", err)
+	}
+	return out.Plaintext, nil
+}
+
+// newWrappedKey generates a data key and wraps it with kek.
+func newWrappedKey(kek []byte) ([]byte, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := sealAESGCM(kek, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, wrapped, nil
+}
+
+// sealAESGCM encrypts plaintext with AES-256-GCM, returning nonce||ciphertext.
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM decrypts nonce||ciphertext produced by sealAESGCM.
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
@@ -1,48 +1,138 @@
 package state
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
 )
 
-// Lock acquires a file lock on the state to prevent concurrent modifications.
-func (m *Manager) Lock() error {
+// Lock acquires an exclusive OS advisory lock on the state's .lock file and
+// records info in it. The lock is released automatically if the process exits,
+// so a crashed run never leaves a stale lock behind.
+func (m *Manager) Lock(info *LockInfo) error {
+	if m.lockFile != nil {
+		return fmt.Errorf("state is already locked by this process")
+	}
+	if info == nil {
+		info = NewLockInfo("")
+	}
+	info.Path = m.path
+
 	lockPath := m.lockPath()
 	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
 		return fmt.Errorf("failed to create lock directory: %w", err)
 	}
 
-	// Check if lock already exists
-	if info, err := os.Stat(lockPath); err == nil {
-		// If lock is older than 10 minutes, consider it stale
-		if time.Since(info.ModTime()) > 10*time.Minute {
-			os.Remove(lockPath)
-		} else {
-			return fmt.Errorf("state is locked by another process (lock file: %s). "+
-				"If this is an error, remove the lock file manually", lockPath)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open lock file: %w", err)
 		}
-	}
 
-	// Create lock file with current PID and timestamp
-	content := fmt.Sprintf("pid=%d\ntime=%s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339))
-	if err := os.WriteFile(lockPath, []byte(content), 0644); err != nil {
-		return fmt.Errorf("failed to create lock file: %w", err)
-	}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			if err == errWouldBlock {
+				return &LockError{Info: readLockInfo(lockPath)}
+			}
+			return fmt.Errorf("failed to lock %s: %w", lockPath, err)
+		}
 
-	return nil
+		// The file may have been removed by Unlock or force-unlock between open
+		// and lock; in that case the lock is on an orphaned file, so try again.
+		if !sameFile(f, lockPath) {
+			unlockFile(f)
+			f.Close()
+			continue
+		}
+
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err == nil {
+			err = f.Truncate(0)
+		}
+		if err == nil {
+			_, err = f.WriteAt(append(data, '\n'), 0)
+		}
+		if err != nil {
+			unlockFile(f)
+			f.Close()
+			return fmt.Errorf("failed to write lock info: %w", err)
+		}
+
+		m.lockFile = f
+		m.lockInfo = info
+		return nil
+	}
 }
 
 // Unlock releases the state lock.
 func (m *Manager) Unlock() error {
-	lockPath := m.lockPath()
-	if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+	if m.lockFile == nil {
+		return nil
+	}
+	f := m.lockFile
+	m.lockFile = nil
+	m.lockInfo = nil
+
+	// Remove the file while still holding the lock so that waiters re-open it
+	if sameFile(f, m.lockPath()) {
+		if err := os.Remove(m.lockPath()); err != nil && !os.IsNotExist(err) {
+			unlockFile(f)
+			f.Close()
+			return fmt.Errorf("failed to remove lock file: %w", err)
+		}
+	}
+	if err := unlockFile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return f.Close()
+}
+
+// ForceUnlock removes the lock file if it holds the lock with the given ID. The
+// holder keeps its OS lock on the removed file but no longer blocks anyone.
+func (m *Manager) ForceUnlock(id string) error {
+	info := readLockInfo(m.lockPath())
+	if info == nil {
+		return fmt.Errorf("state is not locked")
+	}
+	if info.ID != id {
+		return fmt.Errorf("lock ID %q does not match the current lock %q", id, info.ID)
+	}
+	if err := os.Remove(m.lockPath()); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove lock file: %w", err)
 	}
+	// Marker used where flock is unavailable
+	_ = os.Remove(m.lockPath() + ".held")
 	return nil
 }
 
 func (m *Manager) lockPath() string {
 	return m.path + ".lock"
 }
+
+// readLockInfo returns the metadata recorded in a lock file, or nil if there is none.
+func readLockInfo(path string) *LockInfo {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var info LockInfo
+	if json.Unmarshal(data, &info) != nil || info.ID == "" {
+		return nil
+	}
+	return &info
+}
+
+// sameFile reports whether the open file f is still the file at path.
+func sameFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, pi)
+}
@@ -0,0 +1,30 @@
+//go:build !unix
+
+package state
+
+import (
+	"errors"
+	"os"
+)
+
+var errWouldBlock = errors.New("lock is held")
+
+// lockFile emulates an exclusive lock with a marker file created exclusively next
+// to f. Unlike flock, the marker survives a crash and may need force-unlock.
+func lockFile(f *os.File) error {
+	marker, err := os.OpenFile(f.Name()+".held", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return errWouldBlock
+		}
+		return err
+	}
+	return marker.Close()
+}
+
+func unlockFile(f *os.File) error {
+	if err := os.Remove(f.Name() + ".held"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
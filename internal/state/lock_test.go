@@ -0,0 +1,120 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_LockRecordsMetadata(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.pkl")
+	mgr := NewManager(statePath, nil)
+
+	info := NewLockInfo("apply")
+	require.NoError(t, mgr.Lock(info))
+	defer mgr.Unlock()
+
+	recorded := readLockInfo(statePath + ".lock")
+	require.NotNil(t, recorded)
+	assert.Equal(t, info.ID, recorded.ID)
+	assert.Equal(t, "apply", recorded.Operation)
+	assert.Equal(t, statePath, recorded.Path)
+	assert.NotEmpty(t, recorded.Who)
+	assert.NotEmpty(t, recorded.Version)
+	assert.NotEmpty(t, recorded.Created)
+
+	err := NewManager(statePath, nil).Lock(NewLockInfo("plan"))
+	var lockErr *LockError
+	require.True(t, errors.As(err, &lockErr))
+	require.NotNil(t, lockErr.Info)
+	assert.Equal(t, info.ID, lockErr.Info.ID)
+	assert.Contains(t, err.Error(), "picklr force-unlock "+info.ID)
+}
+
+func TestManager_UnlockRemovesLockFile(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.pkl")
+	mgr := NewManager(statePath, nil)
+
+	require.NoError(t, mgr.Lock(nil))
+	require.NoError(t, mgr.Unlock())
+	_, err := os.Stat(statePath + ".lock")
+	assert.True(t, os.IsNotExist(err))
+
+	// Unlocking twice is harmless
+	require.NoError(t, mgr.Unlock())
+}
+
+func TestManager_OldLockIsNotStale(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.pkl")
+	mgr := NewManager(statePath, nil)
+	require.NoError(t, mgr.Lock(nil))
+	defer mgr.Unlock()
+
+	// A long-running holder keeps the lock no matter how old the file is
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(statePath+".lock", old, old))
+	assert.Error(t, NewManager(statePath, nil).Lock(nil))
+}
+
+func TestManager_ForceUnlock(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.pkl")
+	holder := NewManager(statePath, nil)
+	info := NewLockInfo("apply")
+	require.NoError(t, holder.Lock(info))
+	defer holder.Unlock()
+
+	other := NewManager(statePath, nil)
+	err := other.ForceUnlock("wrong-id")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not match")
+
+	require.NoError(t, other.ForceUnlock(info.ID))
+	require.NoError(t, other.Lock(nil))
+	require.NoError(t, other.Unlock())
+
+	assert.Error(t, other.ForceUnlock(info.ID), "nothing left to unlock")
+}
+
+func TestAcquireLock_WaitsForRelease(t *testing.T) {
+	lockRetryInterval = 10 * time.Millisecond
+	defer func() { lockRetryInterval = time.Second }()
+
+	statePath := filepath.Join(t.TempDir(), "state.pkl")
+	holder := NewManager(statePath, nil)
+	require.NoError(t, holder.Lock(nil))
+
+	waiter := NewManager(statePath, nil)
+	ctx := context.Background()
+
+	// Without a timeout the lock fails immediately
+	assert.Error(t, AcquireLock(ctx, waiter, nil, 0))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		holder.Unlock()
+	}()
+	require.NoError(t, AcquireLock(ctx, waiter, NewLockInfo("apply"), 5*time.Second))
+	require.NoError(t, waiter.Unlock())
+}
+
+func TestAcquireLock_TimesOut(t *testing.T) {
+	lockRetryInterval = 10 * time.Millisecond
+	defer func() { lockRetryInterval = time.Second }()
+
+	statePath := filepath.Join(t.TempDir(), "state.pkl")
+	holder := NewManager(statePath, nil)
+	require.NoError(t, holder.Lock(nil))
+	defer holder.Unlock()
+
+	start := time.Now()
+	err := AcquireLock(context.Background(), NewManager(statePath, nil), nil, 50*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "locked")
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
@@ -0,0 +1,100 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// ClientVersion is the picklr version recorded in lock metadata.
+// The CLI sets it from its build version.
+var ClientVersion = "dev"
+
+// LockInfo describes who holds a state lock.
+type LockInfo struct {
+	ID        string `json:"ID"`
+	Operation string `json:"Operation"`
+	Who       string `json:"Who"`
+	Version   string `json:"Version"`
+	Created   string `json:"Created"`
+	Path      string `json:"Path"`
+}
+
+// NewLockInfo returns lock metadata for the current process performing operation.
+// Backends fill in Path when the lock is taken.
+func NewLockInfo(operation string) *LockInfo {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+
+	who := "unknown"
+	if u, err := user.Current(); err == nil {
+		who = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		who = fmt.Sprintf("%s@%s", who, host)
+	}
+
+	return &LockInfo{
+		ID:        hex.EncodeToString(id),
+		Operation: operation,
+		Who:       who,
+		Version:   ClientVersion,
+		Created:   time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// String formats the lock metadata for error messages.
+func (l *LockInfo) String() string {
+	return fmt.Sprintf("ID: %s\n  Path: %s\n  Operation: %s\n  Who: %s\n  Version: %s\n  Created: %s",
+		l.ID, l.Path, l.Operation, l.Who, l.Version, l.Created)
+}
+
+// LockError is returned by Backend.Lock when another process holds the lock.
+// Info describes the holder if the backend could determine it.
+type LockError struct {
+	Info *LockInfo
+	Err  error
+}
+
+func (e *LockError) Error() string {
+	msg := "state is locked by another process"
+	if e.Info != nil && e.Info.ID != "" {
+		msg += ":\n  " + e.Info.String() +
+			"\n\nIf the holder is no longer running, release the lock with: picklr force-unlock " + e.Info.ID
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+// lockRetryInterval is how often AcquireLock retries a held lock.
+var lockRetryInterval = time.Second
+
+// AcquireLock locks the backend, retrying for up to timeout while another process
+// holds the lock. A zero timeout tries exactly once.
+func AcquireLock(ctx context.Context, b Backend, info *LockInfo, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := b.Lock(info)
+		var lockErr *LockError
+		if err == nil || !errors.As(err, &lockErr) || !time.Now().Before(deadline) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up waiting: %v)", err, ctx.Err())
+		case <-time.After(min(lockRetryInterval, time.Until(deadline))):
+		}
+	}
+}
@@ -3,11 +3,12 @@ package state
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
@@ -15,6 +16,7 @@ import (
 	dbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/picklr-io/picklr/internal/eval"
 	"github.com/picklr-io/picklr/internal/ir"
 )
@@ -27,6 +29,9 @@ type s3Backend struct {
 	dynamoDBTable string
 	encrypt       bool
 	profile       string
+	encoding      Encoding
+
+	encryptSensitive bool // encrypt sensitive values rather than the whole state
 
 	evaluator *eval.Evaluator
 	s3Client  *s3.Client
@@ -57,8 +62,10 @@ func newS3Backend(config map[string]string, evaluator *eval.Evaluator) (Backend,
 		dynamoDBTable: config["dynamodb_table"],
 		encrypt:       config["encrypt"] == "true",
 		profile:       config["profile"],
+		encoding:      Encoding(config["encoding"]),
 		evaluator:     evaluator,
 	}
+	b.encryptSensitive = config["encrypt_sensitive"] == "true"
 
 	if err := b.initClients(); err != nil {
 		return nil, fmt.Errorf("failed to initialize S3 backend: %w", err)
@@ -113,31 +120,7 @@ func (b *s3Backend) Read(ctx context.Context) (*ir.State, error) {
 	if _, err := buf.ReadFrom(result.Body); err != nil {
 		return nil, fmt.Errorf("failed to read S3 object body: %w", err)
 	}
-	content := buf.Bytes()
-
-	// Handle encryption
-	if IsEncrypted(content) {
-		decrypted, err := DecryptState(content)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt remote state: %w", err)
-		}
-		content = decrypted
-	}
-
-	// Write to a temporary file so the PKL evaluator can parse it
-	tmpFile, err := os.CreateTemp("", "picklr-state-*.pkl")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file: %w", err)
-	}
-	defer os.Remove(tmpFile.Name())
-
-	if _, err := tmpFile.Write(content); err != nil {
-		tmpFile.Close()
-		return nil, fmt.Errorf("failed to write temp state file: %w", err)
-	}
-	tmpFile.Close()
-
-	state, err := b.evaluator.LoadState(ctx, tmpFile.Name())
+	state, err := parseStateContent(ctx, b.evaluator, buf.Bytes())
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse remote state: %w", err)
 	}
@@ -145,73 +128,300 @@ func (b *s3Backend) Read(ctx context.Context) (*ir.State, error) {
 	return state, nil
 }
 
+// Write puts the state only if the object is still the one whose header was
+// read, so that a concurrent write is never lost, even without a lock table.
 func (b *s3Backend) Write(ctx context.Context, state *ir.State) error {
-	content := SerializeState(state)
+	stored, etag, err := b.storedHeader(ctx)
+	if err != nil {
+		return err
+	}
+	serial, lineage, err := nextVersion(ctx, stored, state)
+	if err != nil {
+		return err
+	}
 
-	// Encrypt if configured
-	data := []byte(content)
-	encrypted, err := EncryptState(data)
+	encoding := writeEncoding(ctx, b.encoding, stored)
+	encrypted, err := encodeForWrite(ctx, versioned(state, serial, lineage), encoding, b.encryptSensitive)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt state: %w", err)
+		return err
 	}
 
 	input := &s3.PutObjectInput{
 		Bucket: aws.String(b.bucket),
 		Key:    aws.String(b.key),
 		Body:   bytes.NewReader(encrypted),
+		// Recorded so that versions can be listed and checked without downloading them
+		Metadata: map[string]string{
+			s3SerialMetadata:   strconv.Itoa(serial),
+			s3LineageMetadata:  lineage,
+			s3EncodingMetadata: string(encoding),
+		},
 	}
 	if b.encrypt {
 		input.ServerSideEncryption = s3types.ServerSideEncryptionAes256
 	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
 
 	if _, err := b.s3Client.PutObject(ctx, input); err != nil {
+		var ae smithy.APIError
+		if errors.As(err, &ae) && (ae.ErrorCode() == "PreconditionFailed" || ae.ErrorCode() == "ConditionalRequestConflict") {
+			return fmt.Errorf("state at s3://%s/%s was changed by another process during this run; configure dynamodb_table for locking and re-run", b.bucket, b.key)
+		}
 		return fmt.Errorf("failed to write state to s3://%s/%s: %w", b.bucket, b.key, err)
 	}
+	state.Serial, state.Lineage = serial, lineage
 
 	return nil
 }
 
-func (b *s3Backend) Lock() error {
+// storedHeader reads the serial and lineage of the current state object, from
+// its metadata if present or else from its content, and the object's ETag, or ""
+// if there is no object.
+func (b *s3Backend) storedHeader(ctx context.Context) (storedHeader, string, error) {
+	head, err := b.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key),
+	})
+	if err != nil {
+		var nf *s3types.NotFound
+		if errors.As(err, &nf) || strings.Contains(err.Error(), "NotFound") || strings.Contains(err.Error(), "404") {
+			return storedHeader{}, "", nil
+		}
+		return storedHeader{}, "", fmt.Errorf("failed to read state from s3://%s/%s: %w", b.bucket, b.key, err)
+	}
+	if serial, err := strconv.Atoi(head.Metadata[s3SerialMetadata]); err == nil {
+		return storedHeader{
+			Serial:   serial,
+			Lineage:  head.Metadata[s3LineageMetadata],
+			Encoding: Encoding(head.Metadata[s3EncodingMetadata]),
+			Exists:   true,
+		}, aws.ToString(head.ETag), nil
+	}
+
+	// The content is read from the version the HEAD request saw
+	result, err := b.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(b.bucket),
+		Key:     aws.String(b.key),
+		IfMatch: head.ETag,
+	})
+	if err != nil {
+		return storedHeader{}, "", fmt.Errorf("failed to read state from s3://%s/%s: %w", b.bucket, b.key, err)
+	}
+	defer result.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(result.Body); err != nil {
+		return storedHeader{}, "", fmt.Errorf("failed to read S3 object body: %w", err)
+	}
+	stored, err := headerFromContent(ctx, buf.Bytes())
+	return stored, aws.ToString(head.ETag), err
+}
+
+// Object metadata keys holding the serial, lineage and encoding of each state version.
+const (
+	s3SerialMetadata   = "picklr-serial"
+	s3LineageMetadata  = "picklr-lineage"
+	s3EncodingMetadata = "picklr-encoding"
+)
+
+// Versions lists the object versions of the state key, newest first. It requires
+// versioning to be enabled on the bucket.
+func (b *s3Backend) Versions(ctx context.Context) ([]StateVersion, error) {
+	var versions []StateVersion
+	paginator := s3.NewListObjectVersionsPaginator(b.s3Client, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(b.key),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions of s3://%s/%s: %w", b.bucket, b.key, err)
+		}
+		for _, v := range page.Versions {
+			if aws.ToString(v.Key) != b.key {
+				continue
+			}
+			version, err := b.describeVersion(ctx, aws.ToString(v.VersionId))
+			if err != nil {
+				return nil, err
+			}
+			version.Created = aws.ToTime(v.LastModified)
+			versions = append(versions, version)
+		}
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Serial > versions[j].Serial })
+	return versions, nil
+}
+
+// describeVersion reads the serial and lineage of one object version, from its
+// metadata if present or else from the object's content.
+func (b *s3Backend) describeVersion(ctx context.Context, versionID string) (StateVersion, error) {
+	head, err := b.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(b.bucket),
+		Key:       aws.String(b.key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return StateVersion{}, fmt.Errorf("failed to read version %s: %w", versionID, err)
+	}
+	if serial, err := strconv.Atoi(head.Metadata[s3SerialMetadata]); err == nil {
+		return StateVersion{Serial: serial, Lineage: head.Metadata[s3LineageMetadata], ID: versionID}, nil
+	}
+
+	content, err := b.getVersion(ctx, versionID)
+	if err != nil {
+		return StateVersion{}, err
+	}
+	serial, lineage, _ := parseStateHeader(content)
+	return StateVersion{Serial: serial, Lineage: lineage, ID: versionID}, nil
+}
+
+// ReadVersion loads the object version that was written with the given serial.
+func (b *s3Backend) ReadVersion(ctx context.Context, serial int) (*ir.State, error) {
+	versions, err := b.Versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v.Serial != serial {
+			continue
+		}
+		content, err := b.getVersion(ctx, v.ID)
+		if err != nil {
+			return nil, err
+		}
+		return parseStateContent(ctx, b.evaluator, content)
+	}
+	return nil, fmt.Errorf("no version of s3://%s/%s with serial %d", b.bucket, b.key, serial)
+}
+
+// getVersion downloads one version of the state object, decrypted.
+func (b *s3Backend) getVersion(ctx context.Context, versionID string) ([]byte, error) {
+	result, err := b.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(b.bucket),
+		Key:       aws.String(b.key),
+		VersionId: aws.String(versionID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version %s of s3://%s/%s: %w", versionID, b.bucket, b.key, err)
+	}
+	defer result.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(result.Body); err != nil {
+		return nil, fmt.Errorf("failed to read S3 object body: %w", err)
+	}
+	return DecryptState(ctx, buf.Bytes())
+}
+
+func (b *s3Backend) Lock(info *LockInfo) error {
 	if b.dynamoDBTable == "" {
 		return nil // No locking without DynamoDB
 	}
 
-	b.lockID = fmt.Sprintf("picklr-%d-%d", os.Getpid(), time.Now().UnixNano())
+	if info == nil {
+		info = NewLockInfo("")
+	}
+	info.Path = fmt.Sprintf("s3://%s/%s", b.bucket, b.key)
+	payload, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("failed to encode lock info: %w", err)
+	}
 
-	_, err := b.dbClient.PutItem(context.Background(), &dynamodb.PutItemInput{
+	_, err = b.dbClient.PutItem(context.Background(), &dynamodb.PutItemInput{
 		TableName: aws.String(b.dynamoDBTable),
 		Item: map[string]dbtypes.AttributeValue{
 			"LockID":  &dbtypes.AttributeValueMemberS{Value: b.key},
-			"Info":    &dbtypes.AttributeValueMemberS{Value: b.lockID},
-			"Created": &dbtypes.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			"Info":    &dbtypes.AttributeValueMemberS{Value: string(payload)},
+			"Created": &dbtypes.AttributeValueMemberS{Value: info.Created},
 		},
 		ConditionExpression: aws.String("attribute_not_exists(LockID)"),
 	})
 	if err != nil {
 		if strings.Contains(err.Error(), "ConditionalCheckFailedException") {
-			return fmt.Errorf("state is locked by another process. If this is an error, "+
-				"manually delete the lock item with LockID=%q from DynamoDB table %q", b.key, b.dynamoDBTable)
+			holder, _ := b.currentLock()
+			return &LockError{Info: holder}
 		}
 		return fmt.Errorf("failed to acquire lock: %w", err)
 	}
 
+	b.lockID = info.ID
 	return nil
 }
 
 func (b *s3Backend) Unlock() error {
-	if b.dynamoDBTable == "" {
+	if b.dynamoDBTable == "" || b.lockID == "" {
 		return nil
 	}
 
+	if err := b.deleteLock(b.lockID); err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+
+	b.lockID = ""
+	return nil
+}
+
+func (b *s3Backend) ForceUnlock(id string) error {
+	if b.dynamoDBTable == "" {
+		return fmt.Errorf("s3 backend has no dynamodb_table configured")
+	}
+
+	holder, err := b.currentLock()
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		return fmt.Errorf("state is not locked")
+	}
+	if holder.ID != id {
+		return fmt.Errorf("lock ID %q does not match the current lock %q", id, holder.ID)
+	}
+
+	if err := b.deleteLock(id); err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+// currentLock returns the metadata of the lock item, or nil if there is none.
+// Items written by older versions hold a plain lock ID instead of JSON.
+func (b *s3Backend) currentLock() (*LockInfo, error) {
+	out, err := b.dbClient.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(b.dynamoDBTable),
+		Key:            map[string]dbtypes.AttributeValue{"LockID": &dbtypes.AttributeValueMemberS{Value: b.key}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock: %w", err)
+	}
+	attr, ok := out.Item["Info"].(*dbtypes.AttributeValueMemberS)
+	if !ok {
+		return nil, nil
+	}
+	var info LockInfo
+	if json.Unmarshal([]byte(attr.Value), &info) != nil || info.ID == "" {
+		info = LockInfo{ID: attr.Value}
+	}
+	return &info, nil
+}
+
+// deleteLock removes the lock item if it is still held under id.
+func (b *s3Backend) deleteLock(id string) error {
 	_, err := b.dbClient.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
 		TableName: aws.String(b.dynamoDBTable),
 		Key: map[string]dbtypes.AttributeValue{
 			"LockID": &dbtypes.AttributeValueMemberS{Value: b.key},
 		},
+		ConditionExpression: aws.String("contains(Info, :id)"),
+		ExpressionAttributeValues: map[string]dbtypes.AttributeValue{
+			":id": &dbtypes.AttributeValueMemberS{Value: id},
+		},
 	})
-	if err != nil {
-		return fmt.Errorf("failed to release lock: %w", err)
-	}
-
-	return nil
+	return err
 }
@@ -63,7 +63,7 @@ func TestSerializeState(t *testing.T) {
 	}
 	content := SerializeState(state)
 	assert.Contains(t, content, "version = 1")
-	assert.Contains(t, content, "serial = 3") // Serial is incremented
+	assert.Contains(t, content, "serial = 2")
 	assert.Contains(t, content, `lineage = "abc-123"`)
 	assert.Contains(t, content, "resources {")
 }
@@ -80,10 +80,16 @@ func TestNewBackendRejectsUnknownType(t *testing.T) {
 	assert.Contains(t, err.Error(), "unknown backend type")
 }
 
-func TestNewBackendLocalFallback(t *testing.T) {
+func TestNewBackendLocal(t *testing.T) {
 	_, err := NewBackend(&BackendConfig{Type: "local"}, nil)
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "state.Manager")
+	assert.Contains(t, err.Error(), "path")
+
+	b, err := NewBackend(&BackendConfig{Type: "local", Config: map[string]string{"path": "/tmp/state.pkl"}}, nil)
+	require.NoError(t, err)
+	mgr, ok := b.(*Manager)
+	require.True(t, ok)
+	assert.Equal(t, "/tmp/state.pkl", mgr.path)
 }
 
 func TestNewBackendGCSNotImplemented(t *testing.T) {
@@ -92,8 +98,8 @@ func TestNewBackendGCSNotImplemented(t *testing.T) {
 	assert.Contains(t, err.Error(), "not yet implemented")
 }
 
-func TestNewBackendHTTPNotImplemented(t *testing.T) {
+func TestNewBackendHTTPRequiresAddress(t *testing.T) {
 	_, err := NewBackend(&BackendConfig{Type: "http"}, nil)
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "not yet implemented")
+	assert.Contains(t, err.Error(), "address")
 }
@@ -0,0 +1,175 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// sealedPrefix marks a sensitive value encrypted inside otherwise plaintext state:
+// picklr-sensitive:v1:<base64 envelope>:<base64 nonce and ciphertext>.
+const sealedPrefix = "picklr-sensitive:v1:"
+
+// encodeForWrite encodes state for storage. By default the whole content is
+// encrypted if a key provider is configured. With sensitiveOnly, only the values
+// of sensitive attributes and outputs are encrypted, each in place.
+func encodeForWrite(ctx context.Context, state *ir.State, encoding Encoding, sensitiveOnly bool) ([]byte, error) {
+	if !sensitiveOnly {
+		content, err := EncodeState(state, encoding)
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := EncryptState(ctx, content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt state: %w", err)
+		}
+		return encrypted, nil
+	}
+
+	sealed, err := sealSensitive(ctx, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt sensitive values: %w", err)
+	}
+	return EncodeState(sealed, encoding)
+}
+
+// sealSensitive returns a copy of state whose sensitive values are encrypted. All
+// values share one data key, so a write needs a single key provider call.
+func sealSensitive(ctx context.Context, state *ir.State) (*ir.State, error) {
+	provider, err := CurrentKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("encrypt_sensitive requires a state encryption key (set %s, %s, %s or %s)",
+			EncryptionKeyEnvVar, EncryptionPassphraseEnvVar, EncryptionKeyFileEnvVar, KMSKeyIDEnvVar)
+	}
+
+	var key, env []byte
+	seal := func(v any) (any, error) {
+		if s, ok := v.(string); ok && strings.HasPrefix(s, sealedPrefix) {
+			return s, nil
+		}
+		if key == nil {
+			if key, env, err = newEnvelope(ctx, provider); err != nil {
+				return nil, err
+			}
+		}
+		plaintext, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, err := sealAESGCM(key, plaintext, env)
+		if err != nil {
+			return nil, err
+		}
+		return sealedPrefix + base64.StdEncoding.EncodeToString(env) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+	}
+
+	sealed := *state
+	if sealed.Outputs, err = transformValues(state.Outputs, state.SensitiveOutputs, seal); err != nil {
+		return nil, err
+	}
+	sealed.Resources = make([]*ir.ResourceState, len(state.Resources))
+	for i, res := range state.Resources {
+		r := *res
+		if r.Inputs, err = transformValues(res.Inputs, res.Sensitive, seal); err != nil {
+			return nil, err
+		}
+		if r.Outputs, err = transformValues(res.Outputs, res.Sensitive, seal); err != nil {
+			return nil, err
+		}
+		sealed.Resources[i] = &r
+	}
+	return &sealed, nil
+}
+
+// openSensitive decrypts, in place, the sensitive values that sealSensitive
+// encrypted. State without encrypted values is returned unchanged.
+func openSensitive(ctx context.Context, state *ir.State) (*ir.State, error) {
+	keys := make(map[string][]byte) // data keys by envelope
+	open := func(v any) (any, error) {
+		s, ok := v.(string)
+		if !ok || !strings.HasPrefix(s, sealedPrefix) {
+			return v, nil
+		}
+		encodedEnv, encodedCiphertext, ok := strings.Cut(strings.TrimPrefix(s, sealedPrefix), ":")
+		if !ok {
+			return nil, fmt.Errorf("malformed encrypted value")
+		}
+		env, err := base64.StdEncoding.DecodeString(encodedEnv)
+		if err != nil {
+			return nil, fmt.Errorf("malformed encrypted value: %w", err)
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(encodedCiphertext)
+		if err != nil {
+			return nil, fmt.Errorf("malformed encrypted value: %w", err)
+		}
+
+		key, ok := keys[encodedEnv]
+		if !ok {
+			var e envelope
+			if err := json.Unmarshal(env, &e); err != nil {
+				return nil, fmt.Errorf("invalid envelope: %w", err)
+			}
+			if key, err = unwrapDataKey(ctx, e); err != nil {
+				return nil, err
+			}
+			keys[encodedEnv] = key
+		}
+
+		plaintext, err := openAESGCM(key, ciphertext, env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt value: %w", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(plaintext))
+		dec.UseNumber()
+		var value any
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		return normalizeJSONValue(value), nil
+	}
+
+	var err error
+	if state.Outputs, err = transformValues(state.Outputs, state.SensitiveOutputs, open); err != nil {
+		return nil, fmt.Errorf("output: %w", err)
+	}
+	for _, res := range state.Resources {
+		if res.Inputs, err = transformValues(res.Inputs, res.Sensitive, open); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", res.Type, res.Name, err)
+		}
+		if res.Outputs, err = transformValues(res.Outputs, res.Sensitive, open); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", res.Type, res.Name, err)
+		}
+	}
+	return state, nil
+}
+
+// transformValues returns a copy of m with fn applied to the values of keys.
+func transformValues(m map[string]any, keys []string, fn func(any) (any, error)) (map[string]any, error) {
+	if len(m) == 0 || len(keys) == 0 {
+		return m, nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	for _, k := range keys {
+		v, ok := m[k]
+		if !ok || v == nil {
+			continue
+		}
+		t, err := fn(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		out[k] = t
+	}
+	return out, nil
+}
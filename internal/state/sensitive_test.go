@@ -0,0 +1,105 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sensitiveTestState() *ir.State {
+	return &ir.State{
+		Version: 1,
+		Lineage: "abc",
+		Outputs: map[string]any{"password": "hunter2!", "name": "db"},
+		Resources: []*ir.ResourceState{{
+			Type:      "aws:RDS.Instance",
+			Name:      "db",
+			Provider:  "aws",
+			Inputs:    map[string]any{"master_user_password": "hunter2!", "port": 5432},
+			Outputs:   map[string]any{"id": "db-1"},
+			Sensitive: []string{"master_user_password"},
+		}},
+		SensitiveOutputs: []string{"password"},
+	}
+}
+
+func TestSealSensitive_RoundTrip(t *testing.T) {
+	clearKeyEnv(t)
+	t.Setenv(EncryptionKeyEnvVar, testKey(1))
+	ctx := context.Background()
+
+	s := sensitiveTestState()
+	sealed, err := sealSensitive(ctx, s)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed.Outputs["password"].(string), sealedPrefix))
+	assert.True(t, strings.HasPrefix(sealed.Resources[0].Inputs["master_user_password"].(string), sealedPrefix))
+	assert.Equal(t, "db", sealed.Outputs["name"])
+	assert.Equal(t, 5432, sealed.Resources[0].Inputs["port"])
+	// The original state is left untouched
+	assert.Equal(t, "hunter2!", s.Outputs["password"])
+
+	opened, err := openSensitive(ctx, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2!", opened.Outputs["password"])
+	assert.Equal(t, "hunter2!", opened.Resources[0].Inputs["master_user_password"])
+}
+
+func TestSealSensitive_RequiresKey(t *testing.T) {
+	clearKeyEnv(t)
+
+	_, err := sealSensitive(context.Background(), sensitiveTestState())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "encrypt_sensitive requires a state encryption key")
+}
+
+func TestOpenSensitive_WrongKey(t *testing.T) {
+	clearKeyEnv(t)
+	t.Setenv(EncryptionKeyEnvVar, testKey(1))
+	ctx := context.Background()
+
+	sealed, err := sealSensitive(ctx, sensitiveTestState())
+	require.NoError(t, err)
+
+	t.Setenv(EncryptionKeyEnvVar, testKey(2))
+	_, err = openSensitive(ctx, sealed)
+	assert.Error(t, err)
+}
+
+func TestBackend_EncryptSensitive(t *testing.T) {
+	clearKeyEnv(t)
+	t.Setenv(EncryptionKeyEnvVar, testKey(1))
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	b, err := NewBackend(&BackendConfig{
+		Type:   "local",
+		Config: map[string]string{"path": path, "encoding": "json", "encrypt_sensitive": "true"},
+	}, nil)
+	require.NoError(t, err)
+	require.NoError(t, b.Write(ctx, sensitiveTestState()))
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.False(t, IsEncrypted(raw))
+	assert.NotContains(t, string(raw), "hunter2!")
+	assert.Contains(t, string(raw), sealedPrefix)
+	assert.Contains(t, string(raw), `"db-1"`)
+
+	s, err := b.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2!", s.Outputs["password"])
+	assert.Equal(t, "hunter2!", s.Resources[0].Inputs["master_user_password"])
+	assert.Equal(t, []string{"password"}, s.SensitiveOutputs)
+}
+
+func TestSerializeState_SensitiveListings(t *testing.T) {
+	data := SerializeState(sensitiveTestState())
+	assert.Contains(t, data, "sensitiveOutputs {\n")
+	assert.Contains(t, data, `"master_user_password"`)
+}
@@ -0,0 +1,110 @@
+package state
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// storedHeader is the serial and lineage of the state currently held by a backend.
+type storedHeader struct {
+	Serial   int
+	Lineage  string
+	Encoding Encoding
+	Exists   bool
+}
+
+// headerFromContent reads the header of stored, possibly encrypted, state content.
+func headerFromContent(ctx context.Context, content []byte) (storedHeader, error) {
+	decrypted, err := DecryptState(ctx, content)
+	if err != nil {
+		return storedHeader{}, err
+	}
+	serial, lineage, ok := parseStateHeader(decrypted)
+	if !ok {
+		return storedHeader{}, fmt.Errorf("stored state has no serial")
+	}
+	return storedHeader{Serial: serial, Lineage: lineage, Encoding: detectEncoding(decrypted), Exists: true}, nil
+}
+
+// ConflictError is returned by Backend.Write when the stored state has moved on
+// since the state being written was read, or belongs to a different lineage.
+type ConflictError struct {
+	StoredSerial  int
+	StoredLineage string
+	Serial        int
+	Lineage       string
+}
+
+func (e *ConflictError) Error() string {
+	if e.StoredLineage != e.Lineage {
+		return fmt.Sprintf("refusing to write state with lineage %q over stored state with lineage %q; "+
+			"the two states have unrelated histories (use 'picklr state push -force' to overwrite)",
+			e.Lineage, e.StoredLineage)
+	}
+	return fmt.Sprintf("refusing to write state: the stored state has serial %d, but this run read serial %d; "+
+		"another run has written the state since (re-run the command, or use 'picklr state push -force' to overwrite)",
+		e.StoredSerial, e.Serial)
+}
+
+type forceWriteKey struct{}
+
+// WithForceWrite returns a context under which Backend.Write skips the serial
+// and lineage checks.
+func WithForceWrite(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceWriteKey{}, true)
+}
+
+func isForceWrite(ctx context.Context) bool {
+	force, _ := ctx.Value(forceWriteKey{}).(bool)
+	return force
+}
+
+// nextVersion checks state against the stored header and returns the serial and
+// lineage to write it with. This is the only place the serial is incremented;
+// backends store the result in state once the write has succeeded.
+func nextVersion(ctx context.Context, stored storedHeader, state *ir.State) (int, string, error) {
+	lineage := state.Lineage
+	if lineage == "" {
+		lineage = stored.Lineage
+	}
+	if lineage == "" {
+		lineage = NewLineage()
+	}
+
+	serial := state.Serial
+	if stored.Exists {
+		if isForceWrite(ctx) {
+			serial = max(serial, stored.Serial)
+		} else if (stored.Lineage != "" && stored.Lineage != lineage) || stored.Serial > state.Serial {
+			return 0, "", &ConflictError{
+				StoredSerial:  stored.Serial,
+				StoredLineage: stored.Lineage,
+				Serial:        state.Serial,
+				Lineage:       lineage,
+			}
+		}
+	}
+
+	return serial + 1, lineage, nil
+}
+
+// versioned returns a shallow copy of state carrying the given serial and lineage.
+func versioned(state *ir.State, serial int, lineage string) *ir.State {
+	next := *state
+	next.Serial = serial
+	next.Lineage = lineage
+	return &next
+}
+
+// NewLineage generates a lineage identifier (a v4 UUID).
+func NewLineage() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant 10
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%12x",
+		b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,83 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_WriteIncrementsSerialOnce(t *testing.T) {
+	mgr := NewManager(filepath.Join(t.TempDir(), "state.pkl"), nil)
+	ctx := context.Background()
+
+	s := &ir.State{Version: 1}
+	require.NoError(t, mgr.Write(ctx, s))
+	assert.Equal(t, 1, s.Serial)
+	assert.NotEmpty(t, s.Lineage, "a lineage is assigned on first write")
+
+	stored, err := mgr.storedHeader(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, storedHeader{Serial: 1, Lineage: s.Lineage, Encoding: EncodingPKL, Exists: true}, stored)
+}
+
+func TestManager_WriteRejectsStaleSerial(t *testing.T) {
+	mgr := NewManager(filepath.Join(t.TempDir(), "state.pkl"), nil)
+	ctx := context.Background()
+
+	first := &ir.State{Version: 1, Lineage: "lin"}
+	second := &ir.State{Version: 1, Lineage: "lin"}
+	require.NoError(t, mgr.Write(ctx, first))
+
+	// second was read before first was written
+	err := mgr.Write(ctx, second)
+	var conflict *ConflictError
+	require.True(t, errors.As(err, &conflict))
+	assert.Equal(t, 1, conflict.StoredSerial)
+	assert.Equal(t, 0, conflict.Serial)
+	assert.Contains(t, err.Error(), "stored state has serial 1, but this run read serial 0")
+	assert.Equal(t, 0, second.Serial, "a rejected write leaves the state untouched")
+
+	require.NoError(t, mgr.Write(WithForceWrite(ctx), second))
+	assert.Equal(t, 2, second.Serial)
+}
+
+func TestManager_WriteRejectsOtherLineage(t *testing.T) {
+	mgr := NewManager(filepath.Join(t.TempDir(), "state.pkl"), nil)
+	ctx := context.Background()
+
+	require.NoError(t, mgr.Write(ctx, &ir.State{Version: 1, Lineage: "lin-a"}))
+
+	err := mgr.Write(ctx, &ir.State{Version: 1, Serial: 5, Lineage: "lin-b"})
+	var conflict *ConflictError
+	require.True(t, errors.As(err, &conflict))
+	assert.Contains(t, err.Error(), `lineage "lin-b" over stored state with lineage "lin-a"`)
+
+	// A state without lineage adopts the stored one
+	s := &ir.State{Version: 1, Serial: 1}
+	require.NoError(t, mgr.Write(ctx, s))
+	assert.Equal(t, "lin-a", s.Lineage)
+}
+
+func TestHTTPBackend_WriteRejectsStaleSerial(t *testing.T) {
+	t.Setenv(EncryptionKeyEnvVar, "")
+	fake := &fakeStateServer{state: []byte(SerializeState(&ir.State{Version: 1, Serial: 4, Lineage: "abc"}))}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b := newTestHTTPBackend(t, srv, nil)
+	require.NoError(t, b.Lock(NewLockInfo("apply")))
+	err := b.Write(context.Background(), &ir.State{Version: 1, Serial: 3, Lineage: "abc"})
+	var conflict *ConflictError
+	require.True(t, errors.As(err, &conflict))
+
+	s := &ir.State{Version: 1, Serial: 4, Lineage: "abc"}
+	require.NoError(t, b.Write(context.Background(), s))
+	assert.Equal(t, 5, s.Serial)
+	assert.Contains(t, string(fake.state), "serial = 5")
+}
@@ -0,0 +1,315 @@
+package state
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/picklr-io/picklr/internal/ir"
+)
+
+// SerializeState converts a State to its PKL text representation.
+//
+// The output is deterministic: mapping keys are sorted and resources are ordered
+// by address, so that unchanged state serializes identically and state diffs only
+// show real changes. Ints, floats, booleans, nulls, listings and mappings keep their
+// types when the file is read back.
+func SerializeState(state *ir.State) string {
+	var b strings.Builder
+
+	// Write header
+	fmt.Fprintf(&b, "// Picklr state file\n")
+	fmt.Fprintf(&b, "amends \"../../pkg/schemas/State.pkl\"\n\n")
+	fmt.Fprintf(&b, "version = %d\n", state.Version)
+	fmt.Fprintf(&b, "serial = %d\n", state.Serial)
+	fmt.Fprintf(&b, "lineage = %s\n\n", pklString(state.Lineage))
+
+	// Write outputs
+	if len(state.Outputs) > 0 {
+		fmt.Fprintf(&b, "outputs {\n")
+		writePklEntries(&b, state.Outputs, 1)
+		fmt.Fprintf(&b, "}\n\n")
+	} else {
+		fmt.Fprintf(&b, "outputs = new {}\n\n")
+	}
+	if len(state.SensitiveOutputs) > 0 {
+		fmt.Fprintf(&b, "sensitiveOutputs {\n")
+		writePklListing(&b, sortedStrings(state.SensitiveOutputs), 1)
+		fmt.Fprintf(&b, "}\n\n")
+	}
+	if len(state.RemoteStateSerials) > 0 {
+		serials := make(map[string]any, len(state.RemoteStateSerials))
+		for name, serial := range state.RemoteStateSerials {
+			serials[name] = serial
+		}
+		fmt.Fprintf(&b, "remoteStateSerials {\n")
+		writePklEntries(&b, serials, 1)
+		fmt.Fprintf(&b, "}\n\n")
+	}
+
+	// Write resources
+	fmt.Fprintf(&b, "resources {\n")
+	for _, res := range sortedResources(state.Resources) {
+		fmt.Fprintf(&b, "  new {\n")
+		fmt.Fprintf(&b, "    type = %s\n", pklString(res.Type))
+		fmt.Fprintf(&b, "    name = %s\n", pklString(res.Name))
+		fmt.Fprintf(&b, "    provider = %s\n", pklString(res.Provider))
+		if res.Module != "" {
+			fmt.Fprintf(&b, "    module = %s\n", pklString(res.Module))
+		}
+
+		// Serialize inputs
+		if len(res.Inputs) > 0 {
+			fmt.Fprintf(&b, "    inputs {\n")
+			writePklEntries(&b, res.Inputs, 3)
+			fmt.Fprintf(&b, "    }\n")
+		} else {
+			fmt.Fprintf(&b, "    inputs = new {}\n")
+		}
+
+		fmt.Fprintf(&b, "    inputsHash = %s\n", pklString(res.InputsHash))
+
+		// Serialize outputs
+		if len(res.Outputs) > 0 {
+			fmt.Fprintf(&b, "    outputs {\n")
+			writePklEntries(&b, res.Outputs, 3)
+			fmt.Fprintf(&b, "    }\n")
+		} else {
+			fmt.Fprintf(&b, "    outputs = new {}\n")
+		}
+
+		if len(res.Dependencies) > 0 {
+			fmt.Fprintf(&b, "    dependencies {\n")
+			writePklListing(&b, res.Dependencies, 3)
+			fmt.Fprintf(&b, "    }\n")
+		}
+
+		if len(res.Sensitive) > 0 {
+			fmt.Fprintf(&b, "    sensitive {\n")
+			writePklListing(&b, sortedStrings(res.Sensitive), 3)
+			fmt.Fprintf(&b, "    }\n")
+		}
+
+		fmt.Fprintf(&b, "  }\n")
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	return b.String()
+}
+
+// writePklEntries writes the entries of m in key order, one per line.
+func writePklEntries(b *strings.Builder, m map[string]any, indentLevel int) {
+	indent := strings.Repeat("  ", indentLevel)
+	for _, k := range sortedKeys(m) {
+		fmt.Fprintf(b, "%s[%s] = %s\n", indent, pklString(k), serializePklValue(m[k], indentLevel))
+	}
+}
+
+// writePklListing writes the elements of a string listing, one per line.
+func writePklListing(b *strings.Builder, items []string, indentLevel int) {
+	indent := strings.Repeat("  ", indentLevel)
+	for _, item := range items {
+		fmt.Fprintf(b, "%s%s\n", indent, pklString(item))
+	}
+}
+
+// serializePklValue recursively serializes a Go value to PKL syntax. Maps become
+// Mappings and slices become Listings, so they decode back to maps and slices.
+func serializePklValue(v any, indentLevel int) string {
+	indent := strings.Repeat("  ", indentLevel)
+
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return pklString(val)
+	case bool:
+		return strconv.FormatBool(val)
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return pklFloat(val)
+	case float32:
+		return pklFloat(float64(val))
+	case map[string]any:
+		if len(val) == 0 {
+			return "new Mapping {}"
+		}
+		var b strings.Builder
+		b.WriteString("new Mapping {\n")
+		writePklEntries(&b, val, indentLevel+1)
+		b.WriteString(indent + "}")
+		return b.String()
+	case map[any]any:
+		m := make(map[string]any, len(val))
+		for k, v := range val {
+			m[fmt.Sprintf("%v", k)] = v
+		}
+		return serializePklValue(m, indentLevel)
+	case []any:
+		if len(val) == 0 {
+			return "new Listing {}"
+		}
+		var b strings.Builder
+		b.WriteString("new Listing {\n")
+		for _, v := range val {
+			b.WriteString(fmt.Sprintf("%s  %s\n", indent, serializePklValue(v, indentLevel+1)))
+		}
+		b.WriteString(indent + "}")
+		return b.String()
+	}
+
+	// Other slices, maps and numeric types, e.g. []string or map[string]string
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		list := make([]any, rv.Len())
+		for i := range list {
+			list[i] = rv.Index(i).Interface()
+		}
+		return serializePklValue(list, indentLevel)
+	case reflect.Map:
+		m := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[fmt.Sprintf("%v", iter.Key().Interface())] = iter.Value().Interface()
+		}
+		return serializePklValue(m, indentLevel)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return "null"
+		}
+		return serializePklValue(rv.Elem().Interface(), indentLevel)
+	}
+	return pklString(fmt.Sprintf("%v", v))
+}
+
+// pklFloat formats a float so that PKL reads it back as a Float, never an Int.
+func pklFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if mantissa, exp, ok := strings.Cut(s, "e"); ok {
+		if !strings.Contains(mantissa, ".") {
+			mantissa += ".0"
+		}
+		return mantissa + "e" + exp
+	}
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// pklString quotes s as a PKL string literal. Unlike Go quoting, PKL only knows the
+// \n, \r, \t, \", \\ and \u{...} escapes.
+func pklString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"':
+			b.WriteString(`\"`)
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u{%x}`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// sortedResources returns the resources ordered by address.
+func sortedResources(resources []*ir.ResourceState) []*ir.ResourceState {
+	sorted := make([]*ir.ResourceState, len(resources))
+	copy(sorted, resources)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Module != sorted[j].Module {
+			return sorted[i].Module < sorted[j].Module
+		}
+		if sorted[i].Type != sorted[j].Type {
+			return sorted[i].Type < sorted[j].Type
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// sortedStrings returns a sorted copy of items.
+func sortedStrings(items []string) []string {
+	sorted := append([]string(nil), items...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// normalizeState converts the map[any]any values that the PKL decoder produces for
+// nested mappings into map[string]any, matching what was serialized.
+func normalizeState(state *ir.State) *ir.State {
+	state.Outputs = normalizeDecodedMap(state.Outputs)
+	for _, res := range state.Resources {
+		res.Inputs = normalizeDecodedMap(res.Inputs)
+		res.Outputs = normalizeDecodedMap(res.Outputs)
+	}
+	return state
+}
+
+func normalizeDecodedMap(m map[string]any) map[string]any {
+	for k, v := range m {
+		m[k] = normalizeDecoded(v)
+	}
+	return m
+}
+
+func normalizeDecoded(v any) any {
+	switch val := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(val))
+		for k, v := range val {
+			m[fmt.Sprintf("%v", k)] = normalizeDecoded(v)
+		}
+		return m
+	case map[string]any:
+		return normalizeDecodedMap(val)
+	case []any:
+		for i := range val {
+			val[i] = normalizeDecoded(val[i])
+		}
+		return val
+	default:
+		return v
+	}
+}
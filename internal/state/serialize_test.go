@@ -0,0 +1,205 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/picklr-io/picklr/internal/eval"
+	"github.com/picklr-io/picklr/internal/ir"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerializeState_Deterministic(t *testing.T) {
+	s := &ir.State{
+		Version: 1,
+		Serial:  2,
+		Lineage: "abc",
+		Outputs: map[string]any{"z": 1, "a": 2, "m": 3},
+		Resources: []*ir.ResourceState{
+			{Type: "null_resource", Name: "b", Provider: "null", Inputs: map[string]any{"y": 1, "x": map[string]any{"d": 1, "c": 2}}},
+			{Type: "null_resource", Name: "a", Provider: "null"},
+			{Type: "aws:S3.Bucket", Name: "logs", Provider: "aws"},
+		},
+	}
+
+	first := SerializeState(s)
+	for i := 0; i < 20; i++ {
+		require.Equal(t, first, SerializeState(s))
+	}
+
+	assert.Less(t, strings.Index(first, `["a"] = 2`), strings.Index(first, `["m"] = 3`))
+	assert.Less(t, strings.Index(first, `["m"] = 3`), strings.Index(first, `["z"] = 1`))
+	assert.Less(t, strings.Index(first, `["c"] = 2`), strings.Index(first, `["d"] = 1`))
+	assert.Less(t, strings.Index(first, `name = "logs"`), strings.Index(first, `name = "a"`))
+	assert.Less(t, strings.Index(first, `name = "a"`), strings.Index(first, `name = "b"`))
+	assert.Equal(t, "b", s.Resources[0].Name, "the state itself is not reordered")
+}
+
+func TestSerializePklValue_Types(t *testing.T) {
+	tests := []struct {
+		input any
+		want  string
+	}{
+		{1.0, "1.0"},
+		{-2.5, "-2.5"},
+		{1e21, "1.0e+21"},
+		{1.5e-7, "1.5e-07"},
+		{int64(-7), "-7"},
+		{uint8(7), "7"},
+		{[]string{"a"}, "new Listing {\n  \"a\"\n}"},
+		{map[string]string{"k": "v"}, "new Mapping {\n  [\"k\"] = \"v\"\n}"},
+		{"say \"hi\"\n\\(x)\x01", `"say \"hi\"\n\\(x)\u{1}"`},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, serializePklValue(tt.input, 0), "%#v", tt.input)
+	}
+}
+
+func TestSerializeState_WritesDependencies(t *testing.T) {
+	content := SerializeState(&ir.State{Resources: []*ir.ResourceState{
+		{Type: "null_resource", Name: "a", Provider: "null", Dependencies: []string{"null_resource.b"}},
+	}})
+	assert.Contains(t, content, "    dependencies {\n      \"null_resource.b\"\n    }\n")
+}
+
+// TestSerializeState_RoundTrip checks that randomly generated states read back
+// through the PKL evaluator unchanged.
+func TestSerializeState_RoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("pkl"); err != nil && os.Getenv("PKL_EXEC") == "" {
+		t.Skip("pkl is not installed")
+	}
+
+	// State files amend ../../pkg/schemas/State.pkl
+	dir := t.TempDir()
+	schema, err := os.ReadFile(filepath.Join("..", "..", "pkg", "schemas", "State.pkl"))
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "pkg", "schemas"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pkg", "schemas", "State.pkl"), schema, 0644))
+	stateDir := filepath.Join(dir, "project", ".picklr")
+	require.NoError(t, os.MkdirAll(stateDir, 0755))
+
+	evaluator := eval.NewEvaluator(dir)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 25; i++ {
+		want := randomState(rng)
+		path := filepath.Join(stateDir, fmt.Sprintf("state-%d.pkl", i))
+		require.NoError(t, os.WriteFile(path, []byte(SerializeState(want)), 0644))
+
+		got, err := LoadStateFile(context.Background(), evaluator, path)
+		require.NoError(t, err, "state %d", i)
+		assert.Equal(t, want, got, "state %d", i)
+	}
+}
+
+func randomState(rng *rand.Rand) *ir.State {
+	s := &ir.State{
+		Version: 1,
+		Serial:  rng.Intn(100),
+		Lineage: NewLineage(),
+		Outputs: randomMap(rng, 0),
+	}
+	for i := rng.Intn(4); i >= 0; i-- {
+		res := &ir.ResourceState{
+			Type:       "null_resource",
+			Name:       fmt.Sprintf("r%d", i),
+			Provider:   "null",
+			Inputs:     randomMap(rng, 0),
+			InputsHash: randomString(rng),
+			Outputs:    randomMap(rng, 0),
+		}
+		if rng.Intn(2) == 0 {
+			res.Dependencies = []string{"null_resource.x", "null_resource.y"}
+		}
+		s.Resources = append(s.Resources, res)
+	}
+	sort.Slice(s.Resources, func(i, j int) bool { return s.Resources[i].Name < s.Resources[j].Name })
+	return s
+}
+
+func randomMap(rng *rand.Rand, depth int) map[string]any {
+	m := make(map[string]any)
+	for i := rng.Intn(4) + 1; i > 0; i-- {
+		m[randomString(rng)] = randomValue(rng, depth+1)
+	}
+	return m
+}
+
+func randomValue(rng *rand.Rand, depth int) any {
+	kinds := 8
+	if depth >= 3 {
+		kinds = 6 // No further nesting
+	}
+	switch rng.Intn(kinds) {
+	case 0:
+		return randomString(rng)
+	case 1:
+		return rng.Intn(1<<40) - 1<<39
+	case 2:
+		return float64(rng.Intn(1000)) / 8
+	case 3:
+		return rng.Intn(2) == 0
+	case 4:
+		return nil
+	case 5:
+		return float64(rng.Intn(10)) // Whole floats stay floats
+	case 6:
+		list := make([]any, rng.Intn(3))
+		for i := range list {
+			list[i] = randomValue(rng, depth+1)
+		}
+		return list
+	default:
+		if rng.Intn(4) == 0 {
+			return map[string]any{}
+		}
+		return randomMap(rng, depth)
+	}
+}
+
+func randomString(rng *rand.Rand) string {
+	pieces := []string{"a", "Z", "0", " ", "\"", "\\", "\n", "\t", "\\(x)", "#", "é", "日本", "\x01", "{", "}", "$"}
+	var b strings.Builder
+	for i := rng.Intn(6); i >= 0; i-- {
+		b.WriteString(pieces[rng.Intn(len(pieces))])
+	}
+	return b.String()
+}
+
+func TestSerializeState_RemoteStateSerials(t *testing.T) {
+	s := &ir.State{Version: 1, Serial: 2, RemoteStateSerials: map[string]int{"network": 7, "dns": 3}}
+	out := SerializeState(s)
+	assert.Contains(t, out, "remoteStateSerials {\n  [\"dns\"] = 3\n  [\"network\"] = 7\n}\n")
+
+	data, err := SerializeStateJSON(s)
+	require.NoError(t, err)
+	decoded, err := ParseStateJSON(data)
+	require.NoError(t, err)
+	assert.Equal(t, s.RemoteStateSerials, decoded.RemoteStateSerials)
+}
+
+func TestSerializeState_Module(t *testing.T) {
+	s := &ir.State{Version: 1, Resources: []*ir.ResourceState{
+		{Type: "null_resource", Name: "x", Provider: "null", Module: `module.net["prod"]`},
+		{Type: "null_resource", Name: "x", Provider: "null"},
+	}}
+	out := SerializeState(s)
+	assert.Contains(t, out, `module = "module.net[\"prod\"]"`)
+	// Root module resources come first
+	assert.Less(t, strings.Index(out, `name = "x"`+"\n    provider = \"null\"\n    inputs"), strings.Index(out, "module ="))
+
+	data, err := SerializeStateJSON(s)
+	require.NoError(t, err)
+	decoded, err := ParseStateJSON(data)
+	require.NoError(t, err)
+	assert.Equal(t, "", decoded.Resources[0].Module)
+	assert.Equal(t, `module.net["prod"]`, decoded.Resources[1].Module)
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/picklr-io/picklr/internal/eval"
 	"github.com/picklr-io/picklr/internal/ir"
@@ -15,6 +14,12 @@ import (
 type Manager struct {
 	path      string
 	evaluator *eval.Evaluator
+	encoding  Encoding // empty keeps the encoding of the existing file
+
+	encryptSensitive bool // encrypt sensitive values rather than the whole file
+
+	lockFile *os.File // held while locked
+	lockInfo *LockInfo
 }
 
 func NewManager(path string, evaluator *eval.Evaluator) *Manager {
@@ -35,40 +40,49 @@ func (m *Manager) Read(ctx context.Context) (*ir.State, error) {
 		}, nil
 	}
 
-	// Check if file is encrypted and decrypt if needed
-	raw, err := os.ReadFile(m.path)
+	return m.readFile(ctx, m.path)
+}
+
+// readFile loads a state file, decrypting it if needed.
+func (m *Manager) readFile(ctx context.Context, path string) (*ir.State, error) {
+	raw, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read state file %s: %w", m.path, err)
+		return nil, fmt.Errorf("failed to read state file %s: %w", path, err)
 	}
 
-	if IsEncrypted(raw) {
-		decrypted, err := DecryptState(raw)
+	var state *ir.State
+	switch {
+	case IsEncrypted(raw):
+		// Decrypted state is only ever held in memory
+		decrypted, err := DecryptState(ctx, raw)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt state: %w", err)
+			return nil, fmt.Errorf("failed to decrypt state %s: %w", path, err)
 		}
-		// Write decrypted content to a temp file for the PKL evaluator
-		tmpFile := m.path + ".dec"
-		if err := os.WriteFile(tmpFile, decrypted, 0600); err != nil {
-			return nil, fmt.Errorf("failed to write decrypted state: %w", err)
+		state, err = loadStateContent(ctx, m.evaluator, decrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load decrypted state from %s: %w", path, err)
 		}
-		defer os.Remove(tmpFile)
-
-		state, err := m.evaluator.LoadState(ctx, tmpFile)
+	case detectEncoding(raw) == EncodingJSON:
+		state, err = ParseStateJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load state from %s: %w", path, err)
+		}
+	default:
+		state, err = LoadStateFile(ctx, m.evaluator, path)
 		if err != nil {
-			return nil, fmt.Errorf("failed to load decrypted state: %w", err)
+			return nil, fmt.Errorf("failed to load state from %s: %w", path, err)
 		}
-		return state, nil
 	}
 
-	state, err := m.evaluator.LoadState(ctx, m.path)
+	state, err = openSensitive(ctx, state)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load state from %s: %w", m.path, err)
+		return nil, fmt.Errorf("failed to decrypt sensitive values in %s: %w", path, err)
 	}
-
 	return state, nil
 }
 
-// Write saves the state to the configured path.
+// Write saves the state to the configured path and keeps a numbered snapshot
+// of it (see Versions).
 // If PICKLR_STATE_ENCRYPTION_KEY is set, the file is transparently encrypted.
 func (m *Manager) Write(ctx context.Context, state *ir.State) error {
 	// Ensure directory exists
@@ -76,136 +90,45 @@ func (m *Manager) Write(ctx context.Context, state *ir.State) error {
 		return fmt.Errorf("failed to create state directory: %w", err)
 	}
 
-	content := []byte(SerializeState(state))
+	stored, err := m.storedHeader(ctx)
+	if err != nil {
+		return err
+	}
+	serial, lineage, err := nextVersion(ctx, stored, state)
+	if err != nil {
+		return err
+	}
 
 	// Encrypt if encryption key is configured
-	encrypted, err := EncryptState(content)
+	encrypted, err := encodeForWrite(ctx, versioned(state, serial, lineage), writeEncoding(ctx, m.encoding, stored), m.encryptSensitive)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt state: %w", err)
+		return err
 	}
 
 	if err := os.WriteFile(m.path, encrypted, 0644); err != nil {
 		return fmt.Errorf("failed to write state file %s: %w", m.path, err)
 	}
+	state.Serial, state.Lineage = serial, lineage
+
+	if err := os.WriteFile(m.snapshotPath(serial), encrypted, 0644); err != nil {
+		return fmt.Errorf("failed to write state snapshot: %w", err)
+	}
 
 	return nil
 }
 
-// SerializeState converts a State to its PKL text representation.
-func SerializeState(state *ir.State) string {
-	var b strings.Builder
-
-	// Write header
-	fmt.Fprintf(&b, "// Picklr state file\n")
-	fmt.Fprintf(&b, "amends \"../../pkg/schemas/State.pkl\"\n\n")
-	fmt.Fprintf(&b, "version = %d\n", state.Version)
-	fmt.Fprintf(&b, "serial = %d\n", state.Serial+1)
-	fmt.Fprintf(&b, "lineage = %q\n\n", state.Lineage)
-
-	// Write outputs
-	if len(state.Outputs) > 0 {
-		fmt.Fprintf(&b, "outputs {\n")
-		for k, v := range state.Outputs {
-			fmt.Fprintf(&b, "  [%q] = %s\n", k, serializePklValue(v, 1))
-		}
-		fmt.Fprintf(&b, "}\n\n")
-	} else {
-		fmt.Fprintf(&b, "outputs = new {}\n\n")
+// storedHeader reads the serial and lineage of the state file without evaluating it.
+func (m *Manager) storedHeader(ctx context.Context) (storedHeader, error) {
+	raw, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return storedHeader{}, nil
 	}
-
-	// Write resources
-	fmt.Fprintf(&b, "resources {\n")
-	for _, res := range state.Resources {
-		fmt.Fprintf(&b, "  new {\n")
-		fmt.Fprintf(&b, "    type = %q\n", res.Type)
-		fmt.Fprintf(&b, "    name = %q\n", res.Name)
-		fmt.Fprintf(&b, "    provider = %q\n", res.Provider)
-
-		// Serialize inputs
-		if len(res.Inputs) > 0 {
-			fmt.Fprintf(&b, "    inputs {\n")
-			for k, v := range res.Inputs {
-				fmt.Fprintf(&b, "      [%q] = %s\n", k, serializePklValue(v, 3))
-			}
-			fmt.Fprintf(&b, "    }\n")
-		} else {
-			fmt.Fprintf(&b, "    inputs = new {}\n")
-		}
-
-		fmt.Fprintf(&b, "    inputsHash = %q\n", res.InputsHash)
-
-		// Serialize outputs
-		if len(res.Outputs) > 0 {
-			fmt.Fprintf(&b, "    outputs {\n")
-			for k, v := range res.Outputs {
-				fmt.Fprintf(&b, "      [%q] = %s\n", k, serializePklValue(v, 3))
-			}
-			fmt.Fprintf(&b, "    }\n")
-		} else {
-			fmt.Fprintf(&b, "    outputs = new {}\n")
-		}
-
-		fmt.Fprintf(&b, "  }\n")
+	if err != nil {
+		return storedHeader{}, fmt.Errorf("failed to read state file %s: %w", m.path, err)
 	}
-	fmt.Fprintf(&b, "}\n")
-
-	return b.String()
-}
-
-// serializePklValue recursively serializes a Go value to PKL syntax.
-func serializePklValue(v any, indentLevel int) string {
-	indent := strings.Repeat("  ", indentLevel)
-
-	switch val := v.(type) {
-	case string:
-		return fmt.Sprintf("%q", val)
-	case bool:
-		return fmt.Sprintf("%t", val)
-	case int:
-		return fmt.Sprintf("%d", val)
-	case int64:
-		return fmt.Sprintf("%d", val)
-	case float64:
-		if val == float64(int64(val)) {
-			return fmt.Sprintf("%d", int64(val))
-		}
-		return fmt.Sprintf("%g", val)
-	case nil:
-		return "null"
-	case map[string]any:
-		if len(val) == 0 {
-			return "new {}"
-		}
-		var b strings.Builder
-		b.WriteString("new {\n")
-		for k, v := range val {
-			b.WriteString(fmt.Sprintf("%s  [%q] = %s\n", indent, k, serializePklValue(v, indentLevel+1)))
-		}
-		b.WriteString(indent + "}")
-		return b.String()
-	case map[any]any:
-		if len(val) == 0 {
-			return "new {}"
-		}
-		var b strings.Builder
-		b.WriteString("new {\n")
-		for k, v := range val {
-			b.WriteString(fmt.Sprintf("%s  [%q] = %s\n", indent, fmt.Sprintf("%v", k), serializePklValue(v, indentLevel+1)))
-		}
-		b.WriteString(indent + "}")
-		return b.String()
-	case []any:
-		if len(val) == 0 {
-			return "new Listing {}"
-		}
-		var b strings.Builder
-		b.WriteString("new Listing {\n")
-		for _, v := range val {
-			b.WriteString(fmt.Sprintf("%s  %s\n", indent, serializePklValue(v, indentLevel+1)))
-		}
-		b.WriteString(indent + "}")
-		return b.String()
-	default:
-		return fmt.Sprintf("%q", fmt.Sprintf("%v", val))
+	header, err := headerFromContent(ctx, raw)
+	if err != nil {
+		return storedHeader{}, fmt.Errorf("failed to read state file %s: %w", m.path, err)
 	}
+	return header, nil
 }
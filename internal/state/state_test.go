@@ -109,12 +109,12 @@ func TestManager_LockUnlock(t *testing.T) {
 	mgr := NewManager(statePath, evaluator)
 
 	// Lock should succeed
-	err := mgr.Lock()
+	err := mgr.Lock(nil)
 	require.NoError(t, err)
 
 	// Second lock should fail
 	mgr2 := NewManager(statePath, evaluator)
-	err = mgr2.Lock()
+	err = mgr2.Lock(nil)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "locked")
 
@@ -123,7 +123,7 @@ func TestManager_LockUnlock(t *testing.T) {
 	require.NoError(t, err)
 
 	// Now lock should succeed again
-	err = mgr2.Lock()
+	err = mgr2.Lock(nil)
 	require.NoError(t, err)
 	mgr2.Unlock()
 }
@@ -150,9 +150,9 @@ func TestSerializePklValue(t *testing.T) {
 			contains: []string{"42"},
 		},
 		{
-			name:     "float as int",
+			name:     "whole float",
 			input:    float64(42),
-			contains: []string{"42"},
+			contains: []string{"42.0"},
 		},
 		{
 			name:     "float",
@@ -167,13 +167,13 @@ func TestSerializePklValue(t *testing.T) {
 		{
 			name:     "empty map",
 			input:    map[string]any{},
-			contains: []string{"new {}"},
+			contains: []string{"new Mapping {}"},
 		},
 		{
 			name:  "nested map",
 			input: map[string]any{"key": "val"},
 			contains: []string{
-				"new {",
+				"new Mapping {",
 				`"key"`,
 				`"val"`,
 			},
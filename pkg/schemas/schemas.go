@@ -0,0 +1,30 @@
+// Package schemas embeds the PKL schemas that Picklr validates its own files against.
+package schemas
+
+import "embed"
+
+// State is the State.pkl schema that state files amend.
+//
+//go:embed State.pkl
+var State []byte
+
+// Stack is the Stack.pkl schema that stack manifests amend.
+//
+//go:embed Stack.pkl
+var Stack []byte
+
+// Mock is the Mock.pkl schema of mock providers.
+//
+//go:embed Mock.pkl
+var Mock []byte
+
+// Test is the Test.pkl schema that *.test.pkl files amend.
+//
+//go:embed Test.pkl
+var Test []byte
+
+// Providers holds the resource schemas of the built-in providers, laid out as
+// <provider>/<Module>.pkl.
+//
+//go:embed aws/*.pkl docker/*.pkl null/*.pkl
+var Providers embed.FS
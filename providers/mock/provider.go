@@ -0,0 +1,184 @@
+// Package mock implements a provider that answers every request locally, so that
+// configurations written for real providers can be planned, applied and tested
+// offline. Resources keep their types; applying one returns its properties along
+// with the canned outputs configured for its type.
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	pb "github.com/picklr-io/picklr/pkg/proto/provider"
+)
+
+type Provider struct {
+	pb.UnimplementedProviderServer
+
+	name     string
+	outputs  map[string]map[string]any // Canned outputs by resource type
+	computed map[string][]string       // Attributes given placeholder values, by resource type
+	defaults map[string]map[string]any // Schema defaults by resource type
+}
+
+// New returns a mock standing in for the provider name. outputs holds the
+// outputs returned for each resource type, e.g. an arn for aws:S3.Bucket.
+// A string output may contain ${name}, replaced by the resource name.
+func New(name string, outputs map[string]map[string]any) *Provider {
+	return &Provider{name: name, outputs: outputs, computed: make(map[string][]string), defaults: make(map[string]map[string]any)}
+}
+
+// Computed declares attributes of a resource type that the real provider computes,
+// such as an arn that other resources reference. Unless a property or canned
+// output sets them, they are given placeholder values.
+func (p *Provider) Computed(typ string, attrs ...string) {
+	p.computed[typ] = append(p.computed[typ], attrs...)
+}
+
+// Defaults declares the default attributes of a resource type, as its schema
+// declares them. They seed the state of resources of the type, under their
+// properties, prior state and canned outputs.
+func (p *Provider) Defaults(typ string, attrs map[string]any) {
+	if p.defaults[typ] == nil {
+		p.defaults[typ] = make(map[string]any, len(attrs))
+	}
+	for k, v := range attrs {
+		p.defaults[typ][k] = v
+	}
+}
+
+func (p *Provider) GetSchema(ctx context.Context, req *pb.GetSchemaRequest) (*pb.GetSchemaResponse, error) {
+	return &pb.GetSchemaResponse{}, nil
+}
+
+func (p *Provider) Configure(ctx context.Context, req *pb.ConfigureRequest) (*pb.ConfigureResponse, error) {
+	return &pb.ConfigureResponse{}, nil
+}
+
+// Plan creates resources without prior state and updates those whose desired
+// properties differ from the prior state.
+func (p *Provider) Plan(ctx context.Context, req *pb.PlanRequest) (*pb.PlanResponse, error) {
+	desired, err := decode(req.DesiredConfigJson)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal desired config: %w", err)
+	}
+	if len(req.PriorStateJson) == 0 {
+		return &pb.PlanResponse{Action: pb.PlanResponse_CREATE, PlannedStateJson: p.state(req.Type, req.Name, desired, nil)}, nil
+	}
+
+	prior, err := decode(req.PriorStateJson)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal prior state: %w", err)
+	}
+	var changed []string
+	for k, v := range desired {
+		// References are resolved on apply, so they can't be compared yet
+		if !hasRef(v) && !reflect.DeepEqual(v, prior[k]) {
+			changed = append(changed, k)
+		}
+	}
+	if len(changed) == 0 {
+		return &pb.PlanResponse{Action: pb.PlanResponse_NOOP}, nil
+	}
+	sort.Strings(changed)
+	return &pb.PlanResponse{
+		Action:            pb.PlanResponse_UPDATE,
+		ChangedAttributes: changed,
+		PlannedStateJson:  p.state(req.Type, req.Name, desired, prior),
+	}, nil
+}
+
+// Apply returns the desired properties merged with the canned outputs of the type.
+func (p *Provider) Apply(ctx context.Context, req *pb.ApplyRequest) (*pb.ApplyResponse, error) {
+	desired, err := decode(req.DesiredConfigJson)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	var prior map[string]any
+	if len(req.PriorStateJson) > 0 {
+		if prior, err = decode(req.PriorStateJson); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal prior state: %w", err)
+		}
+	}
+	return &pb.ApplyResponse{NewStateJson: p.state(req.Type, req.Name, desired, prior)}, nil
+}
+
+// Read reports that the resource exists as recorded in state. A resource without
+// state, as when it is imported, is given the canned outputs of its type, with
+// its ID standing in for its name.
+func (p *Provider) Read(ctx context.Context, req *pb.ReadRequest) (*pb.ReadResponse, error) {
+	if len(req.CurrentStateJson) == 0 {
+		return &pb.ReadResponse{Exists: true, NewStateJson: p.state(req.Type, req.Id, nil, map[string]any{"id": req.Id})}, nil
+	}
+	return &pb.ReadResponse{Exists: true, NewStateJson: req.CurrentStateJson}, nil
+}
+
+func (p *Provider) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	return &pb.DeleteResponse{}, nil
+}
+
+// state returns the state of a resource, layering the schema defaults of its
+// type, placeholders for its computed attributes, its prior state, its
+// properties, the canned outputs of its type and an id. Outputs of prior state
+// that the properties don't set are kept, as the real provider keeps them.
+func (p *Provider) state(typ, name string, desired, prior map[string]any) []byte {
+	state := make(map[string]any, len(prior)+len(desired)+1)
+	for k, v := range p.defaults[typ] {
+		state[k] = v
+	}
+	for _, attr := range p.computed[typ] {
+		state[attr] = fmt.Sprintf("mock-%s-%s-%s", p.name, name, attr)
+	}
+	for k, v := range prior {
+		state[k] = v
+	}
+	for k, v := range desired {
+		state[k] = v
+	}
+	for k, v := range p.outputs[typ] {
+		if s, ok := v.(string); ok {
+			v = strings.ReplaceAll(s, "${name}", name)
+		}
+		state[k] = v
+	}
+	if _, ok := state["id"]; !ok {
+		state["id"] = fmt.Sprintf("mock-%s-%s", p.name, name)
+	}
+	data, _ := json.Marshal(state)
+	return data
+}
+
+// hasRef reports whether v contains a ptr:// reference.
+func hasRef(v any) bool {
+	switch val := v.(type) {
+	case string:
+		return strings.HasPrefix(val, "ptr://")
+	case map[string]any:
+		for _, item := range val {
+			if hasRef(item) {
+				return true
+			}
+		}
+	case []any:
+		for _, item := range val {
+			if hasRef(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decode(data []byte) (map[string]any, error) {
+	m := make(map[string]any)
+	if len(data) == 0 || string(data) == "null" {
+		return m, nil
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,106 @@
+package mock
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	pb "github.com/picklr-io/picklr/pkg/proto/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider_Lifecycle(t *testing.T) {
+	p := New("aws", map[string]map[string]any{
+		"aws:S3.Bucket": {"arn": "arn:aws:s3:::${name}"},
+	})
+	ctx := context.Background()
+	desired := []byte(`{"bucket":"logs","tags":{"env":"prod"}}`)
+
+	plan, err := p.Plan(ctx, &pb.PlanRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: desired})
+	require.NoError(t, err)
+	assert.Equal(t, pb.PlanResponse_CREATE, plan.Action)
+
+	applied, err := p.Apply(ctx, &pb.ApplyRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: desired})
+	require.NoError(t, err)
+	var state map[string]any
+	require.NoError(t, json.Unmarshal(applied.NewStateJson, &state))
+	assert.Equal(t, "arn:aws:s3:::logs", state["arn"])
+	assert.Equal(t, "logs", state["bucket"])
+	assert.Equal(t, "mock-aws-logs", state["id"])
+
+	plan, err = p.Plan(ctx, &pb.PlanRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: desired, PriorStateJson: applied.NewStateJson})
+	require.NoError(t, err)
+	assert.Equal(t, pb.PlanResponse_NOOP, plan.Action)
+
+	// References are unknown until apply and don't cause an update
+	withRef := []byte(`{"bucket":"logs","tags":{"env":"prod"},"policy":"ptr://aws:IAM.Policy/p/arn"}`)
+	plan, err = p.Plan(ctx, &pb.PlanRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: withRef, PriorStateJson: applied.NewStateJson})
+	require.NoError(t, err)
+	assert.Equal(t, pb.PlanResponse_NOOP, plan.Action)
+
+	changed := []byte(`{"bucket":"logs","tags":{"env":"dev"}}`)
+	plan, err = p.Plan(ctx, &pb.PlanRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: changed, PriorStateJson: applied.NewStateJson})
+	require.NoError(t, err)
+	assert.Equal(t, pb.PlanResponse_UPDATE, plan.Action)
+	assert.Equal(t, []string{"tags"}, plan.ChangedAttributes)
+
+	read, err := p.Read(ctx, &pb.ReadRequest{Type: "aws:S3.Bucket", Id: "mock-aws-logs", CurrentStateJson: applied.NewStateJson})
+	require.NoError(t, err)
+	assert.True(t, read.Exists)
+
+	_, err = p.Delete(ctx, &pb.DeleteRequest{Type: "aws:S3.Bucket", Id: "mock-aws-logs"})
+	require.NoError(t, err)
+}
+
+func TestProvider_Computed(t *testing.T) {
+	p := New("aws", map[string]map[string]any{
+		"aws:S3.Bucket": {"arn": "arn:aws:s3:::${name}"},
+	})
+	p.Computed("aws:S3.Bucket", "arn", "bucket", "domain_name")
+
+	applied, err := p.Apply(context.Background(), &pb.ApplyRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: []byte(`{"bucket":"acme-logs"}`)})
+	require.NoError(t, err)
+	var state map[string]any
+	require.NoError(t, json.Unmarshal(applied.NewStateJson, &state))
+	assert.Equal(t, "arn:aws:s3:::logs", state["arn"])
+	assert.Equal(t, "acme-logs", state["bucket"])
+	assert.Equal(t, "mock-aws-logs-domain_name", state["domain_name"])
+}
+
+func TestProvider_Defaults(t *testing.T) {
+	p := New("aws", nil)
+	p.Defaults("aws:S3.Bucket", map[string]any{"acl": "private", "force_destroy": false})
+
+	applied, err := p.Apply(context.Background(), &pb.ApplyRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: []byte(`{"bucket":"acme-logs","acl":"public-read"}`)})
+	require.NoError(t, err)
+	var state map[string]any
+	require.NoError(t, json.Unmarshal(applied.NewStateJson, &state))
+	assert.Equal(t, "public-read", state["acl"], "properties override defaults")
+	assert.Equal(t, false, state["force_destroy"])
+
+	// An imported resource has the defaults of its type
+	read, err := p.Read(context.Background(), &pb.ReadRequest{Type: "aws:S3.Bucket", Id: "acme-logs"})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(read.NewStateJson, &state))
+	assert.Equal(t, "private", state["acl"])
+	assert.Equal(t, "acme-logs", state["id"])
+}
+
+func TestProvider_UpdateKeepsPriorOutputs(t *testing.T) {
+	p := New("aws", nil)
+	p.Computed("aws:S3.Bucket", "arn")
+	ctx := context.Background()
+	prior := []byte(`{"bucket":"logs","tags":{"env":"prod"},"arn":"arn:aws:s3:::logs","region":"eu-west-1","id":"logs-1234"}`)
+	changed := []byte(`{"bucket":"logs","tags":{"env":"dev"}}`)
+
+	plan, err := p.Plan(ctx, &pb.PlanRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: changed, PriorStateJson: prior})
+	require.NoError(t, err)
+	assert.Equal(t, pb.PlanResponse_UPDATE, plan.Action)
+
+	applied, err := p.Apply(ctx, &pb.ApplyRequest{Type: "aws:S3.Bucket", Name: "logs", DesiredConfigJson: changed, PriorStateJson: prior})
+	require.NoError(t, err)
+	for _, state := range [][]byte{plan.PlannedStateJson, applied.NewStateJson} {
+		assert.JSONEq(t, `{"bucket":"logs","tags":{"env":"dev"},"arn":"arn:aws:s3:::logs","region":"eu-west-1","id":"logs-1234"}`, string(state))
+	}
+}